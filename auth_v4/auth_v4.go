@@ -29,13 +29,13 @@
 package auth_v4
 
 import (
+	"context"
+	"crypto/tls"
 	"net/url"
 	"net/http"
 	"fmt"
 	"strconv"
 	"errors"
-	"io"
-	"io/ioutil"
 	"encoding/json"
 	"strings"
 	"hash"
@@ -50,17 +50,80 @@ import (
 )
 
 const (
+	X_AMZ_PREFIX = "x-amz-"
 	IAM_WARN_MESSAGE = "check roles sources and make sure you have run one of the roles " +
 		"management functions in package conf_iam, such as GoIAM"
 )
 
+// headersKey is the context key for headers set with WithHeaders.
+type headersKey struct{}
+
+// WithHeaders returns a context that adds `hdrs` to requests made with it, in addition to
+// (and taking precedence over) any headers set in the conf. Headers named with the
+// x-amz- prefix are included in the signature; the standard signed headers cannot be replaced.
+func WithHeaders(ctx context.Context,hdrs map[string]string) context.Context {
+	merged := make(map[string]string)
+	if prev,ok := ctx.Value(headersKey{}).(map[string]string); ok {
+		for k,v := range prev {
+			merged[k] = v
+		}
+	}
+	for k,v := range hdrs {
+		merged[k] = v
+	}
+	return context.WithValue(ctx,headersKey{},merged)
+}
+
+// port is the DynamoDB port of c, aws_const.PORT unless set. c must be locked.
+func port(c *conf.AWS_Conf) string {
+	if c.Network.DynamoDB.Port == "" {
+		return aws_const.PORT
+	}
+	return c.Network.DynamoDB.Port
+}
+
+// requestHeaders merges the headers of conf c with those set on ctx.
+func requestHeaders(ctx context.Context,c *conf.AWS_Conf) map[string]string {
+	hdrs := make(map[string]string)
+	c.ConfLock.RLock()
+	for k,v := range c.Network.DynamoDB.Headers {
+		hdrs[k] = v
+	}
+	c.ConfLock.RUnlock()
+	if ctx_hdrs,ok := ctx.Value(headersKey{}).(map[string]string); ok {
+		for k,v := range ctx_hdrs {
+			hdrs[k] = v
+		}
+	}
+	for k,_ := range hdrs {
+		switch strings.ToLower(k) {
+		case "host","authorization",
+			strings.ToLower(aws_const.CONTENT_TYPE_HDR),
+			strings.ToLower(aws_const.AMZ_TARGET_HDR),
+			strings.ToLower(aws_const.X_AMZ_DATE_HDR),
+			strings.ToLower(aws_const.X_AMZ_SECURITY_TOKEN_HDR),
+			strings.ToLower(tasks.X_AMZ_REGION_SET_HDR):
+			delete(hdrs,k)
+		}
+	}
+	return hdrs
+}
+
 // Client for executing requests.
 var Client *http.Client
 
-// Initialize package-scoped client.
+// InsecureClient executes requests to https endpoints configured with
+// InsecureSkipVerify, such as a local emulator with a self-signed certificate.
+var InsecureClient *http.Client
+
+// Initialize package-scoped clients.
 func init() {
-	tr := &http.Transport{ResponseHeaderTimeout: time.Duration(20) * time.Second}
+	tr := &http.Transport{ResponseHeaderTimeout: time.Duration(20) * time.Second,
+		DialContext: dialTracked}
 	Client = &http.Client{Transport:tr}
+	insecure_tr := &http.Transport{ResponseHeaderTimeout: time.Duration(20) * time.Second,
+		DialContext: dialTracked,TLSClientConfig:&tls.Config{InsecureSkipVerify:true}}
+	InsecureClient = &http.Client{Transport:insecure_tr}
 }
 
 // GetRespReqID retrieves the unique identifier from the AWS Response
@@ -73,22 +136,20 @@ func GetRespReqID(response http.Response) (string,error) {
 	return "",errors.New("auth_v4.GetRespReqID: no X-Amzn-Requestid found")
 }
 
-// MatchCheckSum will perform a local crc32 on the response body and match it against the aws crc32
-// *** WARNING ***
-// There seems to be a mismatch between what Go calculates and what AWS (java?) calculates here,
-// I believe related to utf8 (go) vs utf16 (java), but I don't know enough about encodings to
-// solve it. So until that issue is solved, don't use this.
+// the canonical form of the X-Amz-Crc32 header name
+const aws_crc32_hdr = "X-Amz-Crc32"
+
+// MatchCheckSum will perform a local crc32 on the response body and match it against the aws crc32.
+// The checksum is of the body as sent, so respbody must not have been decompressed; see readBody.
 func MatchCheckSum(response http.Response,respbody []byte) (bool,error) {
-	if amz_crc_list,crc_ok := response.Header["X-Amz-Crc32"]; crc_ok {
+	if amz_crc_list,crc_ok := response.Header[aws_crc32_hdr]; crc_ok {
 		if len(amz_crc_list) == 1 {
-			amz_crc_int32,amz_crc32_err := strconv.Atoi(amz_crc_list[0])
-			if amz_crc32_err == nil {
-				client_crc_int32 := int(crc32.ChecksumIEEE(respbody))
-				if amz_crc_int32 !=  client_crc_int32 {
-					_ = fmt.Sprintf("auth_v4.RawReq: resp crc mismatch: amz %d client %d",
-						amz_crc_int32,client_crc_int32)
-					return false,nil
-				}
+			amz_crc,amz_crc32_err := strconv.ParseUint(amz_crc_list[0],10,32)
+			if amz_crc32_err != nil {
+				return false,errors.New("auth_v4.MatchCheckSum: X-Amz-Crc32 malformed")
+			}
+			if uint32(amz_crc) != crc32.ChecksumIEEE(respbody) {
+				return false,nil
 			}
 		} else {
 			return false,errors.New("auth_v4.MatchCheckSum: X-Amz-Crc32 malformed")
@@ -102,20 +163,87 @@ func MatchCheckSum(response http.Response,respbody []byte) (bool,error) {
 // RawReq will sign and transmit the request to the AWS DynanoDB endpoint.
 // This method is DynamoDB-specific.
 func RawReq(reqJSON []byte,amzTarget string) (string,string,int,error) {
-	url,url_err := url.Parse(conf.Vals.Network.DynamoDB.URL)
+	return RawReqContext(context.Background(),reqJSON,amzTarget)
+}
+
+// RawReqContext is RawReq with a context governing the lifetime of the http request.
+func RawReqContext(ctx context.Context,reqJSON []byte,amzTarget string) (string,string,int,error) {
+	request,client,canonical_request,sign_err := signRequest(ctx,reqJSON,amzTarget)
+	if sign_err != nil {
+		return "","",0,sign_err
+	}
+	if dryRun(ctx,request,reqJSON,canonical_request) {
+		return "","",0,ErrDryRun
+	}
+
+	// where we finally send req to aws
+	sent := time.Now()
+	response,rsp_err := send(client,request)
+
+	if rsp_err != nil {
+		return "","",0,rsp_err
+	}
+	defer response.Body.Close()
+	respbody,read_err := readBody(response)
+	if read_err == ErrChecksumMismatch {
+		return "","",0,read_err
+	} else if read_err != nil {
+		e := fmt.Sprintf("auth_v4.RawReq:err reading resp body: %s",read_err.Error())
+		return "","",0,errors.New(e)
+	}
+	correctSkew(response,string(respbody),sent)
+
+	amz_requestid,amz_requestid_err := GetRespReqID(*response)
+	if amz_requestid_err != nil {
+		return "","",0,amz_requestid_err
+	}
+
+	return string(respbody),amz_requestid,response.StatusCode,nil
+}
+
+// SignedRequest returns the http request RawReqContext would send for reqJSON, unsent,
+// along with the canonical request its signature was computed over. It is for diagnosing
+// signature mismatches; see the sigtrace package.
+func SignedRequest(ctx context.Context,reqJSON []byte,amzTarget string) (*http.Request,string,error) {
+	request,_,canonical_request,err := signRequest(ctx,reqJSON,amzTarget)
+	return request,canonical_request,err
+}
+
+// signRequest builds the http request for reqJSON, signed with the current credentials
+// of the configuration ctx directs it to (see conf.WithName), and returns it with the
+// client to send it with and the canonical request its signature was computed over.
+func signRequest(ctx context.Context,reqJSON []byte,amzTarget string) (*http.Request,*http.Client,string,error) {
+	resolveOnce.Do(startResolver)
+	c := conf.FromContext(ctx)
+	if c == nil {
+		e := fmt.Sprintf("auth_v4.RawReq: no configuration named %s",conf.NameFrom(ctx))
+		return nil,nil,"",errors.New(e)
+	}
+	c.ConfLock.RLock()
+	dynamo_url := c.Network.DynamoDB.URL
+	host := c.Network.DynamoDB.Host + ":" + port(c)
+	zone := c.Network.DynamoDB.Zone
+	sigv4a := c.Network.DynamoDB.SigningAlgorithm == conf.SIGNING_ALGORITHM_V4A
+	region_set := strings.Join(c.Network.DynamoDB.RegionSet,",")
+	client := Client
+	if c.Network.DynamoDB.InsecureSkipVerify {
+		client = InsecureClient
+	}
+	c.ConfLock.RUnlock()
+	url,url_err := url.Parse(dynamo_url)
 	if url_err != nil {
 		e := "auth_v4.RawReq:parse " +
-			conf.Vals.Network.DynamoDB.URL +
+			dynamo_url +
 			" " + url_err.Error()
-		return "","",0,errors.New(e)
+		return nil,nil,"",errors.New(e)
 	}
 
 	// initialize req with body reader
 	body := strings.NewReader(string(reqJSON))
-	request,req_err := http.NewRequest(aws_const.METHOD,url.String(),body)
+	request,req_err := http.NewRequestWithContext(ctx,aws_const.METHOD,url.String(),body)
 	if req_err != nil {
 		e := fmt.Sprintf("auth_v4.RawReq:failed init conn %s",req_err.Error())
-		return "","",0,errors.New(e)
+		return nil,nil,"",errors.New(e)
 	}
 
 	// add headers
@@ -124,7 +252,7 @@ func RawReq(reqJSON []byte,amzTarget string) (string,string,int,error) {
 	// amz target
 	request.Header.Add(aws_const.AMZ_TARGET_HDR,amzTarget)
 	// dates
-	now := time.Now()
+	now := SigningTime()
 	request.Header.Add(aws_const.X_AMZ_DATE_HDR,
 		now.UTC().Format(aws_const.ISO8601FMT_CONDENSED))
 
@@ -133,84 +261,108 @@ func RawReq(reqJSON []byte,amzTarget string) (string,string,int,error) {
 	h256.Write(reqJSON)
 	hexPayload := string(hex.EncodeToString([]byte(h256.Sum(nil))))
 
-	// create the various signed formats aws uses for v4 signed reqs
-	service := strings.ToLower(aws_const.DYNAMODB)
-	canonical_request := tasks.CanonicalRequest(
-		conf.Vals.Network.DynamoDB.Host,
-		request.Header.Get(aws_const.X_AMZ_DATE_HDR),
-		request.Header.Get(aws_const.AMZ_TARGET_HDR),
-		hexPayload)
-	str2sign := tasks.String2Sign(now,canonical_request,
-		conf.Vals.Network.DynamoDB.Zone,
-		service)
-
-	// obtain the aws secret credential from the global Auth or from IAM
-	var secret string
-	if conf.Vals.UseIAM == true {
-		conf.Vals.ConfLock.RLock()
-		secret = conf.Vals.IAM.Credentials.Secret
-		conf.Vals.ConfLock.RUnlock()
+	// obtain the aws credentials from the global Auth or from IAM, along with
+	// the session token of temporary credentials
+	var accessKey,secret,token string
+	creds := c
+	c.ConfLock.RLock()
+	if c.UseValsCredentials {
+		creds = &conf.Vals
+	}
+	c.ConfLock.RUnlock()
+	creds.ConfLock.RLock()
+	use_iam := creds.UseIAM
+	if use_iam == true {
+		accessKey = creds.IAM.Credentials.AccessKey
+		secret = creds.IAM.Credentials.Secret
+		token = creds.IAM.Credentials.Token
 	} else {
-		secret = conf.Vals.Auth.Secret
+		accessKey = creds.Auth.AccessKey
+		secret = creds.Auth.Secret
+		token = creds.Auth.Token
 	}
+	creds.ConfLock.RUnlock()
 	if secret == "" {
 		panic("auth_v4.cacheable_hmacs: no Secret defined; " + IAM_WARN_MESSAGE)
 	}
-
-	signature := tasks.MakeSignature(str2sign,conf.Vals.Network.DynamoDB.Zone,service,secret)
-
-	// obtain the aws accessKey credential from the global Auth or from IAM
-	// if using IAM, read the token while we have the lock
-	var accessKey,token string
-	if conf.Vals.UseIAM == true {
-		conf.Vals.ConfLock.RLock()
-		accessKey = conf.Vals.IAM.Credentials.AccessKey
-		token = conf.Vals.IAM.Credentials.Token
-		conf.Vals.ConfLock.RUnlock()
-	} else {
-		accessKey = conf.Vals.Auth.AccessKey
-	}
 	if accessKey == "" {
 		panic("auth_v4.RawReq: no Access Key defined; " + IAM_WARN_MESSAGE)
 	}
+	if use_iam == true && token == "" {
+		panic("auth_v4.RawReq: no Token defined;" + IAM_WARN_MESSAGE)
+	}
 
-	v4auth := "AWS4-HMAC-SHA256 Credential=" + accessKey +
-		"/" + now.UTC().Format(aws_const.ISODATEFMT) + "/" +
-		conf.Vals.Network.DynamoDB.Zone + "/" + service + "/aws4_request," +
-		"SignedHeaders=content-type;host;x-amz-date;x-amz-target," +
-		"Signature=" + signature
-	request.Header.Add("Authorization",v4auth)
-	if conf.Vals.UseIAM == true {
-		if token == "" {
-			panic("auth_v4.RawReq: no Token defined;" + IAM_WARN_MESSAGE)
+	// create the various signed formats aws uses for v4 signed reqs
+	service := strings.ToLower(aws_const.DYNAMODB)
+	// extra headers: those from the conf, overridden by those set on ctx.
+	// x-amz-* headers are signed, others (e.g. proxy trace ids) are not.
+	signed_extra := make(map[string]string)
+	for k,v := range requestHeaders(ctx,c) {
+		request.Header.Set(k,v)
+		if strings.HasPrefix(strings.ToLower(k),X_AMZ_PREFIX) {
+			signed_extra[k] = v
 		}
-		request.Header.Add(aws_const.X_AMZ_SECURITY_TOKEN_HDR,token)
 	}
-
-	// where we finally send req to aws
-	response,rsp_err := Client.Do(request)
-
-	if rsp_err != nil {
-		return "","",0,rsp_err
+	// the session token of temporary credentials is signed as well
+	if token != "" {
+		request.Header.Set(aws_const.X_AMZ_SECURITY_TOKEN_HDR,token)
+		signed_extra[aws_const.X_AMZ_SECURITY_TOKEN_HDR] = token
 	}
-	defer response.Body.Close()
-	respbody,read_err := ioutil.ReadAll(response.Body)
-	if read_err != nil && read_err != io.EOF {
-		e := fmt.Sprintf("auth_v4.RawReq:err reading resp body: %s",read_err.Error())
-		return "","",0,errors.New(e)
+	// as are the regions of a SigV4A signature
+	if sigv4a {
+		request.Header.Set(tasks.X_AMZ_REGION_SET_HDR,region_set)
+		signed_extra[tasks.X_AMZ_REGION_SET_HDR] = region_set
 	}
+	canonical_request,signed_headers := tasks.CanonicalRequestHeaders(
+		host,
+		request.Header.Get(aws_const.X_AMZ_DATE_HDR),
+		request.Header.Get(aws_const.AMZ_TARGET_HDR),
+		hexPayload,signed_extra)
 
-	amz_requestid,amz_requestid_err := GetRespReqID(*response)
-	if amz_requestid_err != nil {
-		return "","",0,amz_requestid_err
+	var v4auth string
+	if sigv4a {
+		key,key_err := tasks.DeriveKeyV4A(accessKey,secret)
+		if key_err != nil {
+			return nil,nil,"",key_err
+		}
+		str2sign := tasks.String2SignV4A(now,canonical_request,service)
+		signature,sign_err := tasks.MakeSignatureV4A(str2sign,key)
+		if sign_err != nil {
+			e := fmt.Sprintf("auth_v4.RawReq:cannot sign: %s",sign_err.Error())
+			return nil,nil,"",errors.New(e)
+		}
+		v4auth = tasks.SIGV4A_ALGORITHM + " Credential=" + accessKey +
+			"/" + now.UTC().Format(aws_const.ISODATEFMT) + "/" +
+			service + "/aws4_request," +
+			"SignedHeaders=" + signed_headers + "," +
+			"Signature=" + signature
+	} else {
+		str2sign := tasks.String2Sign(now,canonical_request,
+			zone,
+			service)
+
+		signature := tasks.MakeSignatureAt(now,str2sign,zone,service,secret)
+
+		v4auth = "AWS4-HMAC-SHA256 Credential=" + accessKey +
+			"/" + now.UTC().Format(aws_const.ISODATEFMT) + "/" +
+			zone + "/" + service + "/aws4_request," +
+			"SignedHeaders=" + signed_headers + "," +
+			"Signature=" + signature
 	}
+	request.Header.Add("Authorization",v4auth)
+	acceptEncoding(request)
 
-	return string(respbody),amz_requestid,response.StatusCode,nil
+	return request,client,canonical_request,nil
 }
 
 // Req prepares a RawReq call from either a ep.Endpoint instance or a []byte representation
 // serialization of the request payload. DynamoDB-specific.
 func Req(v interface{},amzTarget string) (string,string,int,error) {
+	return ReqContext(context.Background(),v,amzTarget)
+}
+
+// ReqContext is Req with a context governing the lifetime of the http request.
+func ReqContext(ctx context.Context,v interface{},amzTarget string) (string,string,int,error) {
 	// we take two types here, either an ep.Endpoint implementor, or
 	// a []byte representing the marshaled json
 	_,ep_ok := interface{}(v).(ep.Endpoint)
@@ -219,11 +371,11 @@ func Req(v interface{},amzTarget string) (string,string,int,error) {
 		if json_err != nil {
 			return "","",0,json_err
 		}
-		return RawReq(reqJSON,amzTarget)
+		return RawReqContext(ctx,reqJSON,amzTarget)
 	}
 	v_bytes,v_ok := v.([]byte)
 	if v_ok {
-		return RawReq(v_bytes,amzTarget)
+		return RawReqContext(ctx,v_bytes,amzTarget)
 	}
 	return "","",0,errors.New("auth_v4.Req:v unknown type")
 }
@@ -0,0 +1,133 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"bytes"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"compress/gzip"
+)
+
+// DynamoDB computes the X-Amz-Crc32 header over the response body as sent, which is the
+// compressed body when the response has a Content-Encoding. So responses are decompressed
+// here rather than by net/http, after their checksum has been verified.
+
+const (
+	ACCEPT_ENCODING_HDR  = "Accept-Encoding"
+	CONTENT_ENCODING_HDR = "Content-Encoding"
+	GZIP                 = "gzip"
+)
+
+// ErrChecksumMismatch is returned when a response body does not match its X-Amz-Crc32
+// header. The body was corrupted in transport, and the request may be retried.
+var ErrChecksumMismatch = errors.New("auth_v4: response body does not match X-Amz-Crc32")
+
+var decoders struct {
+	lock sync.RWMutex
+	m map[string] func(io.Reader) (io.Reader,error)
+	accept string
+}
+
+func init() {
+	RegisterDecoder(GZIP,func(r io.Reader) (io.Reader,error) { return gzip.NewReader(r) })
+}
+
+// RegisterDecoder adds a decoder for Content-Encoding `encoding` and includes the encoding
+// in the Accept-Encoding of requests. A nil decoder removes the encoding.
+func RegisterDecoder(encoding string,decoder func(io.Reader) (io.Reader,error)) {
+	decoders.lock.Lock()
+	defer decoders.lock.Unlock()
+	if decoders.m == nil {
+		decoders.m = make(map[string] func(io.Reader) (io.Reader,error))
+	}
+	if decoder == nil {
+		delete(decoders.m,encoding)
+	} else {
+		decoders.m[encoding] = decoder
+	}
+	names := make([]string,0,len(decoders.m))
+	for name,_ := range decoders.m {
+		names = append(names,name)
+	}
+	sort.Strings(names)
+	decoders.accept = strings.Join(names,", ")
+}
+
+// acceptEncoding sets the Accept-Encoding of request to the registered encodings. Setting
+// it also stops net/http from transparently decompressing the response.
+func acceptEncoding(request *http.Request) {
+	decoders.lock.RLock()
+	accept := decoders.accept
+	decoders.lock.RUnlock()
+	if accept == "" {
+		accept = "identity"
+	}
+	request.Header.Set(ACCEPT_ENCODING_HDR,accept)
+}
+
+// readBody reads the body of response, verifies it against X-Amz-Crc32 (when
+// present), and decodes it according to its Content-Encoding.
+func readBody(response *http.Response) ([]byte,error) {
+	raw,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil && read_err != io.EOF {
+		return nil,read_err
+	}
+	if len(response.Header[aws_crc32_hdr]) != 0 {
+		if ok,crc_err := MatchCheckSum(*response,raw); crc_err != nil {
+			return nil,crc_err
+		} else if !ok {
+			return nil,ErrChecksumMismatch
+		}
+	}
+	encoding := strings.TrimSpace(response.Header.Get(CONTENT_ENCODING_HDR))
+	if encoding == "" || encoding == "identity" {
+		return raw,nil
+	}
+	decoders.lock.RLock()
+	decoder,ok := decoders.m[encoding]
+	decoders.lock.RUnlock()
+	if !ok {
+		e := fmt.Sprintf("auth_v4.readBody: no decoder for Content-Encoding %s",encoding)
+		return nil,errors.New(e)
+	}
+	dr,dr_err := decoder(bytes.NewReader(raw))
+	if dr_err != nil {
+		e := fmt.Sprintf("auth_v4.readBody: %s: %s",encoding,dr_err.Error())
+		return nil,errors.New(e)
+	}
+	body,body_err := ioutil.ReadAll(dr)
+	if body_err != nil {
+		e := fmt.Sprintf("auth_v4.readBody: %s: %s",encoding,body_err.Error())
+		return nil,errors.New(e)
+	}
+	return body,nil
+}
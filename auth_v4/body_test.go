@@ -0,0 +1,201 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"context"
+	"testing"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"hash/crc32"
+	"compress/gzip"
+	"net/http/httptest"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const crcBody = `{"TableNames":["a","b"]}`
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte,len(b))
+	for i,c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+func crcOf(b []byte) string {
+	return fmt.Sprintf("%d",crc32.ChecksumIEEE(b))
+}
+
+// crcResponse returns a response with body raw, the X-Amz-Crc32 crc (none if empty) and
+// the Content-Encoding encoding (none if empty).
+func crcResponse(raw []byte,crc,encoding string) *http.Response {
+	response := &http.Response{Header:make(http.Header),
+		Body:ioutil.NopCloser(bytes.NewReader(raw))}
+	if crc != "" {
+		response.Header.Set(aws_crc32_hdr,crc)
+	}
+	if encoding != "" {
+		response.Header.Set(CONTENT_ENCODING_HDR,encoding)
+	}
+	return response
+}
+
+var crcTests = []struct {
+	name string
+	raw []byte
+	crc string
+	encoding string
+	// the error expected, if any: ErrChecksumMismatch, or any other for "other"
+	mismatch bool
+	other bool
+}{
+	{name:"identity",raw:[]byte(crcBody),crc:crcOf([]byte(crcBody))},
+	{name:"mismatch",raw:[]byte(crcBody),crc:crcOf([]byte("corrupt")),mismatch:true},
+	{name:"no crc",raw:[]byte(crcBody)},
+	{name:"malformed crc",raw:[]byte(crcBody),crc:"x",other:true},
+	// the checksum is over the body as sent, not as decoded
+	{name:"gzip",raw:gzipped(crcBody),crc:crcOf(gzipped(crcBody)),encoding:GZIP},
+	{name:"gzip decoded crc",raw:gzipped(crcBody),crc:crcOf([]byte(crcBody)),encoding:GZIP,
+		mismatch:true},
+	{name:"gzip no crc",raw:gzipped(crcBody),encoding:GZIP},
+	{name:"unknown encoding",raw:[]byte(crcBody),crc:crcOf([]byte(crcBody)),encoding:"br",
+		other:true},
+}
+
+func checkCRC(t *testing.T,name string,mismatch,other bool,body []byte,err error) {
+	switch {
+	case mismatch:
+		if err != ErrChecksumMismatch {
+			t.Errorf("%s: got %v, want ErrChecksumMismatch\n",name,err)
+		}
+	case other:
+		if err == nil || err == ErrChecksumMismatch {
+			t.Errorf("%s: got %v, want an error other than a mismatch\n",name,err)
+		}
+	case err != nil:
+		t.Errorf("%s: %s\n",name,err.Error())
+	case string(body) != crcBody:
+		t.Errorf("%s: got body %q, want %q\n",name,string(body),crcBody)
+	}
+}
+
+func TestReadBodyCRC(t *testing.T) {
+	for _,c := range crcTests {
+		body,err := readBody(crcResponse(c.raw,c.crc,c.encoding))
+		checkCRC(t,c.name,c.mismatch,c.other,body,err)
+	}
+}
+
+func TestStreamBodyCRC(t *testing.T) {
+	for _,c := range crcTests {
+		rc,err := streamBody(crcResponse(c.raw,c.crc,c.encoding))
+		var body []byte
+		if err == nil {
+			body,err = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+		checkCRC(t,c.name,c.mismatch,c.other,body,err)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	raw := reverse([]byte(crcBody))
+	response := func() *http.Response { return crcResponse(raw,crcOf(raw),"reversed") }
+	if _,err := readBody(response()); err == nil {
+		t.Errorf("decoded an unregistered encoding\n")
+	}
+	RegisterDecoder("reversed",func(r io.Reader) (io.Reader,error) {
+		b,err := ioutil.ReadAll(r)
+		return bytes.NewReader(reverse(b)),err
+	})
+	request,_ := http.NewRequest("POST","http://localhost/",nil)
+	acceptEncoding(request)
+	if accept := request.Header.Get(ACCEPT_ENCODING_HDR); accept != "gzip, reversed" {
+		t.Errorf("Accept-Encoding %q, want %q\n",accept,"gzip, reversed")
+	}
+	body,err := readBody(response())
+	checkCRC(t,"registered",false,false,body,err)
+	rc,stream_err := streamBody(response())
+	if stream_err == nil {
+		body,err = ioutil.ReadAll(rc)
+		checkCRC(t,"registered stream",false,false,body,err)
+	} else {
+		t.Errorf("registered stream: %s\n",stream_err.Error())
+	}
+	RegisterDecoder("reversed",nil)
+	acceptEncoding(request)
+	if accept := request.Header.Get(ACCEPT_ENCODING_HDR); accept != GZIP {
+		t.Errorf("Accept-Encoding %q, want %q\n",accept,GZIP)
+	}
+	if _,err := readBody(response()); err == nil {
+		t.Errorf("decoded a removed encoding\n")
+	}
+}
+
+// A corrupted body is reported as ErrChecksumMismatch, which authreq retries, rather
+// than as a failure to read it.
+func TestRawReqChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		w.Header().Set(aws_crc32_hdr,crcOf([]byte("corrupt")))
+		w.Write([]byte(crcBody))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	_,_,_,err := RawReqContext(context.Background(),[]byte(`{}`),"DynamoDB_20120810.ListTables")
+	if err != ErrChecksumMismatch {
+		t.Errorf("RawReqContext: got %v, want ErrChecksumMismatch\n",err)
+	}
+	rc,_,_,stream_err := RawReqStreamContext(context.Background(),[]byte(`{}`),
+		"DynamoDB_20120810.ListTables")
+	if stream_err != nil {
+		t.Fatalf("RawReqStreamContext: %s\n",stream_err.Error())
+	}
+	defer rc.Close()
+	if _,err := ioutil.ReadAll(rc); err != ErrChecksumMismatch {
+		t.Errorf("RawReqStreamContext read: got %v, want ErrChecksumMismatch\n",err)
+	}
+}
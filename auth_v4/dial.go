@@ -0,0 +1,48 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"net"
+	"context"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// happyDial dials addr with "Happy Eyeballs" (RFC 6555) connection racing: when the host
+// has both IPv6 and IPv4 addresses, an attempt on the other family starts if the first
+// has not connected within the configured ConnectAttemptDelay, and the first connection
+// established wins. This keeps a broken IPv6 path from adding seconds of connect latency
+// to every new connection. The racing is that of net.Dialer, and so ends with ctx.
+func happyDial(ctx context.Context,network,addr string) (net.Conn,error) {
+	conf.Vals.ConfLock.RLock()
+	delay := conf.Vals.Network.DynamoDB.ConnectAttemptDelay
+	conf.Vals.ConfLock.RUnlock()
+	if delay <= 0 {
+		delay = conf.CONNECT_ATTEMPT_DELAY
+	}
+	d := *dialer
+	d.FallbackDelay = delay
+	return d.DialContext(ctx,network,addr)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"net"
+	"time"
+	"context"
+	"testing"
+)
+
+func TestHappyDial(t *testing.T) {
+	l,err := net.Listen("tcp","127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %s\n",err.Error())
+	}
+	defer l.Close()
+	_,port,_ := net.SplitHostPort(l.Addr().String())
+	// localhost may also resolve to ::1, where nothing listens
+	c,err := happyDial(context.Background(),"tcp",net.JoinHostPort("localhost",port))
+	if err != nil {
+		t.Fatalf("happyDial: %s\n",err.Error())
+	}
+	c.Close()
+}
+
+// A dial that cannot connect ends with its context.
+func TestHappyDialCancel(t *testing.T) {
+	ctx,cancel := context.WithTimeout(context.Background(),100 * time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// a TEST-NET-1 address, which does not answer
+	_,err := happyDial(ctx,"tcp","192.0.2.1:443")
+	if err == nil {
+		t.Fatalf("connected to a blackhole\n")
+	}
+	if d := time.Since(start); d > 2 * time.Second {
+		t.Errorf("dial took %v after its context ended\n",d)
+	}
+}
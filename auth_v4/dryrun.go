@@ -0,0 +1,63 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"errors"
+	"context"
+	"net/http"
+)
+
+// ErrDryRun is returned in place of a response for a request made with WithDryRun.
+var ErrDryRun = errors.New("auth_v4: dry run, request not sent")
+
+// DryRun receives the request a dry run prepared: the signed http request, with its
+// body unread, the body itself and the canonical request its signature was computed over.
+type DryRun struct {
+	Request *http.Request
+	Body []byte
+	CanonicalRequest string
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context whose requests are marshaled, validated and signed as
+// usual but not sent. The prepared request is stored in d, and ErrDryRun returned (and
+// not retried, see authreq). With several requests on the context d holds the last.
+func WithDryRun(ctx context.Context,d *DryRun) context.Context {
+	return context.WithValue(ctx,dryRunKey{},d)
+}
+
+// dryRun stores the prepared request in the DryRun of ctx, reporting whether there is one.
+func dryRun(ctx context.Context,request *http.Request,reqJSON []byte,canonical_request string) bool {
+	d,ok := ctx.Value(dryRunKey{}).(*DryRun)
+	if !ok || d == nil {
+		return false
+	}
+	d.Request = request
+	d.Body = reqJSON
+	d.CanonicalRequest = canonical_request
+	return true
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// REDACTED replaces credentials and signatures in log output.
+	REDACTED = "<redacted>"
+	// the most secrets kept by RegisterSecrets; the oldest are dropped
+	MAX_REGISTERED_SECRETS = 64
+)
+
+// secrets of credentials held outside the configurations, see RegisterSecrets
+var registered struct {
+	lock sync.Mutex
+	s []string
+}
+
+// RegisterSecrets adds secret keys and session tokens to those Redact replaces, for
+// credentials that are not held in a configuration, such as those of the intermediate
+// roles of a conf_iam.RoleChain. The most recent MAX_REGISTERED_SECRETS are kept.
+func RegisterSecrets(secrets ...string) {
+	registered.lock.Lock()
+	defer registered.lock.Unlock()
+	for _,v := range secrets {
+		if v == "" {
+			continue
+		}
+		registered.s = append(registered.s,v)
+	}
+	if n := len(registered.s); n > MAX_REGISTERED_SECRETS {
+		registered.s = append([]string(nil),registered.s[n - MAX_REGISTERED_SECRETS:]...)
+	}
+}
+
+// redact_res match credentials as they may be quoted in error responses and requests:
+// Authorization and security token header values (as in the canonical request of an
+// InvalidSignatureException), signatures, and the secrets of JSON credentials.
+var redact_res = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(authorization\s*[:=]\s*)[^\n"\\]+`),
+	regexp.MustCompile(`(?i)(x-amz-security-token\s*[:=]\s*)[^\s"\\,;]+`),
+	regexp.MustCompile(`(?i)(Signature=)[0-9a-f]+`),
+	regexp.MustCompile(`(?i)("(?:SecretAccessKey|SessionToken|Token|secret_access_key|session_token)"\s*:\s*")[^"]*`),
+}
+
+// secrets returns the secret keys and session tokens of all configurations, and those
+// registered with RegisterSecrets.
+func secrets() []string {
+	confs := []*conf.AWS_Conf{&conf.Vals}
+	for _,name := range conf.Names() {
+		if c := conf.Lookup(name); c != nil && c != &conf.Vals {
+			confs = append(confs,c)
+		}
+	}
+	var s []string
+	for _,c := range confs {
+		c.ConfLock.RLock()
+		for _,v := range []string{c.Auth.Secret,c.Auth.Token,c.IAM.Credentials.Secret,
+			c.IAM.Credentials.Token} {
+			if v != "" {
+				s = append(s,v)
+			}
+		}
+		c.ConfLock.RUnlock()
+	}
+	registered.lock.Lock()
+	s = append(s,registered.s...)
+	registered.lock.Unlock()
+	return s
+}
+
+// Redact returns s with Authorization headers, signatures, secret keys and session tokens
+// replaced by REDACTED, for writing to logs. The secrets of the configurations are
+// redacted wherever they appear.
+func Redact(s string) string {
+	for _,secret := range secrets() {
+		s = strings.Replace(s,secret,REDACTED,-1)
+	}
+	for _,re := range redact_res {
+		s = re.ReplaceAllString(s,"${1}" + REDACTED)
+	}
+	return s
+}
+
+// Logf is log.Printf with the message passed through Redact. The log output of auth_v4
+// and authreq goes through it.
+func Logf(format string,v ...interface{}) {
+	log.Print(Redact(fmt.Sprintf(format,v...)))
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"testing"
+	"strings"
+	"github.com/smugmug/godynamo/conf"
+)
+
+func TestRedact(t *testing.T) {
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Auth.Secret = "confSecretKEY0123456789"
+	conf.Vals.ConfLock.Unlock()
+	// the session token of an intermediate role of a chain, held in no configuration
+	RegisterSecrets("hopSessionTOKEN//abc+def=","")
+	cases := []struct {
+		name string
+		in string
+		hidden []string
+	}{
+		{"authorization header",
+			"Authorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/dynamodb/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a",
+			[]string{"AKIDEXAMPLE","5fa00fa31553b73ebf1942676e86291e8372ff2a"}},
+		{"signature",
+			`The request signature we calculated does not match. Signature=0123456789abcdef`,
+			[]string{"0123456789abcdef"}},
+		{"security token header",
+			"x-amz-security-token:FQoGZXIvYXdzEJr//////////wEaDM\nhost:dynamodb",
+			[]string{"FQoGZXIvYXdzEJr"}},
+		{"json credentials",
+			`{"AccessKeyId":"ASIA1","SecretAccessKey":"jsonSecret/+=","SessionToken":"jsonToken=="}`,
+			[]string{"jsonSecret","jsonToken"}},
+		{"secret of the configuration",
+			"signing with confSecretKEY0123456789 failed",
+			[]string{"confSecretKEY0123456789"}},
+		{"chained role session token",
+			"request body: token hopSessionTOKEN//abc+def= rejected",
+			[]string{"hopSessionTOKEN"}},
+	}
+	for _,c := range cases {
+		out := Redact(c.in)
+		for _,h := range c.hidden {
+			if strings.Contains(out,h) {
+				t.Errorf("%s: %s not redacted from %s\n",c.name,h,out)
+			}
+		}
+		if !strings.Contains(out,REDACTED) {
+			t.Errorf("%s: nothing redacted from %s\n",c.name,out)
+		}
+	}
+	if out := Redact("GetItem on table Users"); out != "GetItem on table Users" {
+		t.Errorf("message without credentials changed to %s\n",out)
+	}
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Auth.Secret = ""
+	conf.Vals.ConfLock.Unlock()
+}
+
+func TestRegisterSecretsBound(t *testing.T) {
+	for i := 0; i < MAX_REGISTERED_SECRETS + 10; i++ {
+		RegisterSecrets(strings.Repeat("secret",i + 1))
+	}
+	registered.lock.Lock()
+	n := len(registered.s)
+	registered.s = nil
+	registered.lock.Unlock()
+	if n != MAX_REGISTERED_SECRETS {
+		t.Errorf("kept %d secrets\n",n)
+	}
+}
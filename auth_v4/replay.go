@@ -0,0 +1,131 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/auth_v4/tasks"
+)
+
+// Capture is a prepared request in a form that can be saved, shared and replayed: its
+// target, body and extra headers, without the signature, date and security token that
+// are replaced when it is signed again. Host is where it was prepared to go, for reference.
+type Capture struct {
+	AmzTarget string
+	Body json.RawMessage
+	Headers map[string]string `json:",omitempty"`
+	Host string
+	Time time.Time
+}
+
+// Capture returns the request of a dry run as a Capture.
+func (d *DryRun) Capture() (*Capture,error) {
+	if d.Request == nil {
+		return nil,errors.New("auth_v4.DryRun.Capture: no request was prepared")
+	}
+	c := &Capture{AmzTarget:d.Request.Header.Get(aws_const.AMZ_TARGET_HDR),
+		Body:json.RawMessage(d.Body),Host:d.Request.URL.Host,Time:time.Now().UTC()}
+	for k,_ := range d.Request.Header {
+		switch strings.ToLower(k) {
+		case "authorization","accept-encoding",
+			strings.ToLower(aws_const.CONTENT_TYPE_HDR),
+			strings.ToLower(aws_const.AMZ_TARGET_HDR),
+			strings.ToLower(aws_const.X_AMZ_DATE_HDR),
+			strings.ToLower(aws_const.X_AMZ_SECURITY_TOKEN_HDR),
+			strings.ToLower(tasks.X_AMZ_REGION_SET_HDR):
+			continue
+		}
+		if c.Headers == nil {
+			c.Headers = make(map[string]string)
+		}
+		c.Headers[k] = d.Request.Header.Get(k)
+	}
+	return c,nil
+}
+
+// ReadCapture reads a Capture saved by Save.
+func ReadCapture(path string) (*Capture,error) {
+	b,read_err := ioutil.ReadFile(path)
+	if read_err != nil {
+		e := fmt.Sprintf("auth_v4.ReadCapture: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	c := new(Capture)
+	if um_err := json.Unmarshal(b,c); um_err != nil {
+		e := fmt.Sprintf("auth_v4.ReadCapture: cannot unmarshal %s: %s",path,um_err.Error())
+		return nil,errors.New(e)
+	}
+	return c,nil
+}
+
+// Save writes the Capture to path, replacing the file atomically.
+func (c *Capture) Save(path string) error {
+	b,json_err := json.MarshalIndent(c,"","\t")
+	if json_err != nil {
+		e := fmt.Sprintf("auth_v4.Capture.Save: %s",json_err.Error())
+		return errors.New(e)
+	}
+	tmp,tmp_err := ioutil.TempFile(filepath.Dir(path),filepath.Base(path) + ".")
+	if tmp_err != nil {
+		e := fmt.Sprintf("auth_v4.Capture.Save: %s",tmp_err.Error())
+		return errors.New(e)
+	}
+	_,write_err := tmp.Write(b)
+	close_err := tmp.Close()
+	if write_err == nil {
+		write_err = close_err
+	}
+	if write_err == nil {
+		write_err = os.Rename(tmp.Name(),path)
+	}
+	if write_err != nil {
+		os.Remove(tmp.Name())
+		e := fmt.Sprintf("auth_v4.Capture.Save: %s",write_err.Error())
+		return errors.New(e)
+	}
+	return nil
+}
+
+// Replay sends the request of c once, as RawReqContext does, signed afresh with the
+// current credentials and clock of the configuration ctx directs it to (see conf.WithName),
+// with the headers of c added to any set on ctx. The body is sent byte for byte as captured.
+func Replay(ctx context.Context,c Capture) (string,string,int,error) {
+	if c.AmzTarget == "" {
+		return "","",0,errors.New("auth_v4.Replay: the capture has no AmzTarget")
+	}
+	if len(c.Headers) != 0 {
+		ctx = WithHeaders(ctx,c.Headers)
+	}
+	return RawReqContext(ctx,[]byte(c.Body),c.AmzTarget)
+}
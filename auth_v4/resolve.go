@@ -0,0 +1,287 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"io"
+	"sync"
+	"time"
+	"context"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// trackedConn wraps a pooled connection so that it can be found and closed when
+// the address it is connected to is no longer published for the endpoint.
+type trackedConn struct {
+	net.Conn
+	// the host dialed, and the address it was connected to
+	host string
+	ip string
+	once sync.Once
+	// the requests using the connection, and whether its address is no longer
+	// returned for the host; both guarded by conns
+	busy int
+	stale bool
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		conns.Lock()
+		delete(conns.m,c)
+		conns.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// trackedOf returns the trackedConn underlying c, which may be a tls.Conn over one.
+func trackedOf(c net.Conn) *trackedConn {
+	if tc,ok := c.(*trackedConn); ok {
+		return tc
+	}
+	if wrapped,ok := c.(interface{ NetConn() net.Conn }); ok {
+		return trackedOf(wrapped.NetConn())
+	}
+	return nil
+}
+
+// acquire marks c as in use by a request.
+func (c *trackedConn) acquire() {
+	conns.Lock()
+	c.busy++
+	conns.Unlock()
+}
+
+// release gives up a request's use of c, closing c if it has gone stale in the meantime.
+func (c *trackedConn) release() {
+	conns.Lock()
+	c.busy--
+	closing := c.stale && c.busy == 0
+	conns.Unlock()
+	if closing {
+		Logf("auth_v4.Resolve: recycling connection to stale address %s\n",c.ip)
+		c.Close()
+	}
+}
+
+// releasingBody releases the connection of a response when its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// send sends request with client, holding the tracked connection it is sent on in use
+// until the response body is closed, so that Resolve does not close it mid-request.
+func send(client *http.Client,request *http.Request) (*http.Response,error) {
+	var lock sync.Mutex
+	var used *trackedConn
+	done := func() {
+		lock.Lock()
+		c := used
+		used = nil
+		lock.Unlock()
+		if c != nil {
+			c.release()
+		}
+	}
+	trace := &httptrace.ClientTrace{GotConn:func(info httptrace.GotConnInfo) {
+		c := trackedOf(info.Conn)
+		if c == nil {
+			return
+		}
+		c.acquire()
+		// the transport may retry on another connection
+		done()
+		lock.Lock()
+		used = c
+		lock.Unlock()
+	}}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(),trace))
+	response,err := client.Do(request)
+	if err != nil {
+		done()
+		return nil,err
+	}
+	response.Body = &releasingBody{ReadCloser:response.Body,done:done}
+	return response,nil
+}
+
+// conns is the set of open connections created by the package-scoped Client.
+var conns = struct {
+	sync.Mutex
+	m map[*trackedConn] bool
+}{m:make(map[*trackedConn] bool)}
+
+var resolveOnce sync.Once
+
+// closed to signal the resolver goroutine to exit; resolving tracks that goroutine.
+var (
+	stopResolve = make(chan bool)
+	stopResolveOnce sync.Once
+	resolving sync.WaitGroup
+)
+
+// while paused, the resolver goroutine skips its periodic lookups.
+var resolvePause struct {
+	sync.Mutex
+	paused bool
+}
+
+var dialer = &net.Dialer{Timeout:time.Duration(10) * time.Second,
+	KeepAlive:time.Duration(30) * time.Second}
+
+// dialTracked dials addr and registers the resulting connection with conns.
+func dialTracked(ctx context.Context,network,addr string) (net.Conn,error) {
+	c,err := happyDial(ctx,network,addr)
+	if err != nil {
+		return nil,err
+	}
+	ip := ""
+	if tcp_addr,ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		ip = tcp_addr.IP.String()
+	}
+	host,_,split_err := net.SplitHostPort(addr)
+	if split_err != nil {
+		host = addr
+	}
+	tc := &trackedConn{Conn:c,host:host,ip:ip}
+	conns.Lock()
+	conns.m[tc] = true
+	conns.Unlock()
+	return tc,nil
+}
+
+// startResolver launches the goroutine that periodically re-resolves the DynamoDB host.
+// It is started lazily on the first request, since the conf is read after package init.
+func startResolver() {
+	conf.Vals.ConfLock.RLock()
+	interval := conf.Vals.Network.DynamoDB.ResolveInterval
+	conf.Vals.ConfLock.RUnlock()
+	if interval <= 0 {
+		interval = conf.RESOLVE_INTERVAL
+	}
+	resolving.Add(1)
+	go func() {
+		defer resolving.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <- ticker.C:
+				resolvePause.Lock()
+				paused := resolvePause.paused
+				resolvePause.Unlock()
+				if !paused {
+					Resolve()
+				}
+			case <- stopResolve:
+				return
+			}
+		}
+	}()
+}
+
+// StopResolver signals the resolver goroutine to exit and waits for it to do so.
+func StopResolver() {
+	// make sure a later request cannot start a new resolver
+	resolveOnce.Do(func() {})
+	stopResolveOnce.Do(func() { close(stopResolve) })
+	resolving.Wait()
+}
+
+// PauseResolver suspends the periodic host lookups until ResumeResolver is called.
+// Open connections are left as they are.
+func PauseResolver() {
+	resolvePause.Lock()
+	resolvePause.paused = true
+	resolvePause.Unlock()
+}
+
+// ResumeResolver restarts the periodic host lookups suspended by PauseResolver.
+func ResumeResolver() {
+	resolvePause.Lock()
+	resolvePause.paused = false
+	resolvePause.Unlock()
+}
+
+// Resolve looks up the DynamoDB host and closes any open connections to it at addresses
+// that are no longer returned. Connections to the hosts of named configurations (see
+// conf.WithName) are left alone. This lets the client recover from AWS load balancer
+// rotations instead of riding a dead connection into a timeout. A connection with a
+// request in flight is only marked stale, and is closed once its response body is.
+// The conf is not changed: Network.DynamoDB.IP remains the address found when it was read.
+func Resolve() {
+	conf.Vals.ConfLock.RLock()
+	host := conf.Vals.Network.DynamoDB.Host
+	conf.Vals.ConfLock.RUnlock()
+	addrs,addrs_err := net.LookupIP(host)
+	if addrs_err != nil || len(addrs) == 0 {
+		// keep what we have; a failed lookup is not evidence the addresses are gone
+		Logf("auth_v4.Resolve: cannot look up hostname %s: %v\n",host,addrs_err)
+		return
+	}
+	live := make(map[string] bool)
+	for _,a := range addrs {
+		live[a.String()] = true
+	}
+	stale := make([]*trackedConn,0)
+	conns.Lock()
+	for c,_ := range conns.m {
+		if c.host == host && c.ip != "" && !live[c.ip] {
+			c.stale = true
+			if c.busy == 0 {
+				stale = append(stale,c)
+			}
+		}
+	}
+	conns.Unlock()
+	for _,c := range stale {
+		Logf("auth_v4.Resolve: recycling connection to stale address %s\n",c.ip)
+		c.Close()
+	}
+}
+
+// Recycle closes every open connection, such as after the endpoint has been changed by a
+// conf reload, so the next requests dial the new endpoint.
+func Recycle() {
+	all := make([]*trackedConn,0)
+	conns.Lock()
+	for c,_ := range conns.m {
+		all = append(all,c)
+	}
+	conns.Unlock()
+	for _,c := range all {
+		c.Close()
+	}
+}
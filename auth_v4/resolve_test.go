@@ -0,0 +1,128 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// isTracked reports whether c is still registered as open.
+func isTracked(c *trackedConn) bool {
+	conns.Lock()
+	defer conns.Unlock()
+	return conns.m[c]
+}
+
+// useHost points the default conf at host, returning a func restoring it.
+func useHost(host string) func() {
+	conf.Vals.ConfLock.Lock()
+	old := conf.Vals.Network.DynamoDB.Host
+	conf.Vals.Network.DynamoDB.Host = host
+	conf.Vals.ConfLock.Unlock()
+	return func() {
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.Network.DynamoDB.Host = old
+		conf.Vals.ConfLock.Unlock()
+	}
+}
+
+func TestResolveStale(t *testing.T) {
+	defer useHost("localhost")()
+	track := func(ip string) *trackedConn {
+		a,b := net.Pipe()
+		go ioutil.ReadAll(b)
+		tc := &trackedConn{Conn:a,host:"localhost",ip:ip}
+		conns.Lock()
+		conns.m[tc] = true
+		conns.Unlock()
+		return tc
+	}
+	idle := track("192.0.2.1")
+	busy := track("192.0.2.2")
+	live := track("127.0.0.1")
+	defer live.Close()
+	busy.acquire()
+	Resolve()
+	if isTracked(idle) {
+		t.Errorf("idle connection to a stale address was not closed\n")
+	}
+	if !isTracked(busy) {
+		t.Errorf("connection in use was closed\n")
+	}
+	if !isTracked(live) {
+		t.Errorf("connection to a live address was closed\n")
+	}
+	busy.release()
+	if isTracked(busy) {
+		t.Errorf("stale connection was not closed once released\n")
+	}
+}
+
+func TestResolveInFlight(t *testing.T) {
+	defer useHost("localhost")()
+	const body = "a response body"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport:&http.Transport{DialContext:dialTracked}}
+	url := strings.Replace(ts.URL,"127.0.0.1","localhost",1)
+	request,_ := http.NewRequest("GET",url,nil)
+	response,err := send(client,request)
+	if err != nil {
+		t.Fatalf("send: %s\n",err.Error())
+	}
+	var used *trackedConn
+	conns.Lock()
+	for c,_ := range conns.m {
+		if c.busy > 0 {
+			used = c
+			// pretend the address has gone from DNS
+			c.ip = "192.0.2.3"
+		}
+	}
+	conns.Unlock()
+	if used == nil {
+		t.Fatalf("no connection in use\n")
+	}
+	Resolve()
+	read,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil || string(read) != body {
+		t.Errorf("read %q,%v; want %q\n",string(read),read_err,body)
+	}
+	if !isTracked(used) {
+		t.Errorf("connection closed with a response outstanding\n")
+	}
+	response.Body.Close()
+	if isTracked(used) {
+		t.Errorf("stale connection not closed with the response body\n")
+	}
+}
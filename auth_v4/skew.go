@@ -0,0 +1,93 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"time"
+	"regexp"
+	"strings"
+	"net/http"
+	"sync/atomic"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/aws_errors"
+)
+
+// server_time_re matches the server time quoted in the message of a skew error, as in
+// "Signature expired: 20130101T000000Z is now earlier than 20130101T001000Z
+// (20130101T001500Z - 5 min.)".
+var server_time_re = regexp.MustCompile(`\(([0-9]{8}T[0-9]{6}Z) [-+] [0-9]+ min\.\)`)
+
+// skew is the offset (in nanoseconds) added to the local clock when signing requests.
+var skew int64
+
+// ClockSkew returns the offset currently applied to the local clock when signing.
+func ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&skew))
+}
+
+// SigningTime returns the local time corrected by the measured clock skew, for signing
+// requests to AWS services other than DynamoDB.
+func SigningTime() time.Time {
+	return time.Now().Add(ClockSkew())
+}
+
+// IsSkewError determines if an error response body is AWS rejecting a request because
+// its signing time is too far from the server time, behind it or ahead of it.
+func IsSkewError(resp_body string) bool {
+	return strings.Contains(resp_body,aws_const.TOO_SKEWED_MSG) ||
+		(aws_errors.Is(resp_body,aws_errors.INVALID_SIGNATURE) &&
+		(strings.Contains(resp_body,aws_const.SIGNATURE_EXPIRED_MSG) ||
+		strings.Contains(resp_body,aws_const.SIGNATURE_NOT_YET_MSG)))
+}
+
+// serverTime returns the server time of a skew error response, from its Date header or,
+// failing that, as quoted in its message.
+func serverTime(response *http.Response,resp_body string) (time.Time,error) {
+	server_time,date_err := http.ParseTime(response.Header.Get(aws_const.DATE_HDR))
+	if date_err == nil {
+		return server_time,nil
+	}
+	if m := server_time_re.FindStringSubmatch(resp_body); m != nil {
+		return time.Parse(aws_const.ISO8601FMT_CONDENSED,m[1])
+	}
+	return time.Time{},date_err
+}
+
+// correctSkew measures the skew from the server time of a response that failed with
+// a skew error, and applies it to subsequent signing timestamps.
+func correctSkew(response *http.Response,resp_body string,sent time.Time) {
+	if response.StatusCode != http.StatusBadRequest || !IsSkewError(resp_body) {
+		return
+	}
+	server_time,date_err := serverTime(response,resp_body)
+	if date_err != nil {
+		Logf("auth_v4.correctSkew: skew error but no usable server time: %v\n",date_err)
+		return
+	}
+	// the Date header has second resolution, so compare against the send time in seconds
+	offset := server_time.Sub(sent.Truncate(time.Second))
+	atomic.StoreInt64(&skew,int64(offset))
+	Logf("auth_v4.correctSkew: server clock differs by %v, correcting\n",offset)
+}
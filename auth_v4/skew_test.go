@@ -0,0 +1,127 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"sync/atomic"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+const (
+	skewBody = `{"__type":"com.amazon.coral.service#InvalidSignatureException",` +
+		`"message":"Signature expired: 20130101T000000Z is now earlier than 20130101T001000Z ` +
+		`(20130101T001500Z - 5 min.)"}`
+	deniedBody = `{"__type":"com.amazon.coral.service#AccessDeniedException","message":"denied"}`
+)
+
+func skewResponse(status int,date string) *http.Response {
+	response := &http.Response{StatusCode:status,Header:make(http.Header)}
+	if date != "" {
+		response.Header.Set(aws_const.DATE_HDR,date)
+	}
+	return response
+}
+
+func setSkew(d time.Duration) {
+	atomic.StoreInt64(&skew,int64(d))
+}
+
+func TestServerTime(t *testing.T) {
+	// the Date header, when there is one
+	got,err := serverTime(skewResponse(http.StatusBadRequest,"Tue, 01 Jan 2013 02:00:00 GMT"),skewBody)
+	if err != nil || !got.Equal(time.Date(2013,1,1,2,0,0,0,time.UTC)) {
+		t.Errorf("server time %v,%v from the Date header\n",got,err)
+	}
+	// otherwise the time quoted in the message
+	got,err = serverTime(skewResponse(http.StatusBadRequest,""),skewBody)
+	if err != nil || !got.Equal(time.Date(2013,1,1,0,15,0,0,time.UTC)) {
+		t.Errorf("server time %v,%v from the message\n",got,err)
+	}
+	got,err = serverTime(skewResponse(http.StatusBadRequest,"yesterday"),skewBody)
+	if err != nil || !got.Equal(time.Date(2013,1,1,0,15,0,0,time.UTC)) {
+		t.Errorf("server time %v,%v with a malformed Date header\n",got,err)
+	}
+	if _,err := serverTime(skewResponse(http.StatusBadRequest,""),`{"message":"RequestTimeTooSkewed"}`); err == nil {
+		t.Errorf("server time without a Date header or a quoted time\n")
+	}
+}
+
+func TestCorrectSkew(t *testing.T) {
+	defer setSkew(0)
+	sent := time.Now()
+	ahead := sent.Add(time.Hour).UTC().Format(http.TimeFormat)
+	// a local clock running slow, then fast
+	setSkew(0)
+	correctSkew(skewResponse(http.StatusBadRequest,ahead),skewBody,sent)
+	if d := ClockSkew() - time.Hour; d < -time.Second || d > time.Second {
+		t.Errorf("skew %v, want an hour\n",ClockSkew())
+	}
+	behind := sent.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	correctSkew(skewResponse(http.StatusBadRequest,behind),skewBody,sent)
+	if d := ClockSkew() + time.Hour; d < -time.Second || d > time.Second {
+		t.Errorf("skew %v, want minus an hour\n",ClockSkew())
+	}
+	if d := SigningTime().Sub(time.Now().Add(-time.Hour)); d < -time.Second || d > time.Second {
+		t.Errorf("signing time %v not corrected\n",SigningTime())
+	}
+	// other responses, even with a Date header, leave the offset as it is
+	setSkew(time.Minute)
+	for _,r := range []struct {
+		status int
+		body string
+	}{
+		{http.StatusBadRequest,deniedBody},
+		{http.StatusForbidden,skewBody},
+		{http.StatusInternalServerError,skewBody},
+		{http.StatusOK,`{}`},
+	} {
+		correctSkew(skewResponse(r.status,ahead),r.body,sent)
+		if ClockSkew() != time.Minute {
+			t.Errorf("skew %v after a %d %s\n",ClockSkew(),r.status,r.body)
+		}
+	}
+	// nor does a skew error without a usable server time
+	correctSkew(skewResponse(http.StatusBadRequest,""),`{"message":"RequestTimeTooSkewed"}`,sent)
+	if ClockSkew() != time.Minute {
+		t.Errorf("skew %v after a skew error without a server time\n",ClockSkew())
+	}
+}
+
+func TestIsSkewError(t *testing.T) {
+	for body,want := range map[string] bool{
+		skewBody:true,
+		`{"__type":"com.amazon.coral.service#InvalidSignatureException","message":"Signature not yet current: x"}`:true,
+		`{"__type":"com.amazon.coral.service#RequestTimeTooSkewed","message":"x"}`:true,
+		`{"__type":"com.amazon.coral.service#InvalidSignatureException","message":"The request signature we calculated does not match"}`:false,
+		deniedBody:false,
+	} {
+		if IsSkewError(body) != want {
+			t.Errorf("IsSkewError(%s) != %v\n",body,want)
+		}
+	}
+}
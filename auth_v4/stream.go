@@ -0,0 +1,152 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auth_v4
+
+import (
+	"io"
+	"fmt"
+	"hash"
+	"bytes"
+	"errors"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"net/http"
+	"io/ioutil"
+	"hash/crc32"
+)
+
+// RawReqStreamContext is RawReqContext for responses too large to hold in memory. A
+// successful (200) response body is returned unread, to be read and closed by the caller.
+// It is decoded as it is read, and checked against X-Amz-Crc32 at its end: a mismatch is
+// returned from Read as ErrChecksumMismatch in place of io.EOF, after the corrupt bytes
+// have been consumed. Any other response body is read in full, as with RawReqContext, and
+// returned as an already read io.ReadCloser.
+func RawReqStreamContext(ctx context.Context,reqJSON []byte,amzTarget string) (io.ReadCloser,string,int,error) {
+	request,client,canonical_request,sign_err := signRequest(ctx,reqJSON,amzTarget)
+	if sign_err != nil {
+		return nil,"",0,sign_err
+	}
+	if dryRun(ctx,request,reqJSON,canonical_request) {
+		return nil,"",0,ErrDryRun
+	}
+	sent := time.Now()
+	response,rsp_err := send(client,request)
+	if rsp_err != nil {
+		return nil,"",0,rsp_err
+	}
+	amz_requestid,amz_requestid_err := GetRespReqID(*response)
+	if amz_requestid_err != nil {
+		response.Body.Close()
+		return nil,"",0,amz_requestid_err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		respbody,read_err := readBody(response)
+		if read_err == ErrChecksumMismatch {
+			return nil,"",0,read_err
+		} else if read_err != nil {
+			e := fmt.Sprintf("auth_v4.RawReqStream:err reading resp body: %s",read_err.Error())
+			return nil,"",0,errors.New(e)
+		}
+		correctSkew(response,string(respbody),sent)
+		return ioutil.NopCloser(bytes.NewReader(respbody)),amz_requestid,response.StatusCode,nil
+	}
+	body,body_err := streamBody(response)
+	if body_err != nil {
+		response.Body.Close()
+		return nil,"",0,body_err
+	}
+	return body,amz_requestid,response.StatusCode,nil
+}
+
+// checkedBody verifies the raw body it reads against the X-Amz-Crc32 of its response.
+type checkedBody struct {
+	raw io.ReadCloser
+	crc hash.Hash32
+	// the raw body, through crc
+	tee io.Reader
+	// tee, decoded per the Content-Encoding
+	decoded io.Reader
+	// the X-Amz-Crc32 of the response, if it has one
+	expected uint32
+	check bool
+}
+
+func (b *checkedBody) Read(p []byte) (int,error) {
+	n,err := b.decoded.Read(p)
+	if err != io.EOF {
+		return n,err
+	}
+	// the decoder may stop short of the end of the raw body
+	if _,drain_err := io.Copy(ioutil.Discard,b.tee); drain_err != nil {
+		return n,drain_err
+	}
+	if b.check && b.crc.Sum32() != b.expected {
+		return n,ErrChecksumMismatch
+	}
+	return n,io.EOF
+}
+
+func (b *checkedBody) Close() error {
+	return b.raw.Close()
+}
+
+// streamBody wraps the body of response to be decoded and verified as it is read.
+func streamBody(response *http.Response) (io.ReadCloser,error) {
+	b := &checkedBody{raw:response.Body,crc:crc32.NewIEEE()}
+	b.tee = io.TeeReader(response.Body,b.crc)
+	b.decoded = b.tee
+	if amz_crc_list,crc_ok := response.Header[aws_crc32_hdr]; crc_ok {
+		if len(amz_crc_list) != 1 {
+			return nil,errors.New("auth_v4.streamBody: X-Amz-Crc32 malformed")
+		}
+		amz_crc,amz_crc32_err := strconv.ParseUint(amz_crc_list[0],10,32)
+		if amz_crc32_err != nil {
+			return nil,errors.New("auth_v4.streamBody: X-Amz-Crc32 malformed")
+		}
+		b.expected = uint32(amz_crc)
+		b.check = true
+	}
+	encoding := strings.TrimSpace(response.Header.Get(CONTENT_ENCODING_HDR))
+	if encoding == "" || encoding == "identity" {
+		return b,nil
+	}
+	decoders.lock.RLock()
+	decoder,ok := decoders.m[encoding]
+	decoders.lock.RUnlock()
+	if !ok {
+		e := fmt.Sprintf("auth_v4.streamBody: no decoder for Content-Encoding %s",encoding)
+		return nil,errors.New(e)
+	}
+	dr,dr_err := decoder(b.tee)
+	if dr_err != nil {
+		e := fmt.Sprintf("auth_v4.streamBody: %s: %s",encoding,dr_err.Error())
+		return nil,errors.New(e)
+	}
+	b.decoded = dr
+	return b,nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package tasks
+
+import (
+	"sync"
+	"time"
+	"errors"
+	"math/big"
+	"crypto/rand"
+	"crypto/hmac"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/binary"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+const (
+	// the algorithm of asymmetric (SigV4A) signatures, which are valid in a set of
+	// regions rather than in one
+	SIGV4A_ALGORITHM = "AWS4-ECDSA-P256-SHA256"
+	// the header naming the regions a SigV4A signature is valid in
+	X_AMZ_REGION_SET_HDR = "X-Amz-Region-Set"
+)
+
+// the order of P-256, less two: derived keys must be below it
+var p256_n_minus_2 = new(big.Int).Sub(elliptic.P256().Params().N,big.NewInt(2))
+
+// the keys derived for each access key, with the secret they were derived from
+var v4aKeys struct {
+	lock sync.Mutex
+	m map[string] v4aKey
+}
+
+type v4aKey struct {
+	secret string
+	key *ecdsa.PrivateKey
+}
+
+// DeriveKeyV4A returns the P-256 private key SigV4A signs with for the accessKey/secret
+// pair, derived with the NIST SP 800-108 counter mode KDF of the SigV4A specification.
+// Keys are cached, as deriving one costs a scalar multiplication.
+func DeriveKeyV4A(accessKey,secret string) (*ecdsa.PrivateKey,error) {
+	v4aKeys.lock.Lock()
+	defer v4aKeys.lock.Unlock()
+	if k,ok := v4aKeys.m[accessKey]; ok && k.secret == secret {
+		return k.key,nil
+	}
+	input_key := []byte("AWS4A" + secret)
+	var block,bits [4]byte
+	binary.BigEndian.PutUint32(block[:],1)
+	binary.BigEndian.PutUint32(bits[:],256)
+	d := new(big.Int)
+	for counter := 1; ; counter++ {
+		if counter > 0xff {
+			return nil,errors.New("tasks.DeriveKeyV4A: exhausted the key derivation counter")
+		}
+		// i || label || 0x00 || access key || counter || L, for the one 256 bit block
+		h := hmac.New(sha256.New,input_key)
+		h.Write(block[:])
+		h.Write([]byte(SIGV4A_ALGORITHM))
+		h.Write([]byte{0})
+		h.Write([]byte(accessKey))
+		h.Write([]byte{byte(counter)})
+		h.Write(bits[:])
+		d.SetBytes(h.Sum(nil))
+		if d.Cmp(p256_n_minus_2) < 0 {
+			break
+		}
+	}
+	d.Add(d,big.NewInt(1))
+	key := new(ecdsa.PrivateKey)
+	key.PublicKey.Curve = elliptic.P256()
+	key.D = d
+	key.PublicKey.X,key.PublicKey.Y = elliptic.P256().ScalarBaseMult(d.Bytes())
+	if v4aKeys.m == nil {
+		v4aKeys.m = make(map[string] v4aKey)
+	}
+	v4aKeys.m[accessKey] = v4aKey{secret:secret,key:key}
+	return key,nil
+}
+
+// String2SignV4A will create the SigV4A `string to sign` from the `canonical request`.
+// Unlike String2Sign, the scope names no region; the regions are those signed in the
+// X-Amz-Region-Set header of the canonical request.
+func String2SignV4A(t time.Time,canonical_request,service string) string {
+	h := sha256.Sum256([]byte(canonical_request))
+	return SIGV4A_ALGORITHM + "\n" +
+		t.UTC().Format(aws_const.ISO8601FMT_CONDENSED) + "\n" +
+		t.UTC().Format(aws_const.ISODATEFMT) + "/" + service + "/aws4_request" + "\n" +
+		hex.EncodeToString(h[:])
+}
+
+// MakeSignatureV4A returns the SigV4A signature of the `string to sign`: the hex of the
+// DER encoded ECDSA signature of its SHA-256 digest by key.
+func MakeSignatureV4A(string2sign string,key *ecdsa.PrivateKey) (string,error) {
+	digest := sha256.Sum256([]byte(string2sign))
+	sig,sign_err := ecdsa.SignASN1(rand.Reader,key,digest[:])
+	if sign_err != nil {
+		return "",sign_err
+	}
+	return hex.EncodeToString(sig),nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package tasks
+
+import (
+	"time"
+	"testing"
+	"strings"
+	"math/big"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// the get-vanilla request of the AWS signature test suite, signed for SigV4A
+const v4a_canonical_request = "GET\n/\n\n" +
+	"host:example.amazonaws.com\n" +
+	"x-amz-date:20150830T123600Z\n" +
+	"x-amz-region-set:us-east-1\n\n" +
+	"host;x-amz-date;x-amz-region-set\n" +
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func hexInt(s string) *big.Int {
+	i,_ := new(big.Int).SetString(s,16)
+	return i
+}
+
+func TestDeriveKeyV4A(t *testing.T) {
+	// public keys of the AWS SDK and the AWS signature test suite (get-vanilla)
+	cases := []struct {
+		access,secret string
+		x,y string
+	}{
+		{"AKISORANDOMAASORANDOM","q+jcrXGc+0zWN6uzclKVhvMmUsIfRPa4rlRandom",
+			"15D242CEEBF8D8169FD6A8B5A746C41140414C3B07579038DA06AF89190FFFCB",
+			"0515242CEDD82E94799482E4C0514B505AFCCF2C0C98D6A553BF539F424C5EC0"},
+		{"AKIDEXAMPLE","wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			"b6618f6a65740a99e650b33b6b4b5bd0d43b176d721a3edfea7e7d2d56d936b1",
+			"865ed22a7eadc9c5cb9d2cbaca1b3699139fedc5043dc6661864218330c8e518"},
+	}
+	for _,c := range cases {
+		k,err := DeriveKeyV4A(c.access,c.secret)
+		if err != nil {
+			t.Fatalf("%s: %s\n",c.access,err.Error())
+		}
+		if k.X.Cmp(hexInt(c.x)) != 0 || k.Y.Cmp(hexInt(c.y)) != 0 {
+			t.Errorf("%s: got public key %x %x\n",c.access,k.X,k.Y)
+		}
+		if k.D.Sign() <= 0 || k.D.Cmp(k.Curve.Params().N) >= 0 {
+			t.Errorf("%s: private key out of range\n",c.access)
+		}
+	}
+	// a new secret for the same access key is not served from the cache
+	k1,_ := DeriveKeyV4A("AKIDEXAMPLE","wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	k2,_ := DeriveKeyV4A("AKIDEXAMPLE","rotated")
+	if k1.D.Cmp(k2.D) == 0 {
+		t.Errorf("key of the old secret reused\n")
+	}
+}
+
+func TestString2SignV4A(t *testing.T) {
+	at,_ := time.Parse("20060102T150405Z","20150830T123600Z")
+	h := sha256.Sum256([]byte(v4a_canonical_request))
+	expected := "AWS4-ECDSA-P256-SHA256\n" +
+		"20150830T123600Z\n" +
+		"20150830/service/aws4_request\n" +
+		hex.EncodeToString(h[:])
+	if s := String2SignV4A(at,v4a_canonical_request,"service"); s != expected {
+		t.Errorf("got string to sign\n%s\nexpected\n%s\n",s,expected)
+	}
+	if strings.Contains(String2SignV4A(at,v4a_canonical_request,"service"),"us-east-1") {
+		t.Errorf("the SigV4A scope names a region\n")
+	}
+}
+
+func TestMakeSignatureV4A(t *testing.T) {
+	at,_ := time.Parse("20060102T150405Z","20150830T123600Z")
+	key,_ := DeriveKeyV4A("AKIDEXAMPLE","wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	s2s := String2SignV4A(at,v4a_canonical_request,"service")
+	sig_hex,err := MakeSignatureV4A(s2s,key)
+	if err != nil {
+		t.Fatalf("cannot sign: %s\n",err.Error())
+	}
+	sig,hex_err := hex.DecodeString(sig_hex)
+	if hex_err != nil {
+		t.Fatalf("signature is not hex: %s\n",sig_hex)
+	}
+	digest := sha256.Sum256([]byte(s2s))
+	if !ecdsa.VerifyASN1(&key.PublicKey,digest[:],sig) {
+		t.Errorf("signature does not verify against the derived public key\n")
+	}
+	other := sha256.Sum256([]byte(s2s + "x"))
+	if ecdsa.VerifyASN1(&key.PublicKey,other[:],sig) {
+		t.Errorf("signature verifies for another string to sign\n")
+	}
+}
+
+func TestMakeSignatureV4(t *testing.T) {
+	// the get-vanilla request of the AWS signature test suite
+	at,_ := time.Parse("20060102T150405Z","20150830T123600Z")
+	canonical_request := "GET\n/\n\n" +
+		"host:example.amazonaws.com\n" +
+		"x-amz-date:20150830T123600Z\n\n" +
+		"host;x-amz-date\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s2s := String2Sign(at,canonical_request,"us-east-1","service")
+	expected := "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+		"bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63"
+	if s2s != expected {
+		t.Errorf("got string to sign\n%s\n",s2s)
+	}
+	sig := MakeSignatureAt(at,s2s,"us-east-1","service","wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	if sig != "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31" {
+		t.Errorf("got signature %s\n",sig)
+	}
+}
@@ -28,6 +28,7 @@
 package tasks
 
 import (
+	"sort"
 	"strings"
 	"hash"
 	"time"
@@ -40,7 +41,13 @@ import (
 // MakeSignature returns a auth_v4 signature from the `string to sign` variable.
 // May be useful for creating v4 requests for services other than DynamoDB.
 func MakeSignature(string2sign,zone,service,secret string) string {
-	kCredentials,_ := cacheable_hmacs(zone,service,secret)
+	return MakeSignatureAt(time.Now(),string2sign,zone,service,secret)
+}
+
+// MakeSignatureAt is MakeSignature for a request signed at time t, which must be the
+// time used to create the `string to sign`.
+func MakeSignatureAt(t time.Time,string2sign,zone,service,secret string) string {
+	kCredentials,_ := cacheable_hmacs(t,zone,service,secret)
 	var kSigning_hmac_sha256 hash.Hash = hmac.New(sha256.New,kCredentials)
         kSigning_hmac_sha256.Write([]byte(string2sign))
         kSigning := kSigning_hmac_sha256.Sum(nil)
@@ -49,9 +56,8 @@ func MakeSignature(string2sign,zone,service,secret string) string {
 
 // Return the byte slice for the cacheable hmac, along with the date string
 // that describes its time of creation.
-func cacheable_hmacs(zone,service,secret string) ([]byte,string) {
-	t := time.Now().UTC()
-	gmt_yyyymmdd := t.Format(aws_const.ISODATEFMT)
+func cacheable_hmacs(t time.Time,zone,service,secret string) ([]byte,string) {
+	gmt_yyyymmdd := t.UTC().Format(aws_const.ISODATEFMT)
 
 	init_secret := []byte("AWS4" + secret)
         var kDate_hmac_sha256 hash.Hash = hmac.New(sha256.New,init_secret)
@@ -72,32 +78,51 @@ func cacheable_hmacs(zone,service,secret string) ([]byte,string) {
         return kCredentials_hmac_sha256.Sum(nil),gmt_yyyymmdd
 }
 
-// CanonicalRequest will create the aws v4 `canonical request`.
-// May be useful for creating v4 requests for services other than DynamoDB.
-func CanonicalRequest(host,amzDateHdr,amzTargetHdr,hexPayload string) string {
+// CanonicalRequestHeaders will create the aws v4 `canonical request` signing the
+// `extra` headers in addition to the standard set. It also returns the signed header
+// list for the Authorization header. Header names are compared and signed lowercased.
+func CanonicalRequestHeaders(host,amzDateHdr,amzTargetHdr,hexPayload string,
+	extra map[string]string) (string,string) {
+	hdrs := make(map[string]string)
+	for k,v := range extra {
+		hdrs[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	hdrs[strings.ToLower(aws_const.CONTENT_TYPE_HDR)] = aws_const.CTYPE
+	// host may carry its own port
+	if !strings.Contains(host,":") {
+		host += ":" + aws_const.PORT
+	}
+	hdrs["host"] = host
+	hdrs[strings.ToLower(aws_const.X_AMZ_DATE_HDR)] = amzDateHdr
 	// Some AWS services use the x-amz-target header. Some don't. Allow it to
 	// be passed as empty when not used.
-	amzTarget_list_elt := ""
-	amzTarget_val      := ""
 	if amzTargetHdr != "" {
-		amzTarget_val = strings.ToLower(aws_const.AMZ_TARGET_HDR) + ":" +
-			amzTargetHdr + "\n"
-		amzTarget_list_elt = ";x-amz-target"
+		hdrs[strings.ToLower(aws_const.AMZ_TARGET_HDR)] = amzTargetHdr
 	}
+	names := make([]string,0,len(hdrs))
+	for k,_ := range hdrs {
+		names = append(names,k)
+	}
+	sort.Strings(names)
+	canonical_hdrs := ""
+	for _,k := range names {
+		canonical_hdrs += k + ":" + hdrs[k] + "\n"
+	}
+	signed := strings.Join(names,";")
 	return aws_const.METHOD + "\n" +
-	 	"/" + "\n" +
-	 	"" + "\n" +
-		strings.ToLower(aws_const.CONTENT_TYPE_HDR) + ":" +
-		aws_const.CTYPE + "\n" +
-		strings.ToLower("host") + ":" +
-		host + ":" +
-		aws_const.PORT  + "\n" +
-		strings.ToLower(aws_const.X_AMZ_DATE_HDR) + ":" +
-		amzDateHdr + "\n" +
-		amzTarget_val +
+		"/" + "\n" +
+		"" + "\n" +
+		canonical_hdrs +
 		"\n" +
-		"content-type;host;x-amz-date" + amzTarget_list_elt + "\n" +
-		hexPayload
+		signed + "\n" +
+		hexPayload,signed
+}
+
+// CanonicalRequest will create the aws v4 `canonical request`.
+// May be useful for creating v4 requests for services other than DynamoDB.
+func CanonicalRequest(host,amzDateHdr,amzTargetHdr,hexPayload string) string {
+	canonical_request,_ := CanonicalRequestHeaders(host,amzDateHdr,amzTargetHdr,hexPayload,nil)
+	return canonical_request
 }
 
 // String2Sign will create the aws v4 `string to sign` from the `canoncial request`.
@@ -0,0 +1,132 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"errors"
+	"context"
+	"encoding/json"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// resolveAliases rewrites the table aliases (see conf.Alias) named by the request v to
+// their physical tables, directing ctx to the configuration of the aliases. It returns
+// the tables renamed, keyed by physical name, so that the response can name the aliases
+// again. v is returned as it is when it names no alias.
+func resolveAliases(ctx context.Context,v interface{}) (context.Context,interface{},map[string] string,error) {
+	if len(conf.Aliases()) == 0 {
+		return ctx,v,nil,nil
+	}
+	b,ok := v.([]byte)
+	if !ok {
+		var m_err error
+		if b,m_err = json.Marshal(v); m_err != nil {
+			return ctx,v,nil,nil
+		}
+	}
+	var r map[string] json.RawMessage
+	if json.Unmarshal(b,&r) != nil {
+		return ctx,v,nil,nil
+	}
+	renamed := make(map[string] string)
+	conf_name,routed := "",false
+	// route notes the configuration of alias a, which every alias of a request must share
+	route := func(alias string,a conf.TableAlias) error {
+		if routed && a.Conf != conf_name {
+			return errors.New("authreq: request names aliases of different configurations: " + alias)
+		}
+		conf_name,routed = a.Conf,true
+		renamed[a.Table] = alias
+		return nil
+	}
+	var tablename string
+	if raw,has := r["TableName"]; has && json.Unmarshal(raw,&tablename) == nil {
+		if a,is_alias := conf.Alias(tablename); is_alias {
+			if route_err := route(tablename,a); route_err != nil {
+				return ctx,v,nil,route_err
+			}
+			r["TableName"],_ = json.Marshal(a.Table)
+		}
+	}
+	var items map[string] json.RawMessage
+	if raw,has := r["RequestItems"]; has && json.Unmarshal(raw,&items) == nil {
+		physical := make(map[string] json.RawMessage,len(items))
+		for name,item := range items {
+			if a,is_alias := conf.Alias(name); is_alias {
+				if route_err := route(name,a); route_err != nil {
+					return ctx,v,nil,route_err
+				}
+				name = a.Table
+			}
+			physical[name] = item
+		}
+		r["RequestItems"],_ = json.Marshal(physical)
+	}
+	if len(renamed) == 0 {
+		return ctx,v,nil,nil
+	}
+	if conf_name != "" {
+		ctx = conf.WithName(ctx,conf_name)
+	}
+	aliased,_ := json.Marshal(r)
+	return ctx,aliased,renamed,nil
+}
+
+// unaliasResponse names the aliases again in place of the physical tables renamed in
+// the tables of the batch responses of resp_body, so that unprocessed requests can be
+// sent again as they are.
+func unaliasResponse(resp_body string,renamed map[string] string) string {
+	if len(renamed) == 0 || resp_body == "" {
+		return resp_body
+	}
+	var r map[string] json.RawMessage
+	if json.Unmarshal([]byte(resp_body),&r) != nil {
+		return resp_body
+	}
+	changed := false
+	for _,k := range []string{"Responses","UnprocessedItems","UnprocessedKeys"} {
+		var tables map[string] json.RawMessage
+		if raw,has := r[k]; !has || json.Unmarshal(raw,&tables) != nil {
+			continue
+		}
+		aliased := make(map[string] json.RawMessage,len(tables))
+		for name,t := range tables {
+			if alias,ok := renamed[name]; ok {
+				name = alias
+				changed = true
+			}
+			aliased[name] = t
+		}
+		r[k],_ = json.Marshal(aliased)
+	}
+	if !changed {
+		return resp_body
+	}
+	b,m_err := json.Marshal(r)
+	if m_err != nil {
+		return resp_body
+	}
+	return string(b)
+}
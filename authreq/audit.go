@@ -0,0 +1,163 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"io"
+	"sync"
+	"time"
+	"context"
+	"strings"
+	"net/http"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// AUDITED_OPERATIONS are the control-plane operations passed to the audit sink.
+var AUDITED_OPERATIONS = map[string] bool{
+	"CreateTable":true,
+	"UpdateTable":true,
+	"DeleteTable":true,
+	"UpdateTimeToLive":true,
+	"CreateBackup":true,
+	"DeleteBackup":true,
+	"RestoreTableFromBackup":true,
+	"UpdateContinuousBackups":true,
+}
+
+// AuditRecord describes one control-plane call made through RetryReq.
+type AuditRecord struct {
+	Time time.Time
+	Operation string
+	// the access key the request was signed with
+	Identity string
+	UsingIAM bool
+	// the ARN of the assumed role session the key belongs to, if any
+	SessionArn string `json:",omitempty"`
+	// the metadata of the request's context
+	Metadata map[string] string `json:",omitempty"`
+	Params json.RawMessage
+	Code int
+	Err string `json:",omitempty"`
+	// hex HMAC-SHA256 of the record with an empty Signature, if a key was set
+	Signature string `json:",omitempty"`
+}
+
+// AuditSink receives AuditRecords. Audit is called synchronously after each audited
+// call returns, and must be safe for concurrent use.
+type AuditSink interface {
+	Audit(r AuditRecord)
+}
+
+var audit struct {
+	lock sync.RWMutex
+	sink AuditSink
+	key []byte
+}
+
+// SetAuditSink sends records of audited operations to sink, signing each with key if
+// it is non-empty. A nil sink turns auditing off.
+func SetAuditSink(sink AuditSink,key []byte) {
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+	audit.sink = sink
+	audit.key = key
+}
+
+func sign(r AuditRecord,key []byte) string {
+	r.Signature = ""
+	b,_ := json.Marshal(r)
+	mac := hmac.New(sha256.New,key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAudit reports whether r carries a valid signature under key.
+func VerifyAudit(r AuditRecord,key []byte) bool {
+	s,err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	expected,_ := hex.DecodeString(sign(r,key))
+	return hmac.Equal(s,expected)
+}
+
+// auditReq passes a record of the request for v to the audit sink, if amzTarget is audited.
+func auditReq(ctx context.Context,v interface{},amzTarget,resp_body string,code int,err error) {
+	audit.lock.RLock()
+	sink,key := audit.sink,audit.key
+	audit.lock.RUnlock()
+	op := strings.TrimPrefix(amzTarget,aws_const.ENDPOINT_PREFIX)
+	if sink == nil || !AUDITED_OPERATIONS[op] {
+		return
+	}
+	r := AuditRecord{Time:time.Now().UTC(),Operation:op,Code:code,Metadata:MetadataFrom(ctx)}
+	if b,ok := v.([]byte); ok {
+		r.Params = json.RawMessage(b)
+	} else if b,m_err := json.Marshal(v); m_err == nil {
+		r.Params = json.RawMessage(b)
+	}
+	if c := conf.FromContext(ctx); c != nil {
+		c.ConfLock.RLock()
+		r.UsingIAM = c.UseIAM
+		if r.UsingIAM {
+			r.Identity = c.IAM.Credentials.AccessKey
+			r.SessionArn = c.IAM.Credentials.SessionArn
+		} else {
+			r.Identity = c.Auth.AccessKey
+		}
+		c.ConfLock.RUnlock()
+	}
+	if err != nil {
+		r.Err = auth_v4.Redact(err.Error())
+	} else if code != http.StatusOK {
+		r.Err = errorType(resp_body)
+	}
+	if len(key) != 0 {
+		r.Signature = sign(r,key)
+	}
+	sink.Audit(r)
+}
+
+type auditWriter struct {
+	lock sync.Mutex
+	enc *json.Encoder
+}
+
+func (a *auditWriter) Audit(r AuditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_ = a.enc.Encode(r)
+}
+
+// NewAuditWriter returns an AuditSink that writes each record to w as a line of JSON.
+func NewAuditWriter(w io.Writer) AuditSink {
+	return &auditWriter{enc:json.NewEncoder(w)}
+}
@@ -28,17 +28,20 @@ package authreq
 
 import (
 	"errors"
+	"sync"
+	"context"
 	"net/http"
 	"fmt"
 	"bytes"
-	"strings"
 	"time"
-	"math"
-	"log"
 	"math/rand"
 	"encoding/json"
 	"github.com/smugmug/godynamo/auth_v4"
 	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/aws_errors"
+	"github.com/smugmug/godynamo/capacity"
+	"github.com/smugmug/godynamo/conf"
+	"github.com/smugmug/godynamo/conf_iam"
 	ep "github.com/smugmug/godynamo/endpoint"
 )
 
@@ -46,30 +49,334 @@ const (
 	// auth version numbers
 	AUTH_V2 = 2
 	AUTH_V4 = 4
+	// logged in place of a body when conf.Vals.SuppressBodyLogging is set
+	BODY_SUPPRESSED = "<body suppressed>"
+	// the endpoint Ping calls; endpoints/list_tables cannot be imported here
+	PING_ENDPOINT_NAME = "ListTables"
 )
 
 // Stipulate the current authorization version.
 var AUTH_VERSION = AUTH_V4
 
+// ErrClosed is returned for requests made after Close has been called.
+var ErrClosed = errors.New("authreq: closed to new requests")
+
+// tracks requests in flight and background goroutines so that Close can wait for them
+var lifecycle struct {
+	sync.Mutex
+	closed bool
+	inflight sync.WaitGroup
+	background sync.WaitGroup
+}
+
+// closed by Close to stop the goroutines started by GoBackground
+var closing = make(chan bool)
+
+// begin registers a new request, or returns false if Close has been called.
+func begin() bool {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+	if lifecycle.closed {
+		return false
+	}
+	lifecycle.inflight.Add(1)
+	return true
+}
+
+// GoBackground runs f in a goroutine that Close stops, by closing the channel passed to
+// f, and waits for. The background loops of other packages, such as conf_file's GoReload,
+// update_table's ScheduleBillingMode and the interval flushes of batch_write_item's
+// WriteBuffer, are run with it. It returns false, without running f, once Close has been
+// called.
+func GoBackground(f func(stop <-chan bool)) bool {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+	if lifecycle.closed {
+		return false
+	}
+	lifecycle.background.Add(1)
+	go func() {
+		defer lifecycle.background.Done()
+		f(closing)
+	}()
+	return true
+}
+
+// Close stops accepting new requests and stops the goroutines started by GoBackground,
+// waits for requests in flight to complete, stops the host resolver, IAM credential
+// refresher and replica probe goroutines, waits for all of those goroutines to return,
+// and closes idle connections. If ctx is done before then, ctx.Err() is returned and
+// anything remaining is left to finish on its own. Close is intended for service
+// shutdown; once called, all further requests fail with ErrClosed.
+func Close(ctx context.Context) error {
+	lifecycle.Lock()
+	if !lifecycle.closed {
+		lifecycle.closed = true
+		close(closing)
+	}
+	lifecycle.Unlock()
+
+	drained := make(chan bool)
+	go func() {
+		lifecycle.inflight.Wait()
+		auth_v4.StopResolver()
+		conf_iam.StopWatch()
+		stopProbesOnce.Do(func() { close(stopProbes) })
+		lifecycle.background.Wait()
+		conf_iam.WaitWatch()
+		close(drained)
+	}()
+	select {
+	case <- drained:
+		auth_v4.Client.CloseIdleConnections()
+		auth_v4.InsecureClient.CloseIdleConnections()
+		return nil
+	case <- ctx.Done():
+		auth_v4.Client.CloseIdleConnections()
+		auth_v4.InsecureClient.CloseIdleConnections()
+		return ctx.Err()
+	}
+}
+
+// PingResult describes the outcome of a successful Ping.
+type PingResult struct {
+	// round trip time of the signed request
+	Latency time.Duration
+	RequestID string
+	// the identity and region the request was signed for
+	AccessKey string
+	UsingIAM bool
+	SessionArn string
+	Zone string
+	Host string
+}
+
+// Ping performs one cheap signed call (ListTables with a Limit of 1) without retries,
+// to validate connectivity, credentials and region, for readiness probes and startup checks.
+// It checks the configuration ctx is directed to, see conf.WithName.
+// A non-200 response is returned as an error naming the AWS exception.
+func Ping(ctx context.Context) (*PingResult,error) {
+	if !begin() {
+		return nil,ErrClosed
+	}
+	defer lifecycle.inflight.Done()
+	r := new(PingResult)
+	c,conf_err := confFor(ctx)
+	if conf_err != nil {
+		return nil,conf_err
+	}
+	c.ConfLock.RLock()
+	r.UsingIAM = c.UseIAM
+	if r.UsingIAM {
+		r.AccessKey = c.IAM.Credentials.AccessKey
+		r.SessionArn = c.IAM.Credentials.SessionArn
+	} else {
+		r.AccessKey = c.Auth.AccessKey
+	}
+	r.Zone = c.Network.DynamoDB.Zone
+	r.Host = c.Network.DynamoDB.Host
+	c.ConfLock.RUnlock()
+
+	start := time.Now()
+	resp_body,amz_requestid,code,resp_err :=
+		auth_v4.ReqContext(ctx,[]byte(`{"Limit":1}`),aws_const.ENDPOINT_PREFIX + PING_ENDPOINT_NAME)
+	r.Latency = time.Since(start)
+	r.RequestID = amz_requestid
+	if resp_err != nil {
+		e := fmt.Sprintf("authreq.Ping: %s",resp_err.Error())
+		return nil,errors.New(e)
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("authreq.Ping: code %d %s (reqid:%s)",
+			code,errorType(resp_body),amz_requestid)
+		return nil,errors.New(e)
+	}
+	return r,nil
+}
+
+// Attempt describes the outcome of a single try of a retryable request.
+type Attempt struct {
+	Time time.Time
+	Code int
+	// the transport error, or the error response body from AWS
+	Err string
+	RequestID string
+}
+
+// RetryError is returned when a request has failed on every retry. Attempts lists every
+// try in order, so the full history is available for post-mortems.
+type RetryError struct {
+	AmzTarget string
+	Attempts []Attempt
+	// the metadata of the request's context
+	Metadata map[string] string
+}
+
+func (r *RetryError) Error() string {
+	e := fmt.Sprintf("authreq.RetryReq: failed %d attempts on %s",
+		len(r.Attempts),r.AmzTarget)
+	if len(r.Attempts) > 0 {
+		last := r.Attempts[len(r.Attempts)-1]
+		e += fmt.Sprintf("; last at %v code:%d err:%s (reqid:%s)",
+			last.Time,last.Code,last.Err,last.RequestID)
+	}
+	return e + logMetadata(r.Metadata)
+}
+
+// logConf returns the configuration requests made with ctx go to, or conf.Vals if
+// ctx names one that is not registered.
+func logConf(ctx context.Context) *conf.AWS_Conf {
+	if c := conf.FromContext(ctx); c != nil {
+		return c
+	}
+	return &conf.Vals
+}
+
+// suppressBodies reports if the conf of ctx forbids writing request or response bodies to logs.
+func suppressBodies(ctx context.Context) bool {
+	c := logConf(ctx)
+	c.ConfLock.RLock()
+	defer c.ConfLock.RUnlock()
+	return c.SuppressBodyLogging
+}
+
+// logReq returns the representation of request `v` that is safe to log.
+func logReq(ctx context.Context,v interface{}) interface{} {
+	if suppressBodies(ctx) {
+		return BODY_SUPPRESSED
+	}
+	if b,ok := v.([]byte); ok {
+		return truncateBody(ctx,string(b))
+	}
+	if s,ok := v.(string); ok {
+		return truncateBody(ctx,s)
+	}
+	return v
+}
+
+// truncateBody cuts the body s down to the MaxLoggedBody bytes of the conf of ctx, if set.
+func truncateBody(ctx context.Context,s string) string {
+	c := logConf(ctx)
+	c.ConfLock.RLock()
+	max := c.MaxLoggedBody
+	c.ConfLock.RUnlock()
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s...(%d bytes)",s[:max],len(s))
+}
+
+// errorType extracts the exception name from an AWS error response body, without
+// any of the message text, which may quote item data.
+func errorType(resp_body string) string {
+	if c := aws_errors.Code(resp_body); c != "" {
+		return c
+	}
+	return BODY_SUPPRESSED
+}
+
+// newAttempt builds an Attempt from the return values of auth_v4.Req.
+func newAttempt(ctx context.Context,t time.Time,resp_body,amz_requestid string,code int,resp_err error) Attempt {
+	a := Attempt{Time:t,Code:code,RequestID:amz_requestid}
+	if resp_err != nil {
+		a.Err = auth_v4.Redact(resp_err.Error())
+	} else if suppressBodies(ctx) {
+		a.Err = errorType(resp_body)
+	} else {
+		a.Err = auth_v4.Redact(resp_body)
+	}
+	return a
+}
+
 // RetryReq_V4 sends a retry-able request using an ep.Endpoint structure and v4 auth.
 func RetryReq_V4(v ep.Endpoint,amzTarget string) (string,int,error) {
-	return retryReq(v,amzTarget)
+	return retryReq(context.Background(),v,amzTarget)
 }
 
 // RetryReq_V4 sends a retry-able request using a JSON serialized request and v4 auth.
 func RetryReqJSON_V4(reqJSON []byte,amzTarget string) (string,int,error) {
-	return retryReq(reqJSON,amzTarget)
+	return retryReq(context.Background(),reqJSON,amzTarget)
+}
+
+// RetryReqContext_V4 is RetryReq_V4 governed by ctx, which may also carry per-request
+// options such as the headers set by auth_v4.WithHeaders. Retries stop if ctx is done.
+func RetryReqContext_V4(ctx context.Context,v ep.Endpoint,amzTarget string) (string,int,error) {
+	return retryReq(ctx,v,amzTarget)
+}
+
+// RetryReqJSONContext_V4 is RetryReqJSON_V4 governed by ctx.
+func RetryReqJSONContext_V4(ctx context.Context,reqJSON []byte,amzTarget string) (string,int,error) {
+	return retryReq(ctx,reqJSON,amzTarget)
+}
+
+// confFor returns the configuration requests made with ctx go to (see conf.WithName),
+// or an error if ctx names one that is not registered.
+func confFor(ctx context.Context) (*conf.AWS_Conf,error) {
+	c := conf.FromContext(ctx)
+	if c == nil {
+		e := fmt.Sprintf("authreq: no configuration named %s",conf.NameFrom(ctx))
+		return nil,errors.New(e)
+	}
+	return c,nil
+}
+
+// retryReq makes the request with retries, within the inflight limits, and records
+// what it consumed. A request naming table aliases is sent to their tables, one of an
+// operation of the conf file's operation_regions to its region, and a read may be sent
+// to a replica zone (see WithReadPreference).
+func retryReq(ctx context.Context,v interface{},amzTarget string) (string,int,error) {
+	ctx,v,renamed,alias_err := resolveAliases(ctx,v)
+	if alias_err != nil {
+		return "",0,alias_err
+	}
+	if probing() {
+		startProbes()
+	}
+	ctx = routeOperation(ctx,amzTarget)
+	ctx = routeRead(ctx,v,amzTarget)
+	if _,conf_err := confFor(ctx); conf_err != nil {
+		return "",0,conf_err
+	}
+	if policy_err := checkPolicies(ctx,v,amzTarget); policy_err != nil {
+		return "",0,policy_err
+	}
+	held,inflight_err := acquireInflight(ctx,v)
+	if inflight_err != nil {
+		return "",0,inflight_err
+	}
+	defer held.release()
+	if !begin() {
+		return "",0,ErrClosed
+	}
+	defer lifecycle.inflight.Done()
+	send := func() (string,string,int,error) {
+		return auth_v4.ReqContext(ctx,v,amzTarget)
+	}
+	resp_body,code,err := retryLoop(ctx,v,amzTarget,held,send)
+	auditReq(ctx,v,amzTarget,resp_body,code,err)
+	if err == nil && code == http.StatusOK {
+		capacity.ObserveWith(amzTarget,resp_body,MetadataFrom(ctx))
+	}
+	return unaliasResponse(resp_body,renamed),code,err
 }
 
 // Implement exponential backoff for the req above in the case of 5xx errors
-// from aws. Algorithm is lifted from AWS docs.
-func retryReq(v interface{},amzTarget string) (string,int,error) {
-	resp_body,amz_requestid,code,resp_err := auth_v4.Req(v,amzTarget)
+// from aws. Algorithm is lifted from AWS docs. The overall inflight slot of held
+// is given up during each backoff sleep. Each attempt is made with send.
+func retryLoop(ctx context.Context,v interface{},amzTarget string,held *slots,
+	send func() (string,string,int,error)) (string,int,error) {
+	t := time.Now()
+	resp_body,amz_requestid,code,resp_err := send()
+	attempts := []Attempt{newAttempt(ctx,t,resp_body,amz_requestid,code,resp_err)}
+	if resp_err == auth_v4.ErrDryRun {
+		// prepared and signed, but not sent: there is nothing to retry
+		return "",0,resp_err
+	}
 	shouldRetry := false
 	if resp_err != nil {
 		e := fmt.Sprintf("authreq.RetryReq:0 " +
 			" try AuthReq Fail:%s (reqid:%s)",resp_err.Error(),amz_requestid)
-		log.Printf("authreq.RetryReq: call err %s\n",e)
+		auth_v4.Logf("authreq.RetryReq: call err %s\n",e)
 		shouldRetry = true
 	}
 	// see:
@@ -78,28 +385,35 @@ func retryReq(v interface{},amzTarget string) (string,int,error) {
 		shouldRetry = true // all 5xx codes are deemed retryable by amazon
 	}
 	if code == http.StatusBadRequest {
-		if strings.Contains(resp_body,aws_const.EXCEEDED_MSG) {
-			log.Printf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+		if aws_errors.IsThrottle(resp_body) {
+			auth_v4.Logf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+			held.throttled()
 			shouldRetry = true
-		} else if strings.Contains(resp_body,aws_const.UNRECOGNIZED_CLIENT_MSG) {
-			log.Printf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+		} else if aws_errors.Is(resp_body,aws_errors.UNRECOGNIZED_CLIENT) {
+			auth_v4.Logf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
 			shouldRetry = true
-		} else if strings.Contains(resp_body,aws_const.THROTTLING_MSG) {
-			log.Printf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+		} else if auth_v4.IsSkewError(resp_body) {
+			// auth_v4 has corrected its clock offset, re-sign and resend
+			auth_v4.Logf("authreq.RetryReq CLOCK SKEW RETRY\n")
 			shouldRetry = true
+		} else if suppressBodies(ctx) {
+			auth_v4.Logf("authreq.RetryReq un-retryable err: code %d %s (reqid:%s)\n",
+				code,errorType(resp_body),amz_requestid)
+			shouldRetry = false
 		} else {
 			v_json,v_json_err := json.Marshal(v)
 			if v_json_err == nil {
 				var buf bytes.Buffer
 				if i_err := json.Indent(&buf,v_json,"","\t"); i_err == nil {
-					log.Printf("authreq.RetryReq un-retryable err: %s\n%s\n",
-						resp_body,buf.String())
+					auth_v4.Logf("authreq.RetryReq un-retryable err: %s\n%s\n",
+						truncateBody(ctx,resp_body),truncateBody(ctx,buf.String()))
 				} else {
-					log.Printf("authreq.RetryReq un-retryable err: %s\n%s\n",
-						resp_body,string(v_json))
+					auth_v4.Logf("authreq.RetryReq un-retryable err: %s\n%s\n",
+						truncateBody(ctx,resp_body),truncateBody(ctx,string(v_json)))
 				}
 			} else {
-				log.Printf("authreq.RetryReq un-retryable err: %s (reqid:%s)\n",resp_body,amz_requestid)
+				auth_v4.Logf("authreq.RetryReq un-retryable err: %s (reqid:%s)\n",
+					truncateBody(ctx,resp_body),amz_requestid)
 			}
 			shouldRetry = false
 		}
@@ -108,23 +422,31 @@ func retryReq(v interface{},amzTarget string) (string,int,error) {
 		// not retryable
 		return resp_body,code,resp_err
 	} else {
-		// retry the request RETRIES time in the case of a 5xx
+		// retry the request per the RetryPolicy in the case of a 5xx
 		// response, with an exponentially decayed sleep interval
+		p := policyFor(ctx)
 
 		// seed our rand number generator g
 		g := rand.New(rand.NewSource(time.Now().UnixNano()))
-		for i := 1; i<aws_const.RETRIES; i++ {
+		for i := 1; i<p.Retries; i++ {
 			// get random delay from range
-			// [0..4**i*100 ms)
-			log.Printf("authreq.RetryReq: BEGIN SLEEP %v (code:%v) (REQ:%v) (reqid:%s)",time.Now(),code,v,amz_requestid)
-			r := time.Millisecond *
-				time.Duration(g.Int63n(int64(
-				math.Pow(4,float64(i))) *
-				100))
-			time.Sleep(r)
-			log.Printf("authreq.RetryReq END SLEEP %v\n",time.Now())
+			// [0..min(Factor**i*Base,Max))
+			auth_v4.Logf("authreq.RetryReq: BEGIN SLEEP %v (code:%v) (REQ:%v) (reqid:%s)%s",time.Now(),code,logReq(ctx,v),amz_requestid,logMetadata(MetadataFrom(ctx)))
+			r := p.delay(i,g)
+			held.yield()
+			select {
+			case <- time.After(r):
+			case <- ctx.Done():
+				return "",0,ctx.Err()
+			}
+			auth_v4.Logf("authreq.RetryReq END SLEEP %v\n",time.Now())
+			if resume_err := held.resume(ctx); resume_err != nil {
+				return "",0,resume_err
+			}
 			shouldRetry = false
-			resp_body,amz_requestid,code,resp_err := auth_v4.Req(v,amzTarget)
+			t := time.Now()
+			resp_body,amz_requestid,code,resp_err := send()
+			attempts = append(attempts,newAttempt(ctx,t,resp_body,amz_requestid,code,resp_err))
 			if resp_err != nil {
 				_ = fmt.Sprintf("authreq.RetryReq:1 " +
 					" try AuthReq Fail:%s (reqid:%s)",resp_err.Error(),amz_requestid)
@@ -134,19 +456,21 @@ func retryReq(v interface{},amzTarget string) (string,int,error) {
 				shouldRetry = true
 			}
 			if code == http.StatusBadRequest {
-				if strings.Contains(resp_body,aws_const.EXCEEDED_MSG) {
-					log.Printf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+				if aws_errors.IsThrottle(resp_body) {
+					auth_v4.Logf("authreq.RetryReq THROUGHPUT WARNING RETRY\n")
+					held.throttled()
+					shouldRetry = true
+				} else if auth_v4.IsSkewError(resp_body) {
+					auth_v4.Logf("authreq.RetryReq CLOCK SKEW RETRY\n")
 					shouldRetry = true
 				}
 			}
 			if !shouldRetry {
 				// worked! no need to retry
-				log.Printf("authreq.RetryReq RETRY LOOP SUCCESS")
+				auth_v4.Logf("authreq.RetryReq RETRY LOOP SUCCESS")
 				return resp_body,code,resp_err
 			}
 		}
-		e := fmt.Sprintf("authreq.RetryReq: failed retries on %s:%v",
-			amzTarget,v)
-		return "",0,errors.New(e)
+		return "",0,&RetryError{AmzTarget:amzTarget,Attempts:attempts,Metadata:MetadataFrom(ctx)}
 	}
 }
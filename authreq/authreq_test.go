@@ -0,0 +1,105 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"fmt"
+	"context"
+	"testing"
+	"net/url"
+	"net/http"
+	"hash/crc32"
+	"sync/atomic"
+	"net/http/httptest"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// A response corrupted in transport is retried.
+func TestRetryChecksumMismatch(t *testing.T) {
+	const body = `{"TableNames":[]}`
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		crc := crc32.ChecksumIEEE([]byte(body))
+		if atomic.AddInt32(&calls,1) == 1 {
+			crc++
+		}
+		w.Header().Set("X-Amz-Crc32",fmt.Sprintf("%d",crc))
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	resp,code,err := RetryReqJSON_V4([]byte(`{}`),"DynamoDB_20120810.ListTables")
+	if err != nil {
+		t.Fatalf("RetryReqJSON_V4: %s\n",err.Error())
+	}
+	if code != http.StatusOK || resp != body {
+		t.Errorf("got %d %q, want 200 %q\n",code,resp,body)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("%d attempts, want 2\n",n)
+	}
+}
+
+// Body log suppression and truncation follow the conf the request goes to.
+func TestSuppressBodiesNamed(t *testing.T) {
+	conf.Vals.ConfLock.RLock()
+	global := conf.Vals.SuppressBodyLogging
+	conf.Vals.ConfLock.RUnlock()
+	if global {
+		t.Fatalf("conf.Vals suppresses bodies\n")
+	}
+	quiet := new(conf.AWS_Conf)
+	quiet.SuppressBodyLogging = true
+	conf.Register("authreq-quiet",quiet)
+	short := new(conf.AWS_Conf)
+	short.MaxLoggedBody = 4
+	conf.Register("authreq-short",short)
+
+	ctx := conf.WithName(context.Background(),"authreq-quiet")
+	if !suppressBodies(ctx) {
+		t.Errorf("named conf suppressing bodies was ignored\n")
+	}
+	if r := logReq(ctx,"{\"Key\":1}"); r != BODY_SUPPRESSED {
+		t.Errorf("logged %v\n",r)
+	}
+	if suppressBodies(context.Background()) {
+		t.Errorf("conf.Vals not consulted without a name\n")
+	}
+	if suppressBodies(conf.WithName(context.Background(),"authreq-missing")) {
+		t.Errorf("unregistered name should fall back to conf.Vals\n")
+	}
+	if s := truncateBody(conf.WithName(context.Background(),"authreq-short"),"abcdefgh"); s != "abcd...(8 bytes)" {
+		t.Errorf("truncated to %s\n",s)
+	}
+}
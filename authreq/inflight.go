@@ -0,0 +1,392 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"reflect"
+	"encoding/json"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// ErrTooManyInflight is returned for a request that could not get a slot under the
+// limits conf.Vals.Inflight.Max and conf.Vals.Inflight.MaxPerTable within
+// conf.Vals.Inflight.Wait.
+var ErrTooManyInflight = errors.New("authreq: too many requests in flight")
+
+// ErrShed is returned for a request of background priority made while its table (or,
+// for a request without one, any table) has been throttled within THROTTLE_SHED_WINDOW,
+// so that the capacity left goes to interactive requests.
+var ErrShed = errors.New("authreq: background request shed while throttled")
+
+// Priorities for WithPriority. Requests below PRIORITY_NORMAL are background requests.
+const (
+	PRIORITY_HIGH = 10
+	PRIORITY_NORMAL = 0
+	PRIORITY_BACKGROUND = -10
+)
+
+// How long after a throttled response background requests are shed.
+const THROTTLE_SHED_WINDOW = 5 * time.Second
+
+// the semaphores are made as they are first needed, and take the limits of conf.Vals as
+// they change
+var inflight struct {
+	all *semaphore
+	lock sync.Mutex
+	tables map[string] *semaphore
+	// when each table, and any table, was last throttled
+	throttled map[string] time.Time
+	last_throttled time.Time
+}
+
+// A semaphore hands its slots to waiters in order of priority, raised by one for every
+// conf.Vals.Inflight.PriorityAging they have waited, and first come first served among
+// equals. Unlike a buffered channel, a free slot is never taken by a newcomer while
+// others wait for it, and a low-rate request (a DescribeTable among floods of GetItem)
+// keeps its place in line.
+type semaphore struct {
+	lock sync.Mutex
+	max int
+	n int
+	waiters []*waiter
+}
+
+type waiter struct {
+	ready chan struct{}
+	priority int
+	aging time.Duration
+	since time.Time
+}
+
+func newSemaphore(max int) *semaphore {
+	return &semaphore{max:max}
+}
+
+// effective is the priority of w at now.
+func (w *waiter) effective(now time.Time) int {
+	if w.aging <= 0 {
+		return w.priority
+	}
+	return w.priority + int(now.Sub(w.since) / w.aging)
+}
+
+// next removes and returns the waiter to hand a slot to. sem must be locked.
+func (sem *semaphore) next() *waiter {
+	now := time.Now()
+	best := 0
+	for i := 1; i < len(sem.waiters); i++ {
+		// waiters are in arrival order, so ties go to the earliest
+		if sem.waiters[i].effective(now) > sem.waiters[best].effective(now) {
+			best = i
+		}
+	}
+	w := sem.waiters[best]
+	sem.waiters = append(sem.waiters[:best],sem.waiters[best + 1:]...)
+	return w
+}
+
+// remove takes w out of line, reporting false if it was handed a slot already. sem
+// must be locked.
+func (sem *semaphore) remove(w *waiter) bool {
+	for i,x := range sem.waiters {
+		if x == w {
+			sem.waiters = append(sem.waiters[:i],sem.waiters[i + 1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// resize changes the slots of sem to max, handing those added to waiters. Slots beyond
+// a lowered max are taken back as they are released.
+func (sem *semaphore) resize(max int) {
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if sem.max == max {
+		return
+	}
+	sem.max = max
+	for sem.n < sem.max && len(sem.waiters) != 0 {
+		sem.n++
+		close(sem.next().ready)
+	}
+}
+
+// release gives up a slot, handing it to the next waiter if there is one.
+func (sem *semaphore) release() {
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if len(sem.waiters) != 0 && sem.n <= sem.max {
+		close(sem.next().ready)
+		return
+	}
+	sem.n--
+}
+
+// allSem returns the semaphore of the overall limit, or nil if requests are unlimited.
+func allSem() *semaphore {
+	conf.Vals.ConfLock.RLock()
+	max := conf.Vals.Inflight.Max
+	conf.Vals.ConfLock.RUnlock()
+	if max <= 0 {
+		return nil
+	}
+	inflight.lock.Lock()
+	defer inflight.lock.Unlock()
+	if inflight.all == nil {
+		inflight.all = newSemaphore(max)
+	} else {
+		inflight.all.resize(max)
+	}
+	return inflight.all
+}
+
+// tableSem returns the semaphore for tablename, or nil if tables are unlimited. A limit
+// per table of the overall limit or more is lowered to one less than the overall limit,
+// so that one table cannot hold every overall slot while its requests are sent.
+func tableSem(tablename string) *semaphore {
+	conf.Vals.ConfLock.RLock()
+	max := conf.Vals.Inflight.MaxPerTable
+	all := conf.Vals.Inflight.Max
+	conf.Vals.ConfLock.RUnlock()
+	if max <= 0 || tablename == "" {
+		return nil
+	}
+	if all > 1 && max >= all {
+		max = all - 1
+	}
+	inflight.lock.Lock()
+	defer inflight.lock.Unlock()
+	if inflight.tables == nil {
+		inflight.tables = make(map[string] *semaphore)
+	}
+	sem,ok := inflight.tables[tablename]
+	if !ok {
+		sem = newSemaphore(max)
+		inflight.tables[tablename] = sem
+	} else {
+		sem.resize(max)
+	}
+	return sem
+}
+
+// tableName finds the TableName of a request, if it has one. Batch requests name
+// several tables and are only subject to the overall limit.
+func tableName(v interface{}) string {
+	if b,ok := v.([]byte); ok {
+		var t struct { TableName string }
+		_ = json.Unmarshal(b,&t)
+		return t.TableName
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return ""
+	}
+	f := rv.FieldByName("TableName")
+	if f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// noteThrottle records a throttled response for tablename.
+func noteThrottle(tablename string) {
+	now := time.Now()
+	inflight.lock.Lock()
+	defer inflight.lock.Unlock()
+	if inflight.throttled == nil {
+		inflight.throttled = make(map[string] time.Time)
+	}
+	if tablename != "" {
+		inflight.throttled[tablename] = now
+	}
+	inflight.last_throttled = now
+}
+
+// shed reports whether a request for tablename with priority is to be shed.
+func shed(tablename string,priority int) bool {
+	if priority >= PRIORITY_NORMAL {
+		return false
+	}
+	inflight.lock.Lock()
+	defer inflight.lock.Unlock()
+	at := inflight.last_throttled
+	if tablename != "" {
+		at = inflight.throttled[tablename]
+	}
+	return !at.IsZero() && time.Since(at) < THROTTLE_SHED_WINDOW
+}
+
+// acquire waits (up to conf.Vals.Inflight.Wait, or ctx) for a slot in sem, in line
+// with the given priority and aging.
+func acquire(ctx context.Context,sem *semaphore,wait time.Duration,priority int,aging time.Duration) error {
+	sem.lock.Lock()
+	if sem.n < sem.max && len(sem.waiters) == 0 {
+		sem.n++
+		sem.lock.Unlock()
+		return nil
+	}
+	if wait <= 0 {
+		sem.lock.Unlock()
+		return ErrTooManyInflight
+	}
+	w := &waiter{ready:make(chan struct{}),priority:priority,aging:aging,since:time.Now()}
+	sem.waiters = append(sem.waiters,w)
+	sem.lock.Unlock()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	var err error
+	select {
+	case <- w.ready:
+		return nil
+	case <- t.C:
+		err = ErrTooManyInflight
+	case <- ctx.Done():
+		err = ctx.Err()
+	}
+	sem.lock.Lock()
+	defer sem.lock.Unlock()
+	if !sem.remove(w) {
+		// handed a slot as it gave up, so take it after all
+		return nil
+	}
+	return err
+}
+
+type priorityKey int
+
+// WithPriority returns ctx carrying the priority of a request waiting for an inflight
+// slot, for RetryReqContext_V4. Requests of higher priority are served first, but every
+// conf.Vals.Inflight.PriorityAging a request waits raises its priority by one, so that
+// lower priorities are not starved. The default priority is PRIORITY_NORMAL. Background
+// requests, below PRIORITY_NORMAL, are shed with ErrShed while throttled, rather than
+// waiting for a slot or retrying.
+func WithPriority(ctx context.Context,priority int) context.Context {
+	return context.WithValue(ctx,priorityKey(0),priority)
+}
+
+// priorityFor returns the priority of a request on ctx.
+func priorityFor(ctx context.Context) int {
+	p,_ := ctx.Value(priorityKey(0)).(int)
+	return p
+}
+
+// slots are the inflight slots held by one request. The per-table slot is held for the
+// whole request, but the overall slot is given up while the request sleeps between
+// retries, so that requests backing off from one throttled or failing table do not
+// starve the requests of other tables of overall slots.
+type slots struct {
+	all *semaphore
+	table *semaphore
+	tablename string
+	wait time.Duration
+	priority int
+	aging time.Duration
+	holding_all bool
+}
+
+// acquireInflight takes the slots a request for v needs: that of its table first, so
+// that a request waiting on a saturated table does not hold an overall slot meanwhile.
+// A background request is shed instead while throttled.
+func acquireInflight(ctx context.Context,v interface{}) (*slots,error) {
+	tablename := tableName(v)
+	priority := priorityFor(ctx)
+	if shed(tablename,priority) {
+		return nil,ErrShed
+	}
+	conf.Vals.ConfLock.RLock()
+	wait := conf.Vals.Inflight.Wait
+	aging := conf.Vals.Inflight.PriorityAging
+	conf.Vals.ConfLock.RUnlock()
+	if p := policyFor(ctx); p.InflightWait != 0 {
+		wait = p.InflightWait
+	}
+	s := &slots{all:allSem(),table:tableSem(tablename),tablename:tablename,wait:wait,
+		priority:priority,aging:aging}
+	if s.table != nil {
+		if err := acquire(ctx,s.table,wait,s.priority,s.aging); err != nil {
+			return nil,err
+		}
+	}
+	if err := s.resume(ctx); err != nil {
+		if s.table != nil {
+			s.table.release()
+		}
+		return nil,err
+	}
+	return s,nil
+}
+
+// yield gives up the overall slot, as before a backoff sleep.
+func (s *slots) yield() {
+	if s != nil && s.all != nil && s.holding_all {
+		s.all.release()
+		s.holding_all = false
+	}
+}
+
+// throttled records a throttled response to the request holding s.
+func (s *slots) throttled() {
+	if s != nil {
+		noteThrottle(s.tablename)
+	}
+}
+
+// resume takes the overall slot again after a yield. A background request is shed
+// instead while throttled.
+func (s *slots) resume(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	if !s.holding_all && shed(s.tablename,s.priority) {
+		return ErrShed
+	}
+	if s.all == nil || s.holding_all {
+		return nil
+	}
+	if err := acquire(ctx,s.all,s.wait,s.priority,s.aging); err != nil {
+		return err
+	}
+	s.holding_all = true
+	return nil
+}
+
+// release gives up all the slots.
+func (s *slots) release() {
+	if s == nil {
+		return
+	}
+	s.yield()
+	if s.table != nil {
+		s.table.release()
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"time"
+	"testing"
+	"context"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// waiting blocks until sem has n waiters.
+func waiting(t *testing.T,sem *semaphore,n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		sem.lock.Lock()
+		l := len(sem.waiters)
+		sem.lock.Unlock()
+		if l == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("never %d waiters\n",n)
+}
+
+// queue starts a waiter on sem with priority and aging, which sends id on got once it
+// has a slot.
+func queue(sem *semaphore,id,priority int,aging time.Duration,got chan int) {
+	go func() {
+		if err := acquire(context.Background(),sem,time.Minute,priority,aging); err == nil {
+			got <- id
+		}
+	}()
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	sem := newSemaphore(1)
+	if err := acquire(context.Background(),sem,0,0,0); err != nil {
+		t.Fatalf("cannot take the free slot: %s\n",err.Error())
+	}
+	if err := acquire(context.Background(),sem,0,0,0); err != ErrTooManyInflight {
+		t.Errorf("took a slot beyond the limit: %v\n",err)
+	}
+	got := make(chan int,3)
+	for i := 0; i < 3; i++ {
+		queue(sem,i,0,0,got)
+		waiting(t,sem,i + 1)
+	}
+	for i := 0; i < 3; i++ {
+		sem.release()
+		if id := <- got; id != i {
+			t.Errorf("waiter %d served before waiter %d\n",id,i)
+		}
+	}
+	sem.release()
+	if sem.n != 0 {
+		t.Errorf("%d slots held after all are released\n",sem.n)
+	}
+}
+
+func TestSemaphoreAging(t *testing.T) {
+	sem := newSemaphore(1)
+	acquire(context.Background(),sem,0,0,0)
+	got := make(chan int,2)
+	// the low priority waiter gains a priority every 10ms, so after 80ms it is ahead of a
+	// newer waiter of priority 3
+	queue(sem,0,0,10 * time.Millisecond,got)
+	waiting(t,sem,1)
+	time.Sleep(80 * time.Millisecond)
+	queue(sem,1,3,10 * time.Millisecond,got)
+	waiting(t,sem,2)
+	sem.release()
+	if id := <- got; id != 0 {
+		t.Errorf("the newer high priority waiter was served before the aged one\n")
+	}
+	sem.release()
+	<- got
+	// without aging, priority decides
+	queue(sem,2,0,0,got)
+	waiting(t,sem,1)
+	time.Sleep(20 * time.Millisecond)
+	queue(sem,3,1,0,got)
+	waiting(t,sem,2)
+	sem.release()
+	if id := <- got; id != 3 {
+		t.Errorf("the lower priority waiter was served first\n")
+	}
+	sem.release()
+	<- got
+	sem.release()
+}
+
+func TestSemaphoreTimeoutRace(t *testing.T) {
+	sem := newSemaphore(1)
+	for i := 0; i < 200; i++ {
+		acquire(context.Background(),sem,0,0,0)
+		done := make(chan error)
+		go func() {
+			done <- acquire(context.Background(),sem,time.Millisecond,0,0)
+		}()
+		// hand the slot over around when the waiter times out
+		time.Sleep(time.Duration(i % 5) * 500 * time.Microsecond)
+		sem.release()
+		if err := <- done; err == nil {
+			sem.release()
+		} else if err != ErrTooManyInflight {
+			t.Fatalf("unexpected error %s\n",err.Error())
+		}
+		sem.lock.Lock()
+		n,l := sem.n,len(sem.waiters)
+		sem.lock.Unlock()
+		if n != 0 || l != 0 {
+			t.Fatalf("round %d: %d slots held and %d waiters left\n",i,n,l)
+		}
+	}
+}
+
+func TestSemaphoreResize(t *testing.T) {
+	sem := newSemaphore(1)
+	acquire(context.Background(),sem,0,0,0)
+	got := make(chan int,3)
+	for i := 0; i < 3; i++ {
+		queue(sem,i,0,0,got)
+		waiting(t,sem,i + 1)
+	}
+	// two added slots go to the first two waiters
+	sem.resize(3)
+	if a,b := <- got,<- got; a + b != 1 {
+		t.Errorf("waiters %d and %d were served, not the first two\n",a,b)
+	}
+	waiting(t,sem,1)
+	// lowered, the slots beyond the limit are taken back as they are released
+	sem.resize(1)
+	sem.release()
+	sem.release()
+	select {
+	case id := <- got:
+		t.Errorf("waiter %d served over the lowered limit\n",id)
+	case <- time.After(20 * time.Millisecond):
+	}
+	sem.release()
+	if id := <- got; id != 2 {
+		t.Errorf("waiter %d served\n",id)
+	}
+	sem.release()
+	if sem.n != 0 || sem.max != 1 {
+		t.Errorf("%d of %d slots held\n",sem.n,sem.max)
+	}
+}
+
+func TestInflightTables(t *testing.T) {
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Inflight.Max = 2
+	conf.Vals.Inflight.MaxPerTable = 2
+	conf.Vals.Inflight.Wait = 0
+	conf.Vals.ConfLock.Unlock()
+	defer func() {
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.Inflight.Max = 0
+		conf.Vals.Inflight.MaxPerTable = 0
+		conf.Vals.ConfLock.Unlock()
+	}()
+	type req struct {
+		TableName string
+	}
+	ctx := context.Background()
+	// the hot table is held to one less than the overall limit
+	hot,err := acquireInflight(ctx,req{TableName:"Hot"})
+	if err != nil {
+		t.Fatalf("cannot send to the hot table: %s\n",err.Error())
+	}
+	if _,err := acquireInflight(ctx,req{TableName:"Hot"}); err != ErrTooManyInflight {
+		t.Errorf("the hot table took every overall slot: %v\n",err)
+	}
+	other,err := acquireInflight(ctx,req{TableName:"Other"})
+	if err != nil {
+		t.Fatalf("another table cannot make progress: %s\n",err.Error())
+	}
+	other.release()
+	hot.release()
+	if inflight.all.n != 0 || inflight.tables["Hot"].n != 0 || inflight.tables["Other"].n != 0 {
+		t.Errorf("slots left held\n")
+	}
+}
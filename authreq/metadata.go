@@ -0,0 +1,65 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"sort"
+	"context"
+	"strings"
+)
+
+type metadataKey int
+
+// WithMetadata returns ctx carrying the key/value pair (for example a caller name,
+// feature flag or tenant) in addition to any metadata ctx already has. Metadata is
+// passed to policies, recorded by the audit hook, written with retry logs and errors,
+// and attributes consumed capacity, see capacity.Attribution.
+func WithMetadata(ctx context.Context,key,value string) context.Context {
+	m := make(map[string] string)
+	for k,v := range MetadataFrom(ctx) {
+		m[k] = v
+	}
+	m[key] = value
+	return context.WithValue(ctx,metadataKey(0),m)
+}
+
+// MetadataFrom returns the metadata of ctx, which must not be modified.
+func MetadataFrom(ctx context.Context) map[string] string {
+	m,_ := ctx.Value(metadataKey(0)).(map[string] string)
+	return m
+}
+
+// logMetadata formats metadata for logs and errors, as " (k=v,...)", or "" if none.
+func logMetadata(m map[string] string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	kvs := make([]string,0,len(m))
+	for k,v := range m {
+		kvs = append(kvs,k + "=" + v)
+	}
+	sort.Strings(kvs)
+	return " (" + strings.Join(kvs,",") + ")"
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Pause and Resume suspend the background activity of the client for maintenance
+// windows without tearing it down as Close does: the host resolver, the IAM credential
+// refreshers and the loops that wait on Resumed: update_table's ScheduleBillingMode, the
+// interval flushes of batch_write_item's WriteBuffer and journal's GoReplay. Requests
+// made directly by the caller, including flushes of a full WriteBuffer, are not affected.
+package authreq
+
+import (
+	"sync"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/conf_iam"
+)
+
+// while paused, resume is non-nil and closed by Resume.
+var pause struct {
+	sync.Mutex
+	resume chan bool
+}
+
+// resumed is returned by Resumed when not paused.
+var resumed = make(chan bool)
+
+func init() {
+	close(resumed)
+}
+
+// Pause suspends background activity until Resume is called. Pausing an already
+// paused client has no effect.
+func Pause() {
+	pause.Lock()
+	defer pause.Unlock()
+	if pause.resume != nil {
+		return
+	}
+	pause.resume = make(chan bool)
+	auth_v4.PauseResolver()
+	conf_iam.PauseWatch()
+}
+
+// Resume restarts the background activity suspended by Pause. Work that came due during
+// the pause, such as an expiring credential refresh, is done then.
+func Resume() {
+	pause.Lock()
+	defer pause.Unlock()
+	if pause.resume == nil {
+		return
+	}
+	auth_v4.ResumeResolver()
+	conf_iam.ResumeWatch()
+	close(pause.resume)
+	pause.resume = nil
+}
+
+// Paused reports whether Pause is in effect.
+func Paused() bool {
+	pause.Lock()
+	defer pause.Unlock()
+	return pause.resume != nil
+}
+
+// Resumed returns a channel that is closed once the client is not paused. Background
+// loops receive from it before doing their work.
+func Resumed() <-chan bool {
+	pause.Lock()
+	defer pause.Unlock()
+	if pause.resume == nil {
+		return resumed
+	}
+	return pause.resume
+}
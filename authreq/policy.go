@@ -0,0 +1,161 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"fmt"
+	"sync"
+	"errors"
+	"context"
+	"strings"
+	"encoding/json"
+	"github.com/smugmug/godynamo/aws_const"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// PolicyRequest describes a request on one table to a Policy.
+type PolicyRequest struct {
+	Operation string
+	TableName string
+	// the keys read, updated or deleted, and the items put
+	Keys []ep.Item
+	Items []ep.Item
+	// the metadata of the request's context
+	Metadata map[string] string
+}
+
+// A Policy returns a non-nil error to deny a request. Caller metadata can be passed
+// to it on the context of RetryReqContext_V4, as the endpoint methods use a
+// background context.
+type Policy func(ctx context.Context,r PolicyRequest) error
+
+// DeniedError is returned for a request a Policy denied. Denied requests are not sent.
+type DeniedError struct {
+	PolicyRequest
+	Err error
+}
+
+func (d *DeniedError) Error() string {
+	return fmt.Sprintf("authreq: %s on %s denied by policy: %s",
+		d.Operation,d.TableName,d.Err.Error())
+}
+
+var policies struct {
+	lock sync.RWMutex
+	m map[string] []Policy
+}
+
+// RegisterPolicy adds p to the policies evaluated before each request on tablename.
+func RegisterPolicy(tablename string,p Policy) {
+	policies.lock.Lock()
+	defer policies.lock.Unlock()
+	if policies.m == nil {
+		policies.m = make(map[string] []Policy)
+	}
+	policies.m[tablename] = append(policies.m[tablename],p)
+}
+
+// policyRequests decodes the request v into a PolicyRequest per table.
+func policyRequests(v interface{},op string) ([]PolicyRequest,error) {
+	b,ok := v.([]byte)
+	if !ok {
+		var m_err error
+		if b,m_err = json.Marshal(v); m_err != nil {
+			return nil,m_err
+		}
+	}
+	var r struct {
+		TableName string
+		Key ep.Item
+		Item ep.Item
+		RequestItems map[string] json.RawMessage
+	}
+	if um_err := json.Unmarshal(b,&r); um_err != nil {
+		return nil,um_err
+	}
+	if r.RequestItems == nil {
+		pr := PolicyRequest{Operation:op,TableName:r.TableName}
+		if len(r.Key) != 0 {
+			pr.Keys = []ep.Item{r.Key}
+		}
+		if len(r.Item) != 0 {
+			pr.Items = []ep.Item{r.Item}
+		}
+		return []PolicyRequest{pr},nil
+	}
+	prs := make([]PolicyRequest,0,len(r.RequestItems))
+	for tablename,raw := range r.RequestItems {
+		pr := PolicyRequest{Operation:op,TableName:tablename}
+		var gets struct { Keys []ep.Item }
+		var writes []struct {
+			PutRequest *struct { Item ep.Item }
+			DeleteRequest *struct { Key ep.Item }
+		}
+		if json.Unmarshal(raw,&writes) == nil {
+			for _,w := range writes {
+				if w.PutRequest != nil {
+					pr.Items = append(pr.Items,w.PutRequest.Item)
+				}
+				if w.DeleteRequest != nil {
+					pr.Keys = append(pr.Keys,w.DeleteRequest.Key)
+				}
+			}
+		} else if um_err := json.Unmarshal(raw,&gets); um_err == nil {
+			pr.Keys = gets.Keys
+		} else {
+			return nil,um_err
+		}
+		prs = append(prs,pr)
+	}
+	return prs,nil
+}
+
+// checkPolicies evaluates the policies of the tables the request v touches.
+func checkPolicies(ctx context.Context,v interface{},amzTarget string) error {
+	policies.lock.RLock()
+	registered := len(policies.m) != 0
+	policies.lock.RUnlock()
+	if !registered {
+		return nil
+	}
+	op := strings.TrimPrefix(amzTarget,aws_const.ENDPOINT_PREFIX)
+	prs,err := policyRequests(v,op)
+	if err != nil {
+		e := fmt.Sprintf("cannot decode request: %s",err.Error())
+		return &DeniedError{PolicyRequest:PolicyRequest{Operation:op},Err:errors.New(e)}
+	}
+	for _,pr := range prs {
+		pr.Metadata = MetadataFrom(ctx)
+		policies.lock.RLock()
+		ps := policies.m[pr.TableName]
+		policies.lock.RUnlock()
+		for _,p := range ps {
+			if p_err := p(ctx,pr); p_err != nil {
+				return &DeniedError{PolicyRequest:pr,Err:p_err}
+			}
+		}
+	}
+	return nil
+}
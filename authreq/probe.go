@@ -0,0 +1,178 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package authreq
+
+import (
+	"sync"
+	"time"
+	"context"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// how often the endpoints are probed unless conf.Vals.ProbeInterval is set
+	PROBE_INTERVAL = 30 * time.Second
+	// the weight of each new probe in the smoothed latency of its endpoint
+	PROBE_EWMA_WEIGHT = 0.3
+)
+
+// EndpointStat describes the probes of one endpoint: the primary zone of the default
+// configuration, or one of its replica zones.
+type EndpointStat struct {
+	// the configuration of the endpoint, "" for the primary
+	Conf string
+	Zone string
+	Host string
+	// the exponentially weighted moving average of the probe round trips, and the latest
+	Latency time.Duration
+	Last time.Duration
+	// false once a probe fails, until one succeeds
+	Healthy bool
+	// probes failed in a row
+	Failures int
+	Probes int
+	Probed time.Time
+}
+
+// the probes of each endpoint, keyed by configuration name
+var probes struct {
+	sync.Mutex
+	once sync.Once
+	m map[string] *EndpointStat
+}
+
+// closed by Close to end the probes
+var (
+	stopProbes = make(chan bool)
+	stopProbesOnce sync.Once
+)
+
+// probeCandidates names the configurations of the endpoints to probe: "" for the
+// primary, and those of the replica zones.
+func probeCandidates() []string {
+	names := []string{""}
+	for _,r := range conf.Replicas() {
+		names = append(names,r.Conf)
+	}
+	return names
+}
+
+// probe makes one Ping of the configuration called name and folds its round trip into
+// the stats of the endpoint.
+func probe(name string) {
+	ctx := context.Background()
+	if name != "" {
+		ctx = conf.WithName(ctx,name)
+	}
+	r,err := Ping(ctx)
+	probes.Lock()
+	defer probes.Unlock()
+	s,ok := probes.m[name]
+	if !ok {
+		s = &EndpointStat{Conf:name}
+		probes.m[name] = s
+	}
+	s.Probes++
+	s.Probed = time.Now()
+	if err != nil {
+		s.Healthy = false
+		s.Failures++
+		return
+	}
+	s.Zone,s.Host = r.Zone,r.Host
+	s.Last = r.Latency
+	if s.Latency == 0 {
+		s.Latency = r.Latency
+	} else {
+		s.Latency = time.Duration(PROBE_EWMA_WEIGHT * float64(r.Latency) +
+			(1 - PROBE_EWMA_WEIGHT) * float64(s.Latency))
+	}
+	s.Healthy = true
+	s.Failures = 0
+}
+
+// probeInterval returns the configured interval between probes.
+func probeInterval() time.Duration {
+	conf.Vals.ConfLock.RLock()
+	interval := conf.Vals.ProbeInterval
+	conf.Vals.ConfLock.RUnlock()
+	if interval <= 0 {
+		return PROBE_INTERVAL
+	}
+	return interval
+}
+
+// probing reports whether the endpoints are to be probed: if a probe interval is
+// configured, or reads may be steered to the fastest endpoint.
+func probing() bool {
+	conf.Vals.ConfLock.RLock()
+	interval := conf.Vals.ProbeInterval
+	conf.Vals.ConfLock.RUnlock()
+	return interval > 0 || conf.ReadPreference() == conf.READ_PREFERENCE_FASTEST
+}
+
+// startProbes starts probing the endpoints, now and then every probe interval, except
+// while paused, until Close. Endpoints added by a reload are probed from the next round.
+func startProbes() {
+	probes.once.Do(func() {
+		probes.Lock()
+		probes.m = make(map[string] *EndpointStat)
+		probes.Unlock()
+		go func() {
+			for {
+				for _,name := range probeCandidates() {
+					probe(name)
+				}
+				select {
+				case <- time.After(probeInterval()):
+				case <- stopProbes:
+					return
+				}
+				select {
+				case <- Resumed():
+				case <- stopProbes:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// EndpointStats returns the probe stats of the primary (first) and replica endpoints, in
+// the order of the replica zones. Endpoints are probed once a request is made with a
+// probe_interval configured or the "fastest" read preference; before that, and for
+// endpoints not yet probed, there are none.
+func EndpointStats() []EndpointStat {
+	probes.Lock()
+	defer probes.Unlock()
+	stats := make([]EndpointStat,0,len(probes.m))
+	for _,name := range probeCandidates() {
+		if s,ok := probes.m[name]; ok {
+			stats = append(stats,*s)
+		}
+	}
+	return stats
+}
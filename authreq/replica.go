@@ -0,0 +1,139 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package authreq
+
+import (
+	"time"
+	"context"
+	"encoding/json"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// the endpoints whose eventually consistent reads a replica may serve
+var replicaReads = map[string] bool{
+	aws_const.ENDPOINT_PREFIX + "GetItem":true,
+	aws_const.ENDPOINT_PREFIX + "BatchGetItem":true,
+	aws_const.ENDPOINT_PREFIX + "Query":true,
+	aws_const.ENDPOINT_PREFIX + "Scan":true,
+}
+
+type readPreferenceKey int
+
+// WithReadPreference returns ctx carrying the read preference (one of
+// conf.READ_PREFERENCE_*) of the requests made with it, in place of that of the conf file.
+func WithReadPreference(ctx context.Context,preference string) context.Context {
+	return context.WithValue(ctx,readPreferenceKey(0),preference)
+}
+
+// readPreferenceFor returns the read preference of a request on ctx.
+func readPreferenceFor(ctx context.Context) string {
+	if p,ok := ctx.Value(readPreferenceKey(0)).(string); ok && p != "" {
+		return p
+	}
+	return conf.ReadPreference()
+}
+
+// consistentRead reports whether the read v asks for strong consistency, which only the
+// primary can give. A request that cannot be read is taken to.
+func consistentRead(v interface{}) bool {
+	b,ok := v.([]byte)
+	if !ok {
+		var m_err error
+		if b,m_err = json.Marshal(v); m_err != nil {
+			return true
+		}
+	}
+	var r struct {
+		ConsistentRead bool
+		RequestItems map[string] struct {
+			ConsistentRead bool
+		}
+	}
+	if json.Unmarshal(b,&r) != nil {
+		return true
+	}
+	for _,t := range r.RequestItems {
+		if t.ConsistentRead {
+			return true
+		}
+	}
+	return r.ConsistentRead
+}
+
+// ReplicaLatencies returns the smoothed probe latencies (see EndpointStats) of the
+// primary (keyed "") and replica configurations that are healthy.
+func ReplicaLatencies() map[string] time.Duration {
+	m := make(map[string] time.Duration)
+	for _,s := range EndpointStats() {
+		if s.Healthy {
+			m[s.Conf] = s.Latency
+		}
+	}
+	return m
+}
+
+// fastestZone returns the configuration of the healthy zone with the lowest smoothed
+// probe latency, "" for the primary, which is also used until a replica has answered a
+// probe.
+func fastestZone() string {
+	startProbes()
+	best,best_latency,found := "",time.Duration(0),false
+	for _,s := range EndpointStats() {
+		if s.Healthy && (!found || s.Latency < best_latency) {
+			best,best_latency,found = s.Conf,s.Latency,true
+		}
+	}
+	return best
+}
+
+// routeRead directs ctx to the replica zone that should serve the read v, by the read
+// preference. Writes, consistent reads, and requests whose context already names a
+// configuration (including those of table aliases) are left to go where ctx sends them.
+func routeRead(ctx context.Context,v interface{},amzTarget string) context.Context {
+	if !replicaReads[amzTarget] || conf.NameFrom(ctx) != "" {
+		return ctx
+	}
+	replicas := conf.Replicas()
+	if len(replicas) == 0 {
+		return ctx
+	}
+	preference := readPreferenceFor(ctx)
+	if preference == conf.READ_PREFERENCE_PRIMARY || consistentRead(v) {
+		return ctx
+	}
+	name := ""
+	switch preference {
+	case conf.READ_PREFERENCE_NEAREST:
+		name = replicas[0].Conf
+	case conf.READ_PREFERENCE_FASTEST:
+		name = fastestZone()
+	}
+	if name == "" {
+		return ctx
+	}
+	return conf.WithName(ctx,name)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"sync"
+	"time"
+	"math"
+	"context"
+	"math/rand"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// RetryPolicy governs how RetryReq retries a failed request: up to Retries attempts in
+// all, waiting a random time in [0,min(Base*Factor**n,Max)) before attempt n.
+type RetryPolicy struct {
+	Retries int
+	Base time.Duration
+	Factor float64
+	// 0 for no bound
+	Max time.Duration
+	// overrides conf.Vals.Inflight.Wait if not 0
+	InflightWait time.Duration
+}
+
+// The retry policy presets.
+var (
+	// the behavior of RetryReq before presets
+	DEFAULT_RETRY_POLICY = RetryPolicy{Retries:aws_const.RETRIES,Base:100 * time.Millisecond,Factor:4}
+	// low-latency API paths: fail fast rather than hold a caller
+	INTERACTIVE_RETRY_POLICY = RetryPolicy{Retries:3,Base:25 * time.Millisecond,Factor:2,
+		Max:200 * time.Millisecond,InflightWait:50 * time.Millisecond}
+	// bulk jobs: ride out throttling
+	BATCH_RETRY_POLICY = RetryPolicy{Retries:10,Base:100 * time.Millisecond,Factor:2,
+		Max:20 * time.Second,InflightWait:time.Minute}
+	// stream processors: keep up, but retry long enough not to drop records
+	PIPELINE_RETRY_POLICY = RetryPolicy{Retries:8,Base:50 * time.Millisecond,Factor:2,
+		Max:5 * time.Second,InflightWait:5 * time.Second}
+)
+
+// RETRY_POLICIES names the presets, as they may be selected in the conf file.
+var RETRY_POLICIES = map[string] RetryPolicy{
+	"":DEFAULT_RETRY_POLICY,
+	conf.RETRY_POLICY_DEFAULT:DEFAULT_RETRY_POLICY,
+	conf.RETRY_POLICY_INTERACTIVE:INTERACTIVE_RETRY_POLICY,
+	conf.RETRY_POLICY_BATCH:BATCH_RETRY_POLICY,
+	conf.RETRY_POLICY_PIPELINE:PIPELINE_RETRY_POLICY,
+}
+
+var retryPolicy struct {
+	lock sync.RWMutex
+	set bool
+	p RetryPolicy
+}
+
+// SetRetryPolicy sets the policy for requests that do not carry one on their context,
+// replacing that named in the conf file.
+func SetRetryPolicy(p RetryPolicy) {
+	retryPolicy.lock.Lock()
+	defer retryPolicy.lock.Unlock()
+	retryPolicy.set = true
+	retryPolicy.p = p
+}
+
+type retryPolicyKey int
+
+// WithRetryPolicy returns ctx carrying p, for RetryReqContext_V4. It takes precedence
+// over SetRetryPolicy and the conf file.
+func WithRetryPolicy(ctx context.Context,p RetryPolicy) context.Context {
+	return context.WithValue(ctx,retryPolicyKey(0),p)
+}
+
+// policyFor returns the retry policy of a request on ctx.
+func policyFor(ctx context.Context) RetryPolicy {
+	if p,ok := ctx.Value(retryPolicyKey(0)).(RetryPolicy); ok {
+		return p
+	}
+	retryPolicy.lock.RLock()
+	set,p := retryPolicy.set,retryPolicy.p
+	retryPolicy.lock.RUnlock()
+	if set {
+		return p
+	}
+	conf.Vals.ConfLock.RLock()
+	name := conf.Vals.RetryPolicy
+	conf.Vals.ConfLock.RUnlock()
+	return confPolicy(name)
+}
+
+// confPolicy returns the preset name with the overrides of the retry section of the
+// conf file.
+func confPolicy(name string) RetryPolicy {
+	p,ok := RETRY_POLICIES[name]
+	if !ok {
+		auth_v4.Logf("authreq: unknown retry_policy %s, using the default\n",name)
+		p = DEFAULT_RETRY_POLICY
+	}
+	conf.Vals.ConfLock.RLock()
+	defer conf.Vals.ConfLock.RUnlock()
+	if conf.Vals.Retry.Retries > 0 {
+		p.Retries = conf.Vals.Retry.Retries
+	}
+	if conf.Vals.Retry.Base > 0 {
+		p.Base = conf.Vals.Retry.Base
+	}
+	if conf.Vals.Retry.Max > 0 {
+		p.Max = conf.Vals.Retry.Max
+	}
+	if conf.Vals.Retry.Factor > 0 {
+		p.Factor = conf.Vals.Retry.Factor
+	}
+	return p
+}
+
+// streamPolicy returns ctx carrying the retry policy the conf file sets for streamed
+// responses, unless ctx carries a policy or one was set with SetRetryPolicy.
+func streamPolicy(ctx context.Context) context.Context {
+	if _,ok := ctx.Value(retryPolicyKey(0)).(RetryPolicy); ok {
+		return ctx
+	}
+	retryPolicy.lock.RLock()
+	set := retryPolicy.set
+	retryPolicy.lock.RUnlock()
+	conf.Vals.ConfLock.RLock()
+	name := conf.Vals.Streams.RetryPolicy
+	conf.Vals.ConfLock.RUnlock()
+	if set || name == "" {
+		return ctx
+	}
+	return WithRetryPolicy(ctx,confPolicy(name))
+}
+
+// delay returns the random wait before attempt n.
+func (p RetryPolicy) delay(n int,g *rand.Rand) time.Duration {
+	d := float64(p.Base) * math.Pow(p.Factor,float64(n))
+	if p.Max > 0 && d > float64(p.Max) {
+		d = float64(p.Max)
+	}
+	if d < 1 {
+		return 0
+	}
+	return time.Duration(g.Int63n(int64(d)))
+}
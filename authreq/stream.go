@@ -0,0 +1,114 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authreq
+
+import (
+	"io"
+	"sync"
+	"context"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"github.com/smugmug/godynamo/auth_v4"
+)
+
+// RetryReqJSONStream_V4 is RetryReqJSONContext_V4 for responses too large to hold in
+// memory, such as multi-megabyte Scan pages to be piped straight to an encoder. The
+// body of a successful (200) response is returned unread, and must be closed. Retries
+// happen only before it is returned: an error from reading the body (including
+// auth_v4.ErrChecksumMismatch at its end) is not retried, since the caller has consumed
+// bytes by then. Any other response is returned as by RetryReqJSONContext_V4, with its
+// body in the reader. The request keeps its inflight slots, and Close waits for it,
+// until the body is closed. The consumed capacity of the response is not recorded, and
+// the tables of batch responses keep their physical names when aliases were used.
+func RetryReqJSONStream_V4(ctx context.Context,reqJSON []byte,amzTarget string) (io.ReadCloser,int,error) {
+	ctx,aliased,_,alias_err := resolveAliases(ctx,reqJSON)
+	if alias_err != nil {
+		return nil,0,alias_err
+	}
+	reqJSON = aliased.([]byte)
+	if probing() {
+		startProbes()
+	}
+	ctx = routeOperation(ctx,amzTarget)
+	ctx = routeRead(ctx,reqJSON,amzTarget)
+	ctx = streamPolicy(ctx)
+	if _,conf_err := confFor(ctx); conf_err != nil {
+		return nil,0,conf_err
+	}
+	if policy_err := checkPolicies(ctx,reqJSON,amzTarget); policy_err != nil {
+		return nil,0,policy_err
+	}
+	held,inflight_err := acquireInflight(ctx,reqJSON)
+	if inflight_err != nil {
+		return nil,0,inflight_err
+	}
+	if !begin() {
+		held.release()
+		return nil,0,ErrClosed
+	}
+	done := func() {
+		held.release()
+		lifecycle.inflight.Done()
+	}
+	// the body of the attempt that succeeded, which retryLoop never sees
+	var body io.ReadCloser
+	send := func() (string,string,int,error) {
+		rc,amz_requestid,code,err := auth_v4.RawReqStreamContext(ctx,reqJSON,amzTarget)
+		if err != nil {
+			return "",amz_requestid,code,err
+		}
+		if code == http.StatusOK {
+			body = rc
+			return "",amz_requestid,code,nil
+		}
+		resp_body,_ := ioutil.ReadAll(rc)
+		rc.Close()
+		return string(resp_body),amz_requestid,code,nil
+	}
+	resp_body,code,err := retryLoop(ctx,reqJSON,amzTarget,held,send)
+	auditReq(ctx,reqJSON,amzTarget,resp_body,code,err)
+	if err != nil || code != http.StatusOK {
+		done()
+		if err != nil {
+			return nil,code,err
+		}
+		return ioutil.NopCloser(strings.NewReader(resp_body)),code,nil
+	}
+	return &streamBody{ReadCloser:body,done:done},code,nil
+}
+
+// streamBody gives up the resources of its request when it is closed.
+type streamBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
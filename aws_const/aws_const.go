@@ -43,7 +43,15 @@ const (
 	X_AMZ_SECURITY_TOKEN_HDR = "X-Amz-Security-Token"
 	X_AMZN_AUTHORIZATION_HDR = "X-Amzn-Authorization"
 	RETRIES                  = 7
+	// the error codes below are kept for existing callers; the aws_errors package
+	// enumerates them all and classifies responses by their __type rather than by
+	// substring.
 	EXCEEDED_MSG             = "ProvisionedThroughputExceededException"
 	UNRECOGNIZED_CLIENT_MSG  = "UnrecognizedClientException"
 	THROTTLING_MSG           = "ThrottlingException"
+	INVALID_SIGNATURE_MSG    = "InvalidSignatureException"
+	SIGNATURE_EXPIRED_MSG    = "Signature expired"
+	SIGNATURE_NOT_YET_MSG    = "Signature not yet current"
+	TOO_SKEWED_MSG           = "RequestTimeTooSkewed"
+	CONDITIONAL_FAILED_MSG   = "ConditionalCheckFailedException"
 )
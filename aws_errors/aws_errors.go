@@ -0,0 +1,177 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Package aws_errors enumerates the error codes DynamoDB returns in the __type field of
+// an error response, and provides predicates to classify response bodies by them.
+// See: http://docs.aws.amazon.com/amazondynamodb/latest/APIReference/CommonErrors.html
+package aws_errors
+
+import (
+	"strings"
+	"encoding/json"
+)
+
+const (
+	ACCESS_DENIED                       = "AccessDeniedException"
+	BACKUP_IN_USE                       = "BackupInUseException"
+	BACKUP_NOT_FOUND                    = "BackupNotFoundException"
+	CONDITIONAL_CHECK_FAILED            = "ConditionalCheckFailedException"
+	CONTINUOUS_BACKUPS_UNAVAILABLE      = "ContinuousBackupsUnavailableException"
+	DUPLICATE_ITEM                      = "DuplicateItemException"
+	EXPIRED_TOKEN                       = "ExpiredTokenException"
+	EXPORT_CONFLICT                     = "ExportConflictException"
+	EXPORT_NOT_FOUND                    = "ExportNotFoundException"
+	GLOBAL_TABLE_ALREADY_EXISTS         = "GlobalTableAlreadyExistsException"
+	GLOBAL_TABLE_NOT_FOUND              = "GlobalTableNotFoundException"
+	IDEMPOTENT_PARAMETER_MISMATCH       = "IdempotentParameterMismatchException"
+	IMPORT_CONFLICT                     = "ImportConflictException"
+	IMPORT_NOT_FOUND                    = "ImportNotFoundException"
+	INCOMPLETE_SIGNATURE                = "IncompleteSignatureException"
+	INDEX_NOT_FOUND                     = "IndexNotFoundException"
+	INTERNAL_FAILURE                    = "InternalFailure"
+	INTERNAL_SERVER_ERROR               = "InternalServerError"
+	INVALID_ENDPOINT                    = "InvalidEndpointException"
+	INVALID_EXPORT_TIME                 = "InvalidExportTimeException"
+	INVALID_RESTORE_TIME                = "InvalidRestoreTimeException"
+	INVALID_SIGNATURE                   = "InvalidSignatureException"
+	ITEM_COLLECTION_SIZE_LIMIT_EXCEEDED = "ItemCollectionSizeLimitExceededException"
+	LIMIT_EXCEEDED                      = "LimitExceededException"
+	MISSING_AUTHENTICATION_TOKEN        = "MissingAuthenticationTokenException"
+	POINT_IN_TIME_RECOVERY_UNAVAILABLE  = "PointInTimeRecoveryUnavailableException"
+	POLICY_NOT_FOUND                    = "PolicyNotFoundException"
+	PROVISIONED_THROUGHPUT_EXCEEDED     = "ProvisionedThroughputExceededException"
+	REPLICA_ALREADY_EXISTS              = "ReplicaAlreadyExistsException"
+	REPLICA_NOT_FOUND                   = "ReplicaNotFoundException"
+	REQUEST_LIMIT_EXCEEDED              = "RequestLimitExceeded"
+	RESOURCE_IN_USE                     = "ResourceInUseException"
+	RESOURCE_NOT_FOUND                  = "ResourceNotFoundException"
+	SERIALIZATION                       = "SerializationException"
+	SERVICE_UNAVAILABLE                 = "ServiceUnavailable"
+	TABLE_ALREADY_EXISTS                = "TableAlreadyExistsException"
+	TABLE_IN_USE                        = "TableInUseException"
+	TABLE_NOT_FOUND                     = "TableNotFoundException"
+	THROTTLING                          = "ThrottlingException"
+	TRANSACTION_CANCELED                = "TransactionCanceledException"
+	TRANSACTION_CONFLICT                = "TransactionConflictException"
+	TRANSACTION_IN_PROGRESS             = "TransactionInProgressException"
+	UNRECOGNIZED_CLIENT                 = "UnrecognizedClientException"
+	VALIDATION                          = "ValidationException"
+)
+
+// CODES lists every error code above.
+var CODES = []string{
+	ACCESS_DENIED,BACKUP_IN_USE,BACKUP_NOT_FOUND,CONDITIONAL_CHECK_FAILED,
+	CONTINUOUS_BACKUPS_UNAVAILABLE,DUPLICATE_ITEM,EXPIRED_TOKEN,EXPORT_CONFLICT,
+	EXPORT_NOT_FOUND,GLOBAL_TABLE_ALREADY_EXISTS,GLOBAL_TABLE_NOT_FOUND,
+	IDEMPOTENT_PARAMETER_MISMATCH,IMPORT_CONFLICT,IMPORT_NOT_FOUND,INCOMPLETE_SIGNATURE,
+	INDEX_NOT_FOUND,INTERNAL_FAILURE,INTERNAL_SERVER_ERROR,INVALID_ENDPOINT,
+	INVALID_EXPORT_TIME,INVALID_RESTORE_TIME,INVALID_SIGNATURE,
+	ITEM_COLLECTION_SIZE_LIMIT_EXCEEDED,LIMIT_EXCEEDED,MISSING_AUTHENTICATION_TOKEN,
+	POINT_IN_TIME_RECOVERY_UNAVAILABLE,POLICY_NOT_FOUND,PROVISIONED_THROUGHPUT_EXCEEDED,
+	REPLICA_ALREADY_EXISTS,REPLICA_NOT_FOUND,REQUEST_LIMIT_EXCEEDED,RESOURCE_IN_USE,
+	RESOURCE_NOT_FOUND,SERIALIZATION,SERVICE_UNAVAILABLE,TABLE_ALREADY_EXISTS,
+	TABLE_IN_USE,TABLE_NOT_FOUND,THROTTLING,TRANSACTION_CANCELED,TRANSACTION_CONFLICT,
+	TRANSACTION_IN_PROGRESS,UNRECOGNIZED_CLIENT,VALIDATION,
+}
+
+// Code returns the error code of a DynamoDB error response body, such as
+// "ResourceNotFoundException" for a __type of
+// "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException". If s is not a JSON
+// error body (e.g. it is the text of an error wrapping one), the first code of CODES
+// that occurs in s as a whole word is returned. The empty string is returned if no
+// code is found.
+func Code(s string) string {
+	var e struct {
+		Type string `json:"__type"`
+	}
+	if json.Unmarshal([]byte(s),&e) == nil && e.Type != "" {
+		if i := strings.LastIndex(e.Type,"#"); i != -1 {
+			return e.Type[i+1:]
+		}
+		return e.Type
+	}
+	for _,c := range CODES {
+		if containsWord(s,c) {
+			return c
+		}
+	}
+	return ""
+}
+
+// containsWord determines if code occurs in s not preceded or followed by a letter, so
+// that LimitExceededException is not found in ItemCollectionSizeLimitExceededException.
+func containsWord(s,code string) bool {
+	for i := strings.Index(s,code); i != -1; {
+		end := i + len(code)
+		if (i == 0 || !isLetter(s[i-1])) && (end == len(s) || !isLetter(s[end])) {
+			return true
+		}
+		next := strings.Index(s[i+1:],code)
+		if next == -1 {
+			return false
+		}
+		i += next + 1
+	}
+	return false
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// Is determines if s carries any of the error codes.
+func Is(s string,codes ...string) bool {
+	c := Code(s)
+	if c == "" {
+		return false
+	}
+	for _,code := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsThrottle determines if s is a throughput or request rate error, which may be retried
+// after a backoff.
+func IsThrottle(s string) bool {
+	return Is(s,PROVISIONED_THROUGHPUT_EXCEEDED,THROTTLING,REQUEST_LIMIT_EXCEEDED)
+}
+
+// IsConditional determines if s is a failed condition (Expected or ConditionExpression).
+func IsConditional(s string) bool {
+	return Is(s,CONDITIONAL_CHECK_FAILED)
+}
+
+// IsResourceNotFound determines if s reports a table, index or other resource that does
+// not exist (or is not yet ACTIVE).
+func IsResourceNotFound(s string) bool {
+	return Is(s,RESOURCE_NOT_FOUND,TABLE_NOT_FOUND,INDEX_NOT_FOUND)
+}
+
+// IsValidation determines if s reports a malformed request, which will fail again if
+// retried unchanged.
+func IsValidation(s string) bool {
+	return Is(s,VALIDATION,SERIALIZATION)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package aws_errors
+
+import (
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	body := `{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`
+	if c := Code(body); c != RESOURCE_NOT_FOUND {
+		t.Errorf("expected %s, got %s\n",RESOURCE_NOT_FOUND,c)
+	}
+	if !IsResourceNotFound(body) || IsThrottle(body) {
+		t.Errorf("misclassified %s\n",body)
+	}
+	body = `{"__type":"com.amazon.coral.validate#ValidationException","message":"ProvisionedThroughputExceededException"}`
+	if !IsValidation(body) || IsThrottle(body) {
+		t.Errorf("message text should not be taken as the code: %s\n",body)
+	}
+	wrapped := "authreq.RetryReq: code 400 ItemCollectionSizeLimitExceededException"
+	if c := Code(wrapped); c != ITEM_COLLECTION_SIZE_LIMIT_EXCEEDED {
+		t.Errorf("expected %s, got %s\n",ITEM_COLLECTION_SIZE_LIMIT_EXCEEDED,c)
+	}
+	if !IsThrottle("retries exhausted: ThrottlingException") {
+		t.Errorf("expected a throttle\n")
+	}
+	if Code("not an error") != "" || IsConditional("") {
+		t.Errorf("expected no code\n")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Table-wide operations built on parallel scans.
+package bulk
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"github.com/smugmug/godynamo/aws_errors"
+	ep "github.com/smugmug/godynamo/endpoint"
+	batch "github.com/smugmug/godynamo/endpoints/batch_write_item"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+const (
+	// parallel scan segments used unless otherwise specified
+	SEGMENTS = 4
+	// times a throttled batch is retried, after authreq's own retries, before giving up
+	THROTTLE_RETRIES = 5
+	// the first wait before retrying a throttled batch, doubled on each retry
+	THROTTLE_WAIT = time.Second
+)
+
+// Progress counts the work done so far by a bulk operation.
+type Progress struct {
+	Scanned uint64
+	Matched uint64
+	Updated uint64
+	Deleted uint64
+	Conflicted uint64
+}
+
+type counters struct {
+	p Progress
+	lock sync.Mutex
+	f func(Progress)
+}
+
+func (c *counters) report() {
+	if c.f == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.f(Progress{
+		Scanned:atomic.LoadUint64(&c.p.Scanned),
+		Matched:atomic.LoadUint64(&c.p.Matched),
+		Updated:atomic.LoadUint64(&c.p.Updated),
+		Deleted:atomic.LoadUint64(&c.p.Deleted),
+		Conflicted:atomic.LoadUint64(&c.p.Conflicted)})
+}
+
+// throttled determines if a response shows the table's capacity was exceeded.
+func throttled(body string,err error) bool {
+	if err != nil {
+		body = err.Error()
+	}
+	return aws_errors.IsThrottle(body)
+}
+
+// parallelScan runs s in `segments` segments concurrently, calling f with each page.
+// The first error stops all segments and is returned.
+func parallelScan(s scan.Scan,segments int,f func(*scan.Response) error) error {
+	if segments <= 0 {
+		segments = SEGMENTS
+	}
+	var wg sync.WaitGroup
+	var stop int32
+	errs := make(chan error,segments)
+	for i := 0; i < segments; i++ {
+		seg := s
+		seg.Segment = ep.NullableUInt64(i)
+		seg.TotalSegments = ep.NullableUInt64(segments)
+		wg.Add(1)
+		go func(seg scan.Scan) {
+			defer wg.Done()
+			err := seg.ForEachPage(func(r *scan.Response) error {
+				if atomic.LoadInt32(&stop) != 0 {
+					return errors.New("stopped")
+				}
+				return f(r)
+			})
+			if err != nil && atomic.CompareAndSwapInt32(&stop,0,1) {
+				errs <- err
+			}
+		}(seg)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// keyNames returns the key attribute names of tablename.
+func keyNames(tablename string) ([]string,error) {
+	t,t_err := desc.DescribeTable(tablename)
+	if t_err != nil {
+		return nil,t_err
+	}
+	hash,rangekey := t.KeyAttributeNames()
+	if rangekey == "" {
+		return []string{hash},nil
+	}
+	return []string{hash,rangekey},nil
+}
+
+// writeBatch writes b to completion, backing off and retrying while the table is throttled.
+func writeBatch(b batch.BatchWriteItem) error {
+	wait := THROTTLE_WAIT
+	for i := 0; ; i++ {
+		body,code,err := b.RetryBatchWrite(0)
+		if err == nil && code == http.StatusOK {
+			return nil
+		}
+		if i == THROTTLE_RETRIES || !throttled(body,err) {
+			e := fmt.Sprintf("code %d: %s %v",code,body,err)
+			return errors.New(e)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// TruncateTable deletes every item in tablename with a parallel scan of `segments` segments
+// (SEGMENTS if 0) that reads only key attributes, deleting each page of keys with batched
+// writes. progress, if not nil, is called after each batch. It returns the count of items deleted.
+func TruncateTable(tablename string,segments int,progress func(Progress)) (uint64,error) {
+	keys,keys_err := keyNames(tablename)
+	if keys_err != nil {
+		e := fmt.Sprintf("bulk.TruncateTable: %s",keys_err.Error())
+		return 0,errors.New(e)
+	}
+	c := &counters{f:progress}
+	s := scan.NewScan()
+	s.TableName = tablename
+	s.AttributesToGet = keys
+	err := parallelScan(*s,segments,func(r *scan.Response) error {
+		atomic.AddUint64(&c.p.Scanned,uint64(len(r.Items)))
+		for i := 0; i < len(r.Items); i += batch.QUERY_LIM {
+			end := i + batch.QUERY_LIM
+			if end > len(r.Items) {
+				end = len(r.Items)
+			}
+			b := batch.NewBatchWriteItem()
+			for _,key := range r.Items[i:end] {
+				b.RequestItems[tablename] = append(b.RequestItems[tablename],
+					batch.RequestInstance{DeleteRequest:&batch.DeleteRequest{Key:key}})
+			}
+			if w_err := writeBatch(*b); w_err != nil {
+				return w_err
+			}
+			atomic.AddUint64(&c.p.Deleted,uint64(end - i))
+			c.report()
+		}
+		return nil
+	})
+	deleted := atomic.LoadUint64(&c.p.Deleted)
+	if err != nil {
+		e := fmt.Sprintf("bulk.TruncateTable: after %d deletes: %s",deleted,err.Error())
+		return deleted,errors.New(e)
+	}
+	return deleted,nil
+}
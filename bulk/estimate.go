@@ -0,0 +1,185 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bulk
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"errors"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	update_table "github.com/smugmug/godynamo/endpoints/update_table"
+	batch "github.com/smugmug/godynamo/endpoints/batch_write_item"
+)
+
+// USD prices used by the estimates, as for us-east-1. Set these for other regions.
+var (
+	// per on-demand read and write request unit
+	READ_REQUEST_UNIT_PRICE  = 0.25 / 1e6
+	WRITE_REQUEST_UNIT_PRICE = 1.25 / 1e6
+	// per provisioned RCU and WCU hour
+	RCU_HOUR_PRICE = 0.00013
+	WCU_HOUR_PRICE = 0.00065
+)
+
+const (
+	// bytes per read and write capacity unit
+	READ_UNIT_BYTES  = 4096
+	WRITE_UNIT_BYTES = 1024
+	// the most a Scan reads in one request
+	SCAN_PAGE_BYTES = 1 << 20
+)
+
+// Estimate is the expected cost of a bulk operation, from the item statistics of
+// DescribeTable. These are updated by AWS only every six hours or so, so treat the
+// estimate as a guide to approve a job with rather than a bill.
+type Estimate struct {
+	Operation string
+	TableName string
+	// items read and items written
+	Items uint64
+	Writes uint64
+	AvgItemBytes uint64
+	ReadUnits float64
+	WriteUnits float64
+	Requests uint64
+	// USD, at on-demand prices for PAY_PER_REQUEST tables and at the price of the
+	// capacity-hours consumed otherwise
+	Cost float64
+	// for provisioned tables, the time to consume the units at the table's throughput
+	Duration time.Duration
+}
+
+func (e *Estimate) String() string {
+	s := fmt.Sprintf("%s on %s: %d items read, %d written (avg %d bytes); " +
+		"%.1f read units, %.1f write units in %d requests; ~$%.4f",
+		e.Operation,e.TableName,e.Items,e.Writes,e.AvgItemBytes,
+		e.ReadUnits,e.WriteUnits,e.Requests,e.Cost)
+	if e.Duration != 0 {
+		s += fmt.Sprintf(", ~%v at provisioned throughput",e.Duration)
+	}
+	return s
+}
+
+// writeUnits returns the write units to write one item of size bytes.
+func writeUnits(size uint64) float64 {
+	return math.Max(1,math.Ceil(float64(size) / WRITE_UNIT_BYTES))
+}
+
+// estimate completes e for t, given whether the table is scanned and the items written.
+// An AvgItemBytes already set in e is used for the written items.
+func estimate(e *Estimate,t *desc.TableDescription,scanned bool,writes uint64) *Estimate {
+	e.TableName = t.TableName
+	e.Writes = writes
+	if scanned {
+		e.Items = t.ItemCount
+	}
+	if e.AvgItemBytes == 0 && t.ItemCount != 0 {
+		e.AvgItemBytes = t.TableSizeBytes / t.ItemCount
+	}
+	if scanned {
+		// eventually consistent scans cost half a unit per 4KB read
+		e.ReadUnits = math.Ceil(float64(t.TableSizeBytes) / READ_UNIT_BYTES) / 2
+		e.Requests += uint64(math.Ceil(float64(t.TableSizeBytes) / SCAN_PAGE_BYTES))
+	}
+	// each write is also applied to every global secondary index
+	e.WriteUnits = float64(writes) * writeUnits(e.AvgItemBytes) *
+		float64(1 + len(t.GlobalSecondaryIndexes))
+	if t.BillingModeSummary.BillingMode == update_table.BILLING_PAY_PER_REQUEST {
+		e.Cost = e.ReadUnits * READ_REQUEST_UNIT_PRICE + e.WriteUnits * WRITE_REQUEST_UNIT_PRICE
+		return e
+	}
+	e.Cost = e.ReadUnits / 3600 * RCU_HOUR_PRICE + e.WriteUnits / 3600 * WCU_HOUR_PRICE
+	var secs float64
+	if rcu := t.ProvisionedThroughput.ReadCapacityUnits; rcu != 0 {
+		secs = e.ReadUnits / float64(rcu)
+	}
+	if wcu := t.ProvisionedThroughput.WriteCapacityUnits; wcu != 0 {
+		secs = math.Max(secs,e.WriteUnits / float64(wcu))
+	}
+	e.Duration = time.Duration(secs * float64(time.Second))
+	return e
+}
+
+func describe(fname,tablename string) (*desc.TableDescription,error) {
+	t,t_err := desc.DescribeTable(tablename)
+	if t_err != nil {
+		e := fmt.Sprintf("bulk.%s: %s",fname,t_err.Error())
+		return nil,errors.New(e)
+	}
+	return t,nil
+}
+
+// EstimateScan estimates the cost of a full parallel scan of tablename, as used by
+// the export package.
+func EstimateScan(tablename string) (*Estimate,error) {
+	t,t_err := describe("EstimateScan",tablename)
+	if t_err != nil {
+		return nil,t_err
+	}
+	return estimate(&Estimate{Operation:"Scan"},t,true,0),nil
+}
+
+// EstimateTruncate estimates the cost of TruncateTable(tablename,...). The key-only
+// scan costs the same as a full one, as units are charged on the size of items read.
+func EstimateTruncate(tablename string) (*Estimate,error) {
+	t,t_err := describe("EstimateTruncate",tablename)
+	if t_err != nil {
+		return nil,t_err
+	}
+	e := &Estimate{Operation:"TruncateTable"}
+	e.Requests = uint64(math.Ceil(float64(t.ItemCount) / batch.QUERY_LIM))
+	return estimate(e,t,true,t.ItemCount),nil
+}
+
+// EstimateUpdateWhere estimates the cost of UpdateWhere on tablename, where selectivity
+// is the expected fraction (0 to 1) of items the filter matches.
+func EstimateUpdateWhere(tablename string,selectivity float64) (*Estimate,error) {
+	if selectivity < 0 || selectivity > 1 {
+		e := fmt.Sprintf("bulk.EstimateUpdateWhere: selectivity %v not in [0,1]",selectivity)
+		return nil,errors.New(e)
+	}
+	t,t_err := describe("EstimateUpdateWhere",tablename)
+	if t_err != nil {
+		return nil,t_err
+	}
+	e := &Estimate{Operation:"UpdateWhere"}
+	matched := uint64(math.Ceil(float64(t.ItemCount) * selectivity))
+	e.Requests = matched
+	return estimate(e,t,true,matched),nil
+}
+
+// EstimateLoad estimates the cost of batch writing `items` new items of avg_bytes each
+// into tablename, as export.ImportCSV does.
+func EstimateLoad(tablename string,items,avg_bytes uint64) (*Estimate,error) {
+	t,t_err := describe("EstimateLoad",tablename)
+	if t_err != nil {
+		return nil,t_err
+	}
+	e := &Estimate{Operation:"Load"}
+	e.Requests = uint64(math.Ceil(float64(items) / batch.QUERY_LIM))
+	e.AvgItemBytes = avg_bytes
+	return estimate(e,t,false,items),nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bulk
+
+import (
+	"fmt"
+	"errors"
+	"sort"
+	"net/http"
+	"sync/atomic"
+	ep "github.com/smugmug/godynamo/endpoint"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+	update "github.com/smugmug/godynamo/endpoints/update_item"
+)
+
+// UpdateWhere is the DynamoDB equivalent of UPDATE ... WHERE: it scans tablename in
+// `segments` segments (SEGMENTS if 0) for the items matching filter and applies updates
+// to each of them. If guard is set, each update is conditional on the filtered attributes
+// still having the values scanned, so items changed since the scan are counted as
+// Conflicted rather than updated. Updates are always conditional on the item still
+// existing, so items deleted since the scan are counted as Conflicted, not re-created
+// with only the updated attributes. progress, if not nil, is called after each page.
+// The final Progress is returned.
+func UpdateWhere(tablename string,filter scan.ScanFilters,updates update.AttributeUpdates,guard bool,segments int,progress func(Progress)) (Progress,error) {
+	if len(updates) == 0 {
+		return Progress{},errors.New("bulk.UpdateWhere: no updates")
+	}
+	keys,keys_err := keyNames(tablename)
+	if keys_err != nil {
+		e := fmt.Sprintf("bulk.UpdateWhere: %s",keys_err.Error())
+		return Progress{},errors.New(e)
+	}
+	c := &counters{f:progress}
+	s := scan.NewScan()
+	s.TableName = tablename
+	s.ScanFilter = filter
+	s.AttributesToGet = scanAttributes(keys,filter)
+	err := parallelScan(*s,segments,func(r *scan.Response) error {
+		atomic.AddUint64(&c.p.Scanned,r.ScannedCount)
+		atomic.AddUint64(&c.p.Matched,uint64(len(r.Items)))
+		for _,item := range r.Items {
+			u := updateFor(tablename,keys,item,filter,updates,guard)
+			body,code,u_err := u.EndpointReq()
+			if u_err == nil && put.ConditionalFailed(code,body) {
+				atomic.AddUint64(&c.p.Conflicted,1)
+				continue
+			}
+			if u_err != nil || code != http.StatusOK {
+				e := fmt.Sprintf("update: code %d: %s %v",code,body,u_err)
+				return errors.New(e)
+			}
+			atomic.AddUint64(&c.p.Updated,1)
+		}
+		c.report()
+		return nil
+	})
+	final := Progress{
+		Scanned:atomic.LoadUint64(&c.p.Scanned),
+		Matched:atomic.LoadUint64(&c.p.Matched),
+		Updated:atomic.LoadUint64(&c.p.Updated),
+		Conflicted:atomic.LoadUint64(&c.p.Conflicted)}
+	if err != nil {
+		e := fmt.Sprintf("bulk.UpdateWhere: after %d updates: %s",final.Updated,err.Error())
+		return final,errors.New(e)
+	}
+	return final,nil
+}
+
+// scanAttributes returns the attributes the scan of UpdateWhere reads: the keys and those
+// of filter, each once.
+func scanAttributes(keys []string,filter scan.ScanFilters) []string {
+	attrs := append([]string{},keys...)
+	seen := make(map[string] bool)
+	for _,k := range keys {
+		seen[k] = true
+	}
+	names := make([]string,0,len(filter))
+	for k,_ := range filter {
+		if !seen[k] {
+			names = append(names,k)
+		}
+	}
+	sort.Strings(names)
+	return append(attrs,names...)
+}
+
+// updateFor returns the update of UpdateWhere for the scanned item, whose key attributes
+// are keys (the hash key first).
+func updateFor(tablename string,keys []string,item ep.Item,filter scan.ScanFilters,updates update.AttributeUpdates,guard bool) *update.Update {
+	u := update.NewUpdate()
+	u.TableName = tablename
+	for _,k := range keys {
+		u.Key[k] = item[k]
+	}
+	u.AttributeUpdates = updates
+	// the item must still exist, or UpdateItem would create it
+	u.Expected[keys[0]] = ep.Constraints{Value:item[keys[0]],Exists:true}
+	if guard {
+		for k,_ := range filter {
+			if v,ok := item[k]; ok {
+				u.Expected[k] = ep.Constraints{Value:v,Exists:true}
+			} else {
+				u.Expected[k] = ep.Constraints{Exists:false}
+			}
+		}
+	}
+	return u
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bulk
+
+import (
+	"testing"
+	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+	update "github.com/smugmug/godynamo/endpoints/update_item"
+)
+
+func TestScanAttributes(t *testing.T) {
+	filter := scan.ScanFilters{
+		"Id":scan.ScanFilter{ComparisonOperator:scan.OP_EQ},
+		"Status":scan.ScanFilter{ComparisonOperator:scan.OP_EQ},
+	}
+	attrs := scanAttributes([]string{"Id","Date"},filter)
+	if len(attrs) != 3 || attrs[0] != "Id" || attrs[1] != "Date" || attrs[2] != "Status" {
+		t.Errorf("unexpected attributes %v\n",attrs)
+	}
+	if attrs := scanAttributes([]string{"Id"},nil); len(attrs) != 1 {
+		t.Errorf("unexpected attributes %v\n",attrs)
+	}
+}
+
+func TestUpdateFor(t *testing.T) {
+	item := ep.Item{
+		"Id":ep.AttributeValue{S:"a"},
+		"Date":ep.AttributeValue{N:"1"},
+		"Status":ep.AttributeValue{S:"old"},
+	}
+	updates := update.AttributeUpdates{
+		"Status":update.AttributeAction{Value:ep.AttributeValue{S:"new"}},
+	}
+	filter := scan.ScanFilters{
+		"Status":scan.ScanFilter{ComparisonOperator:scan.OP_EQ},
+		"Missing":scan.ScanFilter{ComparisonOperator:scan.OP_NULL},
+	}
+	keys := []string{"Id","Date"}
+
+	// unguarded, and with no filter, the item must still exist
+	for _,f := range []scan.ScanFilters{filter,nil} {
+		u := updateFor("T",keys,item,f,updates,false)
+		if len(u.Key) != 2 || u.Key["Id"].S != "a" || u.Key["Date"].N != "1" {
+			t.Errorf("unexpected key %v\n",u.Key)
+		}
+		if len(u.Expected) != 1 || u.Expected["Id"].Exists != true || u.Expected["Id"].Value.S != "a" {
+			t.Errorf("unexpected expected %v\n",u.Expected)
+		}
+		if _,err := json.Marshal(u); err != nil {
+			t.Errorf("cannot marshal: %s\n",err.Error())
+		}
+	}
+
+	u := updateFor("T",keys,item,filter,updates,true)
+	if len(u.Expected) != 3 || u.Expected["Id"].Exists != true ||
+		u.Expected["Status"].Value.S != "old" || u.Expected["Missing"].Exists != false {
+		t.Errorf("unexpected guarded expected %v\n",u.Expected)
+	}
+}
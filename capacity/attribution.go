@@ -0,0 +1,95 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package capacity
+
+import (
+	"sync"
+)
+
+// Units are the read and write capacity units consumed.
+type Units struct {
+	Read float64
+	Write float64
+}
+
+// attribution totals, by metadata key, then value, then table
+var attribution struct {
+	lock sync.Mutex
+	m map[string] map[string] map[string] *Units
+}
+
+func attribute(metadata map[string] string,tablename string,units float64,write bool) {
+	if len(metadata) == 0 {
+		return
+	}
+	attribution.lock.Lock()
+	defer attribution.lock.Unlock()
+	if attribution.m == nil {
+		attribution.m = make(map[string] map[string] map[string] *Units)
+	}
+	for k,v := range metadata {
+		values,ok := attribution.m[k]
+		if !ok {
+			values = make(map[string] map[string] *Units)
+			attribution.m[k] = values
+		}
+		byTable,ok := values[v]
+		if !ok {
+			byTable = make(map[string] *Units)
+			values[v] = byTable
+		}
+		u,ok := byTable[tablename]
+		if !ok {
+			u = new(Units)
+			byTable[tablename] = u
+		}
+		if write {
+			u.Write += units
+		} else {
+			u.Read += units
+		}
+	}
+}
+
+// Attribution returns the units consumed by tablename since the last ResetAttribution,
+// totaled by the value of the request metadata key (e.g. the feature making requests).
+func Attribution(tablename,key string) map[string] Units {
+	attribution.lock.Lock()
+	defer attribution.lock.Unlock()
+	totals := make(map[string] Units)
+	for v,byTable := range attribution.m[key] {
+		if u,ok := byTable[tablename]; ok {
+			totals[v] = *u
+		}
+	}
+	return totals
+}
+
+// ResetAttribution clears the totals of Attribution.
+func ResetAttribution() {
+	attribution.lock.Lock()
+	attribution.m = nil
+	attribution.lock.Unlock()
+}
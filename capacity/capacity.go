@@ -0,0 +1,222 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Tracks the capacity units consumed per table, as reported in the ConsumedCapacity of
+// responses. DynamoDB only reports consumption for requests that set ReturnConsumedCapacity
+// (to TOTAL or INDEXES), so usage here is a lower bound unless all requests set it.
+package capacity
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"encoding/json"
+	"github.com/smugmug/godynamo/aws_const"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+const (
+	// seconds of per-second totals kept for each table
+	WINDOW = 15 * 60
+)
+
+// Ops that consume read capacity. All other ops that report ConsumedCapacity consume write capacity.
+var read_ops = map[string] bool{
+	aws_const.ENDPOINT_PREFIX + "GetItem":true,
+	aws_const.ENDPOINT_PREFIX + "BatchGetItem":true,
+	aws_const.ENDPOINT_PREFIX + "Query":true,
+	aws_const.ENDPOINT_PREFIX + "Scan":true,
+}
+
+type bucket struct {
+	sec int64
+	read float64
+	write float64
+}
+
+type table struct {
+	buckets [WINDOW]bucket
+	// the second of the first consumption recorded
+	first int64
+}
+
+var tables struct {
+	lock sync.Mutex
+	m map[string] *table
+}
+
+// Stat summarizes the per-second consumption of a table over a window.
+type Stat struct {
+	Mean float64
+	Peak float64
+}
+
+// Add records units consumed by tablename at time t.
+func Add(tablename string,units float64,write bool,t time.Time) {
+	sec := t.Unix()
+	tables.lock.Lock()
+	defer tables.lock.Unlock()
+	if tables.m == nil {
+		tables.m = make(map[string] *table)
+	}
+	tt,ok := tables.m[tablename]
+	if !ok {
+		tt = &table{first:sec}
+		tables.m[tablename] = tt
+	}
+	if sec < tt.first {
+		tt.first = sec
+	}
+	b := &tt.buckets[sec % WINDOW]
+	if b.sec != sec {
+		*b = bucket{sec:sec}
+	}
+	if write {
+		b.write += units
+	} else {
+		b.read += units
+	}
+}
+
+type consumed struct {
+	ConsumedCapacity json.RawMessage
+}
+
+// Observe records the ConsumedCapacity (if any) of a successful response body for amzTarget.
+func Observe(amzTarget,body string) {
+	ObserveWith(amzTarget,body,nil)
+}
+
+// ObserveWith is Observe for a request with metadata, which the consumption is also
+// attributed to, see Attribution.
+func ObserveWith(amzTarget,body string,metadata map[string] string) {
+	if !strings.Contains(body,`"ConsumedCapacity"`) {
+		return
+	}
+	var c consumed
+	if um_err := json.Unmarshal([]byte(body),&c); um_err != nil || len(c.ConsumedCapacity) == 0 {
+		return
+	}
+	ccs := make([]ep.ConsumedCapacity,0)
+	if c.ConsumedCapacity[0] == '[' {
+		if um_err := json.Unmarshal(c.ConsumedCapacity,&ccs); um_err != nil {
+			return
+		}
+	} else {
+		var cc ep.ConsumedCapacity
+		if um_err := json.Unmarshal(c.ConsumedCapacity,&cc); um_err != nil {
+			return
+		}
+		ccs = append(ccs,cc)
+	}
+	now := time.Now()
+	write := !read_ops[amzTarget]
+	for _,cc := range ccs {
+		if cc.TableName != "" && cc.CapacityUnits != 0 {
+			Add(cc.TableName,float64(cc.CapacityUnits),write,now)
+			attribute(metadata,cc.TableName,float64(cc.CapacityUnits),write)
+		}
+	}
+}
+
+// perSecond returns the read and write units consumed by tablename in each of the
+// seconds of the window ending now, oldest first.
+func perSecond(tablename string,window time.Duration) ([]float64,[]float64) {
+	secs := int64(window / time.Second)
+	if secs > WINDOW {
+		secs = WINDOW
+	}
+	if secs < 1 {
+		secs = 1
+	}
+	reads := make([]float64,secs)
+	writes := make([]float64,secs)
+	now := time.Now().Unix()
+	tables.lock.Lock()
+	defer tables.lock.Unlock()
+	tt,ok := tables.m[tablename]
+	if !ok {
+		return reads,writes
+	}
+	for i := int64(0); i < secs; i++ {
+		sec := now - secs + 1 + i
+		if b := tt.buckets[sec % WINDOW]; b.sec == sec {
+			reads[i] = b.read
+			writes[i] = b.write
+		}
+	}
+	return reads,writes
+}
+
+func stat(units []float64) Stat {
+	var s Stat
+	for _,u := range units {
+		s.Mean += u
+		if u > s.Peak {
+			s.Peak = u
+		}
+	}
+	s.Mean /= float64(len(units))
+	return s
+}
+
+// Since returns the time of the first consumption recorded for tablename, and false if
+// there is none. Consumption is only recorded for the requests of this process that
+// return ConsumedCapacity, so a window starting before it is not known to be idle.
+func Since(tablename string) (time.Time,bool) {
+	tables.lock.Lock()
+	defer tables.lock.Unlock()
+	tt,ok := tables.m[tablename]
+	if !ok {
+		return time.Time{},false
+	}
+	return time.Unix(tt.first,0),true
+}
+
+// Usage returns the read and write consumption of tablename, per second, over the
+// window (at most WINDOW seconds) ending now.
+func Usage(tablename string,window time.Duration) (Stat,Stat) {
+	reads,writes := perSecond(tablename,window)
+	return stat(reads),stat(writes)
+}
+
+func percentile(units []float64,p float64) float64 {
+	sorted := append([]float64{},units...)
+	sort.Float64s(sorted)
+	i := int(p * float64(len(sorted) - 1) + 0.5)
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Percentile returns the p (0 to 1) percentile of the per-second read and write
+// consumption of tablename over the window (at most WINDOW seconds) ending now.
+func Percentile(tablename string,window time.Duration,p float64) (float64,float64) {
+	reads,writes := perSecond(tablename,window)
+	return percentile(reads,p),percentile(writes,p)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf
+
+import (
+	"sync"
+)
+
+// Table_alias is a logical table name of the conf file. Requests naming the alias are
+// sent to Table, in Zone (or at Host) and with the role Role_arn when they are set, so
+// that a table can be moved to another region or account without changing the code
+// using it.
+type Table_alias struct {
+	// The physical table name.
+	Table string
+	// The zone of the table, "" for that of the configuration (or of Host).
+	Zone string
+	// The host of the table, "" for that of Zone. The zone signed for is that of the
+	// host when Zone is not set.
+	Host string
+	// The role to assume for requests to the table (signed with the credentials of
+	// the configuration), "" for none.
+	Role_arn string
+}
+
+// TableAlias is where requests naming an alias are sent.
+type TableAlias struct {
+	// the physical table name
+	Table string
+	// the name of the configuration (see Register) requests to the table use, or ""
+	// for that of their context
+	Conf string
+	// the role the credentials of Conf are assumed from, see conf_iam.GoAliasRoles
+	RoleArn string
+}
+
+var aliases struct {
+	lock sync.RWMutex
+	m map[string] TableAlias
+}
+
+// SetAliases replaces the table aliases with m.
+func SetAliases(m map[string] TableAlias) {
+	aliases.lock.Lock()
+	defer aliases.lock.Unlock()
+	aliases.m = m
+}
+
+// Alias returns the table that alias names, if it is one.
+func Alias(alias string) (TableAlias,bool) {
+	aliases.lock.RLock()
+	defer aliases.lock.RUnlock()
+	a,ok := aliases.m[alias]
+	return a,ok
+}
+
+// Aliases returns a copy of the table aliases.
+func Aliases() map[string] TableAlias {
+	aliases.lock.RLock()
+	defer aliases.lock.RUnlock()
+	m := make(map[string] TableAlias,len(aliases.m))
+	for k,v := range aliases.m {
+		m[k] = v
+	}
+	return m
+}
@@ -30,11 +30,59 @@ package conf
 
 import (
 	"sync"
+	"time"
 )
 
 const (
 	CONF_NAME          = "aws-config.json"
 	ROLE_PROVIDER_FILE = "file"
+	// temporary credentials from sts:AssumeRole, see conf_iam
+	ROLE_PROVIDER_STS  = "sts"
+	// sts:AssumeRoleWithWebIdentity with a token file, as on EKS, see conf_iam
+	ROLE_PROVIDER_WEB_IDENTITY = "web_identity"
+	// task role credentials from the ECS container endpoint, see conf_iam
+	ROLE_PROVIDER_CONTAINER = "container"
+	// instance profile credentials from the EC2 instance metadata service, see conf_iam
+	ROLE_PROVIDER_INSTANCE = "instance"
+	// the AWS_PROFILE profile of the shared ~/.aws/credentials and ~/.aws/config files
+	ROLE_PROVIDER_PROFILE = "profile"
+	// the first of a chain of providers with credentials, see conf_iam
+	ROLE_PROVIDER_CHAIN = "chain"
+	// IAM Roles Anywhere sessions signed with an X.509 certificate, see conf_iam
+	ROLE_PROVIDER_ROLES_ANYWHERE = "roles_anywhere"
+	// lifetime of IMDSv2 session tokens unless configured
+	IMDS_TOKEN_TTL = 6 * time.Hour
+	// timeout of instance metadata requests unless configured
+	IMDS_TIMEOUT = 2 * time.Second
+	RESOLVE_INTERVAL   = 60 * time.Second
+	// RFC 8305 recommends 250ms as the default connection attempt delay
+	CONNECT_ATTEMPT_DELAY = 250 * time.Millisecond
+	// signed with when a custom endpoint, such as DynamoDB Local, is given no keys
+	LOCAL_ACCESS_KEY = "local"
+	LOCAL_SECRET_KEY = "local"
+	// the zone signed for at a custom endpoint with none configured
+	LOCAL_ZONE = "us-east-1"
+	// request signing algorithms: Signature Version 4, signed for the zone, and the
+	// asymmetric SigV4A, signed for a set of regions (multi-region access points)
+	SIGNING_ALGORITHM_V4 = "sigv4"
+	SIGNING_ALGORITHM_V4A = "sigv4a"
+	// the retry policy presets, see authreq.RETRY_POLICIES
+	RETRY_POLICY_DEFAULT = "default"
+	RETRY_POLICY_INTERACTIVE = "interactive"
+	RETRY_POLICY_BATCH = "batch"
+	RETRY_POLICY_PIPELINE = "pipeline"
+	// how long describe_table.Cached reuses a table description unless configured
+	TABLE_DESCRIPTION_TTL = 5 * time.Minute
+	// keyring backends for static credentials, see conf_iam.KeyringCredentials: the
+	// macOS Keychain, the Secret Service of Linux desktops, the Windows Credential
+	// Manager, or whichever of them the operating system has
+	KEYRING_KEYCHAIN = "keychain"
+	KEYRING_SECRET_SERVICE = "secret_service"
+	KEYRING_WINCRED = "wincred"
+	KEYRING_AUTO = "auto"
+	// the keyring service and account credentials are kept under unless configured
+	KEYRING_SERVICE = "godynamo"
+	KEYRING_ACCOUNT = "default"
 )
 
 // SDK_conf_File roughly matches the format as used by recent amazon SDKs, plus some additions.
@@ -46,23 +94,181 @@ type SDK_conf_file struct {
 				// Traditional AWS access/secret authentication pair.
 				Access_key_id string
 				Secret_access_key string
+				// The session token, if the pair is temporary.
+				Session_token string
+				// The keyring backend to read the pair (and token) from when they are
+				// not set above: "keychain", "secret_service", "wincred" or "auto",
+				// and the service and account they are kept under ("" for "godynamo"
+				// and "default"). See conf_iam.StoreKeyringCredentials.
+				Keyring string
+				Keyring_service string
+				Keyring_account string
 				// If you use syslogd (a linux or *bsd system), you may set this to "true".
 				Use_sys_log bool
+				// Set to true to guarantee request and response bodies are never logged.
+				Suppress_body_logging bool
 			}
 		}
 		Dynamo_db struct {
 			// Your dynamo hostname.
 			Host string
+			// The port to connect to Host on ("" for 80).
+			Port string
+			// The URL of a custom endpoint, such as http://localhost:8000 for
+			// DynamoDB Local or dynalite, in place of Host and Port. Without keys,
+			// requests to it are signed with dummy ones.
+			Endpoint string
+			// Set to true to skip verifying the TLS certificate of an https Endpoint.
+			Insecure_skip_verify bool
+			// Set to true to use the FIPS endpoint (dynamodb-fips.*) and/or the dual-stack
+			// IPv4 and IPv6 endpoint (dynamodb.*.api.aws) of Zone, over https, in place
+			// of Host.
+			Use_fips bool
+			Use_dualstack bool
 			// Your aws zone.
 			Zone string
+			// "sigv4a" to sign requests with SigV4A, valid in the regions of
+			// Region_set (empty for Zone, ["*"] for all), "" or "sigv4" for SigV4.
+			Signing_algorithm string
+			Region_set []string
+			// How often (in seconds) Host is re-resolved so that connections to
+			// addresses no longer in DNS can be recycled. 0 uses the default.
+			Resolve_interval int
+			// When Host has both IPv4 and IPv6 addresses, milliseconds to wait on one
+			// connection attempt before racing the next. 0 uses the default.
+			Connect_attempt_delay int
+			// Extra headers sent with every request. x-amz-* headers are signed.
+			Headers map[string]string
+			// The most requests outstanding at once, and the most for any one table.
+			// 0 is unlimited.
+			Max_inflight int
+			Max_inflight_per_table int
+			// Milliseconds a request over those limits waits for a slot before failing.
+			// 0 fails it immediately.
+			Inflight_wait int
+			// Milliseconds of waiting for a slot that raise a request's priority (see
+			// authreq.WithPriority) by one, so low priority requests are not starved.
+			// 0 never raises it. Requests of equal priority are served in order.
+			Inflight_priority_aging int
+			// The retry policy preset: "interactive", "batch" or "pipeline".
+			// "" keeps the default, see authreq.
+			Retry_policy string
+			// Retries: the preset, in place of Retry_policy when set, and overrides of
+			// its attempts, its base and longest waits (in milliseconds) and the factor
+			// its waits grow by. 0 keeps those of the preset.
+			Retry struct {
+				Policy string
+				Retries int
+				Base int
+				Max int
+				Factor float64
+			}
+			// Bounds on outstanding requests, in place of Max_inflight,
+			// Max_inflight_per_table, Inflight_wait and Inflight_priority_aging
+			// where not 0.
+			Rate_limit struct {
+				Max_inflight int
+				Max_inflight_per_table int
+				Inflight_wait int
+				Inflight_priority_aging int
+			}
+			// Logging: set Suppress_body_logging, as in Default_settings.Params, to
+			// never log bodies, or Max_logged_body to log at most that many bytes of
+			// each (0 for all).
+			Logging struct {
+				Suppress_body_logging bool
+				Max_logged_body int
+			}
+			// Caches: how long in seconds describe_table.Cached reuses a table
+			// description (0 for five minutes).
+			Cache struct {
+				Table_description_ttl int
+			}
+			// Streamed responses (authreq.RetryReqJSONStream_V4): the retry policy
+			// preset for them ("" for that of other requests).
+			Streams struct {
+				Retry_policy string
+			}
+			// Logical table names mapped to physical ones, each optionally in another
+			// zone or at another host and/or with a role to assume, see Table_alias.
+			// An alias with no Table keeps its name, to send a table elsewhere.
+			Table_aliases map[string] Table_alias
+			// Operations (e.g. "Scan") whose requests go to another zone and/or host,
+			// see Region_override. A table alias or conf.WithName takes precedence.
+			Operation_regions map[string] Region_override
+			// The zones of the replicas of global tables, nearest first, and the
+			// read preference: "primary" (the default), "nearest" or "fastest".
+			// Writes and consistent reads always go to Zone, see READ_PREFERENCE_*.
+			Replica_zones []string
+			Read_preference string
+			// How often (in seconds) Zone and the replica zones are probed for their
+			// latency, see authreq.EndpointStats. 0 probes only for the "fastest" read
+			// preference, at the default interval.
+			Probe_interval int
 			IAM struct {
 				// Set to true to use IAM authentication.
 				Use_iam bool
 				// The role provider is described in the goawsroles package.
 				// See: https://github.com/smugmug/goawsroles/
-				// Currently the only support is for the "file" provider, whereby
-				// roles data is written to local files.
+				// The "file" provider reads roles data written to local files;
+				// the "sts" provider assumes Role_arn with the access/secret pair;
+				// the "web_identity" provider assumes AWS_ROLE_ARN with the token in
+				// AWS_WEB_IDENTITY_TOKEN_FILE; the "container" provider reads task role
+				// credentials from the ECS endpoint in AWS_CONTAINER_CREDENTIALS_*_URI;
+				// the "instance" provider reads the instance profile from IMDS; the
+				// "profile" provider reads the shared ~/.aws credentials files; the
+				// "chain" provider uses the first of Credential_chain with credentials;
+				// the "roles_anywhere" provider creates IAM Roles Anywhere sessions for
+				// Role_arn with an X.509 certificate.
 				Role_provider string
+				// If using the "sts" role provider, the role to assume, the session
+				// name to assume it with, and the lifetime in seconds of the
+				// credentials (0 for the STS default of one hour).
+				Role_arn string
+				Role_session_name string
+				Role_duration int
+				// The MFA device serial number (or ARN) to assume Role_arn with. Token
+				// codes come from conf_iam.SetMFATokenProvider.
+				Mfa_serial string
+				// The external id required by the role's trust policy, and an inline
+				// session policy (JSON) and managed policy ARNs restricting the session.
+				Role_external_id string
+				Role_policy string
+				Role_policy_arns []string
+				// Session tags passed to Role_arn (or to the last role of Role_chain),
+				// and the keys of those that chained sessions inherit.
+				Role_session_tags map[string] string
+				Role_transitive_tag_keys []string
+				// Roles to assume in turn after Role_arn, each with the credentials
+				// of the one before (sessions of chained roles last at most an hour).
+				Role_chain []string
+				// If using the "instance" role provider, the lifetime in seconds of
+				// IMDSv2 session tokens (0 for six hours).
+				Imds_token_ttl int
+				// Set to true to never query the instance metadata service, so that
+				// the "instance" provider (alone or in a chain) fails at once off EC2,
+				// as AWS_EC2_METADATA_DISABLED=true does. And the timeout in
+				// milliseconds of its requests (0 for two seconds); containers behind
+				// an IMDS hop limit of 1 otherwise wait out the full timeout.
+				Imds_disabled bool
+				Imds_timeout int
+				// If using the "roles_anywhere" role provider, the trust anchor and
+				// profile ARNs, the PEM files of the certificate (and optionally of its
+				// intermediate CAs) and of its RSA or EC private key. Role_arn,
+				// Role_session_name and Role_duration are used as for "sts".
+				Trust_anchor_arn string
+				Profile_arn string
+				Certificate_file string
+				Certificate_chain_file string
+				Private_key_file string
+				// If using the "chain" role provider, the names of the providers to
+				// try in order (empty for env, conf, profile, web_identity, container
+				// and instance). See conf_iam.RegisterProvider to add providers.
+				Credential_chain []string
+				// How long in seconds before temporary credentials expire to renew
+				// them (0 for five minutes). A random extra of up to a fifth of this
+				// is added to each renewal so that clients do not renew in lockstep.
+				Refresh_before int
 				// The identifier (filename, etc) for the IAM Access Key
 				Access_key string
 				// The identifier (filename, etc) for the IAM Secret Key
@@ -83,28 +289,118 @@ type SDK_conf_file struct {
 type AWS_Conf struct {
 	// Set to true if this struct is populated correctly.
 	Initialized bool
-	// Traditional AWS authentication pair.
+	// Traditional AWS authentication pair, and the session token when the pair is
+	// temporary (as when taken from an AWS_SESSION_TOKEN environment).
 	Auth struct {
 		AccessKey string
 		Secret string
+		Token string
+	}
+	// The OS keyring the pair may be kept in, see conf_iam.KeyringCredentials.
+	Keyring struct {
+		Backend string
+		Service string
+		Account string
 	}
 	// Dynamo connection data.
 	Network struct {
 		DynamoDB struct {
 			Host string
+			Port string
 			IP   string
 			Zone string
 			URL  string
+			// Set for an https endpoint whose certificate is not verified, see auth_v4.
+			InsecureSkipVerify bool
+			// The signing algorithm, SIGNING_ALGORITHM_V4 or _V4A, and the regions
+			// SigV4A signatures are valid in.
+			SigningAlgorithm string
+			RegionSet []string
+			// Set when Host is the FIPS and/or dual-stack endpoint, for other services
+			// such as STS to follow.
+			UseFIPS bool
+			UseDualStack bool
+			// How often Host is re-resolved, see auth_v4.
+			ResolveInterval time.Duration
+			// Delay between raced connection attempts, see auth_v4.
+			ConnectAttemptDelay time.Duration
+			// Extra headers sent with every request, see auth_v4.WithHeaders.
+			Headers map[string]string
 		}
 	}
+	// Bounds on outstanding requests, see authreq.
+	Inflight struct {
+		Max int
+		MaxPerTable int
+		Wait time.Duration
+		PriorityAging time.Duration
+	}
+	// Name of the retry policy preset, see authreq.
+	RetryPolicy string
+	// Overrides of the preset's retries, waits and factor, where not 0.
+	Retry struct {
+		Retries int
+		Base time.Duration
+		Max time.Duration
+		Factor float64
+	}
+	// Name of the retry policy preset of streamed responses ("" for RetryPolicy).
+	Streams struct {
+		RetryPolicy string
+	}
+	// Lifetimes of cached values.
+	Cache struct {
+		TableDescriptionTTL time.Duration
+	}
+	// Interval between latency probes of the endpoints, see authreq.
+	ProbeInterval time.Duration
+	// Set to sign requests with the credentials of Vals rather than those of this
+	// configuration, as the replica configurations of conf_file do.
+	UseValsCredentials bool
 	// If using syslogd
 	UseSysLog bool
+	// If set, request and response bodies are never written to logs
+	SuppressBodyLogging bool
+	// The most bytes of a request or response body written to logs (0 for all)
+	MaxLoggedBody int
 	// If using IAM
 	UseIAM bool
 	// The IAM role provider info
 	IAM struct {
 		RoleProvider string
 		Watch bool
+		// The role assumed by the "sts" role provider
+		AssumeRole struct {
+			RoleArn string
+			SessionName string
+			Duration time.Duration
+			MFASerial string
+			ExternalId string
+			Policy string
+			PolicyArns []string
+			Tags map[string] string
+			TransitiveTagKeys []string
+			Chain []string
+		}
+		// Lifetime of IMDSv2 session tokens for the "instance" role provider
+		IMDSTokenTTL time.Duration
+		// Set if the instance metadata service is never queried, and the timeout of
+		// its requests
+		IMDSDisabled bool
+		IMDSTimeout time.Duration
+		// The certificate of the "roles_anywhere" role provider, which assumes the
+		// AssumeRole.RoleArn
+		RolesAnywhere struct {
+			TrustAnchorArn string
+			ProfileArn string
+			CertificateFile string
+			CertificateChainFile string
+			PrivateKeyFile string
+		}
+		// Credential providers tried by the "chain" role provider
+		Chain []string
+		// Lead time for renewing temporary credentials (0 for conf_iam.REFRESH_BEFORE)
+		RefreshBefore time.Duration
 		// Tells you where the credentials can be read from
 		File struct {
 			AccessKey string
@@ -119,6 +415,8 @@ type AWS_Conf struct {
 			AccessKey string
 			Secret string
 			Token string
+			// the ARN of the assumed role session, if the provider assumed a role
+			SessionArn string
 		}
 	}
 	// Lock used when accessing IAM values, which will change during execution.
@@ -131,3 +429,162 @@ type AWS_Conf struct {
 var (
 	Vals AWS_Conf
 )
+
+// Conf is a flat form of the settings of the conf file, for programs that configure
+// godynamo in code rather than with a conf file (see conf_file.Init). Zero durations,
+// limits and names take the same defaults as the corresponding conf file settings.
+type Conf struct {
+	// Traditional AWS authentication pair, and the session token if it is temporary.
+	AccessKeyId string
+	SecretAccessKey string
+	SessionToken string
+	// The keyring to read the pair from if it is not set, as described in SDK_conf_file.
+	Keyring string
+	KeyringService string
+	KeyringAccount string
+	// The aws region, and the dynamo hostname and port ("" for the region's endpoint
+	// and port 80).
+	Region string
+	Host string
+	Port string
+	// A custom endpoint URL, overriding Host and Port, as described in SDK_conf_file.
+	Endpoint string
+	InsecureSkipVerify bool
+	// The FIPS and dual-stack endpoint variants of Region, overriding Host.
+	UseFIPS bool
+	UseDualStack bool
+	// The signing algorithm and SigV4A region set, as described in SDK_conf_file.
+	SigningAlgorithm string
+	RegionSet []string
+	UseSysLog bool
+	SuppressBodyLogging bool
+	MaxLoggedBody int
+	ResolveInterval time.Duration
+	ConnectAttemptDelay time.Duration
+	Headers map[string]string
+	MaxInflight int
+	MaxInflightPerTable int
+	InflightWait time.Duration
+	InflightPriorityAging time.Duration
+	RetryPolicy string
+	// Overrides of the retry policy, and the policy of streamed responses, as
+	// described in SDK_conf_file.
+	Retries int
+	RetryBase time.Duration
+	RetryMax time.Duration
+	RetryFactor float64
+	StreamRetryPolicy string
+	TableDescriptionTTL time.Duration
+	// Logical table names and operations in other regions, as described in SDK_conf_file.
+	TableAliases map[string] Table_alias
+	OperationRegions map[string] Region_override
+	// Replicas of global tables, as described in SDK_conf_file.
+	ReplicaZones []string
+	ReadPreference string
+	ProbeInterval time.Duration
+	// IAM settings, as described in SDK_conf_file.
+	UseIAM bool
+	RoleProvider string
+	RoleArn string
+	RoleSessionName string
+	RoleDuration time.Duration
+	MFASerial string
+	RoleExternalId string
+	RolePolicy string
+	RolePolicyArns []string
+	RoleSessionTags map[string] string
+	RoleTransitiveTagKeys []string
+	RoleChain []string
+	IMDSTokenTTL time.Duration
+	IMDSDisabled bool
+	IMDSTimeout time.Duration
+	TrustAnchorArn string
+	ProfileArn string
+	CertificateFile string
+	CertificateChainFile string
+	PrivateKeyFile string
+	CredentialChain []string
+	RefreshBefore time.Duration
+	// For the "file" role provider.
+	RolesBaseDir string
+	RolesAccessKey string
+	RolesSecretKey string
+	RolesToken string
+	RolesWatch bool
+}
+
+// File returns c in the form of the conf file.
+func (c Conf) File() SDK_conf_file {
+	var cf SDK_conf_file
+	p := &cf.Services.Default_settings.Params
+	p.Access_key_id = c.AccessKeyId
+	p.Secret_access_key = c.SecretAccessKey
+	p.Session_token = c.SessionToken
+	p.Keyring = c.Keyring
+	p.Keyring_service = c.KeyringService
+	p.Keyring_account = c.KeyringAccount
+	p.Use_sys_log = c.UseSysLog
+	p.Suppress_body_logging = c.SuppressBodyLogging
+	d := &cf.Services.Dynamo_db
+	d.Host = c.Host
+	if d.Host == "" && c.Region != "" {
+		d.Host,_ = ServiceHost("dynamodb",c.Region,false,false)
+	}
+	d.Port = c.Port
+	d.Endpoint = c.Endpoint
+	d.Insecure_skip_verify = c.InsecureSkipVerify
+	d.Use_fips = c.UseFIPS
+	d.Use_dualstack = c.UseDualStack
+	d.Zone = c.Region
+	d.Signing_algorithm = c.SigningAlgorithm
+	d.Region_set = c.RegionSet
+	d.Resolve_interval = int(c.ResolveInterval / time.Second)
+	d.Connect_attempt_delay = int(c.ConnectAttemptDelay / time.Millisecond)
+	d.Headers = c.Headers
+	d.Max_inflight = c.MaxInflight
+	d.Max_inflight_per_table = c.MaxInflightPerTable
+	d.Inflight_wait = int(c.InflightWait / time.Millisecond)
+	d.Inflight_priority_aging = int(c.InflightPriorityAging / time.Millisecond)
+	d.Retry_policy = c.RetryPolicy
+	d.Retry.Retries = c.Retries
+	d.Retry.Base = int(c.RetryBase / time.Millisecond)
+	d.Retry.Max = int(c.RetryMax / time.Millisecond)
+	d.Retry.Factor = c.RetryFactor
+	d.Logging.Max_logged_body = c.MaxLoggedBody
+	d.Cache.Table_description_ttl = int(c.TableDescriptionTTL / time.Second)
+	d.Streams.Retry_policy = c.StreamRetryPolicy
+	d.Table_aliases = c.TableAliases
+	d.Operation_regions = c.OperationRegions
+	d.Replica_zones = c.ReplicaZones
+	d.Read_preference = c.ReadPreference
+	d.Probe_interval = int(c.ProbeInterval / time.Second)
+	i := &d.IAM
+	i.Use_iam = c.UseIAM
+	i.Role_provider = c.RoleProvider
+	i.Role_arn = c.RoleArn
+	i.Role_session_name = c.RoleSessionName
+	i.Role_duration = int(c.RoleDuration / time.Second)
+	i.Mfa_serial = c.MFASerial
+	i.Role_external_id = c.RoleExternalId
+	i.Role_policy = c.RolePolicy
+	i.Role_policy_arns = c.RolePolicyArns
+	i.Role_session_tags = c.RoleSessionTags
+	i.Role_transitive_tag_keys = c.RoleTransitiveTagKeys
+	i.Role_chain = c.RoleChain
+	i.Imds_token_ttl = int(c.IMDSTokenTTL / time.Second)
+	i.Imds_disabled = c.IMDSDisabled
+	i.Imds_timeout = int(c.IMDSTimeout / time.Millisecond)
+	i.Trust_anchor_arn = c.TrustAnchorArn
+	i.Profile_arn = c.ProfileArn
+	i.Certificate_file = c.CertificateFile
+	i.Certificate_chain_file = c.CertificateChainFile
+	i.Private_key_file = c.PrivateKeyFile
+	i.Credential_chain = c.CredentialChain
+	i.Refresh_before = int(c.RefreshBefore / time.Second)
+	i.Base_dir = c.RolesBaseDir
+	i.Access_key = c.RolesAccessKey
+	i.Secret_key = c.RolesSecretKey
+	i.Token = c.RolesToken
+	i.Watch = c.RolesWatch
+	return cf
+}
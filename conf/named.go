@@ -0,0 +1,98 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf
+
+import (
+	"sync"
+	"context"
+)
+
+// Named configurations let one process talk to several DynamoDB accounts, regions or
+// endpoints at once. Each is an AWS_Conf of its own, registered under a name (see
+// conf_file.LoadNamed), and a request is directed to one by making it with a context
+// from WithName. Requests whose context names no configuration use Vals.
+var named struct {
+	lock sync.RWMutex
+	m map[string] *AWS_Conf
+}
+
+// nameKey is the context key for the name set with WithName.
+type nameKey struct{}
+
+// Register makes c available as the configuration called name, replacing any there was.
+func Register(name string,c *AWS_Conf) {
+	named.lock.Lock()
+	defer named.lock.Unlock()
+	if named.m == nil {
+		named.m = make(map[string] *AWS_Conf)
+	}
+	named.m[name] = c
+}
+
+// Lookup returns the configuration registered as name, or nil.
+func Lookup(name string) *AWS_Conf {
+	named.lock.RLock()
+	defer named.lock.RUnlock()
+	return named.m[name]
+}
+
+// Names lists the registered configurations.
+func Names() []string {
+	named.lock.RLock()
+	defer named.lock.RUnlock()
+	names := make([]string,0,len(named.m))
+	for name,_ := range named.m {
+		names = append(names,name)
+	}
+	return names
+}
+
+// WithName returns a context that directs requests made with it to the configuration
+// registered as name.
+func WithName(ctx context.Context,name string) context.Context {
+	return context.WithValue(ctx,nameKey{},name)
+}
+
+// FromContext returns the configuration that requests made with ctx use: the one named
+// by WithName, and Vals if ctx names none. It returns nil if the name ctx carries is not
+// registered, rather than let the request go to the wrong account.
+func FromContext(ctx context.Context) *AWS_Conf {
+	if ctx != nil {
+		if name,ok := ctx.Value(nameKey{}).(string); ok {
+			return Lookup(name)
+		}
+	}
+	return &Vals
+}
+
+// NameFrom returns the name of the configuration set on ctx with WithName, or "".
+func NameFrom(ctx context.Context) string {
+	if ctx != nil {
+		if name,ok := ctx.Value(nameKey{}).(string); ok {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// the AWS partitions, each with its own DNS names
+	PARTITION_AWS = "aws"
+	PARTITION_AWS_US_GOV = "aws-us-gov"
+	PARTITION_AWS_CN = "aws-cn"
+)
+
+// Partition describes the endpoint names of an AWS partition.
+type Partition struct {
+	Name string
+	// the suffix of the standard and dual-stack endpoints, e.g. "amazonaws.com" and "api.aws"
+	DNSSuffix string
+	DualStackDNSSuffix string
+	// whether the partition has FIPS endpoints, and whether its standard endpoints are them
+	HasFIPS bool
+	FIPSByDefault bool
+}
+
+var partitions = map[string] Partition{
+	PARTITION_AWS:{PARTITION_AWS,"amazonaws.com","api.aws",true,false},
+	PARTITION_AWS_US_GOV:{PARTITION_AWS_US_GOV,"amazonaws.com","api.aws",true,true},
+	PARTITION_AWS_CN:{PARTITION_AWS_CN,"amazonaws.com.cn","api.amazonwebservices.com.cn",false,false},
+}
+
+// PartitionOf returns the partition of region, which is aws unless region is in
+// GovCloud (us-gov-*) or China (cn-*).
+func PartitionOf(region string) Partition {
+	region,_ = SigningRegion(region)
+	switch {
+	case strings.HasPrefix(region,"us-gov-"):
+		return partitions[PARTITION_AWS_US_GOV]
+	case strings.HasPrefix(region,"cn-"):
+		return partitions[PARTITION_AWS_CN]
+	}
+	return partitions[PARTITION_AWS]
+}
+
+// SigningRegion returns the region requests to zone are signed for, and whether zone is
+// a FIPS pseudo-region (such as "fips-us-gov-west-1" or "us-east-1-fips") of the SDKs,
+// which is signed for the region it names.
+func SigningRegion(zone string) (string,bool) {
+	if strings.HasPrefix(zone,"fips-") {
+		return strings.TrimPrefix(zone,"fips-"),true
+	}
+	if strings.HasSuffix(zone,"-fips") {
+		return strings.TrimSuffix(zone,"-fips"),true
+	}
+	return zone,false
+}
+
+// ServiceHost returns the hostname of the endpoint of service (e.g. "dynamodb" or "sts")
+// in region, in the FIPS and/or dual-stack variant, with the DNS suffix of the region's
+// partition.
+func ServiceHost(service,region string,fips,dualstack bool) (string,error) {
+	region,pseudo_fips := SigningRegion(region)
+	if region == "" {
+		return "",errors.New("conf.ServiceHost: no region")
+	}
+	fips = fips || pseudo_fips
+	p := PartitionOf(region)
+	if fips && !p.HasFIPS {
+		return "",errors.New("conf.ServiceHost: no FIPS endpoint in " + region)
+	}
+	// the standard endpoints of a FIPS partition are already FIPS
+	if fips && (dualstack || !p.FIPSByDefault) {
+		service += "-fips"
+	}
+	if dualstack {
+		return service + "." + region + "." + p.DualStackDNSSuffix,nil
+	}
+	return service + "." + region + "." + p.DNSSuffix,nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf
+
+import (
+	"sync"
+)
+
+// Region_override sends the requests of an operation of the conf file (see
+// Operation_regions) to another zone and/or host. The zone signed for is that of the
+// host when only the host is set.
+type Region_override struct {
+	Zone string
+	Host string
+}
+
+var operations struct {
+	lock sync.RWMutex
+	m map[string] string
+}
+
+// SetOperationConfs replaces the configurations (see Register) of operations with m,
+// keyed by operation name (e.g. "Scan").
+func SetOperationConfs(m map[string] string) {
+	operations.lock.Lock()
+	defer operations.lock.Unlock()
+	operations.m = m
+}
+
+// OperationConf returns the name of the configuration requests of operation go to, if
+// it has one of its own.
+func OperationConf(operation string) (string,bool) {
+	operations.lock.RLock()
+	defer operations.lock.RUnlock()
+	name,ok := operations.m[operation]
+	return name,ok
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package conf
+
+import (
+	"sync"
+)
+
+const (
+	// the read preferences of a global table with replicas: every read goes to the
+	// primary zone, eventually consistent reads go to the nearest replica (the first
+	// listed), or to whichever of the primary and replicas answers probes fastest.
+	// Writes and consistent reads always go to the primary.
+	READ_PREFERENCE_PRIMARY = "primary"
+	READ_PREFERENCE_NEAREST = "nearest"
+	READ_PREFERENCE_FASTEST = "fastest"
+)
+
+// Replica is a zone holding replicas of the global tables of the default configuration.
+type Replica struct {
+	Zone string
+	// the name of the configuration (see Register) requests to the zone use
+	Conf string
+}
+
+var replicas struct {
+	lock sync.RWMutex
+	preference string
+	r []Replica
+}
+
+// SetReplicas replaces the replica zones, listed nearest first, and the read preference.
+func SetReplicas(preference string,r []Replica) {
+	replicas.lock.Lock()
+	defer replicas.lock.Unlock()
+	replicas.preference = preference
+	replicas.r = r
+}
+
+// Replicas returns a copy of the replica zones, nearest first.
+func Replicas() []Replica {
+	replicas.lock.RLock()
+	defer replicas.lock.RUnlock()
+	return append([]Replica(nil),replicas.r...)
+}
+
+// ReadPreference returns the read preference, READ_PREFERENCE_PRIMARY unless set.
+func ReadPreference() string {
+	replicas.lock.RLock()
+	defer replicas.lock.RUnlock()
+	if replicas.preference == "" {
+		return READ_PREFERENCE_PRIMARY
+	}
+	return replicas.preference
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// the prefix of the names of the configurations of aliases in other zones or roles
+	ALIAS_CONF_PREFIX = "alias:"
+)
+
+// loadAliases adds to p the table aliases of cf, whose settings are those of the default
+// configuration. An alias with a zone or role of its own gets a configuration of its own,
+// named ALIAS_CONF_PREFIX and the alias, differing in just those.
+func loadAliases(cf *conf.SDK_conf_file,p *pending) error {
+	m := make(map[string] conf.TableAlias)
+	for alias,ta := range cf.Services.Dynamo_db.Table_aliases {
+		a := conf.TableAlias{Table:ta.Table,RoleArn:ta.Role_arn}
+		if a.Table == "" {
+			a.Table = alias
+		}
+		if ta.Zone != "" || ta.Host != "" || ta.Role_arn != "" {
+			alias_cf := *cf
+			d := &alias_cf.Services.Dynamo_db
+			d.Table_aliases = nil
+			d.Operation_regions = nil
+			overrideRegion(&alias_cf,ta.Zone,ta.Host)
+			if ta.Role_arn != "" {
+				d.IAM.Use_iam = true
+				d.IAM.Role_provider = conf.ROLE_PROVIDER_STS
+				d.IAM.Role_arn = ta.Role_arn
+				d.IAM.Role_chain = nil
+			}
+			a.Conf = ALIAS_CONF_PREFIX + alias
+			k,check_err := check(&alias_cf)
+			if check_err != nil {
+				return check_err
+			}
+			p.named = append(p.named,namedConf{name:a.Conf,k:k})
+		}
+		m[alias] = a
+	}
+	p.aliases = m
+	return nil
+}
+
+// overrideRegion points cf, a copy of a configuration, at zone and/or host. With only a
+// host, the zone is taken from the host (see resolveHost), so requests are signed for it.
+func overrideRegion(cf *conf.SDK_conf_file,zone,host string) {
+	d := &cf.Services.Dynamo_db
+	if host != "" {
+		d.Host = host
+		d.Endpoint = ""
+		d.Zone = zone
+		return
+	}
+	if zone != "" && zone != d.Zone {
+		d.Zone = zone
+		// the host of the zone, unless requests go to a custom endpoint
+		d.Host = ""
+	}
+}
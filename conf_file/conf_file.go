@@ -29,88 +29,365 @@ import (
 	"os"
 	"net"
 	"log"
+	"time"
+	"errors"
 	"io/ioutil"
-	"encoding/json"
 	"path/filepath"
-	"github.com/smugmug/goawsroles/roles_files"
 	"github.com/smugmug/godynamo/aws_const"
 	"github.com/smugmug/godynamo/conf"
+	"github.com/smugmug/godynamo/conf_iam"
 )
 
 // Read will look for and read in the conf file, which can then be referenced as conf.Vals.
-// The conf file is specifically relevant to properly formatted requests, so it is currently
-// called in the initialization of the authreq package.
+// The conf file is specifically relevant to properly formatted requests, so it must be
+// called (or Init or Load used instead) before any request is made.
 func Read() {
 	var cf conf.SDK_conf_file
-	local_conf := os.Getenv("HOME") + string(filepath.Separator) + "." + conf.CONF_NAME
-	etc_conf   := string(filepath.Separator) + "etc" + string(filepath.Separator) + conf.CONF_NAME
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.UseSysLog = true
+	conf.Vals.ConfLock.Unlock()
+	conf_file,read_err := find(&cf)
+	if read_err != nil {
+		panic(read_err.Error())
+	}
+	if load_err := load(&cf); load_err != nil {
+		panic(load_err.Error())
+	}
+	setReadFrom(conf_file)
+}
+
+// find reads the settings into cf from the first conf file found, or failing that
+// from the shared aws config profile or the environment. It returns the name of the
+// conf file read, which is empty if there was none.
+func find(cf *conf.SDK_conf_file) (string,error) {
 	read_conf  := false
-	conf_files := []string{local_conf,etc_conf}
+	read_from  := ""
+	conf_files := append(confNames(os.Getenv("HOME"),"."),
+		confNames(string(filepath.Separator) + "etc","")...)
 	cf.Services.Default_settings.Params.Use_sys_log = true
-	conf.Vals.UseSysLog = true
-	conf.Vals.ConfLock.Lock()
-	defer conf.Vals.ConfLock.Unlock()
 	CONF_LOCATIONS:for _,conf_file := range conf_files {
 		conf_bytes,conf_err := ioutil.ReadFile(conf_file)
 		if conf_err != nil {
 			log.Printf("cannot find conf file at %s\n",conf_file)
 			continue CONF_LOCATIONS
 		} else {
-			um_err := json.Unmarshal(conf_bytes,&cf)
+			um_err := unmarshalConf(conf_file,conf_bytes,cf)
 			if um_err != nil {
-				panic("conf_file.Read:" + conf_file +
-					" json err: " +
+				return "",errors.New("conf_file.Read:" + conf_file +
+					" parse err: " +
 					um_err.Error())
 			} else {
 				log.Printf("read conf from: %s\n",conf_file)
 				read_conf = true
+				read_from = conf_file
 				break
 			}
 		}
 	}
 	if !read_conf {
-		panic("confload.init: read err: " +
+		// without a conf file, use the region and credentials of the AWS CLI's profile
+		if region := conf_iam.ProfileRegion(conf_iam.ProfileName()); region != "" {
+			log.Printf("no conf file, using the %s profile of the shared aws config\n",
+				conf_iam.ProfileName())
+			cf.Services.Dynamo_db.Host,_ = conf.ServiceHost("dynamodb",region,false,false)
+			cf.Services.Dynamo_db.Zone = region
+			cf.Services.Dynamo_db.IAM.Use_iam = true
+			cf.Services.Dynamo_db.IAM.Role_provider = conf.ROLE_PROVIDER_PROFILE
+			read_conf = true
+		}
+	}
+	if !read_conf && envConfigured() {
+		log.Printf("no conf file, using the %s* environment\n",ENV_PREFIX)
+		read_conf = true
+	}
+	if !read_conf {
+		return "",errors.New("confload.init: read err: " +
 			"\n\n\n*****\nMake sure you have a conf file!\n" +
 			"An example conf file is located in the /conf dir.\n" +
 			"Put it in your home dir as\n$HOME/.aws-config.json\nor " +
 			"in /etc as\n/etc/aws-config.json\nand fill " +
 			"in the values for your AWS account*****\n\n\n")
 	}
+	return read_from,nil
+}
+
+// Load assigns the settings of cf, populated in code rather than read from a conf file,
+// to conf.Vals. See also Init. A configuration with problems is not assigned, and a
+// *ValidationError lists them.
+func Load(cf conf.SDK_conf_file) error {
+	return load(&cf)
+}
+
+// Init assigns the settings of c to conf.Vals, for programs that get their configuration
+// from their own systems instead of a conf file. It replaces Read, and should likewise be
+// followed by conf_iam.GoIAM.
+func Init(c conf.Conf) error {
+	return Load(c.File())
+}
+
+// load assigns cf, overridden by the environment (see EnvNames), to conf.Vals, along
+// with the configurations of its replica zones, operation regions and table aliases.
+func load(cf *conf.SDK_conf_file) error {
+	p,prepare_err := prepare(cf)
+	if prepare_err != nil {
+		return prepare_err
+	}
+	p.apply(false)
+	return nil
+}
+
+// pending are the settings of a load, checked and ready to be swapped in.
+type pending struct {
+	vals *checked
+	named []namedConf
+	read_preference string
+	replicas []conf.Replica
+	operations map[string] string
+	aliases map[string] conf.TableAlias
+}
+
+// namedConf is a configuration to register (see conf.Register) as name.
+type namedConf struct {
+	name string
+	k *checked
+	// whether it signs with the credentials of conf.Vals
+	vals_credentials bool
+}
+
+// prepare does everything that can fail or wait of loading cf: reading the environment,
+// the keyring and the instance metadata, validating, and looking up the hosts of the
+// default configuration and of those derived from it. No lock is held meanwhile, and
+// nothing is assigned until apply.
+func prepare(cf *conf.SDK_conf_file) (*pending,error) {
+	if env_err := applyEnv(cf); env_err != nil {
+		return nil,env_err
+	}
+	// the endpoint environment of the AWS SDKs
+	if cf.Services.Dynamo_db.Endpoint == "" {
+		cf.Services.Dynamo_db.Endpoint = envEndpoint()
+	}
+	envVariants(cf)
+	// without a zone, nor one in the host, that of the environment, profile or instance
+	if d := &cf.Services.Dynamo_db; d.Zone == "" && host_re.FindStringSubmatch(d.Host) == nil {
+		timeout := time.Duration(d.IAM.Imds_timeout) * time.Millisecond
+		if region := conf_iam.DiscoverRegion(!d.IAM.Imds_disabled,timeout); region != "" {
+			log.Printf("no zone configured, using the discovered region %s\n",region)
+			d.Zone = region
+		}
+	}
+	// without keys in the conf file, use those of the environment, along with
+	// the session token if they are temporary
+	p := &cf.Services.Default_settings.Params
+	if p.Access_key_id == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		p.Access_key_id = os.Getenv("AWS_ACCESS_KEY_ID")
+		p.Secret_access_key = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		p.Session_token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	// nor of the environment, those kept in the keyring
+	if p.Access_key_id == "" && p.Keyring != "" {
+		creds,keyring_err := conf_iam.KeyringCredentials(p.Keyring,p.Keyring_service,p.Keyring_account)
+		if keyring_err != nil {
+			v := new(ValidationError)
+			v.add("default_settings.params.keyring",keyring_err.Error())
+			return nil,v
+		}
+		p.Access_key_id = creds.AccessKeyId
+		p.Secret_access_key = creds.SecretAccessKey
+		p.Session_token = creds.SessionToken
+	}
+	vals,check_err := check(cf)
+	if check_err != nil {
+		return nil,check_err
+	}
+	pc := &pending{vals:vals}
+	if replica_err := loadReplicas(cf,pc); replica_err != nil {
+		return nil,replica_err
+	}
+	if operation_err := loadOperations(cf,pc); operation_err != nil {
+		return nil,operation_err
+	}
+	if alias_err := loadAliases(cf,pc); alias_err != nil {
+		return nil,alias_err
+	}
+	return pc,nil
+}
 
+// apply swaps the settings of p into conf.Vals under its lock, and then into the
+// configurations derived from it. keep_iam leaves conf.Vals.UseIAM as it is, for a
+// reload, as the provider goroutines of conf_iam.GoIAM that maintain it are not
+// restarted.
+func (p *pending) apply(keep_iam bool) {
+	conf.Vals.ConfLock.Lock()
+	use_iam := conf.Vals.UseIAM
+	assign(&conf.Vals,p.vals)
+	if keep_iam {
+		conf.Vals.UseIAM = use_iam
+	}
+	conf.Vals.ConfLock.Unlock()
+	for _,n := range p.named {
+		registerNamed(n.name,n.k,n.vals_credentials)
+	}
+	conf.SetReplicas(p.read_preference,p.replicas)
+	conf.SetOperationConfs(p.operations)
+	conf.SetAliases(p.aliases)
+}
+
+// checked is a configuration whose settings are valid and whose host was looked up,
+// which can be assigned without failing.
+type checked struct {
+	cf conf.SDK_conf_file
+	scheme string
+	ip string
+}
+
+// check validates cf, resolving its host, and looks the host up. Unlike load, it takes
+// nothing from the environment, which belongs to the default configuration.
+func check(cf *conf.SDK_conf_file) (*checked,error) {
+	v := new(ValidationError)
+	scheme := resolveHost(cf,v)
+	validate(cf,v)
+	if len(v.Problems) != 0 {
+		return nil,v
+	}
 	// make sure the dynamo endpoint is available
 	addrs,addrs_err := net.LookupIP(cf.Services.Dynamo_db.Host)
 	if addrs_err != nil {
-		panic("cannot look up hostname: " + cf.Services.Dynamo_db.Host)
+		v.add("dynamo_db.host","cannot look up hostname: " + cf.Services.Dynamo_db.Host)
+		return nil,v
 	}
-	dynamo_ip := (addrs[0]).String()
+	return &checked{cf:*cf,scheme:scheme,ip:(addrs[0]).String()},nil
+}
+
+// assign assigns the checked settings k to the configuration c, which must be locked.
+func assign(c *conf.AWS_Conf,k *checked) {
+	cf := &k.cf
+	scheme,dynamo_ip := k.scheme,k.ip
 
 	// assign the values to our globally-available conf.Vals struct instance
-	conf.Vals.Auth.AccessKey = cf.Services.Default_settings.Params.Access_key_id
-	conf.Vals.Auth.Secret = cf.Services.Default_settings.Params.Secret_access_key
-	conf.Vals.UseSysLog = cf.Services.Default_settings.Params.Use_sys_log
-	conf.Vals.Network.DynamoDB.Host = cf.Services.Dynamo_db.Host
-	conf.Vals.Network.DynamoDB.IP = dynamo_ip
-	conf.Vals.Network.DynamoDB.Zone = cf.Services.Dynamo_db.Zone
-	conf.Vals.Network.DynamoDB.URL = "http://" + conf.Vals.Network.DynamoDB.Host +
-	":" + aws_const.PORT
+	c.Auth.AccessKey = cf.Services.Default_settings.Params.Access_key_id
+	c.Auth.Secret = cf.Services.Default_settings.Params.Secret_access_key
+	c.Auth.Token = cf.Services.Default_settings.Params.Session_token
+	c.Keyring.Backend = cf.Services.Default_settings.Params.Keyring
+	c.Keyring.Service = cf.Services.Default_settings.Params.Keyring_service
+	c.Keyring.Account = cf.Services.Default_settings.Params.Keyring_account
+	c.UseSysLog = cf.Services.Default_settings.Params.Use_sys_log
+	c.SuppressBodyLogging = cf.Services.Default_settings.Params.Suppress_body_logging ||
+		cf.Services.Dynamo_db.Logging.Suppress_body_logging
+	c.MaxLoggedBody = cf.Services.Dynamo_db.Logging.Max_logged_body
+	c.Network.DynamoDB.Host = cf.Services.Dynamo_db.Host
+	c.Network.DynamoDB.IP = dynamo_ip
+	c.Network.DynamoDB.Zone = cf.Services.Dynamo_db.Zone
+	c.Network.DynamoDB.SigningAlgorithm = cf.Services.Dynamo_db.Signing_algorithm
+	if c.Network.DynamoDB.SigningAlgorithm == "" {
+		c.Network.DynamoDB.SigningAlgorithm = conf.SIGNING_ALGORITHM_V4
+	}
+	c.Network.DynamoDB.RegionSet = cf.Services.Dynamo_db.Region_set
+	if len(c.Network.DynamoDB.RegionSet) == 0 {
+		c.Network.DynamoDB.RegionSet = []string{c.Network.DynamoDB.Zone}
+	}
+	c.Network.DynamoDB.Port = cf.Services.Dynamo_db.Port
+	if c.Network.DynamoDB.Port == "" {
+		c.Network.DynamoDB.Port = aws_const.PORT
+	}
+	c.Network.DynamoDB.URL = scheme + "://" +
+		net.JoinHostPort(c.Network.DynamoDB.Host,c.Network.DynamoDB.Port)
+	c.Network.DynamoDB.InsecureSkipVerify = cf.Services.Dynamo_db.Insecure_skip_verify
+	c.Network.DynamoDB.UseFIPS = cf.Services.Dynamo_db.Endpoint == "" && cf.Services.Dynamo_db.Use_fips
+	c.Network.DynamoDB.UseDualStack = cf.Services.Dynamo_db.Endpoint == "" && cf.Services.Dynamo_db.Use_dualstack
+	c.Network.DynamoDB.Headers = cf.Services.Dynamo_db.Headers
+	rl := &cf.Services.Dynamo_db.Rate_limit
+	c.Inflight.Max = either(rl.Max_inflight,cf.Services.Dynamo_db.Max_inflight)
+	c.Inflight.MaxPerTable = either(rl.Max_inflight_per_table,cf.Services.Dynamo_db.Max_inflight_per_table)
+	c.Inflight.Wait =
+		time.Duration(either(rl.Inflight_wait,cf.Services.Dynamo_db.Inflight_wait)) * time.Millisecond
+	c.Inflight.PriorityAging = time.Duration(either(rl.Inflight_priority_aging,
+		cf.Services.Dynamo_db.Inflight_priority_aging)) * time.Millisecond
+	r := &cf.Services.Dynamo_db.Retry
+	c.RetryPolicy = cf.Services.Dynamo_db.Retry_policy
+	if r.Policy != "" {
+		c.RetryPolicy = r.Policy
+	}
+	c.Retry.Retries = r.Retries
+	c.Retry.Base = time.Duration(r.Base) * time.Millisecond
+	c.Retry.Max = time.Duration(r.Max) * time.Millisecond
+	c.Retry.Factor = r.Factor
+	c.Streams.RetryPolicy = cf.Services.Dynamo_db.Streams.Retry_policy
+	if cf.Services.Dynamo_db.Cache.Table_description_ttl > 0 {
+		c.Cache.TableDescriptionTTL =
+			time.Duration(cf.Services.Dynamo_db.Cache.Table_description_ttl) * time.Second
+	} else {
+		c.Cache.TableDescriptionTTL = conf.TABLE_DESCRIPTION_TTL
+	}
+	c.ProbeInterval = time.Duration(cf.Services.Dynamo_db.Probe_interval) * time.Second
+	if cf.Services.Dynamo_db.Resolve_interval > 0 {
+		c.Network.DynamoDB.ResolveInterval =
+			time.Duration(cf.Services.Dynamo_db.Resolve_interval) * time.Second
+	} else {
+		c.Network.DynamoDB.ResolveInterval = conf.RESOLVE_INTERVAL
+	}
+	if cf.Services.Dynamo_db.Connect_attempt_delay > 0 {
+		c.Network.DynamoDB.ConnectAttemptDelay =
+			time.Duration(cf.Services.Dynamo_db.Connect_attempt_delay) * time.Millisecond
+	} else {
+		c.Network.DynamoDB.ConnectAttemptDelay = conf.CONNECT_ATTEMPT_DELAY
+	}
+
+	// the instance metadata service may be reached without IAM configured, as by
+	// the default credential chain and region discovery
+	c.IAM.IMDSDisabled = cf.Services.Dynamo_db.IAM.Imds_disabled
+	if cf.Services.Dynamo_db.IAM.Imds_timeout > 0 {
+		c.IAM.IMDSTimeout = time.Duration(cf.Services.Dynamo_db.IAM.Imds_timeout) * time.Millisecond
+	} else {
+		c.IAM.IMDSTimeout = conf.IMDS_TIMEOUT
+	}
 
 	// read in flags for IAM support
 	if cf.Services.Dynamo_db.IAM.Use_iam == true {
-		if cf.Services.Dynamo_db.IAM.Role_provider != roles_files.ROLE_PROVIDER {
-			panic("confload.init: read err: " +
-				"\n\n\n**** only IAM role provider 'file' is supported *****\n\n\n")
+		c.IAM.RoleProvider = cf.Services.Dynamo_db.IAM.Role_provider
+		c.IAM.AssumeRole.RoleArn = cf.Services.Dynamo_db.IAM.Role_arn
+		c.IAM.AssumeRole.SessionName = cf.Services.Dynamo_db.IAM.Role_session_name
+		c.IAM.AssumeRole.Duration =
+			time.Duration(cf.Services.Dynamo_db.IAM.Role_duration) * time.Second
+		c.IAM.AssumeRole.MFASerial = cf.Services.Dynamo_db.IAM.Mfa_serial
+		c.IAM.AssumeRole.ExternalId = cf.Services.Dynamo_db.IAM.Role_external_id
+		c.IAM.AssumeRole.Policy = cf.Services.Dynamo_db.IAM.Role_policy
+		c.IAM.AssumeRole.PolicyArns = cf.Services.Dynamo_db.IAM.Role_policy_arns
+		c.IAM.AssumeRole.Tags = cf.Services.Dynamo_db.IAM.Role_session_tags
+		c.IAM.AssumeRole.TransitiveTagKeys = cf.Services.Dynamo_db.IAM.Role_transitive_tag_keys
+		c.IAM.AssumeRole.Chain = cf.Services.Dynamo_db.IAM.Role_chain
+		if cf.Services.Dynamo_db.IAM.Imds_token_ttl > 0 {
+			c.IAM.IMDSTokenTTL =
+				time.Duration(cf.Services.Dynamo_db.IAM.Imds_token_ttl) * time.Second
+		} else {
+			c.IAM.IMDSTokenTTL = conf.IMDS_TOKEN_TTL
 		}
-		conf.Vals.IAM.RoleProvider = cf.Services.Dynamo_db.IAM.Role_provider
-		conf.Vals.IAM.File.BaseDir = cf.Services.Dynamo_db.IAM.Base_dir
-		conf.Vals.IAM.File.AccessKey = cf.Services.Dynamo_db.IAM.Access_key
-		conf.Vals.IAM.File.Secret = cf.Services.Dynamo_db.IAM.Secret_key
-		conf.Vals.IAM.File.Token = cf.Services.Dynamo_db.IAM.Token
+		c.IAM.RolesAnywhere.TrustAnchorArn = cf.Services.Dynamo_db.IAM.Trust_anchor_arn
+		c.IAM.RolesAnywhere.ProfileArn = cf.Services.Dynamo_db.IAM.Profile_arn
+		c.IAM.RolesAnywhere.CertificateFile = cf.Services.Dynamo_db.IAM.Certificate_file
+		c.IAM.RolesAnywhere.CertificateChainFile = cf.Services.Dynamo_db.IAM.Certificate_chain_file
+		c.IAM.RolesAnywhere.PrivateKeyFile = cf.Services.Dynamo_db.IAM.Private_key_file
+		c.IAM.Chain = cf.Services.Dynamo_db.IAM.Credential_chain
+		c.IAM.RefreshBefore =
+			time.Duration(cf.Services.Dynamo_db.IAM.Refresh_before) * time.Second
+		c.IAM.File.BaseDir = cf.Services.Dynamo_db.IAM.Base_dir
+		c.IAM.File.AccessKey = cf.Services.Dynamo_db.IAM.Access_key
+		c.IAM.File.Secret = cf.Services.Dynamo_db.IAM.Secret_key
+		c.IAM.File.Token = cf.Services.Dynamo_db.IAM.Token
 		if cf.Services.Dynamo_db.IAM.Watch == true {
-			conf.Vals.IAM.Watch = true
+			c.IAM.Watch = true
 		} else {
-			conf.Vals.IAM.Watch = false
+			c.IAM.Watch = false
 		}
-		conf.Vals.UseIAM = true
+		c.UseIAM = true
+	}
+	c.Initialized = true
+}
+
+// either returns the setting of a conf file section if it is set, else the flat setting
+// it takes the place of.
+func either(section,flat int) int {
+	if section != 0 {
+		return section
 	}
-	conf.Vals.Initialized = true
+	return flat
 }
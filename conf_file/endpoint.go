@@ -0,0 +1,119 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"os"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// the endpoint environment of the AWS SDKs, for DynamoDB and for every service
+	AWS_ENDPOINT_URL_DYNAMODB = "AWS_ENDPOINT_URL_DYNAMODB"
+	AWS_ENDPOINT_URL          = "AWS_ENDPOINT_URL"
+	// and the endpoint variants of the AWS SDKs
+	AWS_USE_FIPS_ENDPOINT      = "AWS_USE_FIPS_ENDPOINT"
+	AWS_USE_DUALSTACK_ENDPOINT = "AWS_USE_DUALSTACK_ENDPOINT"
+	// the port of an https endpoint that names none
+	HTTPS_PORT = "443"
+)
+
+// envEndpoint is the custom endpoint set in the AWS SDK environment, or "".
+func envEndpoint() string {
+	if e := os.Getenv(AWS_ENDPOINT_URL_DYNAMODB); e != "" {
+		return e
+	}
+	return os.Getenv(AWS_ENDPOINT_URL)
+}
+
+// envVariants sets the endpoint variants of cf from the AWS SDK environment, when cf
+// selects none.
+func envVariants(cf *conf.SDK_conf_file) {
+	d := &cf.Services.Dynamo_db
+	if !d.Use_fips {
+		d.Use_fips,_ = strconv.ParseBool(os.Getenv(AWS_USE_FIPS_ENDPOINT))
+	}
+	if !d.Use_dualstack {
+		d.Use_dualstack,_ = strconv.ParseBool(os.Getenv(AWS_USE_DUALSTACK_ENDPOINT))
+	}
+}
+
+// VariantHost returns the hostname of the DynamoDB endpoint of zone, using the FIPS
+// and/or dual-stack variant, as conf.ServiceHost does.
+func VariantHost(zone string,fips,dualstack bool) (string,error) {
+	return conf.ServiceHost("dynamodb",zone,fips,dualstack)
+}
+
+// variantEndpoint sets the host and port of cf to those of the endpoint variant it
+// selects.
+func variantEndpoint(cf *conf.SDK_conf_file) error {
+	d := &cf.Services.Dynamo_db
+	host,host_err := VariantHost(d.Zone,d.Use_fips,d.Use_dualstack)
+	if host_err != nil {
+		return host_err
+	}
+	d.Host = host
+	// the variants are https only, so the default http port gives way to its https one
+	if d.Port == "" || d.Port == aws_const.PORT {
+		d.Port = HTTPS_PORT
+	}
+	return nil
+}
+
+// customEndpoint sets the host and port of cf from its endpoint URL, along with the
+// zone and dummy keys that DynamoDB Local and dynalite accept when cf has none. It
+// returns the scheme of the endpoint.
+func customEndpoint(cf *conf.SDK_conf_file) (string,error) {
+	d := &cf.Services.Dynamo_db
+	u,u_err := url.Parse(d.Endpoint)
+	if u_err != nil || u.Hostname() == "" {
+		return "",errors.New("conf_file: cannot parse endpoint: " + d.Endpoint)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "",errors.New("conf_file: endpoint must be http or https: " + d.Endpoint)
+	}
+	d.Host = u.Hostname()
+	d.Port = u.Port()
+	if d.Port == "" && scheme == "https" {
+		d.Port = HTTPS_PORT
+	} else if d.Port == "" {
+		d.Port = aws_const.PORT
+	}
+	if d.Zone == "" {
+		d.Zone = conf.LOCAL_ZONE
+	}
+	p := &cf.Services.Default_settings.Params
+	if p.Access_key_id == "" && !d.IAM.Use_iam {
+		p.Access_key_id = conf.LOCAL_ACCESS_KEY
+		p.Secret_access_key = conf.LOCAL_SECRET_KEY
+	}
+	return scheme,nil
+}
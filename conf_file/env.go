@@ -0,0 +1,157 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package conf_file
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// prefix of the environment variables that override conf file settings
+	ENV_PREFIX = "GODYNAMO_"
+	// and of those for the settings of the iam section
+	ENV_IAM_PREFIX = ENV_PREFIX + "IAM_"
+)
+
+// EnvNames returns the environment variable that overrides each setting of the conf
+// file, keyed by its path in the file (e.g. "dynamo_db.iam.role_arn").
+// A setting is named by ENV_PREFIX (ENV_IAM_PREFIX in the iam section, and likewise for
+// the other sections of dynamo_db) and its key in upper case, so "host" is GODYNAMO_HOST,
+// "role_arn" is GODYNAMO_IAM_ROLE_ARN and "retry.retries" is GODYNAMO_RETRY_RETRIES.
+func EnvNames() map[string]string {
+	names := make(map[string]string)
+	var cf conf.SDK_conf_file
+	envFields(&cf,func(path,name string,_ reflect.Value) {
+		names[path] = name
+	})
+	return names
+}
+
+// envFields calls f with the path, environment variable name and value of each setting
+// of cf.
+func envFields(cf *conf.SDK_conf_file,f func(path,name string,v reflect.Value)) {
+	sections := []struct {
+		path,prefix string
+		v reflect.Value
+	}{
+		{"default_settings.params",ENV_PREFIX,reflect.ValueOf(&cf.Services.Default_settings.Params).Elem()},
+		{"dynamo_db",ENV_PREFIX,reflect.ValueOf(&cf.Services.Dynamo_db).Elem()},
+		{"dynamo_db.iam",ENV_IAM_PREFIX,reflect.ValueOf(&cf.Services.Dynamo_db.IAM).Elem()},
+		{"dynamo_db.retry",ENV_PREFIX + "RETRY_",reflect.ValueOf(&cf.Services.Dynamo_db.Retry).Elem()},
+		{"dynamo_db.rate_limit",ENV_PREFIX + "RATE_LIMIT_",
+			reflect.ValueOf(&cf.Services.Dynamo_db.Rate_limit).Elem()},
+		{"dynamo_db.logging",ENV_PREFIX + "LOGGING_",reflect.ValueOf(&cf.Services.Dynamo_db.Logging).Elem()},
+		{"dynamo_db.cache",ENV_PREFIX + "CACHE_",reflect.ValueOf(&cf.Services.Dynamo_db.Cache).Elem()},
+		{"dynamo_db.streams",ENV_PREFIX + "STREAMS_",reflect.ValueOf(&cf.Services.Dynamo_db.Streams).Elem()},
+	}
+	for _,s := range sections {
+		t := s.v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			// sections, and maps other than headers, have no variable
+			if t.Field(i).Type.Kind() == reflect.Struct ||
+				(t.Field(i).Type.Kind() == reflect.Map && t.Field(i).Type.Elem().Kind() != reflect.String) {
+				continue
+			}
+			key := strings.ToLower(t.Field(i).Name)
+			f(s.path + "." + key,s.prefix + strings.ToUpper(key),s.v.Field(i))
+		}
+	}
+}
+
+// envConfigured determines if the environment sets the dynamo host or zone, enough to
+// run without a conf file. A custom endpoint is enough as well.
+func envConfigured() bool {
+	return os.Getenv(ENV_PREFIX + "HOST") != "" || os.Getenv(ENV_PREFIX + "ZONE") != "" ||
+		os.Getenv(ENV_PREFIX + "ENDPOINT") != "" || envEndpoint() != ""
+}
+
+// applyEnv overrides the settings of cf with those set in the environment. Lists are
+// comma separated, and headers are comma separated name=value pairs.
+func applyEnv(cf *conf.SDK_conf_file) error {
+	var err error
+	envFields(cf,func(path,name string,v reflect.Value) {
+		s,set := os.LookupEnv(name)
+		if !set || err != nil {
+			return
+		}
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(s)
+		case reflect.Int:
+			n,n_err := strconv.Atoi(s)
+			if n_err != nil {
+				e := fmt.Sprintf("conf_file: %s: not an integer: %q",name,s)
+				err = errors.New(e)
+				return
+			}
+			v.SetInt(int64(n))
+		case reflect.Float64:
+			f,f_err := strconv.ParseFloat(s,64)
+			if f_err != nil {
+				e := fmt.Sprintf("conf_file: %s: not a number: %q",name,s)
+				err = errors.New(e)
+				return
+			}
+			v.SetFloat(f)
+		case reflect.Bool:
+			b,b_err := strconv.ParseBool(s)
+			if b_err != nil {
+				e := fmt.Sprintf("conf_file: %s: not a boolean: %q",name,s)
+				err = errors.New(e)
+				return
+			}
+			v.SetBool(b)
+		case reflect.Slice:
+			l := make([]string,0)
+			for _,item := range strings.Split(s,",") {
+				if item = strings.TrimSpace(item); item != "" {
+					l = append(l,item)
+				}
+			}
+			v.Set(reflect.ValueOf(l))
+		case reflect.Map:
+			m := make(map[string]string)
+			for _,pair := range strings.Split(s,",") {
+				if strings.TrimSpace(pair) == "" {
+					continue
+				}
+				kv := strings.SplitN(pair,"=",2)
+				if len(kv) != 2 {
+					e := fmt.Sprintf("conf_file: %s: not a name=value pair: %q",name,pair)
+					err = errors.New(e)
+					return
+				}
+				m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+			v.Set(reflect.ValueOf(m))
+		}
+	})
+	return err
+}
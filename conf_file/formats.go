@@ -0,0 +1,769 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"fmt"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"path/filepath"
+	"encoding/json"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// CONF_EXTENSIONS are the conf file formats, by extension, in the order they are looked
+// for. A conf file is JSON unless its extension is that of YAML or TOML.
+var CONF_EXTENSIONS = []string{".json",".yaml",".yml",".toml"}
+
+// confNames returns the names a conf file may have in dir, with prefix before the
+// name (such as "." in a home directory), one per format.
+func confNames(dir,prefix string) []string {
+	base := strings.TrimSuffix(conf.CONF_NAME,filepath.Ext(conf.CONF_NAME))
+	names := make([]string,len(CONF_EXTENSIONS))
+	for i,ext := range CONF_EXTENSIONS {
+		names[i] = filepath.Join(dir,prefix + base + ext)
+	}
+	return names
+}
+
+// unmarshalConf reads the conf file name, with contents b, into cf in the format its
+// extension names. The keys of YAML and TOML files are those of the JSON format.
+func unmarshalConf(name string,b []byte,cf *conf.SDK_conf_file) error {
+	var doc map[string] interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml",".yml":
+		doc,err = parseYAML(string(b))
+	case ".toml":
+		doc,err = parseTOML(string(b))
+	default:
+		return json.Unmarshal(b,cf)
+	}
+	if err != nil {
+		return err
+	}
+	j,m_err := json.Marshal(coerce(reflect.TypeOf(*cf),doc))
+	if m_err != nil {
+		return m_err
+	}
+	return json.Unmarshal(j,cf)
+}
+
+// coerce converts the scalars of x to the kinds of t where YAML and TOML differ from
+// JSON, so a port written as 80 or a key as 0123 is taken as the string it is written
+// as in the conf file.
+func coerce(t reflect.Type,x interface{}) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := x.(type) {
+	case map[string] interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			for k,val := range v {
+				if f,ok := fieldFold(t,k); ok {
+					v[k] = coerce(f.Type,val)
+				}
+			}
+		case reflect.Map:
+			for k,val := range v {
+				v[k] = coerce(t.Elem(),val)
+			}
+		}
+		return v
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i,val := range v {
+				v[i] = coerce(t.Elem(),val)
+			}
+		} else if t.Kind() == reflect.String {
+			for i,val := range v {
+				v[i] = coerce(t,val)
+			}
+			return fmt.Sprint(v...)
+		}
+		return v
+	case plainScalar:
+		if t.Kind() == reflect.String && v.value != nil {
+			return v.text
+		}
+		return v.value
+	}
+	return x
+}
+
+// plainScalar is an unquoted scalar of a YAML or TOML document: its value, and the text
+// it was written as, which is what a string field of the conf gets.
+type plainScalar struct {
+	text string
+	value interface{}
+}
+
+// MarshalJSON marshals the value of the scalar, for those no conf field is typed for.
+func (p plainScalar) MarshalJSON() ([]byte,error) {
+	return json.Marshal(p.value)
+}
+
+// fieldFold finds the field of the struct t named name, ignoring case as encoding/json
+// does.
+func fieldFold(t reflect.Type,name string) (reflect.StructField,bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name,name) {
+			return t.Field(i),true
+		}
+	}
+	return reflect.StructField{},false
+}
+
+// formatError describes a problem at line n of a YAML or TOML conf file.
+func formatError(format string,n int,msg string) error {
+	e := fmt.Sprintf("conf_file: %s line %d: %s",format,n,msg)
+	return errors.New(e)
+}
+
+// stripComment removes a # comment from line, outside of quotes.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitFlow splits s at the top-level occurrences of sep, outside quotes and brackets.
+func splitFlow(s string,sep byte) ([]string,error) {
+	parts := make([]string,0)
+	depth,start := 0,0
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts,s[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil,errors.New("unterminated quote or bracket")
+	}
+	return append(parts,s[start:]),nil
+}
+
+// unquote returns the string of a quoted scalar: "..." with escapes, or '...' in which
+// a quote is doubled (in YAML) or cannot appear (in TOML).
+func unquote(s string) (string,error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1],"''","'",-1),nil
+	}
+	return strconv.Unquote(s)
+}
+
+// the plain scalars of the YAML 1.2 core schema, and those that YAML 1.1 (the schema
+// of many tools that write YAML) reads differently: booleans such as yes and off, and
+// numbers with underscores
+var (
+	yaml_int_re    = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yaml_float_re  = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yaml11_bool_re = regexp.MustCompile(`^(y|Y|yes|Yes|YES|n|N|no|No|NO|on|On|ON|off|Off|OFF)$`)
+	yaml11_num_re  = regexp.MustCompile(`^[-+]?([0-9][0-9_]*(\.[0-9_]*)?|0x[0-9a-fA-F_]+|0b[01_]+)$`)
+)
+
+// the bare values of TOML: underscores only between digits, no leading zeros, and floats
+// with a fraction or exponent; dates are taken as strings
+var (
+	toml_int_re    = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+	toml_prefix_re = regexp.MustCompile(`^0(x[0-9a-fA-F](_?[0-9a-fA-F])*|o[0-7](_?[0-7])*|b[01](_?[01])*)$`)
+	toml_float_re  = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)` +
+		`((\.[0-9](_?[0-9])*)([eE][-+]?[0-9](_?[0-9])*)?|[eE][-+]?[0-9](_?[0-9])*)$`)
+	toml_date_re   = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}([Tt ][0-9:.]+([Zz]|[-+][0-9:]+)?)?$|^[0-9]{2}:[0-9]{2}:[0-9]{2}`)
+)
+
+// scalar returns the value of a plain scalar: a boolean, integer, float, null (nil) or
+// else, in YAML, the string itself, along with its text. A TOML value that is none of
+// these (or a date) is an error, as is a YAML scalar that YAML 1.1 reads differently.
+func scalar(s string,yaml bool) (plainScalar,error) {
+	p := plainScalar{text:s,value:s}
+	if yaml {
+		switch {
+		case yaml11_bool_re.MatchString(s) || strings.Contains(s,"_") && yaml11_num_re.MatchString(s):
+			return p,errors.New("ambiguous between YAML 1.1 and 1.2, quote it or write " +
+				"true, false or a plain number: " + s)
+		case s == "true" || s == "True" || s == "TRUE":
+			p.value = true
+		case s == "false" || s == "False" || s == "FALSE":
+			p.value = false
+		case s == "null" || s == "Null" || s == "NULL" || s == "~" || s == "":
+			p.value = nil
+		case yaml_int_re.MatchString(s):
+			if n,n_err := strconv.ParseInt(s,10,64); n_err == nil {
+				p.value = n
+			}
+		case strings.HasPrefix(s,"0x") || strings.HasPrefix(s,"0o"):
+			if n,n_err := strconv.ParseInt(s,0,64); n_err == nil {
+				p.value = n
+			}
+		case yaml_float_re.MatchString(s):
+			if f,f_err := strconv.ParseFloat(s,64); f_err == nil {
+				p.value = f
+			}
+		}
+		return p,nil
+	}
+	var err error
+	switch {
+	case s == "true":
+		p.value = true
+	case s == "false":
+		p.value = false
+	case toml_int_re.MatchString(s) || toml_prefix_re.MatchString(s):
+		p.value,err = strconv.ParseInt(s,0,64)
+	case toml_float_re.MatchString(s) || strings.TrimLeft(s,"+-") == "inf" || strings.TrimLeft(s,"+-") == "nan":
+		p.value,err = strconv.ParseFloat(strings.Replace(s,"_","",-1),64)
+	case toml_date_re.MatchString(s):
+	default:
+		return p,errors.New("not a value: " + s)
+	}
+	if err != nil {
+		return p,errors.New("not a value: " + s)
+	}
+	return p,nil
+}
+
+// flowValue parses a quoted or plain scalar, or a flow sequence [a, b] or mapping
+// {k: v} (k = v in TOML) of them.
+func flowValue(s string,yaml bool) (interface{},error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		if yaml {
+			return nil,nil
+		}
+		return nil,errors.New("missing value")
+	}
+	if yaml && (s[0] == '&' || s[0] == '*' || s[0] == '!') {
+		return nil,errors.New("anchors, aliases and tags are not supported: " + s)
+	}
+	switch s[0] {
+	case '"','\'':
+		return unquote(s)
+	case '[':
+		if s[len(s)-1] != ']' {
+			return nil,errors.New("unterminated [")
+		}
+		parts,split_err := splitFlow(s[1:len(s)-1],',')
+		if split_err != nil {
+			return nil,split_err
+		}
+		l := make([]interface{},0,len(parts))
+		for _,p := range parts {
+			if strings.TrimSpace(p) == "" {
+				// a trailing comma
+				continue
+			}
+			v,v_err := flowValue(p,yaml)
+			if v_err != nil {
+				return nil,v_err
+			}
+			l = append(l,v)
+		}
+		return l,nil
+	case '{':
+		if s[len(s)-1] != '}' {
+			return nil,errors.New("unterminated {")
+		}
+		parts,split_err := splitFlow(s[1:len(s)-1],',')
+		if split_err != nil {
+			return nil,split_err
+		}
+		m := make(map[string] interface{})
+		for _,p := range parts {
+			if strings.TrimSpace(p) == "" {
+				continue
+			}
+			var k,v string
+			var ok bool
+			if yaml {
+				k,v,ok = splitYAMLKey(p)
+			} else {
+				k,v,ok = splitTOMLKey(p)
+			}
+			if !ok {
+				return nil,errors.New("not a key and value: " + strings.TrimSpace(p))
+			}
+			key,key_err := keyName(k)
+			if key_err != nil {
+				return nil,key_err
+			}
+			if _,dup := m[key]; dup {
+				return nil,errors.New("duplicate key " + key)
+			}
+			val,val_err := flowValue(v,yaml)
+			if val_err != nil {
+				return nil,val_err
+			}
+			m[key] = val
+		}
+		return m,nil
+	}
+	return scalar(s,yaml)
+}
+
+// keyName returns the name of a bare or quoted key.
+func keyName(k string) (string,error) {
+	k = strings.TrimSpace(k)
+	if k != "" && (k[0] == '"' || k[0] == '\'') {
+		return unquote(k)
+	}
+	if k == "" {
+		return "",errors.New("empty key")
+	}
+	return k,nil
+}
+
+// yamlLine is a line of a YAML document with its indentation.
+type yamlLine struct {
+	n int
+	indent int
+	text string
+}
+
+// parseYAML parses the block mappings, block sequences, flow collections, scalars and
+// literal (|) and folded (>) strings of a YAML document whose root is a mapping. Rather
+// than be read wrongly, anchors, aliases, tags, directives and streams of more than one
+// document are errors.
+func parseYAML(s string) (map[string] interface{},error) {
+	lines := make([]yamlLine,0)
+	started,ended := false,false
+	for i,raw := range strings.Split(strings.Replace(s,"\r\n","\n",-1),"\n") {
+		text := strings.TrimRight(stripComment(raw),"\t ")
+		trimmed := strings.TrimLeft(text," ")
+		if trimmed == "" {
+			continue
+		}
+		if ended {
+			return nil,formatError("yaml",i + 1,"only one document is supported")
+		}
+		switch {
+		case text == "---":
+			if started || len(lines) != 0 {
+				return nil,formatError("yaml",i + 1,"only one document is supported")
+			}
+			started = true
+			continue
+		case strings.HasPrefix(text,"--- "):
+			return nil,formatError("yaml",i + 1,"content on the --- line is not supported")
+		case text == "...":
+			ended = true
+			continue
+		case strings.HasPrefix(text,"%"):
+			return nil,formatError("yaml",i + 1,"directives are not supported")
+		}
+		if strings.HasPrefix(trimmed,"\t") {
+			return nil,formatError("yaml",i + 1,"tabs cannot indent")
+		}
+		lines = append(lines,yamlLine{n:i + 1,indent:len(text) - len(trimmed),text:trimmed,})
+	}
+	if len(lines) == 0 {
+		return make(map[string] interface{}),nil
+	}
+	// keep the raw lines for block strings, whose comments and blank lines are text
+	raw := strings.Split(strings.Replace(s,"\r\n","\n",-1),"\n")
+	p := &yamlParser{lines:lines,raw:raw}
+	v,err := p.block(lines[0].indent)
+	if err != nil {
+		return nil,err
+	}
+	if p.i < len(p.lines) {
+		return nil,formatError("yaml",p.lines[p.i].n,"unexpected indentation")
+	}
+	m,ok := v.(map[string] interface{})
+	if !ok {
+		return nil,formatError("yaml",lines[0].n,"the document must be a mapping")
+	}
+	return m,nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	raw []string
+	i int
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text,"- ")
+}
+
+// splitYAMLKey splits "key: value" at the colon, outside quotes.
+func splitYAMLKey(s string) (string,string,bool) {
+	parts,err := splitFlow(s,':')
+	if err != nil || len(parts) < 2 {
+		return "","",false
+	}
+	// a colon must be followed by a space or end the line, as in URLs it does not
+	k := parts[0]
+	rest := strings.Join(parts[1:],":")
+	if rest != "" && rest[0] != ' ' {
+		return "","",false
+	}
+	return k,strings.TrimSpace(rest),true
+}
+
+// block parses the mapping or sequence at indent.
+func (p *yamlParser) block(indent int) (interface{},error) {
+	if isSeqItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{},error) {
+	m := make(map[string] interface{})
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		l := p.lines[p.i]
+		if isSeqItem(l.text) {
+			return nil,formatError("yaml",l.n,"a sequence item in a mapping")
+		}
+		k,v,ok := splitYAMLKey(l.text)
+		if !ok {
+			// a key whose value follows on the next lines ends in a bare colon
+			if strings.HasSuffix(l.text,":") {
+				k,v,ok = strings.TrimSuffix(l.text,":"),"",true
+			} else {
+				return nil,formatError("yaml",l.n,"not a key and value: " + l.text)
+			}
+		}
+		if strings.HasPrefix(k,"&") || strings.HasPrefix(k,"*") || strings.HasPrefix(k,"!") || k == "<<" {
+			return nil,formatError("yaml",l.n,"anchors, aliases, tags and merge keys are not supported: " + k)
+		}
+		key,key_err := keyName(k)
+		if key_err != nil {
+			return nil,formatError("yaml",l.n,key_err.Error())
+		}
+		if _,dup := m[key]; dup {
+			return nil,formatError("yaml",l.n,"duplicate key " + key)
+		}
+		p.i++
+		val,val_err := p.value(l,indent,v)
+		if val_err != nil {
+			return nil,val_err
+		}
+		m[key] = val
+	}
+	return m,nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{},error) {
+	l := make([]interface{},0)
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text) {
+		line := p.lines[p.i]
+		item := strings.TrimSpace(strings.TrimPrefix(line.text,"-"))
+		if _,_,is_map := splitYAMLKey(item); is_map || strings.HasSuffix(item,":") && !strings.HasPrefix(item,"\"") {
+			// a mapping begun on the item's line, at the indentation of its first key
+			p.lines[p.i] = yamlLine{n:line.n,indent:indent + len(line.text) - len(item),text:item}
+			v,err := p.mapping(p.lines[p.i].indent)
+			if err != nil {
+				return nil,err
+			}
+			l = append(l,v)
+			continue
+		}
+		p.i++
+		v,err := p.value(line,indent,item)
+		if err != nil {
+			return nil,err
+		}
+		l = append(l,v)
+	}
+	return l,nil
+}
+
+// value parses the value v given on line l at indent, or on the lines after it.
+func (p *yamlParser) value(l yamlLine,indent int,v string) (interface{},error) {
+	if v == "|" || v == ">" || strings.HasPrefix(v,"|-") || strings.HasPrefix(v,">-") {
+		return p.blockString(l,indent,v),nil
+	}
+	if v != "" {
+		val,err := flowValue(v,true)
+		if err != nil {
+			return nil,formatError("yaml",l.n,err.Error())
+		}
+		return val,nil
+	}
+	if p.i < len(p.lines) {
+		next := p.lines[p.i]
+		if next.indent > indent || (next.indent == indent && isSeqItem(next.text) && !isSeqItem(l.text)) {
+			return p.block(next.indent)
+		}
+	}
+	return nil,nil
+}
+
+// blockString collects the lines indented past indent after l as a literal (|) or
+// folded (>) string.
+func (p *yamlParser) blockString(l yamlLine,indent int,style string) string {
+	text := make([]string,0)
+	block_indent := -1
+	last := l.n
+	for p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		last = p.lines[p.i].n
+		p.i++
+	}
+	for _,raw := range p.raw[l.n:last] {
+		trimmed := strings.TrimLeft(raw," ")
+		if trimmed != "" && block_indent < 0 {
+			block_indent = len(raw) - len(trimmed)
+		}
+		if block_indent >= 0 && len(raw) >= block_indent {
+			raw = raw[block_indent:]
+		} else {
+			raw = trimmed
+		}
+		text = append(text,raw)
+	}
+	sep := "\n"
+	if style[0] == '>' {
+		sep = " "
+	}
+	s := strings.Join(text,sep)
+	if !strings.HasSuffix(style,"-") {
+		s += "\n"
+	}
+	return s
+}
+
+// splitTOMLKey splits "key = value" at the first equals sign outside a quoted key.
+func splitTOMLKey(s string) (string,string,bool) {
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return s[:i],strings.TrimSpace(s[i + 1:]),true
+		}
+	}
+	return "","",false
+}
+
+// tomlKey splits a dotted key into its names.
+func tomlKey(k string) ([]string,error) {
+	parts,err := splitFlow(strings.TrimSpace(k),'.')
+	if err != nil {
+		return nil,err
+	}
+	names := make([]string,len(parts))
+	for i,part := range parts {
+		if names[i],err = keyName(part); err != nil {
+			return nil,err
+		}
+	}
+	return names,nil
+}
+
+// tomlTable returns the table at path in root, creating the tables missing.
+func tomlTable(root map[string] interface{},path []string) (map[string] interface{},error) {
+	t := root
+	for _,name := range path {
+		next,ok := t[name]
+		if !ok {
+			next = make(map[string] interface{})
+			t[name] = next
+		}
+		nt,is_table := next.(map[string] interface{})
+		if !is_table {
+			return nil,errors.New(name + " is not a table")
+		}
+		t = nt
+	}
+	return t,nil
+}
+
+// parseTOML parses the tables, dotted keys, strings (including multi-line ones),
+// numbers, booleans, arrays and inline tables of a TOML document. Arrays of tables
+// and dates (taken as strings) are not needed by the conf and not supported.
+func parseTOML(s string) (map[string] interface{},error) {
+	root := make(map[string] interface{})
+	table := root
+	// the tables defined by headers, which may each be defined once
+	defined := make(map[string] bool)
+	lines := strings.Split(strings.Replace(s,"\r\n","\n",-1),"\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line,"[[") {
+			return nil,formatError("toml",n,"arrays of tables are not supported")
+		}
+		if strings.HasPrefix(line,"[") {
+			if !strings.HasSuffix(line,"]") {
+				return nil,formatError("toml",n,"unterminated table header")
+			}
+			path,path_err := tomlKey(line[1:len(line)-1])
+			if path_err != nil {
+				return nil,formatError("toml",n,path_err.Error())
+			}
+			if name := strings.Join(path,"\x00"); defined[name] {
+				return nil,formatError("toml",n,"duplicate table " + strings.Join(path,"."))
+			} else {
+				defined[name] = true
+			}
+			var t_err error
+			if table,t_err = tomlTable(root,path); t_err != nil {
+				return nil,formatError("toml",n,t_err.Error())
+			}
+			continue
+		}
+		k,v,ok := splitTOMLKey(line)
+		if !ok {
+			return nil,formatError("toml",n,"not a key and value: " + line)
+		}
+		// gather the lines of multi-line strings and arrays
+		multiline := false
+		for _,delim := range []string{"\"\"\"","'''"} {
+			if strings.HasPrefix(v,delim) {
+				body := strings.TrimPrefix(v,delim)
+				for !strings.Contains(body,delim) {
+					if i++; i >= len(lines) {
+						return nil,formatError("toml",n,"unterminated multi-line string")
+					}
+					body += "\n" + lines[i]
+				}
+				end := strings.Index(body,delim)
+				if rest := strings.TrimSpace(stripComment(body[end + len(delim):])); rest != "" {
+					return nil,formatError("toml",i + 1,"text after the end of a multi-line string: " + rest)
+				}
+				body = body[:end]
+				// a newline right after the opening delimiter is trimmed
+				body = strings.TrimPrefix(body,"\n")
+				if delim == "\"\"\"" {
+					unq,unq_err := strconv.Unquote("\"" + escapeMultiline(body) + "\"")
+					if unq_err != nil {
+						return nil,formatError("toml",n,unq_err.Error())
+					}
+					body = unq
+				}
+				multiline = true
+				if set_err := tomlSet(table,k,body); set_err != nil {
+					return nil,formatError("toml",n,set_err.Error())
+				}
+				break
+			}
+		}
+		if multiline {
+			continue
+		}
+		for strings.HasPrefix(v,"[") {
+			if _,split_err := splitFlow(v,','); split_err == nil {
+				break
+			}
+			if i++; i >= len(lines) {
+				return nil,formatError("toml",n,"unterminated array")
+			}
+			v += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		val,val_err := flowValue(v,false)
+		if val_err != nil {
+			return nil,formatError("toml",n,val_err.Error())
+		}
+		if set_err := tomlSet(table,k,val); set_err != nil {
+			return nil,formatError("toml",n,set_err.Error())
+		}
+	}
+	return root,nil
+}
+
+// escapeMultiline escapes the newlines and unescaped quotes a multi-line basic string may
+// hold, for strconv.Unquote.
+func escapeMultiline(body string) string {
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i + 1 < len(body):
+			b.WriteByte(c)
+			b.WriteByte(body[i + 1])
+			i++
+		case c == '\n':
+			b.WriteString("\\n")
+		case c == '"':
+			b.WriteString("\\\"")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// tomlSet sets the dotted key k of table to v.
+func tomlSet(table map[string] interface{},k string,v interface{}) error {
+	path,path_err := tomlKey(k)
+	if path_err != nil {
+		return path_err
+	}
+	t,t_err := tomlTable(table,path[:len(path)-1])
+	if t_err != nil {
+		return t_err
+	}
+	name := path[len(path)-1]
+	if _,dup := t[name]; dup {
+		return errors.New("duplicate key " + name)
+	}
+	t[name] = v
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"testing"
+	"github.com/smugmug/godynamo/conf"
+)
+
+func TestYAMLConf(t *testing.T) {
+	doc := `
+services:
+  default_settings:
+    params:
+      access_key_id: 0123   # kept as written
+      secret_access_key: 1.10
+      use_sys_log: true
+  dynamo_db:
+    host: dynamodb.us-east-1.amazonaws.com
+    port: 443
+    zone: us-east-1
+    region_set: [us-east-1, 0x10]
+    max_inflight: 0123
+    retry:
+      factor: 1.5
+`
+	var cf conf.SDK_conf_file
+	if err := unmarshalConf("aws-config.yaml",[]byte(doc),&cf); err != nil {
+		t.Fatalf("cannot read: %s\n",err.Error())
+	}
+	p := cf.Services.Default_settings.Params
+	d := cf.Services.Dynamo_db
+	if p.Access_key_id != "0123" || p.Secret_access_key != "1.10" || !p.Use_sys_log {
+		t.Errorf("unexpected params %+v\n",p)
+	}
+	if d.Port != "443" || d.Zone != "us-east-1" || len(d.Region_set) != 2 || d.Region_set[1] != "0x10" {
+		t.Errorf("unexpected dynamo_db %+v\n",d)
+	}
+	if d.Max_inflight != 123 || d.Retry.Factor != 1.5 {
+		t.Errorf("unexpected numbers %d %v\n",d.Max_inflight,d.Retry.Factor)
+	}
+	// 1_000 is a number in YAML 1.1 and a string in YAML 1.2
+	bad := "services:\n  dynamo_db:\n    retry:\n      retries: 1_000\n"
+	if err := unmarshalConf("aws-config.yaml",[]byte(bad),&cf); err == nil {
+		t.Errorf("1_000 read as %d\n",cf.Services.Dynamo_db.Retry.Retries)
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	for _,doc := range []string{
+		"a: 1\na: 2\n",
+		"a: {b: 1, b: 2}\n",
+		"a: [1, 2\n",
+		"- a\n- b\n",
+		"a: 1\n\tb: 2\n",
+	} {
+		if _,err := parseYAML(doc); err == nil {
+			t.Errorf("no error for %q\n",doc)
+		}
+	}
+}
+
+func TestYAMLUnsupported(t *testing.T) {
+	cases := []struct {
+		name string
+		doc string
+	}{
+		{"anchor","a: &x 1\nb: 2\n"},
+		{"alias","a: 1\nb: *x\n"},
+		{"anchored mapping","base: &base\n  port: 443\n"},
+		{"merge key","a:\n  <<: *base\n  port: 80\n"},
+		{"alias in a sequence","a:\n  - *x\n"},
+		{"alias in a flow sequence","a: [1, *x]\n"},
+		{"tag","a: !!str 1\n"},
+		{"local tag","a: !secret abc\n"},
+		{"tagged block string","a: !!str |\n  text\n"},
+		{"two documents","a: 1\n---\nb: 2\n"},
+		{"document after an end","a: 1\n...\nb: 2\n"},
+		{"second start","---\n---\na: 1\n"},
+		{"content on the start line","--- !tag\na: 1\n"},
+		{"directive","%YAML 1.2\n---\na: 1\n"},
+		{"yaml 1.1 yes","a: yes\n"},
+		{"yaml 1.1 off","a: Off\n"},
+		{"yaml 1.1 underscores","a: 1_000\n"},
+		{"yaml 1.1 hex underscores","a: 0xff_ff\n"},
+	}
+	for _,c := range cases {
+		if _,err := parseYAML(c.doc); err == nil {
+			t.Errorf("%s: no error for %q\n",c.name,c.doc)
+		}
+	}
+	// a single document, marked or not, and strings that only look like those above
+	for _,doc := range []string{"---\na: 1\n","---\na: 1\n...\n","a: \"yes\"\nb: '*x'\nc: table_name\n",
+		"a: dead_beef\nb: x&y\n"} {
+		if _,err := parseYAML(doc); err != nil {
+			t.Errorf("%q: %s\n",doc,err.Error())
+		}
+	}
+}
+
+func TestYAMLBlockString(t *testing.T) {
+	m,err := parseYAML("a: |\n  one\n  two\nb: >-\n  three\n  four\n")
+	if err != nil {
+		t.Fatalf("cannot parse: %s\n",err.Error())
+	}
+	if m["a"] != "one\ntwo\n" || m["b"] != "three four" {
+		t.Errorf("unexpected strings %q %q\n",m["a"],m["b"])
+	}
+}
+
+func TestTOMLConf(t *testing.T) {
+	doc := `
+[services.default_settings.params]
+access_key_id = 12345
+secret_access_key = """
+multi
+line""" # a comment
+[services.dynamo_db]
+host = "dynamodb.us-east-1.amazonaws.com"
+port = 443
+zone = 'us-east-1'
+region_set = [
+  "us-east-1",
+  "us-west-2",
+]
+max_inflight = 1_000
+retry.factor = 1.10
+retry.retries = 0x10
+`
+	var cf conf.SDK_conf_file
+	if err := unmarshalConf("aws-config.toml",[]byte(doc),&cf); err != nil {
+		t.Fatalf("cannot read: %s\n",err.Error())
+	}
+	p := cf.Services.Default_settings.Params
+	d := cf.Services.Dynamo_db
+	if p.Access_key_id != "12345" || p.Secret_access_key != "multi\nline" {
+		t.Errorf("unexpected params %+v\n",p)
+	}
+	if d.Port != "443" || d.Zone != "us-east-1" || len(d.Region_set) != 2 {
+		t.Errorf("unexpected dynamo_db %+v\n",d)
+	}
+	if d.Max_inflight != 1000 || d.Retry.Factor != 1.1 || d.Retry.Retries != 16 {
+		t.Errorf("unexpected numbers %d %v %d\n",d.Max_inflight,d.Retry.Factor,d.Retry.Retries)
+	}
+}
+
+func TestTOMLErrors(t *testing.T) {
+	for _,doc := range []string{
+		"a = 0123\n",
+		"a = 1__000\n",
+		"a = _1\n",
+		"a = True\n",
+		"a = bare\n",
+		"a = 1.\n",
+		"[t]\na = 1\n[t]\nb = 2\n",
+		"a = 1\na = 2\n",
+		"a = \"\"\"x\"\"\" trailing\n",
+		"a = \"\"\"x\ny\"\"\" trailing\n",
+		"a = \"x\" trailing\n",
+		"a = \"\"\"x\n",
+		"[[t]]\n",
+	} {
+		if _,err := parseTOML(doc); err == nil {
+			t.Errorf("no error for %q\n",doc)
+		}
+	}
+}
+
+func TestTOMLValues(t *testing.T) {
+	m,err := parseTOML("a = -0.5e3\nb = 0b101\nc = 1979-05-27\nd = {x = 1, y = 'z'}\ne = +inf\n")
+	if err != nil {
+		t.Fatalf("cannot parse: %s\n",err.Error())
+	}
+	if m["a"].(plainScalar).value != -500.0 || m["b"].(plainScalar).value != int64(5) {
+		t.Errorf("unexpected numbers %v %v\n",m["a"],m["b"])
+	}
+	if m["c"].(plainScalar).value != "1979-05-27" {
+		t.Errorf("unexpected date %v\n",m["c"])
+	}
+	if d,ok := m["d"].(map[string] interface{}); !ok || d["y"] != "z" || d["x"].(plainScalar).value != int64(1) {
+		t.Errorf("unexpected inline table %v\n",m["d"])
+	}
+}
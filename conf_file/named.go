@@ -0,0 +1,90 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"errors"
+	"io/ioutil"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// LoadNamed assigns the settings of cf to the configuration registered as name (see
+// conf.WithName), registering a new one if there is none. The environment is not
+// consulted, as it belongs to the default configuration conf.Vals. A named configuration
+// that uses IAM credentials gets them from conf_iam.GoProviderTo; the role providers of
+// conf_iam.GoIAM only serve conf.Vals.
+func LoadNamed(name string,cf conf.SDK_conf_file) error {
+	k,check_err := check(&cf)
+	if check_err != nil {
+		return check_err
+	}
+	c := conf.Lookup(name)
+	if c == nil {
+		c = new(conf.AWS_Conf)
+		assign(c,k)
+		conf.Register(name,c)
+		return nil
+	}
+	c.ConfLock.Lock()
+	assign(c,k)
+	c.ConfLock.Unlock()
+	return nil
+}
+
+// registerNamed assigns k to the configuration registered as name, registering a new
+// one if there is none. vals_credentials signs its requests with the credentials of
+// conf.Vals.
+func registerNamed(name string,k *checked,vals_credentials bool) {
+	c := conf.Lookup(name)
+	if c == nil {
+		c = new(conf.AWS_Conf)
+		assign(c,k)
+		c.UseValsCredentials = vals_credentials
+		conf.Register(name,c)
+		return
+	}
+	c.ConfLock.Lock()
+	assign(c,k)
+	c.UseValsCredentials = vals_credentials
+	c.ConfLock.Unlock()
+}
+
+// InitNamed is Init for the configuration registered as name.
+func InitNamed(name string,c conf.Conf) error {
+	return LoadNamed(name,c.File())
+}
+
+// ReadNamed reads the conf file at path (JSON, YAML or TOML by its extension) into the configuration registered as name.
+func ReadNamed(name,path string) error {
+	var cf conf.SDK_conf_file
+	conf_bytes,conf_err := ioutil.ReadFile(path)
+	if conf_err != nil {
+		return conf_err
+	}
+	if um_err := unmarshalConf(path,conf_bytes,&cf); um_err != nil {
+		return errors.New("conf_file.ReadNamed:" + path + " parse err: " + um_err.Error())
+	}
+	return LoadNamed(name,cf)
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// the prefix of the names of the configurations of operations in other regions
+	OPERATION_CONF_PREFIX = "operation:"
+)
+
+// loadOperations adds to p the operation regions of cf, whose settings are those of the
+// default configuration. Each operation gets a configuration of its own, named
+// OPERATION_CONF_PREFIX and the operation, differing in the zone and host, and signing
+// with the default credentials.
+func loadOperations(cf *conf.SDK_conf_file,p *pending) error {
+	m := make(map[string] string)
+	for op,o := range cf.Services.Dynamo_db.Operation_regions {
+		op_cf := *cf
+		d := &op_cf.Services.Dynamo_db
+		d.Table_aliases = nil
+		d.Operation_regions = nil
+		d.Replica_zones = nil
+		overrideRegion(&op_cf,o.Zone,o.Host)
+		name := OPERATION_CONF_PREFIX + op
+		k,check_err := check(&op_cf)
+		if check_err != nil {
+			return check_err
+		}
+		p.named = append(p.named,namedConf{name:name,k:k,vals_credentials:true})
+		m[op] = name
+	}
+	p.operations = m
+	return nil
+}
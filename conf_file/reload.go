@@ -0,0 +1,186 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"os"
+	"log"
+	"sync"
+	"time"
+	"syscall"
+	"os/signal"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/authreq"
+	"github.com/smugmug/godynamo/conf"
+)
+
+// RELOAD_INTERVAL is how often GoReload checks the conf file for changes by default.
+const RELOAD_INTERVAL = time.Duration(30) * time.Second
+
+// readFrom is the conf file that Read last loaded, if any.
+var readFrom struct {
+	lock sync.Mutex
+	name string
+}
+
+func setReadFrom(name string) {
+	readFrom.lock.Lock()
+	readFrom.name = name
+	readFrom.lock.Unlock()
+}
+
+func getReadFrom() string {
+	readFrom.lock.Lock()
+	defer readFrom.lock.Unlock()
+	return readFrom.name
+}
+
+// reloading tracks the goroutine started by GoReload.
+var reloading struct {
+	lock sync.Mutex
+	stop chan bool
+	done chan bool
+}
+
+// Reload looks for and reads the conf file again as Read does, and swaps its settings
+// into conf.Vals under the conf lock, so a request sees either the old settings or the
+// new ones but never a mix. Everything that can fail (reading the file and the keyring,
+// validating, looking up hosts) is done first, without the lock, so if anything fails
+// conf.Vals and the configurations of replica zones, operation regions and table
+// aliases are all left as they were and the error is returned. Open connections are
+// closed if the endpoint changed.
+//
+// Credentials from an IAM role provider are unaffected; it is the static keys, the
+// endpoint, retry, logging and inflight limit settings that are replaced (requests
+// already waiting for a slot see the new limits as slots are released). A change to
+// use_iam, role_provider or the other IAM settings needs a restart, as the provider
+// goroutines are not restarted, and conf.Vals.UseIAM keeps its value. Reload is meant
+// for programs configured by Read; settings given to Load or Init are not kept to be
+// reapplied.
+func Reload() error {
+	var cf conf.SDK_conf_file
+	conf_file,find_err := find(&cf)
+	if find_err != nil {
+		return find_err
+	}
+	p,prepare_err := prepare(&cf)
+	if prepare_err != nil {
+		return prepare_err
+	}
+	conf.Vals.ConfLock.RLock()
+	url := conf.Vals.Network.DynamoDB.URL
+	conf.Vals.ConfLock.RUnlock()
+	p.apply(true)
+	conf.Vals.ConfLock.RLock()
+	new_url := conf.Vals.Network.DynamoDB.URL
+	conf.Vals.ConfLock.RUnlock()
+	changed := url != new_url
+	setReadFrom(conf_file)
+	if changed {
+		log.Printf("conf_file.Reload: endpoint changed from %s to %s\n",url,new_url)
+		auth_v4.Recycle()
+	}
+	return nil
+}
+
+// GoReload starts a goroutine that calls Reload when the process receives SIGHUP, and
+// when the modification time of the conf file changes, checked every interval (or
+// RELOAD_INTERVAL if interval is not positive). This lets long-running daemons pick up
+// rotated static keys without restarting. Reload errors are logged and the current
+// settings kept. Calling GoReload again while the goroutine is running has no effect;
+// stop it with StopReload, or authreq.Close.
+func GoReload(interval time.Duration) {
+	if interval <= 0 {
+		interval = RELOAD_INTERVAL
+	}
+	reloading.lock.Lock()
+	defer reloading.lock.Unlock()
+	if reloading.stop != nil {
+		return
+	}
+	stop := make(chan bool)
+	done := make(chan bool)
+	hup := make(chan os.Signal,1)
+	signal.Notify(hup,syscall.SIGHUP)
+	started := authreq.GoBackground(func(closing <-chan bool) {
+		defer close(done)
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		mod_time := modTime(getReadFrom())
+		for {
+			select {
+			case <- hup:
+				log.Printf("conf_file.GoReload: SIGHUP, reloading conf\n")
+			case <- ticker.C:
+				t := modTime(getReadFrom())
+				if t.Equal(mod_time) {
+					continue
+				}
+				log.Printf("conf_file.GoReload: conf file changed, reloading conf\n")
+			case <- stop:
+				return
+			case <- closing:
+				return
+			}
+			if reload_err := Reload(); reload_err != nil {
+				log.Printf("conf_file.GoReload: keeping current conf: %s\n",reload_err.Error())
+			}
+			mod_time = modTime(getReadFrom())
+		}
+	})
+	if !started {
+		signal.Stop(hup)
+		return
+	}
+	reloading.stop = stop
+	reloading.done = done
+}
+
+// StopReload stops the goroutine started by GoReload and waits for it to exit.
+func StopReload() {
+	reloading.lock.Lock()
+	stop,done := reloading.stop,reloading.done
+	reloading.stop,reloading.done = nil,nil
+	reloading.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<- done
+}
+
+// modTime is the modification time of the named file, or the zero time if there is
+// no such file.
+func modTime(name string) time.Time {
+	if name == "" {
+		return time.Time{}
+	}
+	fi,stat_err := os.Stat(name)
+	if stat_err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
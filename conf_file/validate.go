@@ -0,0 +1,352 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_file
+
+import (
+	"fmt"
+	"sort"
+	"regexp"
+	"strconv"
+	"strings"
+	"github.com/smugmug/goawsroles/roles_files"
+	"github.com/smugmug/godynamo/conf"
+	"github.com/smugmug/godynamo/conf_iam"
+)
+
+// region_re matches region names such as us-east-1, us-gov-west-1 and ap-southeast-3.
+var region_re = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-(north|south|east|west|central|northeast|northwest|southeast|southwest)-[0-9]+$`)
+
+// host_re matches the regional DynamoDB hostnames, with their region.
+var host_re = regexp.MustCompile(`^dynamodb(?:-fips)?\.([a-z0-9-]+)\.(?:amazonaws\.com|api\.aws|amazonaws\.com\.cn|api\.amazonwebservices\.com\.cn)$`)
+
+// A Problem is one thing wrong with a configuration.
+type Problem struct {
+	// the setting, by its path in the conf file as in EnvNames (e.g. "dynamo_db.port")
+	Key string
+	Message string
+}
+
+// ValidationError is returned when a configuration cannot be loaded, listing everything
+// wrong with it rather than just the first problem found.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (v *ValidationError) Error() string {
+	s := fmt.Sprintf("conf_file: invalid configuration (%d problems):",len(v.Problems))
+	for _,p := range v.Problems {
+		s += "\n  " + p.Key + ": " + p.Message
+	}
+	return s
+}
+
+func (v *ValidationError) add(key,message string) {
+	v.Problems = append(v.Problems,Problem{Key:key,Message:message})
+}
+
+// Validate checks cf as it would be loaded, without the environment overrides or the DNS
+// lookup of the host, returning a *ValidationError if anything is wrong with it.
+func Validate(cf conf.SDK_conf_file) error {
+	v := new(ValidationError)
+	resolveHost(&cf,v)
+	validate(&cf,v)
+	if len(v.Problems) != 0 {
+		return v
+	}
+	return nil
+}
+
+// resolveHost sets the host and port of cf from its zone or endpoint, returning the
+// scheme to connect with and adding to v any problem with the endpoint.
+func resolveHost(cf *conf.SDK_conf_file,v *ValidationError) string {
+	d := &cf.Services.Dynamo_db
+	// a FIPS pseudo-region of the SDKs is signed for the region it names
+	if region,fips := conf.SigningRegion(d.Zone); fips {
+		d.Zone = region
+		d.Use_fips = true
+	}
+	scheme := "http"
+	if d.Endpoint != "" {
+		endpoint_scheme,endpoint_err := customEndpoint(cf)
+		if endpoint_err != nil {
+			v.add("dynamo_db.endpoint",endpoint_err.Error())
+		}
+		scheme = endpoint_scheme
+	} else if d.Use_fips || d.Use_dualstack {
+		if d.Zone != "" {
+			if variant_err := variantEndpoint(cf); variant_err != nil {
+				v.add("dynamo_db.use_fips",variant_err.Error())
+			}
+		}
+		scheme = "https"
+	}
+	if d.Host == "" && d.Zone != "" {
+		d.Host,_ = conf.ServiceHost("dynamodb",d.Zone,false,false)
+	}
+	// the zone of a regional endpoint named without one
+	if d.Zone == "" {
+		if m := host_re.FindStringSubmatch(d.Host); m != nil {
+			d.Zone = m[1]
+		}
+	}
+	return scheme
+}
+
+// validate adds to v the problems of cf, whose host is resolved.
+func validate(cf *conf.SDK_conf_file,v *ValidationError) {
+	d := &cf.Services.Dynamo_db
+	p := &cf.Services.Default_settings.Params
+	iam := &d.IAM
+
+	// where requests go, and the region they are signed for
+	if d.Zone == "" {
+		v.add("dynamo_db.zone","no zone: set it to the region of your tables, e.g. us-east-1, " +
+			"or set AWS_REGION")
+	} else if d.Endpoint == "" && !region_re.MatchString(d.Zone) {
+		v.add("dynamo_db.zone","not a region name: " + d.Zone)
+	}
+	switch d.Signing_algorithm {
+	case "",conf.SIGNING_ALGORITHM_V4:
+		if len(d.Region_set) != 0 {
+			v.add("dynamo_db.region_set","region_set is set without signing_algorithm sigv4a")
+		}
+	case conf.SIGNING_ALGORITHM_V4A:
+		// wildcards such as "*" and "us-*" are taken as they are
+		for i,region := range d.Region_set {
+			if !strings.Contains(region,"*") && !region_re.MatchString(region) {
+				v.add(fmt.Sprintf("dynamo_db.region_set[%d]",i),"not a region name: " + region)
+			}
+		}
+	default:
+		v.add("dynamo_db.signing_algorithm","only signing algorithms 'sigv4' and 'sigv4a' are " +
+			fmt.Sprintf("supported, not %q",d.Signing_algorithm))
+	}
+	if d.Port != "" {
+		if n,n_err := strconv.Atoi(d.Port); n_err != nil || n < 1 || n > 65535 {
+			v.add("dynamo_db.port","not a port number (1-65535): " + d.Port)
+		}
+	}
+
+	// credentials
+	if p.Access_key_id != "" && p.Secret_access_key == "" {
+		v.add("default_settings.params.secret_access_key","access_key_id is set without its secret")
+	}
+	if p.Access_key_id == "" && p.Secret_access_key != "" {
+		v.add("default_settings.params.access_key_id","secret_access_key is set without its key id")
+	}
+	switch p.Keyring {
+	case "",conf.KEYRING_KEYCHAIN,conf.KEYRING_SECRET_SERVICE,conf.KEYRING_WINCRED,conf.KEYRING_AUTO:
+	default:
+		v.add("default_settings.params.keyring","only keyrings 'keychain', 'secret_service', " +
+			fmt.Sprintf("'wincred' and 'auto' are supported, not %q",p.Keyring))
+	}
+	if p.Session_token != "" && p.Access_key_id == "" {
+		v.add("default_settings.params.session_token","session_token is set without access_key_id")
+	}
+	if !iam.Use_iam && p.Access_key_id == "" && p.Keyring == "" && d.Endpoint == "" &&
+		!conf_iam.WebIdentityEnv() && !conf_iam.ContainerEnv() {
+		v.add("default_settings.params.access_key_id",
+			"no credentials: set access_key_id and secret_access_key, or iam.use_iam")
+	}
+	if iam.Use_iam {
+		switch iam.Role_provider {
+		case roles_files.ROLE_PROVIDER:
+			if iam.Base_dir == "" {
+				v.add("dynamo_db.iam.base_dir","the file role provider needs base_dir")
+			}
+		case conf.ROLE_PROVIDER_STS:
+			if iam.Role_arn == "" {
+				v.add("dynamo_db.iam.role_arn","the sts role provider needs role_arn")
+			}
+		case conf.ROLE_PROVIDER_ROLES_ANYWHERE:
+			needs := []struct {
+				key string
+				set bool
+			}{{"role_arn",iam.Role_arn != ""},{"trust_anchor_arn",iam.Trust_anchor_arn != ""},
+				{"profile_arn",iam.Profile_arn != ""},{"certificate_file",iam.Certificate_file != ""},
+				{"private_key_file",iam.Private_key_file != ""}}
+			for _,n := range needs {
+				if !n.set {
+					v.add("dynamo_db.iam." + n.key,"the roles_anywhere role provider needs " + n.key)
+				}
+			}
+		case conf.ROLE_PROVIDER_WEB_IDENTITY,conf.ROLE_PROVIDER_CONTAINER,conf.ROLE_PROVIDER_INSTANCE,
+			conf.ROLE_PROVIDER_PROFILE,conf.ROLE_PROVIDER_CHAIN:
+		default:
+			v.add("dynamo_db.iam.role_provider","only IAM role providers 'file', 'sts', " +
+				"'web_identity', 'container', 'instance', 'profile', 'chain' and 'roles_anywhere' " +
+				"are supported, not " + fmt.Sprintf("%q",iam.Role_provider))
+		}
+	}
+	if iam.Role_arn == "" {
+		without := []struct {
+			key string
+			set bool
+		}{{"role_chain",len(iam.Role_chain) != 0},{"mfa_serial",iam.Mfa_serial != ""},
+			{"role_external_id",iam.Role_external_id != ""},
+			{"role_session_tags",len(iam.Role_session_tags) != 0}}
+		for _,w := range without {
+			if w.set {
+				v.add("dynamo_db.iam." + w.key,w.key + " is set without role_arn")
+			}
+		}
+	}
+	anywhere := []struct {
+		key,arn string
+	}{{"trust_anchor_arn",iam.Trust_anchor_arn},{"profile_arn",iam.Profile_arn}}
+	for _,a := range anywhere {
+		if a.arn != "" && !strings.HasPrefix(a.arn,"arn:") {
+			v.add("dynamo_db.iam." + a.key,"not an ARN: " + a.arn)
+		}
+	}
+	for _,k := range iam.Role_transitive_tag_keys {
+		if _,ok := iam.Role_session_tags[k]; !ok {
+			v.add("dynamo_db.iam.role_transitive_tag_keys","not a key of role_session_tags: " + k)
+		}
+	}
+	for i,arn := range append([]string{iam.Role_arn},iam.Role_chain...) {
+		if arn != "" && !strings.HasPrefix(arn,"arn:") {
+			key := "dynamo_db.iam.role_arn"
+			if i != 0 {
+				key = fmt.Sprintf("dynamo_db.iam.role_chain[%d]",i - 1)
+			}
+			v.add(key,"not an ARN: " + arn)
+		}
+	}
+
+	// limits and intervals
+	counts := []struct {
+		key string
+		n int
+	}{{"dynamo_db.resolve_interval",d.Resolve_interval},
+		{"dynamo_db.connect_attempt_delay",d.Connect_attempt_delay},
+		{"dynamo_db.max_inflight",d.Max_inflight},
+		{"dynamo_db.max_inflight_per_table",d.Max_inflight_per_table},
+		{"dynamo_db.inflight_wait",d.Inflight_wait},
+		{"dynamo_db.inflight_priority_aging",d.Inflight_priority_aging},
+		{"dynamo_db.probe_interval",d.Probe_interval},
+		{"dynamo_db.retry.retries",d.Retry.Retries},
+		{"dynamo_db.retry.base",d.Retry.Base},
+		{"dynamo_db.retry.max",d.Retry.Max},
+		{"dynamo_db.rate_limit.max_inflight",d.Rate_limit.Max_inflight},
+		{"dynamo_db.rate_limit.max_inflight_per_table",d.Rate_limit.Max_inflight_per_table},
+		{"dynamo_db.rate_limit.inflight_wait",d.Rate_limit.Inflight_wait},
+		{"dynamo_db.rate_limit.inflight_priority_aging",d.Rate_limit.Inflight_priority_aging},
+		{"dynamo_db.logging.max_logged_body",d.Logging.Max_logged_body},
+		{"dynamo_db.cache.table_description_ttl",d.Cache.Table_description_ttl},
+		{"dynamo_db.iam.role_duration",iam.Role_duration},
+		{"dynamo_db.iam.imds_token_ttl",iam.Imds_token_ttl},
+		{"dynamo_db.iam.imds_timeout",iam.Imds_timeout},
+		{"dynamo_db.iam.refresh_before",iam.Refresh_before}}
+	for _,c := range counts {
+		if c.n < 0 {
+			v.add(c.key,fmt.Sprintf("must not be negative: %d",c.n))
+		}
+	}
+
+
+	// retries
+	policies := []struct {
+		key,name string
+	}{{"dynamo_db.retry_policy",d.Retry_policy},{"dynamo_db.retry.policy",d.Retry.Policy},
+		{"dynamo_db.streams.retry_policy",d.Streams.Retry_policy}}
+	for _,rp := range policies {
+		switch rp.name {
+		case "",conf.RETRY_POLICY_DEFAULT,conf.RETRY_POLICY_INTERACTIVE,conf.RETRY_POLICY_BATCH,
+			conf.RETRY_POLICY_PIPELINE:
+		default:
+			v.add(rp.key,"only retry policies 'default', 'interactive', 'batch' and " +
+				fmt.Sprintf("'pipeline' are supported, not %q",rp.name))
+		}
+	}
+	if d.Retry.Factor != 0 && d.Retry.Factor < 1 {
+		v.add("dynamo_db.retry.factor",fmt.Sprintf("must be at least 1: %v",d.Retry.Factor))
+	}
+	if d.Retry.Max > 0 && d.Retry.Max < d.Retry.Base {
+		v.add("dynamo_db.retry.max",fmt.Sprintf("must not be less than base (%d): %d",
+			d.Retry.Base,d.Retry.Max))
+	}
+
+	switch d.Read_preference {
+	case "",conf.READ_PREFERENCE_PRIMARY:
+	case conf.READ_PREFERENCE_NEAREST,conf.READ_PREFERENCE_FASTEST:
+		if len(d.Replica_zones) == 0 {
+			v.add("dynamo_db.read_preference","read_preference " + d.Read_preference +
+				" is set without replica_zones")
+		}
+	default:
+		v.add("dynamo_db.read_preference","only read preferences 'primary', 'nearest' and " +
+			fmt.Sprintf("'fastest' are supported, not %q",d.Read_preference))
+	}
+	if len(d.Replica_zones) != 0 && d.Endpoint != "" {
+		v.add("dynamo_db.replica_zones","replica_zones cannot be used with a custom endpoint")
+	}
+	for i,zone := range d.Replica_zones {
+		if !region_re.MatchString(zone) {
+			v.add(fmt.Sprintf("dynamo_db.replica_zones[%d]",i),"not a region name: " + zone)
+		}
+	}
+
+	names := make([]string,0,len(d.Table_aliases))
+	for alias,_ := range d.Table_aliases {
+		names = append(names,alias)
+	}
+	sort.Strings(names)
+	for _,alias := range names {
+		ta := d.Table_aliases[alias]
+		key := "dynamo_db.table_aliases." + alias
+		if ta.Zone != "" && (d.Endpoint == "" || ta.Host != "") && !region_re.MatchString(ta.Zone) {
+			v.add(key + ".zone","not a region name: " + ta.Zone)
+		}
+		if strings.Contains(ta.Host,"/") {
+			v.add(key + ".host","a host name, without a scheme or path: " + ta.Host)
+		}
+		if ta.Role_arn != "" && !strings.HasPrefix(ta.Role_arn,"arn:") {
+			v.add(key + ".role_arn","not an ARN: " + ta.Role_arn)
+		}
+	}
+
+	ops := make([]string,0,len(d.Operation_regions))
+	for op,_ := range d.Operation_regions {
+		ops = append(ops,op)
+	}
+	sort.Strings(ops)
+	for _,op := range ops {
+		o := d.Operation_regions[op]
+		key := "dynamo_db.operation_regions." + op
+		if op == "" || strings.Contains(op,".") {
+			v.add(key,"not an operation name such as Scan: " + op)
+		}
+		if o.Zone == "" && o.Host == "" {
+			v.add(key,"neither a zone nor a host is set")
+		}
+		if o.Zone != "" && !region_re.MatchString(o.Zone) {
+			v.add(key + ".zone","not a region name: " + o.Zone)
+		}
+		if strings.Contains(o.Host,"/") {
+			v.add(key + ".host","a host name, without a scheme or path: " + o.Host)
+		}
+	}
+}
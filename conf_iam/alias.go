@@ -0,0 +1,103 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"sync"
+	"time"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// the session name of alias roles when none is configured
+	ALIAS_SESSION_NAME = "godynamo"
+)
+
+// the configurations GoAliasRoles is refreshing the credentials of
+var aliasRoles struct {
+	lock sync.Mutex
+	started map[string] bool
+}
+
+// aliasRoleProvider assumes the role of the configuration called name, signed with the
+// current credentials of conf.Vals.
+func aliasRoleProvider(name string) CredentialProvider {
+	return ProviderFunc(func() (*Credentials,error) {
+		var role_arn,session_name string
+		var duration time.Duration
+		if c := conf.Lookup(name); c != nil {
+			c.ConfLock.RLock()
+			role_arn = c.IAM.AssumeRole.RoleArn
+			session_name = c.IAM.AssumeRole.SessionName
+			duration = c.IAM.AssumeRole.Duration
+			c.ConfLock.RUnlock()
+		}
+		if session_name == "" {
+			session_name = ALIAS_SESSION_NAME
+		}
+		var base Credentials
+		conf.Vals.ConfLock.RLock()
+		if conf.Vals.UseIAM {
+			base = Credentials{AccessKeyId:conf.Vals.IAM.Credentials.AccessKey,
+				SecretAccessKey:conf.Vals.IAM.Credentials.Secret,
+				SessionToken:conf.Vals.IAM.Credentials.Token}
+		} else {
+			base = Credentials{AccessKeyId:conf.Vals.Auth.AccessKey,SecretAccessKey:conf.Vals.Auth.Secret,
+				SessionToken:conf.Vals.Auth.Token}
+		}
+		conf.Vals.ConfLock.RUnlock()
+		return assumeRoleWith(role_arn,session_name,duration,RoleOptions{},&base)
+	})
+}
+
+// GoAliasRoles assumes the roles of the table aliases (see conf.Alias) for their
+// configurations, and keeps them refreshed as GoProviderTo does. The roles are assumed
+// with the credentials of conf.Vals, so GoAliasRoles follows GoIAM. It may be called
+// again after a reload, and only starts refreshing the aliases it has not yet. ready_chan
+// receives true once every role is assumed, or false if one could not be.
+func GoAliasRoles(ready_chan chan bool) {
+	names := make([]string,0)
+	aliasRoles.lock.Lock()
+	if aliasRoles.started == nil {
+		aliasRoles.started = make(map[string] bool)
+	}
+	for _,a := range conf.Aliases() {
+		if a.RoleArn == "" || a.Conf == "" || aliasRoles.started[a.Conf] || conf.Lookup(a.Conf) == nil {
+			continue
+		}
+		aliasRoles.started[a.Conf] = true
+		names = append(names,a.Conf)
+	}
+	aliasRoles.lock.Unlock()
+	ready := true
+	for _,name := range names {
+		ch := make(chan bool)
+		go GoProviderTo(conf.Lookup(name),aliasRoleProvider(name),ch)
+		if !<- ch {
+			ready = false
+		}
+	}
+	ready_chan <- ready
+}
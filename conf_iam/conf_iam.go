@@ -39,6 +39,7 @@ package conf_iam
 import (
 	"fmt"
 	"time"
+	"sync"
 	"errors"
 	"log/syslog"
 	"github.com/bradclawsie/slog"
@@ -77,8 +78,96 @@ func ReadIAM(rf *roles_files.RolesFiles) (error) {
 	return AssignCredentials(rf)
 }
 
+// closed by StopWatch to end any WatchIAM loops.
+var (
+	stopWatch = make(chan bool)
+	stopWatchOnce sync.Once
+)
+
+// the WatchIAM and GoProvider goroutines, counted so that WaitWatch can wait for them
+var watching struct {
+	sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+// startWatching counts a goroutine that StopWatch ends, or returns false once StopWatch
+// has been called.
+func startWatching() bool {
+	watching.Lock()
+	defer watching.Unlock()
+	if watching.stopped {
+		return false
+	}
+	watching.running.Add(1)
+	return true
+}
+
+// StopWatch ends WatchIAM and GoProvider. Credentials already assigned remain in
+// conf.Vals.
+func StopWatch() {
+	watching.Lock()
+	watching.stopped = true
+	watching.Unlock()
+	stopWatchOnce.Do(func() { close(stopWatch) })
+}
+
+// WaitWatch waits for the goroutines ended by StopWatch to return.
+func WaitWatch() {
+	watching.running.Wait()
+}
+
+// while paused, resume is non-nil and closed by ResumeWatch.
+var watchPause struct {
+	sync.Mutex
+	resume chan bool
+}
+
+// PauseWatch suspends credential refreshes by WatchIAM and GoProvider until ResumeWatch
+// is called. The credentials already assigned remain in use, so a pause should be shorter
+// than their remaining lifetime.
+func PauseWatch() {
+	watchPause.Lock()
+	if watchPause.resume == nil {
+		watchPause.resume = make(chan bool)
+	}
+	watchPause.Unlock()
+}
+
+// ResumeWatch ends a PauseWatch. Refreshes that came due during the pause are made then.
+func ResumeWatch() {
+	watchPause.Lock()
+	if watchPause.resume != nil {
+		close(watchPause.resume)
+		watchPause.resume = nil
+	}
+	watchPause.Unlock()
+}
+
+// awaitWatch blocks while refreshes are paused. It returns false if StopWatch is called
+// in the meantime.
+func awaitWatch() bool {
+	watchPause.Lock()
+	resume := watchPause.resume
+	watchPause.Unlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <- resume:
+		return true
+	case <- stopWatch:
+		return false
+	}
+}
+
 // WatchIAM will receive notifications for changes in IAM files and update credentials when a read signal is received.
+// WatchIAM returns when StopWatch is called.
 func WatchIAM(rf *roles_files.RolesFiles,watch_err_chan chan error) {
+	if !startWatching() {
+		return
+	}
+	defer watching.running.Done()
 	err_chan := make(chan error)
 	read_signal := make(chan bool)
 	go rf.RolesWatch(err_chan,read_signal)
@@ -91,10 +180,15 @@ func WatchIAM(rf *roles_files.RolesFiles,watch_err_chan chan error) {
 		case <- read_signal:
 			e := "WatchIAM received a read signal"
 			slog.SLog(syslog.LOG_NOTICE,e,true)
+			if !awaitWatch() {
+				return
+			}
 			assign_err := AssignCredentials(rf)
 			if assign_err != nil {
 				watch_err_chan <- assign_err
 			}
+		case <- stopWatch:
+			return
 		}
 	}
 }
@@ -104,13 +198,39 @@ func WatchIAM(rf *roles_files.RolesFiles,watch_err_chan chan error) {
 // file notification watcher will run as a goroutine, and resetting the global conf.Vals roles
 // values. If IAM Credentials are ready for use, the parameter chan `ready_chan` will receive a true
 // value, otherwise false. A false value on this chan should indicate to a caller that another auth
-// mechanism (for example, hardocded credentials) should be used.
+// mechanism (for example, hardocded credentials) should be used. With the "sts" role
+// provider, GoIAM assumes the configured role instead, see GoAssumeRole. With the
+// "web_identity" provider, or when IAM is not configured but no access key is either and
+// the web identity environment is set (as on EKS), it uses GoWebIdentity; likewise
+// GoContainer with the "container" provider or the ECS container environment, and
+// GoInstance with the "instance" provider, GoProfile with the "profile" provider,
+// GoChain with the "chain" provider and GoRolesAnywhere with the "roles_anywhere" provider.
 func GoIAM(ready_chan chan bool) {
 	use_iam := false
 	conf.Vals.ConfLock.RLock()
 	use_iam = conf.Vals.UseIAM
 	conf.Vals.ConfLock.RUnlock()
-	if use_iam == true {
+	conf.Vals.ConfLock.RLock()
+	provider := conf.Vals.IAM.RoleProvider
+	static_keys := conf.Vals.Auth.AccessKey != ""
+	conf.Vals.ConfLock.RUnlock()
+	if use_iam == true && provider == conf.ROLE_PROVIDER_STS {
+		go GoAssumeRole(ready_chan)
+	} else if (use_iam == true && provider == conf.ROLE_PROVIDER_WEB_IDENTITY) ||
+		(use_iam == false && !static_keys && WebIdentityEnv()) {
+		go GoWebIdentity(ready_chan)
+	} else if (use_iam == true && provider == conf.ROLE_PROVIDER_CONTAINER) ||
+		(use_iam == false && !static_keys && ContainerEnv()) {
+		go GoContainer(ready_chan)
+	} else if use_iam == true && provider == conf.ROLE_PROVIDER_INSTANCE {
+		go GoInstance(ready_chan)
+	} else if use_iam == true && provider == conf.ROLE_PROVIDER_PROFILE {
+		go GoProfile(ready_chan)
+	} else if use_iam == true && provider == conf.ROLE_PROVIDER_CHAIN {
+		go GoChain(ready_chan)
+	} else if use_iam == true && provider == conf.ROLE_PROVIDER_ROLES_ANYWHERE {
+		go GoRolesAnywhere(ready_chan)
+	} else if use_iam == true {
 		rf := roles_files.NewRolesFiles()
 		watching := false
 		conf.Vals.ConfLock.RLock()
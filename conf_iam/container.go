@@ -0,0 +1,117 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+)
+
+const (
+	// environment variables set in ECS tasks with a task role
+	CONTAINER_RELATIVE_URI_ENV = "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"
+	CONTAINER_FULL_URI_ENV     = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	CONTAINER_TOKEN_ENV        = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+	CONTAINER_TOKEN_FILE_ENV   = "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"
+	// the ECS credentials endpoint relative URIs are resolved against
+	CONTAINER_ENDPOINT = "http://169.254.170.2"
+)
+
+var containerClient = &http.Client{Timeout:5 * time.Second}
+
+// ContainerEnv reports whether the container credential environment variables are set.
+func ContainerEnv() bool {
+	return os.Getenv(CONTAINER_RELATIVE_URI_ENV) != "" || os.Getenv(CONTAINER_FULL_URI_ENV) != ""
+}
+
+// ContainerCredentials fetches task role credentials from the ECS container endpoint
+// named in the environment, sending the authorization token if one is set.
+func ContainerCredentials() (*Credentials,error) {
+	uri := os.Getenv(CONTAINER_FULL_URI_ENV)
+	if rel := os.Getenv(CONTAINER_RELATIVE_URI_ENV); rel != "" {
+		uri = CONTAINER_ENDPOINT + rel
+	}
+	if uri == "" {
+		return nil,errors.New("conf_iam.ContainerCredentials: no container credentials uri")
+	}
+	request,req_err := http.NewRequest("GET",uri,nil)
+	if req_err != nil {
+		e := fmt.Sprintf("conf_iam.ContainerCredentials: %s",req_err.Error())
+		return nil,errors.New(e)
+	}
+	token := os.Getenv(CONTAINER_TOKEN_ENV)
+	if token_file := os.Getenv(CONTAINER_TOKEN_FILE_ENV); token_file != "" {
+		b,read_err := ioutil.ReadFile(token_file)
+		if read_err != nil {
+			e := fmt.Sprintf("conf_iam.ContainerCredentials: %s",read_err.Error())
+			return nil,errors.New(e)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		request.Header.Set("Authorization",token)
+	}
+	response,rsp_err := containerClient.Do(request)
+	if rsp_err != nil {
+		e := fmt.Sprintf("conf_iam.ContainerCredentials: %s",rsp_err.Error())
+		return nil,errors.New(e)
+	}
+	defer response.Body.Close()
+	body,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.ContainerCredentials: err reading resp body: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	if response.StatusCode != http.StatusOK {
+		e := fmt.Sprintf("conf_iam.ContainerCredentials: code %d: %s",response.StatusCode,string(body))
+		return nil,errors.New(e)
+	}
+	var resp struct {
+		AccessKeyId string
+		SecretAccessKey string
+		Token string
+		Expiration time.Time
+	}
+	if um_err := json.Unmarshal(body,&resp); um_err != nil {
+		e := fmt.Sprintf("conf_iam.ContainerCredentials: cannot unmarshal response: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	return &Credentials{AccessKeyId:resp.AccessKeyId,SecretAccessKey:resp.SecretAccessKey,
+		SessionToken:resp.Token,Expiration:resp.Expiration},nil
+}
+
+// ContainerProvider reads the ECS task role credentials.
+var ContainerProvider = ProviderFunc(ContainerCredentials)
+
+// GoContainer is GoProvider for ContainerProvider.
+func GoContainer(ready_chan chan bool) {
+	GoProvider(ContainerProvider,ready_chan)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"net"
+	"time"
+	"context"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"path/filepath"
+	"net/http/httptest"
+)
+
+const containerResponse = `{"AccessKeyId":"ASIATASK","SecretAccessKey":"s","Token":"t",` +
+	`"Expiration":"2031-01-02T03:04:05Z","RoleArn":"arn:aws:iam::123456789012:role/task"}`
+
+// containerServer serves the container credentials at path to requests with the
+// Authorization token, directing every container credentials request to it until the
+// returned func is called. It returns the URL of the server.
+func containerServer(t *testing.T,path,token string) (string,func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("request for %s, want %s\n",r.URL.Path,path)
+		}
+		if r.Header.Get("Authorization") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(containerResponse))
+	}))
+	old := containerClient
+	// relative URIs are on CONTAINER_ENDPOINT
+	containerClient = &http.Client{Transport:&http.Transport{
+		DialContext:func(ctx context.Context,network,addr string) (net.Conn,error) {
+			return (&net.Dialer{}).DialContext(ctx,network,srv.Listener.Addr().String())
+		}}}
+	return srv.URL,func() {
+		containerClient = old
+		srv.Close()
+	}
+}
+
+func checkContainerCredentials(t *testing.T,name string) {
+	c,err := ContainerCredentials()
+	if err != nil {
+		t.Errorf("%s: %s\n",name,err.Error())
+		return
+	}
+	if c.AccessKeyId != "ASIATASK" || c.SecretAccessKey != "s" || c.SessionToken != "t" ||
+		!c.Expiration.Equal(time.Date(2031,1,2,3,4,5,0,time.UTC)) {
+		t.Errorf("%s: credentials %+v\n",name,*c)
+	}
+}
+
+func TestContainerRelativeURI(t *testing.T) {
+	_,restore := containerServer(t,"/v2/credentials/id","")
+	defer restore()
+	t.Setenv(CONTAINER_FULL_URI_ENV,"")
+	t.Setenv(CONTAINER_RELATIVE_URI_ENV,"")
+	if ContainerEnv() {
+		t.Errorf("container environment without its variables\n")
+	}
+	if _,err := ContainerCredentials(); err == nil {
+		t.Errorf("read credentials without a uri\n")
+	}
+	t.Setenv(CONTAINER_RELATIVE_URI_ENV,"/v2/credentials/id")
+	if !ContainerEnv() {
+		t.Errorf("no container environment with %s\n",CONTAINER_RELATIVE_URI_ENV)
+	}
+	checkContainerCredentials(t,"relative")
+}
+
+func TestContainerFullURI(t *testing.T) {
+	uri,restore := containerServer(t,"/creds","Bearer secret")
+	defer restore()
+	t.Setenv(CONTAINER_RELATIVE_URI_ENV,"")
+	t.Setenv(CONTAINER_FULL_URI_ENV,uri + "/creds")
+	t.Setenv(CONTAINER_TOKEN_ENV,"")
+	t.Setenv(CONTAINER_TOKEN_FILE_ENV,"")
+	if _,err := ContainerCredentials(); err == nil {
+		t.Errorf("read credentials without the authorization token\n")
+	}
+	t.Setenv(CONTAINER_TOKEN_ENV,"Bearer secret")
+	checkContainerCredentials(t,"token")
+	// the token file, as mounted by EKS Pod Identity, is preferred to the token
+	token_file := filepath.Join(t.TempDir(),"token")
+	ioutil.WriteFile(token_file,[]byte("Bearer secret\n"),0600)
+	t.Setenv(CONTAINER_TOKEN_ENV,"Bearer stale")
+	t.Setenv(CONTAINER_TOKEN_FILE_ENV,token_file)
+	checkContainerCredentials(t,"token file")
+	t.Setenv(CONTAINER_TOKEN_FILE_ENV,filepath.Join(t.TempDir(),"missing"))
+	if _,err := ContainerCredentials(); err == nil {
+		t.Errorf("read credentials with a missing token file\n")
+	}
+}
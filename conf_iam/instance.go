@@ -0,0 +1,229 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	IMDS_ENDPOINT         = "http://169.254.169.254"
+	IMDS_TOKEN_PATH       = "/latest/api/token"
+	IMDS_CREDENTIALS_PATH = "/latest/meta-data/iam/security-credentials/"
+	IMDS_TOKEN_HDR        = "X-aws-ec2-metadata-token"
+	IMDS_TOKEN_TTL_HDR    = "X-aws-ec2-metadata-token-ttl-seconds"
+	// set to "true" to never query the instance metadata service, as with the AWS SDKs
+	IMDS_DISABLED_ENV     = "AWS_EC2_METADATA_DISABLED"
+	// set to query the instance metadata service at an endpoint other than IMDS_ENDPOINT
+	IMDS_ENDPOINT_ENV     = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+	// how long IMDSv1 is used without asking again for a session token, once asking fails
+	IMDS_TOKEN_RETRY      = 5 * time.Minute
+)
+
+// ErrIMDSDisabled is returned for instance metadata reads when the service is disabled.
+var ErrIMDSDisabled = errors.New("conf_iam: the instance metadata service is disabled")
+
+// IMDSDisabled reports whether the instance metadata service is not to be queried, by
+// conf.Vals.IAM.IMDSDisabled or the IMDS_DISABLED_ENV environment variable.
+func IMDSDisabled() bool {
+	conf.Vals.ConfLock.RLock()
+	disabled := conf.Vals.IAM.IMDSDisabled
+	conf.Vals.ConfLock.RUnlock()
+	return disabled || strings.EqualFold(os.Getenv(IMDS_DISABLED_ENV),"true")
+}
+
+// imdsEndpoint returns the endpoint of the instance metadata service: IMDS_ENDPOINT_ENV,
+// as with the AWS SDKs, or IMDS_ENDPOINT.
+func imdsEndpoint() string {
+	if endpoint := os.Getenv(IMDS_ENDPOINT_ENV); endpoint != "" {
+		return strings.TrimRight(endpoint,"/")
+	}
+	return IMDS_ENDPOINT
+}
+
+// imdsSettings are the settings of the requests to the instance metadata service.
+type imdsSettings struct {
+	timeout time.Duration
+	token_ttl time.Duration
+}
+
+// valsIMDS returns the settings of conf.Vals, or their defaults.
+func valsIMDS() imdsSettings {
+	conf.Vals.ConfLock.RLock()
+	s := imdsSettings{timeout:conf.Vals.IAM.IMDSTimeout,token_ttl:conf.Vals.IAM.IMDSTokenTTL}
+	conf.Vals.ConfLock.RUnlock()
+	return s
+}
+
+// client returns a client for the instance metadata service with the timeout of s.
+func (s imdsSettings) client() *http.Client {
+	timeout := s.timeout
+	if timeout <= 0 {
+		timeout = conf.IMDS_TIMEOUT
+	}
+	return &http.Client{Timeout:timeout}
+}
+
+// the IMDSv2 session token, refreshed before it expires or when rejected. When asking
+// for one fails (as it times out behind a hop limit of 1), IMDSv1 is used until retry.
+var imdsToken struct {
+	lock sync.Mutex
+	token string
+	expires time.Time
+	retry time.Time
+}
+
+// imdsSessionToken returns an IMDSv2 session token, or "" if the service does not
+// issue them (IMDSv1 only) or did not answer the last time it was asked, less than
+// IMDS_TOKEN_RETRY ago. refresh asks for a new token regardless.
+func imdsSessionToken(s imdsSettings,refresh bool) string {
+	imdsToken.lock.Lock()
+	defer imdsToken.lock.Unlock()
+	if !refresh && imdsToken.token != "" && time.Now().Before(imdsToken.expires) {
+		return imdsToken.token
+	}
+	if !refresh && imdsToken.token == "" && time.Now().Before(imdsToken.retry) {
+		return ""
+	}
+	ttl := s.token_ttl
+	if ttl <= 0 {
+		ttl = conf.IMDS_TOKEN_TTL
+	}
+	imdsToken.token = ""
+	imdsToken.retry = time.Now().Add(IMDS_TOKEN_RETRY)
+	request,req_err := http.NewRequest("PUT",imdsEndpoint() + IMDS_TOKEN_PATH,nil)
+	if req_err != nil {
+		return ""
+	}
+	request.Header.Set(IMDS_TOKEN_TTL_HDR,fmt.Sprintf("%d",int64(ttl / time.Second)))
+	response,rsp_err := s.client().Do(request)
+	if rsp_err != nil {
+		return ""
+	}
+	defer response.Body.Close()
+	body,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil || response.StatusCode != http.StatusOK {
+		return ""
+	}
+	imdsToken.token = strings.TrimSpace(string(body))
+	// renew a minute early so a token is never used as it expires
+	imdsToken.expires = time.Now().Add(ttl - time.Minute)
+	return imdsToken.token
+}
+
+// imdsGet reads path from the instance metadata service, with an IMDSv2 session token
+// if one can be had. A token rejected as expired is refreshed and the read retried once.
+// It fails with ErrIMDSDisabled, without a request, if the service is disabled.
+func imdsGet(path string) ([]byte,error) {
+	if IMDSDisabled() {
+		return nil,ErrIMDSDisabled
+	}
+	return imdsGetWith(path,valsIMDS())
+}
+
+// imdsGetWith is imdsGet with the settings s, for callers that hold conf.Vals.ConfLock.
+// It does not check whether the service is disabled.
+func imdsGetWith(path string,s imdsSettings) ([]byte,error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		request,req_err := http.NewRequest("GET",imdsEndpoint() + path,nil)
+		if req_err != nil {
+			return nil,req_err
+		}
+		if token := imdsSessionToken(s,attempt != 0); token != "" {
+			request.Header.Set(IMDS_TOKEN_HDR,token)
+		}
+		response,rsp_err := s.client().Do(request)
+		if rsp_err != nil {
+			return nil,rsp_err
+		}
+		body,read_err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if read_err != nil {
+			return nil,read_err
+		}
+		if response.StatusCode == http.StatusUnauthorized {
+			continue
+		}
+		if response.StatusCode != http.StatusOK {
+			e := fmt.Sprintf("code %d: %s",response.StatusCode,string(body))
+			return nil,errors.New(e)
+		}
+		return body,nil
+	}
+	return nil,errors.New("metadata session token rejected")
+}
+
+// InstanceCredentials fetches the instance profile credentials from the instance
+// metadata service.
+func InstanceCredentials() (*Credentials,error) {
+	roles,roles_err := imdsGet(IMDS_CREDENTIALS_PATH)
+	if roles_err != nil {
+		e := fmt.Sprintf("conf_iam.InstanceCredentials: %s",roles_err.Error())
+		return nil,errors.New(e)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles),"\n",2)[0])
+	if role == "" {
+		return nil,errors.New("conf_iam.InstanceCredentials: no instance profile")
+	}
+	body,body_err := imdsGet(IMDS_CREDENTIALS_PATH + role)
+	if body_err != nil {
+		e := fmt.Sprintf("conf_iam.InstanceCredentials: %s",body_err.Error())
+		return nil,errors.New(e)
+	}
+	var resp struct {
+		Code string
+		AccessKeyId string
+		SecretAccessKey string
+		Token string
+		Expiration time.Time
+	}
+	if um_err := json.Unmarshal(body,&resp); um_err != nil {
+		e := fmt.Sprintf("conf_iam.InstanceCredentials: cannot unmarshal response: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	if resp.Code != "" && resp.Code != "Success" {
+		e := fmt.Sprintf("conf_iam.InstanceCredentials: %s",resp.Code)
+		return nil,errors.New(e)
+	}
+	return &Credentials{AccessKeyId:resp.AccessKeyId,SecretAccessKey:resp.SecretAccessKey,
+		SessionToken:resp.Token,Expiration:resp.Expiration},nil
+}
+
+// InstanceProvider reads the instance profile credentials.
+var InstanceProvider = ProviderFunc(InstanceCredentials)
+
+// GoInstance is GoProvider for InstanceProvider.
+func GoInstance(ready_chan chan bool) {
+	GoProvider(InstanceProvider,ready_chan)
+}
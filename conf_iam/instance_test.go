@@ -0,0 +1,227 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"testing"
+	"net/http"
+	"sync/atomic"
+	"net/http/httptest"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+// fakeIMDS is an instance metadata service with the instance profile "r". tokens is how
+// it answers session token requests: with a token, with a status code, or not at all.
+type fakeIMDS struct {
+	token_status int
+	token_hang bool
+	// token PUTs and credential reads served
+	puts int32
+	reads int32
+	// the token the credential reads must carry, if any, and whether they did
+	token string
+	tokened int32
+	// the lifetime of the credentials served
+	lifetime time.Duration
+}
+
+func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter,r *http.Request) {
+	if r.Method == "PUT" && r.URL.Path == IMDS_TOKEN_PATH {
+		atomic.AddInt32(&f.puts,1)
+		if r.Header.Get(IMDS_TOKEN_TTL_HDR) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.token_hang {
+			<- r.Context().Done()
+			return
+		}
+		if f.token_status != http.StatusOK {
+			w.WriteHeader(f.token_status)
+			return
+		}
+		w.Write([]byte(f.token))
+		return
+	}
+	if got := r.Header.Get(IMDS_TOKEN_HDR); got != "" {
+		if got != f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&f.tokened,1)
+	}
+	switch r.URL.Path {
+	case IMDS_CREDENTIALS_PATH:
+		w.Write([]byte("r\n"))
+	case IMDS_CREDENTIALS_PATH + "r":
+		n := atomic.AddInt32(&f.reads,1)
+		fmt.Fprintf(w,`{"Code":"Success","AccessKeyId":"ASIA%d","SecretAccessKey":"s%d",` +
+			`"Token":"t%d","Expiration":"%s"}`,n,n,n,
+			time.Now().Add(f.lifetime).UTC().Format(time.RFC3339))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveIMDS directs the instance metadata reads to f until the returned func is called.
+func serveIMDS(f *fakeIMDS) func() {
+	srv := httptest.NewServer(f)
+	old,had := os.LookupEnv(IMDS_ENDPOINT_ENV)
+	os.Setenv(IMDS_ENDPOINT_ENV,srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.IAM.IMDSTimeout = 200 * time.Millisecond
+	conf.Vals.IAM.IMDSDisabled = false
+	conf.Vals.ConfLock.Unlock()
+	resetIMDSToken()
+	return func() {
+		if had {
+			os.Setenv(IMDS_ENDPOINT_ENV,old)
+		} else {
+			os.Unsetenv(IMDS_ENDPOINT_ENV)
+		}
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.IAM.IMDSTimeout = 0
+		conf.Vals.ConfLock.Unlock()
+		resetIMDSToken()
+		srv.Close()
+	}
+}
+
+func resetIMDSToken() {
+	imdsToken.lock.Lock()
+	imdsToken.token = ""
+	imdsToken.expires = time.Time{}
+	imdsToken.retry = time.Time{}
+	imdsToken.lock.Unlock()
+}
+
+func TestInstanceCredentialsToken(t *testing.T) {
+	f := &fakeIMDS{token_status:http.StatusOK,token:"session",lifetime:time.Hour}
+	defer serveIMDS(f)()
+	c,err := InstanceCredentials()
+	if err != nil {
+		t.Fatalf("InstanceCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIA1" || c.SecretAccessKey != "s1" || c.SessionToken != "t1" {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	if _,err := InstanceCredentials(); err != nil {
+		t.Fatalf("InstanceCredentials: %s\n",err.Error())
+	}
+	// the token is reused for every read
+	if f.puts != 1 || f.tokened != 4 {
+		t.Errorf("%d token requests, %d reads with it; want 1, 4\n",f.puts,f.tokened)
+	}
+}
+
+// A rejected token is replaced, and the read retried.
+func TestInstanceCredentialsTokenRejected(t *testing.T) {
+	f := &fakeIMDS{token_status:http.StatusOK,token:"session",lifetime:time.Hour}
+	defer serveIMDS(f)()
+	imdsToken.lock.Lock()
+	imdsToken.token = "expired"
+	imdsToken.expires = time.Now().Add(time.Hour)
+	imdsToken.lock.Unlock()
+	if _,err := InstanceCredentials(); err != nil {
+		t.Fatalf("InstanceCredentials: %s\n",err.Error())
+	}
+	if f.puts != 1 {
+		t.Errorf("%d token requests, want 1\n",f.puts)
+	}
+}
+
+// Without a session token, whether refused or not answered, IMDSv1 is used, and a new
+// token is not asked for again until IMDS_TOKEN_RETRY.
+func TestInstanceCredentialsV1(t *testing.T) {
+	for _,f := range []*fakeIMDS{
+		&fakeIMDS{token_status:http.StatusForbidden,lifetime:time.Hour},
+		&fakeIMDS{token_hang:true,lifetime:time.Hour},
+	} {
+		restore := serveIMDS(f)
+		for i := 0; i < 2; i++ {
+			c,err := InstanceCredentials()
+			if err != nil {
+				t.Fatalf("hang %v: InstanceCredentials: %s\n",f.token_hang,err.Error())
+			}
+			if c.AccessKeyId == "" {
+				t.Errorf("hang %v: no credentials\n",f.token_hang)
+			}
+		}
+		if f.puts != 1 || f.tokened != 0 {
+			t.Errorf("hang %v: %d token requests, %d reads with one; want 1, 0\n",
+				f.token_hang,f.puts,f.tokened)
+		}
+		restore()
+	}
+}
+
+// Credentials near their expiration are reported expired, and retrieved anew.
+func TestInstanceCredentialsRefresh(t *testing.T) {
+	f := &fakeIMDS{token_status:http.StatusOK,token:"session",lifetime:time.Minute}
+	defer serveIMDS(f)()
+	p := ProviderFunc(InstanceCredentials)
+	if !p.IsExpired() {
+		t.Errorf("provider expired before retrieving\n")
+	}
+	c,err := p.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	// a minute is within the REFRESH_BEFORE lead time
+	if !p.IsExpired() {
+		t.Errorf("credentials expiring %v not expired\n",c.Expiration)
+	}
+	f.lifetime = time.Hour
+	c,err = p.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIA2" || p.IsExpired() {
+		t.Errorf("refreshed credentials %+v, expired %v\n",*c,p.IsExpired())
+	}
+}
+
+func TestIMDSDisabled(t *testing.T) {
+	f := &fakeIMDS{token_status:http.StatusOK,token:"session",lifetime:time.Hour}
+	defer serveIMDS(f)()
+	old,had := os.LookupEnv(IMDS_DISABLED_ENV)
+	os.Setenv(IMDS_DISABLED_ENV,"true")
+	defer func() {
+		if had {
+			os.Setenv(IMDS_DISABLED_ENV,old)
+		} else {
+			os.Unsetenv(IMDS_DISABLED_ENV)
+		}
+	}()
+	if _,err := InstanceCredentials(); err == nil {
+		t.Errorf("read credentials with the service disabled\n")
+	}
+	if f.puts != 0 || f.reads != 0 {
+		t.Errorf("the disabled service was queried\n")
+	}
+}
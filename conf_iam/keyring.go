@@ -0,0 +1,306 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/base64"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// KEYRING_TIMEOUT bounds each run of a keyring command, which may prompt to unlock it.
+	KEYRING_TIMEOUT = time.Minute
+	// KEYRING_REFRESH is how often GoProvider reads the keyring again with KeyringProvider.
+	KEYRING_REFRESH = 15 * time.Minute
+)
+
+// keyringItem is how credentials are kept in a keyring: as JSON in the format of a
+// credential_process, so they can be read back with other tools.
+type keyringItem struct {
+	Version int
+	AccessKeyId string
+	SecretAccessKey string
+	SessionToken string `json:",omitempty"`
+}
+
+// keyringBackend resolves conf.KEYRING_AUTO to the backend of the operating system.
+func keyringBackend(backend string) (string,error) {
+	if backend != conf.KEYRING_AUTO {
+		return backend,nil
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return conf.KEYRING_KEYCHAIN,nil
+	case "windows":
+		return conf.KEYRING_WINCRED,nil
+	case "linux","freebsd","openbsd","netbsd":
+		return conf.KEYRING_SECRET_SERVICE,nil
+	}
+	e := fmt.Sprintf("conf_iam: no keyring backend for %s",runtime.GOOS)
+	return "",errors.New(e)
+}
+
+// keyringNames defaults service and account, which must not hold quotes or line breaks.
+func keyringNames(service,account string) (string,string,error) {
+	if service == "" {
+		service = conf.KEYRING_SERVICE
+	}
+	if account == "" {
+		account = conf.KEYRING_ACCOUNT
+	}
+	if strings.ContainsAny(service + account,"\"'\r\n") {
+		return "","",errors.New("conf_iam: keyring service and account cannot hold quotes or line breaks")
+	}
+	return service,account,nil
+}
+
+// runKeyring runs the command name with args, writing stdin to it, and returns its output.
+func runKeyring(stdin string,name string,args ...string) (string,error) {
+	cmd := exec.Command(name,args...)
+	var stdout,stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if start_err := cmd.Start(); start_err != nil {
+		return "",start_err
+	}
+	done := make(chan error,1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <- done:
+		if err != nil {
+			return "",fmt.Errorf("%s: %s: %s",name,err.Error(),strings.TrimSpace(stderr.String()))
+		}
+	case <- time.After(KEYRING_TIMEOUT):
+		_ = cmd.Process.Kill()
+		return "",fmt.Errorf("%s: timed out",name)
+	}
+	return stdout.String(),nil
+}
+
+// psQuote quotes s as a PowerShell string literal.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s,"'","''",-1) + "'"
+}
+
+// wincred_ps reads and writes generic credentials of the Windows Credential Manager,
+// which has no command of its own that reveals a stored secret.
+const wincred_ps = `$ErrorActionPreference = 'Stop'
+Add-Type -TypeDefinition @"
+using System;
+using System.Text;
+using System.Runtime.InteropServices;
+public static class GodynamoCred {
+	[StructLayout(LayoutKind.Sequential, CharSet=CharSet.Unicode)]
+	public struct CREDENTIAL {
+		public int Flags; public int Type; public string TargetName; public string Comment;
+		public System.Runtime.InteropServices.ComTypes.FILETIME LastWritten;
+		public int CredentialBlobSize; public IntPtr CredentialBlob; public int Persist;
+		public int AttributeCount; public IntPtr Attributes; public string TargetAlias;
+		public string UserName;
+	}
+	[DllImport("advapi32.dll", CharSet=CharSet.Unicode, SetLastError=true)]
+	static extern bool CredRead(string target, int type, int flags, out IntPtr cred);
+	[DllImport("advapi32.dll", CharSet=CharSet.Unicode, SetLastError=true)]
+	static extern bool CredWrite(ref CREDENTIAL cred, int flags);
+	[DllImport("advapi32.dll")]
+	static extern void CredFree(IntPtr cred);
+	public static string Read(string target) {
+		IntPtr p;
+		if (!CredRead(target, 1, 0, out p)) {
+			throw new Exception("CredRead failed: " + Marshal.GetLastWin32Error());
+		}
+		try {
+			CREDENTIAL c = (CREDENTIAL)Marshal.PtrToStructure(p, typeof(CREDENTIAL));
+			byte[] b = new byte[c.CredentialBlobSize];
+			Marshal.Copy(c.CredentialBlob, b, 0, b.Length);
+			return Encoding.UTF8.GetString(b);
+		} finally {
+			CredFree(p);
+		}
+	}
+	public static void Write(string target, string user, string secret) {
+		byte[] b = Encoding.UTF8.GetBytes(secret);
+		CREDENTIAL c = new CREDENTIAL();
+		c.Type = 1; c.TargetName = target; c.UserName = user; c.Persist = 2;
+		c.CredentialBlobSize = b.Length;
+		c.CredentialBlob = Marshal.AllocHGlobal(b.Length);
+		try {
+			Marshal.Copy(b, 0, c.CredentialBlob, b.Length);
+			if (!CredWrite(ref c, 0)) {
+				throw new Exception("CredWrite failed: " + Marshal.GetLastWin32Error());
+			}
+		} finally {
+			Marshal.FreeHGlobal(c.CredentialBlob);
+		}
+	}
+}
+"@
+`
+
+// keyringRead returns the secret kept in backend under service and account.
+func keyringRead(backend,service,account string) (string,error) {
+	switch backend {
+	case conf.KEYRING_KEYCHAIN:
+		return runKeyring("","security","find-generic-password","-s",service,"-a",account,"-w")
+	case conf.KEYRING_SECRET_SERVICE:
+		return runKeyring("","secret-tool","lookup","service",service,"account",account)
+	case conf.KEYRING_WINCRED:
+		script := wincred_ps + "[Console]::Out.Write([GodynamoCred]::Read(" +
+			psQuote(service + ":" + account) + "))\n"
+		return runKeyring(script,"powershell.exe","-NoProfile","-NonInteractive","-Command","-")
+	}
+	e := fmt.Sprintf("unknown keyring backend %q",backend)
+	return "",errors.New(e)
+}
+
+// keyringWrite keeps secret in backend under service and account, replacing any there was.
+// The secret is passed on stdin, never in the arguments of a command.
+func keyringWrite(backend,service,account,secret string) error {
+	var err error
+	switch backend {
+	case conf.KEYRING_KEYCHAIN:
+		cmd := fmt.Sprintf("add-generic-password -U -s \"%s\" -a \"%s\" -X %s\n",
+			service,account,hex.EncodeToString([]byte(secret)))
+		_,err = runKeyring(cmd,"security","-i")
+	case conf.KEYRING_SECRET_SERVICE:
+		_,err = runKeyring(secret,"secret-tool","store","--label",service + " " + account,
+			"service",service,"account",account)
+	case conf.KEYRING_WINCRED:
+		script := wincred_ps + "[GodynamoCred]::Write(" + psQuote(service + ":" + account) + "," +
+			psQuote(account) + ",[Text.Encoding]::UTF8.GetString([Convert]::FromBase64String(" +
+			psQuote(base64.StdEncoding.EncodeToString([]byte(secret))) + ")))\n"
+		_,err = runKeyring(script,"powershell.exe","-NoProfile","-NonInteractive","-Command","-")
+	default:
+		e := fmt.Sprintf("unknown keyring backend %q",backend)
+		err = errors.New(e)
+	}
+	return err
+}
+
+// KeyringCredentials reads the credentials kept in the keyring backend (a conf.KEYRING_*
+// name) under service and account ("" for conf.KEYRING_SERVICE and conf.KEYRING_ACCOUNT),
+// as stored by StoreKeyringCredentials.
+func KeyringCredentials(backend,service,account string) (*Credentials,error) {
+	backend,backend_err := keyringBackend(backend)
+	if backend_err != nil {
+		return nil,backend_err
+	}
+	service,account,names_err := keyringNames(service,account)
+	if names_err != nil {
+		return nil,names_err
+	}
+	secret,read_err := keyringRead(backend,service,account)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.KeyringCredentials: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	var item keyringItem
+	if um_err := json.Unmarshal([]byte(strings.TrimSpace(secret)),&item); um_err != nil {
+		e := fmt.Sprintf("conf_iam.KeyringCredentials: cannot unmarshal the %s item %s/%s: %s",
+			backend,service,account,um_err.Error())
+		return nil,errors.New(e)
+	}
+	if item.AccessKeyId == "" || item.SecretAccessKey == "" {
+		e := fmt.Sprintf("conf_iam.KeyringCredentials: no keys in the %s item %s/%s",
+			backend,service,account)
+		return nil,errors.New(e)
+	}
+	return &Credentials{AccessKeyId:item.AccessKeyId,SecretAccessKey:item.SecretAccessKey,
+		SessionToken:item.SessionToken},nil
+}
+
+// StoreKeyringCredentials keeps the keys (and session token) of c in the keyring backend
+// under service and account, for KeyringCredentials and the keyring settings of the conf
+// file to read in place of plaintext keys.
+func StoreKeyringCredentials(backend,service,account string,c Credentials) error {
+	backend,backend_err := keyringBackend(backend)
+	if backend_err != nil {
+		return backend_err
+	}
+	service,account,names_err := keyringNames(service,account)
+	if names_err != nil {
+		return names_err
+	}
+	if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+		return errors.New("conf_iam.StoreKeyringCredentials: no keys to store")
+	}
+	b,json_err := json.Marshal(keyringItem{Version:1,AccessKeyId:c.AccessKeyId,
+		SecretAccessKey:c.SecretAccessKey,SessionToken:c.SessionToken})
+	if json_err != nil {
+		return json_err
+	}
+	if write_err := keyringWrite(backend,service,account,string(b)); write_err != nil {
+		e := fmt.Sprintf("conf_iam.StoreKeyringCredentials: %s",write_err.Error())
+		return errors.New(e)
+	}
+	return nil
+}
+
+// keyringProvider considers the keys it read expired after KEYRING_REFRESH, since keys
+// kept in a keyring have no Expiration of their own.
+type keyringProvider struct {
+	lock sync.Mutex
+	read time.Time
+}
+
+// KeyringProvider reads the keyring of conf.Vals each time it retrieves, and GoProvider
+// retrieves again every KEYRING_REFRESH, so keys rotated in the keyring are picked up.
+var KeyringProvider CredentialProvider = &keyringProvider{}
+
+func (k *keyringProvider) Retrieve() (*Credentials,error) {
+	conf.Vals.ConfLock.RLock()
+	backend := conf.Vals.Keyring.Backend
+	service := conf.Vals.Keyring.Service
+	account := conf.Vals.Keyring.Account
+	conf.Vals.ConfLock.RUnlock()
+	if backend == "" {
+		return nil,errors.New("conf_iam.KeyringProvider: no keyring is configured")
+	}
+	c,err := KeyringCredentials(backend,service,account)
+	if err != nil {
+		return nil,err
+	}
+	k.lock.Lock()
+	k.read = time.Now()
+	k.lock.Unlock()
+	return c,nil
+}
+
+func (k *keyringProvider) IsExpired() bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.read.IsZero() || time.Since(k.read) >= KEYRING_REFRESH
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"time"
+	"runtime"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+// fakeSecretTool puts a secret-tool on the PATH keeping its one item in a file, and
+// returns the path of that file.
+func fakeSecretTool(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake secret-tool is a shell script")
+	}
+	dir := t.TempDir()
+	item := filepath.Join(dir,"item")
+	script := "#!/bin/sh\n" +
+		"case \"$1\" in\n" +
+		"lookup) cat '" + item + "' ;;\n" +
+		"store) cat > '" + item + "' ;;\n" +
+		"*) exit 2 ;;\n" +
+		"esac\n"
+	ioutil.WriteFile(filepath.Join(dir,"secret-tool"),[]byte(script),0700)
+	t.Setenv("PATH",dir + string(os.PathListSeparator) + os.Getenv("PATH"))
+	return item
+}
+
+func TestKeyringCredentials(t *testing.T) {
+	item := fakeSecretTool(t)
+	if _,err := KeyringCredentials(conf.KEYRING_SECRET_SERVICE,"",""); err == nil {
+		t.Errorf("read credentials from an empty keyring\n")
+	}
+	c := Credentials{AccessKeyId:"AKIDRING",SecretAccessKey:"ring-secret"}
+	if err := StoreKeyringCredentials(conf.KEYRING_SECRET_SERVICE,"","",c); err != nil {
+		t.Fatalf("StoreKeyringCredentials: %s\n",err.Error())
+	}
+	got,err := KeyringCredentials(conf.KEYRING_SECRET_SERVICE,"","")
+	if err != nil {
+		t.Fatalf("KeyringCredentials: %s\n",err.Error())
+	}
+	if *got != c {
+		t.Errorf("read %+v, stored %+v\n",*got,c)
+	}
+	// kept in the format of a credential_process
+	if b,_ := ioutil.ReadFile(item); string(b) != `{"Version":1,"AccessKeyId":"AKIDRING","SecretAccessKey":"ring-secret"}` {
+		t.Errorf("stored item %s\n",string(b))
+	}
+	if err := StoreKeyringCredentials(conf.KEYRING_SECRET_SERVICE,"","",Credentials{}); err == nil {
+		t.Errorf("stored no keys\n")
+	}
+	if _,err := KeyringCredentials(conf.KEYRING_SECRET_SERVICE,"it's","a"); err == nil {
+		t.Errorf("read a service name with a quote\n")
+	}
+	if _,err := KeyringCredentials("nonesuch","",""); err == nil {
+		t.Errorf("read an unknown backend\n")
+	}
+}
+
+// The keyring is read again once KEYRING_REFRESH has passed, picking up rotated keys.
+func TestKeyringProviderRefresh(t *testing.T) {
+	item := fakeSecretTool(t)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Keyring.Backend = ""
+	conf.Vals.ConfLock.Unlock()
+	if _,err := KeyringProvider.Retrieve(); err == nil {
+		t.Errorf("retrieved without a keyring configured\n")
+	}
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Keyring.Backend = conf.KEYRING_SECRET_SERVICE
+	conf.Vals.ConfLock.Unlock()
+	defer func() {
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.Keyring.Backend = ""
+		conf.Vals.ConfLock.Unlock()
+	}()
+	ioutil.WriteFile(item,[]byte(`{"Version":1,"AccessKeyId":"AKIDOLD","SecretAccessKey":"s"}`),0600)
+	c,err := KeyringProvider.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "AKIDOLD" || KeyringProvider.IsExpired() {
+		t.Errorf("retrieved %+v, expired %v\n",*c,KeyringProvider.IsExpired())
+	}
+	ioutil.WriteFile(item,[]byte(`{"Version":1,"AccessKeyId":"AKIDNEW","SecretAccessKey":"s"}`),0600)
+	k := KeyringProvider.(*keyringProvider)
+	k.lock.Lock()
+	k.read = time.Now().Add(-KEYRING_REFRESH)
+	k.lock.Unlock()
+	if !KeyringProvider.IsExpired() {
+		t.Fatalf("keys read KEYRING_REFRESH ago not expired\n")
+	}
+	c,err = KeyringProvider.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "AKIDNEW" || KeyringProvider.IsExpired() {
+		t.Errorf("retrieved %+v, expired %v\n",*c,KeyringProvider.IsExpired())
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// MFA support for sts:AssumeRole, for roles whose trust policy requires
+// aws:MultiFactorAuthPresent. The token code is obtained from a callback each time a role
+// is assumed, including on credential refresh.
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"bufio"
+	"errors"
+	"strings"
+	"net/url"
+)
+
+// MFATokenFunc returns the current code of the MFA device with the given serial number
+// (or ARN, for a virtual device).
+type MFATokenFunc func(serial string) (string,error)
+
+var mfaToken struct {
+	lock sync.RWMutex
+	f MFATokenFunc
+}
+
+// SetMFATokenProvider sets the callback that supplies MFA token codes. A nil f removes it.
+func SetMFATokenProvider(f MFATokenFunc) {
+	mfaToken.lock.Lock()
+	mfaToken.f = f
+	mfaToken.lock.Unlock()
+}
+
+// StdinMFAToken is a MFATokenFunc for command line tools: it prompts on stderr and reads
+// the code from a line of stdin.
+func StdinMFAToken(serial string) (string,error) {
+	fmt.Fprintf(os.Stderr,"MFA code for %s: ",serial)
+	line,read_err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if read_err != nil && line == "" {
+		e := fmt.Sprintf("conf_iam.StdinMFAToken: %s",read_err.Error())
+		return "",errors.New(e)
+	}
+	return strings.TrimSpace(line),nil
+}
+
+// mfaParams adds the SerialNumber and TokenCode of the MFA device serial to params. It
+// does nothing if serial is empty.
+func mfaParams(params url.Values,serial string) error {
+	if serial == "" {
+		return nil
+	}
+	mfaToken.lock.RLock()
+	f := mfaToken.f
+	mfaToken.lock.RUnlock()
+	if f == nil {
+		e := fmt.Sprintf("conf_iam.mfaParams: MFA device %s configured, but no token provider set",serial)
+		return errors.New(e)
+	}
+	code,code_err := f(serial)
+	if code_err != nil {
+		return code_err
+	}
+	if code == "" {
+		e := fmt.Sprintf("conf_iam.mfaParams: empty token code for %s",serial)
+		return errors.New(e)
+	}
+	params.Set("SerialNumber",serial)
+	params.Set("TokenCode",code)
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"errors"
+	"testing"
+	"net/http"
+)
+
+func TestAssumeRoleWithMFA(t *testing.T) {
+	const serial = "arn:aws:iam::123456789012:mfa/user"
+	codes := 0
+	defer stsServer(assumeRoleHandler(t,func(r *http.Request) {
+		if r.Form.Get("SerialNumber") != serial || r.Form.Get("TokenCode") != "123456" {
+			t.Errorf("mfa params %v\n",r.Form)
+		}
+	}))()
+	defer SetMFATokenProvider(nil)
+	role := "arn:aws:iam::123456789012:role/admin"
+	SetMFATokenProvider(nil)
+	if _,err := AssumeRoleWithMFA(role,"s",0,serial); err == nil {
+		t.Errorf("assumed a role with MFA without a token provider\n")
+	}
+	SetMFATokenProvider(func(s string) (string,error) { return "",errors.New("no device") })
+	if _,err := AssumeRoleWithMFA(role,"s",0,serial); err == nil || err.Error() != "no device" {
+		t.Errorf("token provider error: %v\n",err)
+	}
+	SetMFATokenProvider(func(s string) (string,error) { return "",nil })
+	if _,err := AssumeRoleWithMFA(role,"s",0,serial); err == nil {
+		t.Errorf("assumed a role with an empty token code\n")
+	}
+	// the code is asked for on every assumption, as it changes
+	SetMFATokenProvider(func(s string) (string,error) {
+		if s != serial {
+			t.Errorf("code asked for %s\n",s)
+		}
+		codes++
+		return "123456",nil
+	})
+	for i := 0; i < 2; i++ {
+		if _,err := AssumeRoleWithMFA(role,"s",0,serial); err != nil {
+			t.Fatalf("AssumeRoleWithMFA: %s\n",err.Error())
+		}
+	}
+	useProfiles(t,"[profile mfa]\nrole_arn = " + role + "\nsource_profile = base\nmfa_serial = " +
+		serial + "\n","[base]\naws_access_key_id = AKIDBASE\naws_secret_access_key = s\n")
+	if _,err := ProfileCredentials("mfa"); err != nil {
+		t.Fatalf("ProfileCredentials: %s\n",err.Error())
+	}
+	if codes != 3 {
+		t.Errorf("%d token codes asked for, want 3\n",codes)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"os/exec"
+	"runtime"
+	"encoding/json"
+)
+
+// PROCESS_TIMEOUT bounds the run of a credential_process command.
+const PROCESS_TIMEOUT = time.Minute
+
+// ProcessCredentials runs command, a credential_process as in the shared config file,
+// with the shell, and parses the credentials it writes to stdout.
+func ProcessCredentials(command string) (*Credentials,error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe","/C",command)
+	} else {
+		cmd = exec.Command("sh","-c",command)
+	}
+	var stdout,stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if start_err := cmd.Start(); start_err != nil {
+		e := fmt.Sprintf("conf_iam.ProcessCredentials: %s",start_err.Error())
+		return nil,errors.New(e)
+	}
+	done := make(chan error,1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <- done:
+		if err != nil {
+			e := fmt.Sprintf("conf_iam.ProcessCredentials: %s: %s",err.Error(),stderr.String())
+			return nil,errors.New(e)
+		}
+	case <- time.After(PROCESS_TIMEOUT):
+		_ = cmd.Process.Kill()
+		return nil,errors.New("conf_iam.ProcessCredentials: command timed out")
+	}
+	var out struct {
+		Version int
+		AccessKeyId string
+		SecretAccessKey string
+		SessionToken string
+		Expiration time.Time
+	}
+	if um_err := json.Unmarshal(stdout.Bytes(),&out); um_err != nil {
+		e := fmt.Sprintf("conf_iam.ProcessCredentials: cannot unmarshal output: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	if out.Version != 1 {
+		e := fmt.Sprintf("conf_iam.ProcessCredentials: unsupported Version %d",out.Version)
+		return nil,errors.New(e)
+	}
+	if out.AccessKeyId == "" || out.SecretAccessKey == "" {
+		return nil,errors.New("conf_iam.ProcessCredentials: no keys in output")
+	}
+	return &Credentials{AccessKeyId:out.AccessKeyId,SecretAccessKey:out.SecretAccessKey,
+		SessionToken:out.SessionToken,Expiration:out.Expiration},nil
+}
+
+// ProcessProvider returns a CredentialProvider running command.
+func ProcessProvider(command string) CredentialProvider {
+	return ProviderFunc(func() (*Credentials,error) {
+		return ProcessCredentials(command)
+	})
+}
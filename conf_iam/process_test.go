@@ -0,0 +1,98 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"time"
+	"runtime"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// catCommand returns a credential_process writing out.
+func catCommand(t *testing.T,out string) string {
+	path := filepath.Join(t.TempDir(),"out.json")
+	ioutil.WriteFile(path,[]byte(out),0600)
+	return "cat '" + path + "'"
+}
+
+func TestProcessCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential_process commands are run with cmd.exe")
+	}
+	c,err := ProcessCredentials(catCommand(t,`{"Version":1,"AccessKeyId":"ASIAPROC",` +
+		`"SecretAccessKey":"s","SessionToken":"t","Expiration":"2031-01-02T03:04:05Z"}`))
+	if err != nil {
+		t.Fatalf("ProcessCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIAPROC" || c.SecretAccessKey != "s" || c.SessionToken != "t" ||
+		!c.Expiration.Equal(time.Date(2031,1,2,3,4,5,0,time.UTC)) {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	// long-term keys have neither a token nor an expiration
+	c,err = ProcessCredentials(catCommand(t,`{"Version":1,"AccessKeyId":"AKIDPROC","SecretAccessKey":"s"}`))
+	if err != nil {
+		t.Fatalf("ProcessCredentials: %s\n",err.Error())
+	}
+	if c.SessionToken != "" || !c.Expiration.IsZero() {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	bad := map[string] string{
+		"version":catCommand(t,`{"Version":2,"AccessKeyId":"A","SecretAccessKey":"s"}`),
+		"no version":catCommand(t,`{"AccessKeyId":"A","SecretAccessKey":"s"}`),
+		"no secret":catCommand(t,`{"Version":1,"AccessKeyId":"A"}`),
+		"not json":catCommand(t,`AccessKeyId=A`),
+		"failed":"echo denied >&2; exit 1",
+	}
+	for name,command := range bad {
+		if _,err := ProcessCredentials(command); err == nil {
+			t.Errorf("%s: no error\n",name)
+		} else if name == "failed" && !strings.Contains(err.Error(),"denied") {
+			t.Errorf("error %q does not include stderr\n",err.Error())
+		}
+	}
+}
+
+func TestProfileProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential_process commands are run with cmd.exe")
+	}
+	command := catCommand(t,`{"Version":1,"AccessKeyId":"ASIAPROC","SecretAccessKey":"s",` +
+		`"SessionToken":"t","Expiration":"2031-01-02T03:04:05Z"}`)
+	useProfiles(t,"[profile proc]\ncredential_process = " + command + "\n","")
+	c,err := ProfileCredentials("proc")
+	if err != nil {
+		t.Fatalf("ProfileCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIAPROC" {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	p := ProcessProvider(command)
+	if _,err := p.Retrieve(); err != nil || p.IsExpired() {
+		t.Errorf("ProcessProvider: %v, expired %v\n",err,p.IsExpired())
+	}
+}
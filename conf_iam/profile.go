@@ -0,0 +1,220 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"net/url"
+	"path/filepath"
+)
+
+const (
+	PROFILE_ENV                 = "AWS_PROFILE"
+	SHARED_CREDENTIALS_FILE_ENV = "AWS_SHARED_CREDENTIALS_FILE"
+	CONFIG_FILE_ENV             = "AWS_CONFIG_FILE"
+	DEFAULT_PROFILE             = "default"
+	// bound on role_arn/source_profile chains, which may not loop
+	MAX_SOURCE_PROFILES = 5
+)
+
+// readINI reads the sections of an AWS shared config or credentials file. In the
+// config file, sections other than [default] are named [profile name]; trim is that
+// prefix, so the sections of both files are keyed by the profile name.
+func readINI(path,trim string) (map[string] map[string] string,error) {
+	f,err := os.Open(path)
+	if err != nil {
+		return nil,err
+	}
+	defer f.Close()
+	sections := make(map[string] map[string] string)
+	var section map[string] string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if raw[0] == ' ' || raw[0] == '\t' {
+			// nested settings (e.g. under s3 =) are not used here
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1:len(line)-1])
+			name = strings.TrimSpace(strings.TrimPrefix(name,trim))
+			section = make(map[string] string)
+			sections[name] = section
+			continue
+		}
+		kv := strings.SplitN(line,"=",2)
+		if len(kv) != 2 || section == nil {
+			continue
+		}
+		section[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return sections,sc.Err()
+}
+
+func sharedFile(env,name string) string {
+	if p := os.Getenv(env); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"),".aws",name)
+}
+
+// ProfileName returns the profile named by AWS_PROFILE, or "default".
+func ProfileName() string {
+	if p := os.Getenv(PROFILE_ENV); p != "" {
+		return p
+	}
+	return DEFAULT_PROFILE
+}
+
+// LoadProfile returns the settings of the named profile from the shared config file
+// (~/.aws/config or AWS_CONFIG_FILE) overridden by those of the shared credentials
+// file (~/.aws/credentials or AWS_SHARED_CREDENTIALS_FILE).
+func LoadProfile(name string) (map[string] string,error) {
+	profile := make(map[string] string)
+	found := false
+	config,config_err := readINI(sharedFile(CONFIG_FILE_ENV,"config"),"profile ")
+	if config_err == nil {
+		if s,ok := config[name]; ok {
+			found = true
+			for k,v := range s {
+				profile[k] = v
+			}
+		}
+	}
+	creds,creds_err := readINI(sharedFile(SHARED_CREDENTIALS_FILE_ENV,"credentials"),"")
+	if creds_err == nil {
+		if s,ok := creds[name]; ok {
+			found = true
+			for k,v := range s {
+				profile[k] = v
+			}
+		}
+	}
+	if !found {
+		e := fmt.Sprintf("conf_iam.LoadProfile: no profile %s",name)
+		return nil,errors.New(e)
+	}
+	return profile,nil
+}
+
+// ProfileRegion returns the region of the named profile, or "".
+func ProfileRegion(name string) string {
+	profile,err := LoadProfile(name)
+	if err != nil {
+		return ""
+	}
+	return profile["region"]
+}
+
+// ProfileCredentials returns the credentials of the named profile: its keys, those of
+// its SSO role (see SSOCredentials) or credential_process, or if it has a role_arn, those of the role assumed
+// with the credentials of its source_profile or credential_source.
+func ProfileCredentials(name string) (*Credentials,error) {
+	return profileCredentials(name,0)
+}
+
+func profileCredentials(name string,depth int) (*Credentials,error) {
+	if depth > MAX_SOURCE_PROFILES {
+		e := fmt.Sprintf("conf_iam.ProfileCredentials: source_profile chain too long at %s",name)
+		return nil,errors.New(e)
+	}
+	profile,err := LoadProfile(name)
+	if err != nil {
+		return nil,err
+	}
+	role_arn := profile["role_arn"]
+	if role_arn == "" && profile["sso_account_id"] != "" {
+		return SSOCredentials(profile)
+	}
+	if role_arn == "" && profile["credential_process"] != "" {
+		return ProcessCredentials(profile["credential_process"])
+	}
+	if role_arn == "" || (depth != 0 && profile["aws_access_key_id"] != "") {
+		// a source profile with keys of its own uses them, as the CLI does
+		if profile["aws_access_key_id"] == "" {
+			e := fmt.Sprintf("conf_iam.ProfileCredentials: profile %s has no keys",name)
+			return nil,errors.New(e)
+		}
+		return &Credentials{AccessKeyId:profile["aws_access_key_id"],
+			SecretAccessKey:profile["aws_secret_access_key"],
+			SessionToken:profile["aws_session_token"]},nil
+	}
+	var base *Credentials
+	switch {
+	case profile["source_profile"] != "":
+		base,err = profileCredentials(profile["source_profile"],depth + 1)
+	case profile["credential_source"] == "Ec2InstanceMetadata":
+		base,err = InstanceCredentials()
+	case profile["credential_source"] == "EcsContainer":
+		base,err = ContainerCredentials()
+	case profile["credential_source"] == "Environment":
+		base = &Credentials{AccessKeyId:os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey:os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:os.Getenv("AWS_SESSION_TOKEN")}
+	default:
+		e := fmt.Sprintf("conf_iam.ProfileCredentials: profile %s has role_arn but no source",name)
+		return nil,errors.New(e)
+	}
+	if err != nil {
+		return nil,err
+	}
+	params := url.Values{}
+	params.Set("RoleArn",role_arn)
+	session_name := profile["role_session_name"]
+	if session_name == "" {
+		session_name = fmt.Sprintf("godynamo-%d",time.Now().Unix())
+	}
+	params.Set("RoleSessionName",session_name)
+	if d,d_err := strconv.Atoi(profile["duration_seconds"]); d_err == nil && d > 0 {
+		params.Set("DurationSeconds",strconv.Itoa(d))
+	}
+	if x := profile["external_id"]; x != "" {
+		params.Set("ExternalId",x)
+	}
+	if mfa_err := mfaParams(params,profile["mfa_serial"]); mfa_err != nil {
+		return nil,mfa_err
+	}
+	return assumeRole(params,base)
+}
+
+// ProfileProvider reads the credentials of the profile named by AWS_PROFILE.
+var ProfileProvider = ProviderFunc(func() (*Credentials,error) {
+	return ProfileCredentials(ProfileName())
+})
+
+// GoProfile is GoProvider for ProfileProvider.
+func GoProfile(ready_chan chan bool) {
+	GoProvider(ProfileProvider,ready_chan)
+}
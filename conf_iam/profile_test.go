@@ -0,0 +1,188 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"strings"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const testConfig = `# comment
+[default]
+region = us-west-2
+
+[profile dev]
+role_arn = arn:aws:iam::123456789012:role/dev
+source_profile = mid
+role_session_name = dev-session
+duration_seconds = 1200
+external_id = ext
+
+[profile mid]
+role_arn = arn:aws:iam::123456789012:role/mid
+source_profile = base
+s3 =
+    max_concurrent_requests = 20
+
+[profile keyed]
+role_arn = arn:aws:iam::123456789012:role/keyed
+source_profile = base
+
+[profile viakeyed]
+role_arn = arn:aws:iam::123456789012:role/viakeyed
+source_profile = keyed
+
+[profile loop1]
+role_arn = arn:aws:iam::123456789012:role/loop1
+source_profile = loop2
+
+[profile loop2]
+role_arn = arn:aws:iam::123456789012:role/loop2
+source_profile = loop1
+
+[profile sourceless]
+role_arn = arn:aws:iam::123456789012:role/sourceless
+`
+
+const testCredentials = `[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default-secret
+
+; the keys of base
+[base]
+aws_access_key_id = AKIDBASE
+aws_secret_access_key = base-secret
+aws_session_token = base-token
+
+[keyed]
+aws_access_key_id = AKIDKEYED
+aws_secret_access_key = keyed-secret
+`
+
+// useProfiles points the shared config and credentials files at config and credentials.
+func useProfiles(t *testing.T,config,credentials string) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir,"config"),[]byte(config),0600)
+	ioutil.WriteFile(filepath.Join(dir,"credentials"),[]byte(credentials),0600)
+	t.Setenv(CONFIG_FILE_ENV,filepath.Join(dir,"config"))
+	t.Setenv(SHARED_CREDENTIALS_FILE_ENV,filepath.Join(dir,"credentials"))
+}
+
+func TestLoadProfile(t *testing.T) {
+	useProfiles(t,testConfig,testCredentials)
+	p,err := LoadProfile("mid")
+	if err != nil {
+		t.Fatalf("LoadProfile: %s\n",err.Error())
+	}
+	if p["role_arn"] != "arn:aws:iam::123456789012:role/mid" || p["source_profile"] != "base" {
+		t.Errorf("profile mid %v\n",p)
+	}
+	if _,nested := p["max_concurrent_requests"]; nested {
+		t.Errorf("nested setting read as a profile setting\n")
+	}
+	// the credentials file adds to the config file
+	p,err = LoadProfile(DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("LoadProfile: %s\n",err.Error())
+	}
+	if p["region"] != "us-west-2" || p["aws_access_key_id"] != "AKIDDEFAULT" {
+		t.Errorf("profile default %v\n",p)
+	}
+	if _,err := LoadProfile("none"); err == nil {
+		t.Errorf("loaded a missing profile\n")
+	}
+	if r := ProfileRegion(DEFAULT_PROFILE); r != "us-west-2" {
+		t.Errorf("region %q\n",r)
+	}
+	t.Setenv(PROFILE_ENV,"")
+	if n := ProfileName(); n != DEFAULT_PROFILE {
+		t.Errorf("profile name %q\n",n)
+	}
+	t.Setenv(PROFILE_ENV,"dev")
+	if n := ProfileName(); n != "dev" {
+		t.Errorf("profile name %q\n",n)
+	}
+}
+
+func TestProfileCredentials(t *testing.T) {
+	useProfiles(t,testConfig,testCredentials)
+	c,err := ProfileCredentials(DEFAULT_PROFILE)
+	if err != nil {
+		t.Fatalf("ProfileCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "AKIDDEFAULT" || c.SecretAccessKey != "default-secret" {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	for _,name := range []string{"loop1","sourceless","none"} {
+		if _,err := ProfileCredentials(name); err == nil {
+			t.Errorf("credentials for profile %s\n",name)
+		}
+	}
+}
+
+// A role_arn is assumed with the credentials of its source_profile, in turn.
+func TestProfileSourceChain(t *testing.T) {
+	useProfiles(t,testConfig,testCredentials)
+	// the key each role is assumed with
+	signers := make(map[string] string)
+	defer stsServer(assumeRoleHandler(t,func(r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		key := strings.TrimPrefix(auth,"AWS4-HMAC-SHA256 Credential=")
+		signers[r.Form.Get("RoleArn")] = key[:strings.Index(key,"/")]
+		if r.Form.Get("RoleArn") == "arn:aws:iam::123456789012:role/dev" {
+			if r.Form.Get("RoleSessionName") != "dev-session" ||
+				r.Form.Get("DurationSeconds") != "1200" || r.Form.Get("ExternalId") != "ext" {
+				t.Errorf("dev request %v\n",r.Form)
+			}
+		}
+	}))()
+	c,err := ProfileCredentials("dev")
+	if err != nil {
+		t.Fatalf("ProfileCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIADEV" {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	want := map[string] string{
+		"arn:aws:iam::123456789012:role/mid":"AKIDBASE",
+		"arn:aws:iam::123456789012:role/dev":"ASIAMID",
+	}
+	for role,key := range want {
+		if signers[role] != key {
+			t.Errorf("%s assumed with %q, want %q\n",role,signers[role],key)
+		}
+	}
+	// a source profile with keys of its own uses them rather than assuming its role
+	signers = make(map[string] string)
+	if _,err := ProfileCredentials("viakeyed"); err != nil {
+		t.Fatalf("ProfileCredentials: %s\n",err.Error())
+	}
+	if len(signers) != 1 || signers["arn:aws:iam::123456789012:role/viakeyed"] != "AKIDKEYED" {
+		t.Errorf("viakeyed assumed with %v\n",signers)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"math/rand"
+	"strings"
+	"log/syslog"
+	"github.com/bradclawsie/slog"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// credentials are refreshed this long before they expire, unless configured
+	// with Refresh_before
+	REFRESH_BEFORE = 5 * time.Minute
+	// up to this fraction of the lead time is added at random to each refresh
+	REFRESH_JITTER = 0.2
+	// wait before retrying a failed refresh, and between checks of credentials that
+	// give no expiration
+	REFRESH_RETRY = 30 * time.Second
+)
+
+// ErrCredentialsExpired is passed to the RefreshCallback when the credentials in use
+// expire without having been renewed.
+var ErrCredentialsExpired = errors.New("conf_iam: temporary credentials expired without renewal")
+
+// RefreshCallback is called by GoProvider after each attempt to retrieve credentials:
+// with the new credentials and a nil err on success, and with the credentials still in
+// use (nil if there are none) and the error on failure.
+type RefreshCallback func(c *Credentials,err error)
+
+var refreshCallback struct {
+	lock sync.RWMutex
+	f RefreshCallback
+}
+
+// SetRefreshCallback sets the callback GoProvider reports refreshes to. A nil f removes it.
+func SetRefreshCallback(f RefreshCallback) {
+	refreshCallback.lock.Lock()
+	refreshCallback.f = f
+	refreshCallback.lock.Unlock()
+}
+
+func notifyRefresh(c *Credentials,err error) {
+	refreshCallback.lock.RLock()
+	f := refreshCallback.f
+	refreshCallback.lock.RUnlock()
+	if f != nil {
+		f(c,err)
+	}
+}
+
+// refreshBefore is the configured lead time for refreshing credentials.
+func refreshBefore() time.Duration {
+	conf.Vals.ConfLock.RLock()
+	lead := conf.Vals.IAM.RefreshBefore
+	conf.Vals.ConfLock.RUnlock()
+	if lead <= 0 {
+		return REFRESH_BEFORE
+	}
+	return lead
+}
+
+// refreshWait is how long to wait before refreshing credentials that expire at exp:
+// the lead time plus jitter before exp, and at least REFRESH_RETRY.
+func refreshWait(exp time.Time) time.Duration {
+	lead := refreshBefore()
+	lead += time.Duration(rand.Int63n(int64(float64(lead) * REFRESH_JITTER) + 1))
+	wait := time.Until(exp) - lead
+	if wait < REFRESH_RETRY {
+		return REFRESH_RETRY
+	}
+	return wait
+}
+
+// Credentials are keys for signing requests. Temporary keys have a SessionToken and
+// an Expiration; long-term keys have neither. SessionArn is set for the credentials of
+// an assumed role.
+type Credentials struct {
+	AccessKeyId string
+	SecretAccessKey string
+	SessionToken string
+	Expiration time.Time
+	SessionArn string
+}
+
+// CredentialProvider is a source of credentials. Retrieve fetches them; IsExpired
+// reports whether those last retrieved need to be fetched again. Implementations
+// outside this package (a secrets store, for example) can be used by GoProvider
+// directly or named in a chain with RegisterProvider.
+type CredentialProvider interface {
+	Retrieve() (*Credentials,error)
+	IsExpired() bool
+}
+
+type funcProvider struct {
+	fetch func() (*Credentials,error)
+	lock sync.Mutex
+	expiration time.Time
+	retrieved bool
+}
+
+// ProviderFunc returns a CredentialProvider that retrieves credentials with fetch,
+// and considers them expired the refresh lead time (REFRESH_BEFORE unless configured)
+// before their Expiration.
+func ProviderFunc(fetch func() (*Credentials,error)) CredentialProvider {
+	return &funcProvider{fetch:fetch}
+}
+
+func (f *funcProvider) Retrieve() (*Credentials,error) {
+	c,err := f.fetch()
+	if err != nil {
+		return nil,err
+	}
+	f.lock.Lock()
+	f.retrieved = true
+	f.expiration = c.Expiration
+	f.lock.Unlock()
+	return c,nil
+}
+
+func (f *funcProvider) IsExpired() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.retrieved {
+		return true
+	}
+	return !f.expiration.IsZero() && time.Now().After(f.expiration.Add(-refreshBefore()))
+}
+
+// EnvProvider reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+var EnvProvider = ProviderFunc(func() (*Credentials,error) {
+	c := &Credentials{AccessKeyId:os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey:os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:os.Getenv("AWS_SESSION_TOKEN")}
+	if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+		return nil,errors.New("conf_iam.EnvProvider: no keys in the environment")
+	}
+	return c,nil
+})
+
+// ConfProvider reads the access/secret pair of the conf file.
+var ConfProvider = ProviderFunc(func() (*Credentials,error) {
+	conf.Vals.ConfLock.RLock()
+	defer conf.Vals.ConfLock.RUnlock()
+	if conf.Vals.Auth.AccessKey == "" || conf.Vals.Auth.Secret == "" {
+		return nil,errors.New("conf_iam.ConfProvider: no keys in the conf file")
+	}
+	return &Credentials{AccessKeyId:conf.Vals.Auth.AccessKey,SecretAccessKey:conf.Vals.Auth.Secret,
+		SessionToken:conf.Vals.Auth.Token},nil
+})
+
+// Chain is a CredentialProvider that retrieves from the first of its providers to
+// succeed, which it then keeps using until its credentials expire.
+type Chain struct {
+	Providers []CredentialProvider
+	lock sync.Mutex
+	current CredentialProvider
+}
+
+// NewChain returns a Chain of providers, tried in order.
+func NewChain(providers ...CredentialProvider) *Chain {
+	return &Chain{Providers:providers}
+}
+
+func (c *Chain) Retrieve() (*Credentials,error) {
+	errs := make([]string,0,len(c.Providers))
+	for _,p := range c.Providers {
+		creds,err := p.Retrieve()
+		if err == nil {
+			c.lock.Lock()
+			c.current = p
+			c.lock.Unlock()
+			return creds,nil
+		}
+		errs = append(errs,err.Error())
+	}
+	e := fmt.Sprintf("conf_iam.Chain: no provider has credentials: %s",strings.Join(errs,"; "))
+	return nil,errors.New(e)
+}
+
+func (c *Chain) IsExpired() bool {
+	c.lock.Lock()
+	current := c.current
+	c.lock.Unlock()
+	return current == nil || current.IsExpired()
+}
+
+var providers struct {
+	lock sync.RWMutex
+	m map[string] CredentialProvider
+}
+
+// DEFAULT_CHAIN names the providers of the chain used when conf.Vals.IAM.Chain is empty.
+var DEFAULT_CHAIN = []string{"env","conf","profile","web_identity","container","instance"}
+
+func init() {
+	providers.m = map[string] CredentialProvider{
+		"env":EnvProvider,
+		"conf":ConfProvider,
+		"profile":ProfileProvider,
+		"sts":AssumeRoleProvider,
+		"web_identity":WebIdentityProvider,
+		"container":ContainerProvider,
+		"instance":InstanceProvider,
+		"roles_anywhere":RolesAnywhereProvider,
+		"keyring":KeyringProvider,
+	}
+}
+
+// RegisterProvider names p, for use in the credential_chain of the conf file.
+func RegisterProvider(name string,p CredentialProvider) {
+	providers.lock.Lock()
+	defer providers.lock.Unlock()
+	providers.m[name] = p
+}
+
+// ConfiguredChain returns the Chain of the providers named in conf.Vals.IAM.Chain,
+// or of DEFAULT_CHAIN.
+func ConfiguredChain() (*Chain,error) {
+	conf.Vals.ConfLock.RLock()
+	names := conf.Vals.IAM.Chain
+	conf.Vals.ConfLock.RUnlock()
+	if len(names) == 0 {
+		names = DEFAULT_CHAIN
+	}
+	providers.lock.RLock()
+	defer providers.lock.RUnlock()
+	c := NewChain()
+	for _,name := range names {
+		p,ok := providers.m[name]
+		if !ok {
+			e := fmt.Sprintf("conf_iam.ConfiguredChain: no provider %s",name)
+			return nil,errors.New(e)
+		}
+		c.Providers = append(c.Providers,p)
+	}
+	return c,nil
+}
+
+// AssignTemporary locks the global state and copies over temporary credentials,
+// which auth_v4 then signs with.
+// Credentials with no SessionToken are long-term keys, and replace the Auth pair.
+func AssignTemporary(c *Credentials) {
+	AssignTemporaryTo(&conf.Vals,c)
+}
+
+// AssignTemporaryTo is AssignTemporary for the configuration vals, such as one
+// registered with conf.Register.
+func AssignTemporaryTo(vals *conf.AWS_Conf,c *Credentials) {
+	vals.ConfLock.Lock()
+	if c.SessionToken == "" {
+		vals.Auth.AccessKey = c.AccessKeyId
+		vals.Auth.Secret    = c.SecretAccessKey
+		vals.Auth.Token     = ""
+		vals.UseIAM = false
+	} else {
+		vals.IAM.Credentials.AccessKey = c.AccessKeyId
+		vals.IAM.Credentials.Secret    = c.SecretAccessKey
+		vals.IAM.Credentials.Token     = c.SessionToken
+		vals.IAM.Credentials.SessionArn = c.SessionArn
+		vals.UseIAM = true
+	}
+	vals.ConfLock.Unlock()
+	e := fmt.Sprintf("temporary credentials assigned at %v, expiring %v",time.Now(),c.Expiration)
+	slog.SLog(syslog.LOG_NOTICE,e,true)
+}
+
+// GoProvider retrieves credentials from p, assigns them, and then retrieves and
+// assigns them again the refresh lead time (with jitter) before they expire, until
+// StopWatch is called. Each retrieval is reported to the RefreshCallback, if set.
+// ready_chan receives true once credentials are assigned, or false if the first
+// retrieval fails, in which case conf.Vals.UseIAM is cleared so the access/secret pair
+// is used. Once StopWatch has been called, ready_chan receives false at once.
+func GoProvider(p CredentialProvider,ready_chan chan bool) {
+	GoProviderTo(&conf.Vals,p,ready_chan)
+}
+
+// GoProviderTo is GoProvider assigning the credentials to the configuration vals, so
+// that each named configuration (see conf.Register) can have credentials of its own.
+func GoProviderTo(vals *conf.AWS_Conf,p CredentialProvider,ready_chan chan bool) {
+	if !startWatching() {
+		ready_chan <- false
+		return
+	}
+	defer watching.running.Done()
+	c,err := p.Retrieve()
+	notifyRefresh(c,err)
+	if err != nil {
+		slog.SLog(syslog.LOG_ERR,err.Error(),true)
+		vals.ConfLock.Lock()
+		vals.UseIAM = false
+		vals.ConfLock.Unlock()
+		ready_chan <- false
+		return
+	}
+	AssignTemporaryTo(vals,c)
+	ready_chan <- true
+	failed,expired := false,false
+	for {
+		// without an expiration, poll IsExpired
+		wait,scheduled := REFRESH_RETRY,false
+		if !c.Expiration.IsZero() && !failed {
+			wait,scheduled = refreshWait(c.Expiration),true
+		}
+		select {
+		case <- time.After(wait):
+		case <- stopWatch:
+			return
+		}
+		if !awaitWatch() {
+			return
+		}
+		// a scheduled refresh may come, by its jitter, before p considers c expired
+		if !scheduled && !p.IsExpired() {
+			continue
+		}
+		next,err := p.Retrieve()
+		if err != nil {
+			// keep using the current credentials until they expire
+			slog.SLog(syslog.LOG_ERR,err.Error(),true)
+			notifyRefresh(c,err)
+			if !expired && !c.Expiration.IsZero() && time.Now().After(c.Expiration) {
+				expired = true
+				slog.SLog(syslog.LOG_ERR,ErrCredentialsExpired.Error(),true)
+				notifyRefresh(c,ErrCredentialsExpired)
+			}
+			failed = true
+			continue
+		}
+		c,failed,expired = next,false,false
+		AssignTemporaryTo(vals,c)
+		notifyRefresh(c,nil)
+	}
+}
+
+// GoRefresh is GoProvider for ProviderFunc(fetch).
+func GoRefresh(fetch func() (*Credentials,error),ready_chan chan bool) {
+	GoProvider(ProviderFunc(fetch),ready_chan)
+}
+
+// GoChain is GoProvider for ConfiguredChain.
+func GoChain(ready_chan chan bool) {
+	c,err := ConfiguredChain()
+	if err != nil {
+		slog.SLog(syslog.LOG_ERR,err.Error(),true)
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.UseIAM = false
+		conf.Vals.ConfLock.Unlock()
+		ready_chan <- false
+		return
+	}
+	GoProvider(c,ready_chan)
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+// fakeProvider returns c, or err, counting its retrievals.
+type fakeProvider struct {
+	c *Credentials
+	err error
+	expired bool
+	calls int
+}
+
+func (f *fakeProvider) Retrieve() (*Credentials,error) {
+	f.calls++
+	if f.err != nil {
+		return nil,f.err
+	}
+	return f.c,nil
+}
+
+func (f *fakeProvider) IsExpired() bool {
+	return f.expired
+}
+
+func TestChain(t *testing.T) {
+	none := &fakeProvider{err:errors.New("none here")}
+	first := &fakeProvider{c:&Credentials{AccessKeyId:"FIRST"}}
+	second := &fakeProvider{c:&Credentials{AccessKeyId:"SECOND"}}
+	c := NewChain(none,first,second)
+	if !c.IsExpired() {
+		t.Errorf("chain expired before retrieving\n")
+	}
+	creds,err := c.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	// providers are tried in order, and those after the first to succeed are not
+	if creds.AccessKeyId != "FIRST" || none.calls != 1 || first.calls != 1 || second.calls != 0 {
+		t.Errorf("retrieved %s, calls %d %d %d\n",creds.AccessKeyId,none.calls,first.calls,second.calls)
+	}
+	// the chain expires with the provider it retrieved from
+	if c.IsExpired() {
+		t.Errorf("chain expired with its provider current\n")
+	}
+	first.expired = true
+	if !c.IsExpired() {
+		t.Errorf("chain not expired with its provider\n")
+	}
+	// falling through to the next provider when the current one fails
+	first.err = errors.New("first gone")
+	creds,err = c.Retrieve()
+	if err != nil || creds.AccessKeyId != "SECOND" {
+		t.Errorf("retrieved %v, %v; want SECOND\n",creds,err)
+	}
+	second.err = errors.New("second gone")
+	_,err = c.Retrieve()
+	if err == nil {
+		t.Fatalf("retrieved with every provider failing\n")
+	}
+	for _,s := range []string{"none here","first gone","second gone"} {
+		if !strings.Contains(err.Error(),s) {
+			t.Errorf("error %q does not mention %q\n",err.Error(),s)
+		}
+	}
+}
+
+func useChain(t *testing.T,names []string) {
+	conf.Vals.ConfLock.Lock()
+	old := conf.Vals.IAM.Chain
+	conf.Vals.IAM.Chain = names
+	conf.Vals.ConfLock.Unlock()
+	t.Cleanup(func() {
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.IAM.Chain = old
+		conf.Vals.ConfLock.Unlock()
+	})
+}
+
+func TestConfiguredChain(t *testing.T) {
+	useChain(t,nil)
+	c,err := ConfiguredChain()
+	if err != nil {
+		t.Fatalf("ConfiguredChain: %s\n",err.Error())
+	}
+	if len(c.Providers) != len(DEFAULT_CHAIN) || c.Providers[0] != EnvProvider ||
+		c.Providers[len(c.Providers)-1] != InstanceProvider {
+		t.Errorf("default chain of %d providers\n",len(c.Providers))
+	}
+	useChain(t,[]string{"env","nonesuch"})
+	if _,err := ConfiguredChain(); err == nil {
+		t.Errorf("chain with an unknown provider\n")
+	}
+	// env has no keys, so the conf pair is used
+	custom := &fakeProvider{c:&Credentials{AccessKeyId:"CUSTOM"}}
+	RegisterProvider("custom",custom)
+	useChain(t,[]string{"env","conf","custom"})
+	t.Setenv("AWS_ACCESS_KEY_ID","")
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Auth.AccessKey = "AKIDCONF"
+	conf.Vals.Auth.Secret = "conf-secret"
+	conf.Vals.Auth.Token = ""
+	conf.Vals.ConfLock.Unlock()
+	c,err = ConfiguredChain()
+	if err != nil {
+		t.Fatalf("ConfiguredChain: %s\n",err.Error())
+	}
+	creds,err := c.Retrieve()
+	if err != nil || creds.AccessKeyId != "AKIDCONF" || custom.calls != 0 {
+		t.Errorf("retrieved %v, %v, custom called %d times\n",creds,err,custom.calls)
+	}
+	// and the env keys, once set
+	t.Setenv("AWS_ACCESS_KEY_ID","AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY","env-secret")
+	creds,err = c.Retrieve()
+	if err != nil || creds.AccessKeyId != "AKIDENV" {
+		t.Errorf("retrieved %v, %v; want AKIDENV\n",creds,err)
+	}
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Auth.AccessKey = ""
+	conf.Vals.ConfLock.Unlock()
+	t.Setenv("AWS_ACCESS_KEY_ID","")
+	creds,err = c.Retrieve()
+	if err != nil || creds.AccessKeyId != "CUSTOM" {
+		t.Errorf("retrieved %v, %v; want CUSTOM\n",creds,err)
+	}
+}
+
+func TestAssignTemporaryTo(t *testing.T) {
+	var vals conf.AWS_Conf
+	AssignTemporaryTo(&vals,&Credentials{AccessKeyId:"ASIA",SecretAccessKey:"s",SessionToken:"t",
+		Expiration:time.Now().Add(time.Hour),SessionArn:"arn"})
+	if !vals.UseIAM || vals.IAM.Credentials.AccessKey != "ASIA" || vals.IAM.Credentials.Token != "t" ||
+		vals.IAM.Credentials.SessionArn != "arn" {
+		t.Errorf("temporary credentials assigned as %+v\n",vals.IAM.Credentials)
+	}
+	// long-term keys replace the access/secret pair
+	AssignTemporaryTo(&vals,&Credentials{AccessKeyId:"AKID",SecretAccessKey:"s"})
+	if vals.UseIAM || vals.Auth.AccessKey != "AKID" || vals.Auth.Secret != "s" {
+		t.Errorf("long-term keys assigned as %+v, UseIAM %v\n",vals.Auth,vals.UseIAM)
+	}
+}
+
+func useRefreshBefore(t *testing.T,lead time.Duration) {
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.IAM.RefreshBefore = lead
+	conf.Vals.ConfLock.Unlock()
+	t.Cleanup(func() {
+		conf.Vals.ConfLock.Lock()
+		conf.Vals.IAM.RefreshBefore = 0
+		conf.Vals.ConfLock.Unlock()
+	})
+}
+
+func TestRefreshWait(t *testing.T) {
+	useRefreshBefore(t,0)
+	if refreshBefore() != REFRESH_BEFORE {
+		t.Errorf("default lead %v\n",refreshBefore())
+	}
+	exp := time.Now().Add(time.Hour)
+	for i := 0; i < 100; i++ {
+		// the lead time, plus up to REFRESH_JITTER of it, before exp
+		wait := refreshWait(exp)
+		lo := time.Hour - REFRESH_BEFORE - time.Duration(float64(REFRESH_BEFORE) * REFRESH_JITTER) - time.Second
+		if wait < lo || wait > time.Hour - REFRESH_BEFORE {
+			t.Fatalf("wait %v outside [%v,%v]\n",wait,lo,time.Hour - REFRESH_BEFORE)
+		}
+	}
+	if wait := refreshWait(time.Now().Add(time.Minute)); wait != REFRESH_RETRY {
+		t.Errorf("wait %v for credentials about to expire, want %v\n",wait,REFRESH_RETRY)
+	}
+	useRefreshBefore(t,20 * time.Minute)
+	if wait := refreshWait(exp); wait > 40 * time.Minute {
+		t.Errorf("wait %v with a 20 minute lead\n",wait)
+	}
+	// the configured lead also decides when the credentials of a provider are expired
+	p := ProviderFunc(func() (*Credentials,error) {
+		return &Credentials{AccessKeyId:"A",Expiration:time.Now().Add(10 * time.Minute)},nil
+	})
+	p.Retrieve()
+	if !p.IsExpired() {
+		t.Errorf("credentials within the configured lead not expired\n")
+	}
+	useRefreshBefore(t,time.Minute)
+	if p.IsExpired() {
+		t.Errorf("credentials outside the configured lead expired\n")
+	}
+}
+
+func TestRefreshCallback(t *testing.T) {
+	type refresh struct {
+		c *Credentials
+		err error
+	}
+	got := make(chan refresh,4)
+	SetRefreshCallback(func(c *Credentials,err error) { got <- refresh{c,err} })
+	defer SetRefreshCallback(nil)
+	var vals conf.AWS_Conf
+	vals.UseIAM = true
+	ready := make(chan bool,1)
+	GoProviderTo(&vals,&fakeProvider{err:errors.New("unavailable")},ready)
+	if <- ready {
+		t.Errorf("ready after a failed retrieval\n")
+	}
+	if r := <- got; r.c != nil || r.err == nil {
+		t.Errorf("failed retrieval reported as %+v\n",r)
+	}
+	if vals.UseIAM {
+		t.Errorf("UseIAM left set after a failed retrieval\n")
+	}
+	// the provider goroutine then waits out the hour to its refresh
+	creds := &Credentials{AccessKeyId:"ASIA",SecretAccessKey:"s",SessionToken:"t",
+		Expiration:time.Now().Add(time.Hour)}
+	go GoProviderTo(&vals,&fakeProvider{c:creds},ready)
+	if !<- ready {
+		t.Fatalf("not ready after retrieving\n")
+	}
+	if r := <- got; r.c != creds || r.err != nil {
+		t.Errorf("retrieval reported as %+v\n",r)
+	}
+	vals.ConfLock.RLock()
+	defer vals.ConfLock.RUnlock()
+	if !vals.UseIAM || vals.IAM.Credentials.AccessKey != "ASIA" {
+		t.Errorf("credentials not assigned\n")
+	}
+}
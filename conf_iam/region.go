@@ -0,0 +1,96 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REGION_ENV         = "AWS_REGION"
+	DEFAULT_REGION_ENV = "AWS_DEFAULT_REGION"
+	IMDS_REGION_PATH   = "/latest/meta-data/placement/region"
+)
+
+// the region of the instance, which does not change, once read
+var instanceRegion struct {
+	lock sync.Mutex
+	region string
+}
+
+// InstanceRegion returns the region of the instance from its placement metadata.
+func InstanceRegion() (string,error) {
+	if IMDSDisabled() {
+		return "",ErrIMDSDisabled
+	}
+	return instanceRegionWith(valsIMDS())
+}
+
+func instanceRegionWith(s imdsSettings) (string,error) {
+	instanceRegion.lock.Lock()
+	defer instanceRegion.lock.Unlock()
+	if instanceRegion.region != "" {
+		return instanceRegion.region,nil
+	}
+	body,body_err := imdsGetWith(IMDS_REGION_PATH,s)
+	if body_err != nil {
+		e := fmt.Sprintf("conf_iam.InstanceRegion: %s",body_err.Error())
+		return "",errors.New(e)
+	}
+	region := strings.TrimSpace(string(body))
+	if region == "" {
+		return "",errors.New("conf_iam.InstanceRegion: no region in the placement metadata")
+	}
+	instanceRegion.region = region
+	return region,nil
+}
+
+// DiscoverRegion returns the region to use when none is configured: that of the
+// AWS_REGION or AWS_DEFAULT_REGION environment variables, of the profile (see
+// ProfileName) in the shared config file, or of the placement of the instance, in that
+// order, or "" if there is none. The instance metadata service is asked only if imds is
+// set and IMDS_DISABLED_ENV does not disable it, with timeout per request (0 for
+// conf.IMDS_TIMEOUT). Unlike InstanceRegion, it does not read conf.Vals, so it can be
+// called while loading it.
+func DiscoverRegion(imds bool,timeout time.Duration) string {
+	for _,env := range []string{REGION_ENV,DEFAULT_REGION_ENV} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	if region := ProfileRegion(ProfileName()); region != "" {
+		return region
+	}
+	if !imds || strings.EqualFold(os.Getenv(IMDS_DISABLED_ENV),"true") {
+		return ""
+	}
+	region,_ := instanceRegionWith(imdsSettings{timeout:timeout})
+	return region
+}
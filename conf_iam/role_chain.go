@@ -0,0 +1,142 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Role chaining: assuming role A with the base credentials, then role B with A's
+// credentials, and so on. STS limits the sessions of chained roles to one hour, so each
+// hop keeps its own credentials and is only assumed again when they near expiration.
+package conf_iam
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"strings"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// the longest session STS grants a role assumed with role credentials
+	CHAINED_ROLE_MAX_DURATION = time.Hour
+)
+
+// RoleChain is a CredentialProvider that assumes each of Arns in turn, starting from the
+// conf.Vals.Auth pair. The MFASerial of Options applies to the first hop, made with the
+// base credentials; the ExternalId, session policies and session tags apply to the last,
+// the role the credentials are for.
+type RoleChain struct {
+	Arns []string
+	SessionName string
+	Duration time.Duration
+	Options RoleOptions
+	lock sync.Mutex
+	// credentials of each hop, as last assumed
+	hops []*Credentials
+}
+
+// NewRoleChain returns a RoleChain for arns. An empty session_name is defaulted, so the
+// hops share one.
+func NewRoleChain(arns []string,session_name string,duration time.Duration,o RoleOptions) *RoleChain {
+	if session_name == "" {
+		session_name = fmt.Sprintf("godynamo-%d",time.Now().Unix())
+	}
+	return &RoleChain{Arns:arns,SessionName:session_name,Duration:duration,Options:o}
+}
+
+// Retrieve assumes the last role of the chain, first assuming again any earlier hop whose
+// credentials are missing or within the refresh lead time of expiring.
+func (r *RoleChain) Retrieve() (*Credentials,error) {
+	if len(r.Arns) == 0 {
+		return nil,errors.New("conf_iam.RoleChain: no roles")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.hops) != len(r.Arns) {
+		r.hops = make([]*Credentials,len(r.Arns))
+	}
+	conf.Vals.ConfLock.RLock()
+	base := &Credentials{AccessKeyId:conf.Vals.Auth.AccessKey,SecretAccessKey:conf.Vals.Auth.Secret,
+		SessionToken:conf.Vals.Auth.Token}
+	conf.Vals.ConfLock.RUnlock()
+	last := len(r.Arns) - 1
+	lead := refreshBefore()
+	for i,arn := range r.Arns {
+		if h := r.hops[i]; i != last && h != nil && time.Until(h.Expiration) > lead {
+			base = h
+			continue
+		}
+		var o RoleOptions
+		if i == 0 {
+			o.MFASerial = r.Options.MFASerial
+		}
+		if i == last {
+			o.ExternalId = r.Options.ExternalId
+			o.Policy = r.Options.Policy
+			o.PolicyArns = r.Options.PolicyArns
+			o.Tags = r.Options.Tags
+			o.TransitiveTagKeys = r.Options.TransitiveTagKeys
+		}
+		duration := r.Duration
+		if i != 0 && (duration == 0 || duration > CHAINED_ROLE_MAX_DURATION) {
+			duration = CHAINED_ROLE_MAX_DURATION
+		}
+		c,err := assumeRoleWith(arn,r.SessionName,duration,o,base)
+		if err != nil {
+			e := fmt.Sprintf("conf_iam.RoleChain: hop %d (%s): %s",i,arn,err.Error())
+			return nil,errors.New(e)
+		}
+		r.hops[i] = c
+		base = c
+	}
+	return r.hops[last],nil
+}
+
+// IsExpired reports whether the credentials of the last hop need to be assumed again.
+func (r *RoleChain) IsExpired() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.hops) == 0 || r.hops[len(r.hops)-1] == nil {
+		return true
+	}
+	return time.Now().After(r.hops[len(r.hops)-1].Expiration.Add(-refreshBefore()))
+}
+
+// the chain configured with Role_arn and Role_chain, kept so that its hops are reused
+var confChain struct {
+	lock sync.Mutex
+	key string
+	c *RoleChain
+}
+
+// configuredRoleChain returns the RoleChain for arns, reusing the previous one if the
+// configuration has not changed.
+func configuredRoleChain(arns []string,session_name string,duration time.Duration,o RoleOptions) *RoleChain {
+	key := fmt.Sprintf("%s|%s|%v|%v",strings.Join(arns,","),session_name,duration,o)
+	confChain.lock.Lock()
+	defer confChain.lock.Unlock()
+	if confChain.c == nil || confChain.key != key {
+		confChain.c = NewRoleChain(arns,session_name,duration,o)
+		confChain.key = key
+	}
+	return confChain.c
+}
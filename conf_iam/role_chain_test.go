@@ -0,0 +1,114 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"net/http"
+)
+
+func TestRoleChain(t *testing.T) {
+	arns := []string{"arn:aws:iam::111111111111:role/a","arn:aws:iam::222222222222:role/b",
+		"arn:aws:iam::333333333333:role/c"}
+	signers := make(map[string] string)
+	calls := make(map[string] int)
+	fail := ""
+	defer stsServer(func(w http.ResponseWriter,r *http.Request) {
+		r.ParseForm()
+		arn := r.Form.Get("RoleArn")
+		if arn == fail {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>`))
+			return
+		}
+		calls[arn]++
+		key := strings.TrimPrefix(r.Header.Get("Authorization"),"AWS4-HMAC-SHA256 Credential=")
+		signers[arn] = key[:strings.Index(key,"/")]
+		first,last := arn == arns[0],arn == arns[len(arns)-1]
+		if (r.Form.Get("SerialNumber") != "") != first {
+			t.Errorf("%s: SerialNumber %q\n",arn,r.Form.Get("SerialNumber"))
+		}
+		if (r.Form.Get("ExternalId") != "") != last || (r.Form.Get("Tags.member.1.Key") != "") != last {
+			t.Errorf("%s: ExternalId %q, tags %v\n",arn,r.Form.Get("ExternalId"),r.Form)
+		}
+		// chained sessions are limited to an hour
+		want := "3600"
+		if first {
+			want = "7200"
+		}
+		if r.Form.Get("DurationSeconds") != want {
+			t.Errorf("%s: DurationSeconds %q, want %s\n",arn,r.Form.Get("DurationSeconds"),want)
+		}
+		assumeRoleHandler(t,nil)(w,r)
+	})()
+	SetMFATokenProvider(func(string) (string,error) { return "123456",nil })
+	defer SetMFATokenProvider(nil)
+	r := NewRoleChain(arns,"",2 * time.Hour,RoleOptions{MFASerial:"arn:aws:iam::111111111111:mfa/u",
+		ExternalId:"ext",Tags:map[string] string{"k":"v"}})
+	if r.SessionName == "" {
+		t.Errorf("no default session name\n")
+	}
+	if !r.IsExpired() {
+		t.Errorf("chain expired before retrieving\n")
+	}
+	c,err := r.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIAC" || r.IsExpired() {
+		t.Errorf("credentials %+v, expired %v\n",*c,r.IsExpired())
+	}
+	want := map[string] string{arns[0]:"AKIDEXAMPLE",arns[1]:"ASIAA",arns[2]:"ASIAB"}
+	for arn,key := range want {
+		if signers[arn] != key {
+			t.Errorf("%s assumed with %q, want %q\n",arn,signers[arn],key)
+		}
+	}
+	// the earlier hops are reused while their credentials are good
+	if _,err := r.Retrieve(); err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if calls[arns[0]] != 1 || calls[arns[1]] != 1 || calls[arns[2]] != 2 {
+		t.Errorf("assumptions %v\n",calls)
+	}
+	// a hop near expiry is assumed again, along with those after it
+	r.hops[1].Expiration = time.Now().Add(time.Minute)
+	if _,err := r.Retrieve(); err != nil {
+		t.Fatalf("Retrieve: %s\n",err.Error())
+	}
+	if calls[arns[0]] != 1 || calls[arns[1]] != 2 || calls[arns[2]] != 3 {
+		t.Errorf("assumptions %v\n",calls)
+	}
+	fail = arns[1]
+	r.hops[1] = nil
+	if _,err := r.Retrieve(); err == nil || !strings.Contains(err.Error(),"hop 1") {
+		t.Errorf("failed hop error %v\n",err)
+	}
+	if _,err := NewRoleChain(nil,"s",0,RoleOptions{}).Retrieve(); err == nil {
+		t.Errorf("retrieved an empty chain\n")
+	}
+}
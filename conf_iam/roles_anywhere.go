@@ -0,0 +1,325 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/json"
+	"encoding/base64"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/auth_v4"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	ROLES_ANYWHERE_SERVICE = "rolesanywhere"
+	ROLES_ANYWHERE_PATH    = "/sessions"
+	ROLES_ANYWHERE_CTYPE   = "application/json"
+	X_AMZ_X509_HDR         = "X-Amz-X509"
+	X_AMZ_X509_CHAIN_HDR   = "X-Amz-X509-Chain"
+	// the CreateSession default, used when no duration is configured
+	ROLES_ANYWHERE_DURATION = time.Hour
+)
+
+var rolesAnywhereClient = &http.Client{Timeout:30 * time.Second}
+
+// RolesAnywhereOptions are the parameters of a Roles Anywhere CreateSession call.
+type RolesAnywhereOptions struct {
+	// the trust anchor the certificate chains to, the profile, and the role to assume
+	TrustAnchorArn string
+	ProfileArn string
+	RoleArn string
+	SessionName string
+	// PEM files of the end-entity certificate (optionally followed by its intermediates),
+	// of further intermediates, and of the certificate's RSA or EC private key
+	CertificateFile string
+	CertificateChainFile string
+	PrivateKeyFile string
+	// 0 for ROLES_ANYWHERE_DURATION
+	Duration time.Duration
+}
+
+// readCertificates parses the PEM certificates of file.
+func readCertificates(file string) ([]*x509.Certificate,error) {
+	b,read_err := ioutil.ReadFile(file)
+	if read_err != nil {
+		return nil,read_err
+	}
+	certs := make([]*x509.Certificate,0)
+	for {
+		var block *pem.Block
+		block,b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert,parse_err := x509.ParseCertificate(block.Bytes)
+		if parse_err != nil {
+			return nil,parse_err
+		}
+		certs = append(certs,cert)
+	}
+	if len(certs) == 0 {
+		return nil,errors.New("no certificate in " + file)
+	}
+	return certs,nil
+}
+
+// readPrivateKey parses the PKCS#1, SEC 1 or PKCS#8 PEM private key of file, which
+// must be an RSA or EC key.
+func readPrivateKey(file string) (crypto.Signer,error) {
+	b,read_err := ioutil.ReadFile(file)
+	if read_err != nil {
+		return nil,read_err
+	}
+	block,_ := pem.Decode(b)
+	if block == nil {
+		return nil,errors.New("no PEM private key in " + file)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key,parse_err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if parse_err != nil {
+		return nil,parse_err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k,nil
+	case *ecdsa.PrivateKey:
+		return k,nil
+	}
+	return nil,errors.New("not an RSA or EC private key: " + file)
+}
+
+// x509Algorithm returns the signing algorithm for key.
+func x509Algorithm(key crypto.Signer) (string,error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "AWS4-X509-RSA-SHA256",nil
+	case *ecdsa.PrivateKey:
+		return "AWS4-X509-ECDSA-SHA256",nil
+	}
+	return "",errors.New("not an RSA or EC private key")
+}
+
+// x509Sign returns the hex signature of string2sign by key, which is an RSA or EC key.
+func x509Sign(key crypto.Signer,string2sign string) (string,error) {
+	digest := sha256.Sum256([]byte(string2sign))
+	// PKCS#1 v1.5 for RSA keys, and ASN.1 DER for EC keys
+	sig,sign_err := key.Sign(rand.Reader,digest[:],crypto.SHA256)
+	if sign_err != nil {
+		return "",sign_err
+	}
+	return hex.EncodeToString(sig),nil
+}
+
+// rolesAnywhereRegion returns the region of the trust anchor arn, or failing that the
+// DynamoDB zone.
+func rolesAnywhereRegion(trust_anchor_arn string) string {
+	// arn:partition:rolesanywhere:region:account:trust-anchor/id
+	if parts := strings.Split(trust_anchor_arn,":"); len(parts) > 3 && parts[3] != "" {
+		return parts[3]
+	}
+	conf.Vals.ConfLock.RLock()
+	defer conf.Vals.ConfLock.RUnlock()
+	return conf.Vals.Network.DynamoDB.Zone
+}
+
+// RolesAnywhereCredentials calls the IAM Roles Anywhere CreateSession API, signing the
+// request with the X.509 certificate and private key of o in place of AWS keys, and
+// returns the temporary credentials of the role. The files are read on every call, so
+// renewed certificates are picked up.
+func RolesAnywhereCredentials(o RolesAnywhereOptions) (*Credentials,error) {
+	if o.TrustAnchorArn == "" || o.ProfileArn == "" || o.RoleArn == "" {
+		return nil,errors.New("conf_iam.RolesAnywhereCredentials: need trust anchor, profile and role arns")
+	}
+	certs,certs_err := readCertificates(o.CertificateFile)
+	if certs_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",certs_err.Error())
+		return nil,errors.New(e)
+	}
+	if o.CertificateChainFile != "" {
+		chain,chain_err := readCertificates(o.CertificateChainFile)
+		if chain_err != nil {
+			e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",chain_err.Error())
+			return nil,errors.New(e)
+		}
+		certs = append(certs,chain...)
+	}
+	key,key_err := readPrivateKey(o.PrivateKeyFile)
+	if key_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",key_err.Error())
+		return nil,errors.New(e)
+	}
+	duration := o.Duration
+	if duration <= 0 {
+		duration = ROLES_ANYWHERE_DURATION
+	}
+	session := map[string]interface{}{
+		"trustAnchorArn":o.TrustAnchorArn,
+		"profileArn":o.ProfileArn,
+		"roleArn":o.RoleArn,
+		"durationSeconds":int64(duration / time.Second),
+	}
+	if o.SessionName != "" {
+		session["roleSessionName"] = o.SessionName
+	}
+	payload,m_err := json.Marshal(session)
+	if m_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",m_err.Error())
+		return nil,errors.New(e)
+	}
+
+	region := rolesAnywhereRegion(o.TrustAnchorArn)
+	host,host_err := conf.ServiceHost(ROLES_ANYWHERE_SERVICE,region,false,false)
+	if host_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",host_err.Error())
+		return nil,errors.New(e)
+	}
+	request,req_err := http.NewRequest(aws_const.METHOD,"https://" + host + ROLES_ANYWHERE_PATH,
+		bytes.NewReader(payload))
+	if req_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",req_err.Error())
+		return nil,errors.New(e)
+	}
+	now := auth_v4.SigningTime()
+	amz_date := now.UTC().Format(aws_const.ISO8601FMT_CONDENSED)
+	leaf := base64.StdEncoding.EncodeToString(certs[0].Raw)
+	request.Header.Set(aws_const.CONTENT_TYPE_HDR,ROLES_ANYWHERE_CTYPE)
+	request.Header.Set(aws_const.X_AMZ_DATE_HDR,amz_date)
+	request.Header.Set(X_AMZ_X509_HDR,leaf)
+	signed_headers := "content-type;host;x-amz-date;x-amz-x509"
+	canonical_hdrs := "content-type:" + ROLES_ANYWHERE_CTYPE + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amz_date + "\n" +
+		"x-amz-x509:" + leaf + "\n"
+	if len(certs) > 1 {
+		intermediates := make([]string,0,len(certs) - 1)
+		for _,c := range certs[1:] {
+			intermediates = append(intermediates,base64.StdEncoding.EncodeToString(c.Raw))
+		}
+		chain := strings.Join(intermediates,",")
+		request.Header.Set(X_AMZ_X509_CHAIN_HDR,chain)
+		signed_headers += ";x-amz-x509-chain"
+		canonical_hdrs += "x-amz-x509-chain:" + chain + "\n"
+	}
+	h := sha256.Sum256(payload)
+	canonical_request := aws_const.METHOD + "\n" + ROLES_ANYWHERE_PATH + "\n\n" +
+		canonical_hdrs + "\n" + signed_headers + "\n" + hex.EncodeToString(h[:])
+	ch := sha256.Sum256([]byte(canonical_request))
+	scope := now.UTC().Format(aws_const.ISODATEFMT) + "/" + region + "/" +
+		ROLES_ANYWHERE_SERVICE + "/aws4_request"
+	algorithm,alg_err := x509Algorithm(key)
+	if alg_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",alg_err.Error())
+		return nil,errors.New(e)
+	}
+	str2sign := algorithm + "\n" + amz_date + "\n" + scope + "\n" + hex.EncodeToString(ch[:])
+	signature,sign_err := x509Sign(key,str2sign)
+	if sign_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: cannot sign: %s",sign_err.Error())
+		return nil,errors.New(e)
+	}
+	// the credential of an X.509 signature is the serial number of the certificate
+	request.Header.Set("Authorization",algorithm + " Credential=" + certs[0].SerialNumber.String() +
+		"/" + scope + ", SignedHeaders=" + signed_headers + ", Signature=" + signature)
+
+	response,rsp_err := rolesAnywhereClient.Do(request)
+	if rsp_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: %s",rsp_err.Error())
+		return nil,errors.New(e)
+	}
+	defer response.Body.Close()
+	body,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: err reading resp body: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: code %d: %s",response.StatusCode,string(body))
+		return nil,errors.New(e)
+	}
+	var resp struct {
+		CredentialSet []struct {
+			Credentials struct {
+				AccessKeyId string `json:"accessKeyId"`
+				SecretAccessKey string `json:"secretAccessKey"`
+				SessionToken string `json:"sessionToken"`
+				Expiration time.Time `json:"expiration"`
+			} `json:"credentials"`
+		} `json:"credentialSet"`
+	}
+	if um_err := json.Unmarshal(body,&resp); um_err != nil {
+		e := fmt.Sprintf("conf_iam.RolesAnywhereCredentials: cannot unmarshal response: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	if len(resp.CredentialSet) == 0 {
+		return nil,errors.New("conf_iam.RolesAnywhereCredentials: no credentials in response")
+	}
+	rc := resp.CredentialSet[0].Credentials
+	return &Credentials{AccessKeyId:rc.AccessKeyId,SecretAccessKey:rc.SecretAccessKey,
+		SessionToken:rc.SessionToken,Expiration:rc.Expiration},nil
+}
+
+// RolesAnywhereProvider creates Roles Anywhere sessions for the role configured in
+// conf.Vals.IAM.AssumeRole with the certificate of conf.Vals.IAM.RolesAnywhere.
+var RolesAnywhereProvider = ProviderFunc(func() (*Credentials,error) {
+	conf.Vals.ConfLock.RLock()
+	o := RolesAnywhereOptions{TrustAnchorArn:conf.Vals.IAM.RolesAnywhere.TrustAnchorArn,
+		ProfileArn:conf.Vals.IAM.RolesAnywhere.ProfileArn,
+		RoleArn:conf.Vals.IAM.AssumeRole.RoleArn,
+		SessionName:conf.Vals.IAM.AssumeRole.SessionName,
+		CertificateFile:conf.Vals.IAM.RolesAnywhere.CertificateFile,
+		CertificateChainFile:conf.Vals.IAM.RolesAnywhere.CertificateChainFile,
+		PrivateKeyFile:conf.Vals.IAM.RolesAnywhere.PrivateKeyFile,
+		Duration:conf.Vals.IAM.AssumeRole.Duration}
+	conf.Vals.ConfLock.RUnlock()
+	return RolesAnywhereCredentials(o)
+})
+
+// GoRolesAnywhere is GoProvider for RolesAnywhereProvider.
+func GoRolesAnywhere(ready_chan chan bool) {
+	GoProvider(RolesAnywhereProvider,ready_chan)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"net"
+	"time"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"math/big"
+	"crypto/tls"
+	"crypto/rand"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/sha256"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/json"
+	"encoding/base64"
+	"path/filepath"
+	"net/http/httptest"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+// writeCertificate writes a self-signed EC certificate and its key to dir, returning
+// the paths of the files and the certificate.
+func writeCertificate(t *testing.T,dir string) (string,string,*x509.Certificate) {
+	key,_ := ecdsa.GenerateKey(elliptic.P256(),rand.Reader)
+	template := &x509.Certificate{SerialNumber:big.NewInt(4242),NotBefore:time.Now().Add(-time.Hour),
+		NotAfter:time.Now().Add(time.Hour)}
+	der,err := x509.CreateCertificate(rand.Reader,template,template,&key.PublicKey,key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %s\n",err.Error())
+	}
+	cert,_ := x509.ParseCertificate(der)
+	key_der,_ := x509.MarshalPKCS8PrivateKey(key)
+	cert_file,key_file := filepath.Join(dir,"cert.pem"),filepath.Join(dir,"key.pem")
+	ioutil.WriteFile(cert_file,pem.EncodeToMemory(&pem.Block{Type:"CERTIFICATE",Bytes:der}),0600)
+	ioutil.WriteFile(key_file,pem.EncodeToMemory(&pem.Block{Type:"PRIVATE KEY",Bytes:key_der}),0600)
+	return cert_file,key_file,cert
+}
+
+// verifyX509 checks the signature of a Roles Anywhere request with the public key of cert.
+func verifyX509(t *testing.T,r *http.Request,body []byte,cert *x509.Certificate) {
+	auth := r.Header.Get("Authorization")
+	prefix := "AWS4-X509-ECDSA-SHA256 Credential=4242/" + time.Now().UTC().Format(aws_const.ISODATEFMT) +
+		"/us-east-2/rolesanywhere/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-x509, Signature="
+	if !strings.HasPrefix(auth,prefix) {
+		t.Errorf("Authorization %q\n",auth)
+		return
+	}
+	if r.Header.Get(X_AMZ_X509_HDR) != base64.StdEncoding.EncodeToString(cert.Raw) {
+		t.Errorf("%s is not the certificate\n",X_AMZ_X509_HDR)
+	}
+	amz_date := r.Header.Get(aws_const.X_AMZ_DATE_HDR)
+	h := sha256.Sum256(body)
+	canonical_request := "POST\n" + ROLES_ANYWHERE_PATH + "\n\n" +
+		"content-type:" + r.Header.Get(aws_const.CONTENT_TYPE_HDR) + "\n" +
+		"host:rolesanywhere.us-east-2.amazonaws.com\n" +
+		"x-amz-date:" + amz_date + "\n" +
+		"x-amz-x509:" + r.Header.Get(X_AMZ_X509_HDR) + "\n\n" +
+		"content-type;host;x-amz-date;x-amz-x509\n" + hex.EncodeToString(h[:])
+	ch := sha256.Sum256([]byte(canonical_request))
+	str2sign := "AWS4-X509-ECDSA-SHA256\n" + amz_date + "\n" + time.Now().UTC().Format(aws_const.ISODATEFMT) +
+		"/us-east-2/rolesanywhere/aws4_request\n" + hex.EncodeToString(ch[:])
+	digest := sha256.Sum256([]byte(str2sign))
+	sig,_ := hex.DecodeString(strings.TrimPrefix(auth,prefix))
+	if !ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey),digest[:],sig) {
+		t.Errorf("signature does not verify\n")
+	}
+}
+
+func TestRolesAnywhereCredentials(t *testing.T) {
+	dir := t.TempDir()
+	cert_file,key_file,cert := writeCertificate(t,dir)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		body,_ := ioutil.ReadAll(r.Body)
+		if r.Host != "rolesanywhere.us-east-2.amazonaws.com" || r.URL.Path != ROLES_ANYWHERE_PATH {
+			t.Errorf("request for %s%s\n",r.Host,r.URL.Path)
+		}
+		verifyX509(t,r,body,cert)
+		var session map[string] interface{}
+		json.Unmarshal(body,&session)
+		if session["roleArn"] != "arn:aws:iam::123456789012:role/onprem" ||
+			session["durationSeconds"] != float64(3600) || session["roleSessionName"] != "host1" {
+			t.Errorf("session %v\n",session)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"credentialSet":[{"credentials":{"accessKeyId":"ASIAX509",` +
+			`"secretAccessKey":"s","sessionToken":"t","expiration":"2031-01-02T03:04:05Z"}}]}`))
+	}))
+	defer srv.Close()
+	old := rolesAnywhereClient
+	rolesAnywhereClient = &http.Client{Transport:&http.Transport{
+		DialContext:func(ctx context.Context,network,addr string) (net.Conn,error) {
+			return (&net.Dialer{}).DialContext(ctx,network,srv.Listener.Addr().String())
+		},
+		TLSClientConfig:&tls.Config{InsecureSkipVerify:true}}}
+	defer func() { rolesAnywhereClient = old }()
+	o := RolesAnywhereOptions{
+		TrustAnchorArn:"arn:aws:rolesanywhere:us-east-2:123456789012:trust-anchor/ta",
+		ProfileArn:"arn:aws:rolesanywhere:us-east-2:123456789012:profile/p",
+		RoleArn:"arn:aws:iam::123456789012:role/onprem",
+		SessionName:"host1",
+		CertificateFile:cert_file,
+		PrivateKeyFile:key_file}
+	c,err := RolesAnywhereCredentials(o)
+	if err != nil {
+		t.Fatalf("RolesAnywhereCredentials: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIAX509" || c.SessionToken != "t" ||
+		!c.Expiration.Equal(time.Date(2031,1,2,3,4,5,0,time.UTC)) {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	o.PrivateKeyFile = cert_file
+	if _,err := RolesAnywhereCredentials(o); err == nil {
+		t.Errorf("signed with a certificate for a key\n")
+	}
+	o.RoleArn = ""
+	if _,err := RolesAnywhereCredentials(o); err == nil {
+		t.Errorf("created a session without a role\n")
+	}
+}
+
+func TestReadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	cert_file,_,cert := writeCertificate(t,dir)
+	// the leaf may be followed by its intermediates, and other blocks are skipped
+	b,_ := ioutil.ReadFile(cert_file)
+	chain := filepath.Join(dir,"chain.pem")
+	ioutil.WriteFile(chain,bytes.Join([][]byte{b,
+		pem.EncodeToMemory(&pem.Block{Type:"COMMENT",Bytes:[]byte("x")}),b},nil),0600)
+	certs,err := readCertificates(chain)
+	if err != nil || len(certs) != 2 || !certs[0].Equal(cert) {
+		t.Errorf("read %d certificates, %v\n",len(certs),err)
+	}
+	empty := filepath.Join(dir,"empty.pem")
+	ioutil.WriteFile(empty,[]byte("no pem here"),0600)
+	if _,err := readCertificates(empty); err == nil {
+		t.Errorf("read certificates from a file without any\n")
+	}
+}
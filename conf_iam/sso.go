@@ -0,0 +1,138 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+const (
+	SSO_TOKEN_HDR = "x-amz-sso_bearer_token"
+)
+
+var ssoClient = &http.Client{Timeout:30 * time.Second}
+
+// ssoToken reads the access token `aws sso login` cached for key, the sso-session
+// name or (for legacy profiles) the start URL.
+func ssoToken(key string) (string,error) {
+	h := sha1.Sum([]byte(key))
+	path := filepath.Join(os.Getenv("HOME"),".aws","sso","cache",hex.EncodeToString(h[:]) + ".json")
+	b,read_err := ioutil.ReadFile(path)
+	if read_err != nil {
+		e := fmt.Sprintf("no cached sso token, run aws sso login: %s",read_err.Error())
+		return "",errors.New(e)
+	}
+	var cached struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if um_err := json.Unmarshal(b,&cached); um_err != nil {
+		return "",um_err
+	}
+	if cached.AccessToken == "" || time.Now().After(cached.ExpiresAt) {
+		return "",errors.New("cached sso token has expired, run aws sso login")
+	}
+	return cached.AccessToken,nil
+}
+
+// SSOCredentials exchanges the cached SSO token of a profile (with sso_account_id,
+// sso_role_name, and either sso_session or the legacy sso_start_url and sso_region)
+// for the credentials of its role.
+func SSOCredentials(profile map[string] string) (*Credentials,error) {
+	region,key := profile["sso_region"],profile["sso_start_url"]
+	if session := profile["sso_session"]; session != "" {
+		config,config_err := readINI(sharedFile(CONFIG_FILE_ENV,"config"),"profile ")
+		if config_err != nil {
+			e := fmt.Sprintf("conf_iam.SSOCredentials: %s",config_err.Error())
+			return nil,errors.New(e)
+		}
+		s,ok := config["sso-session " + session]
+		if !ok {
+			e := fmt.Sprintf("conf_iam.SSOCredentials: no sso-session %s",session)
+			return nil,errors.New(e)
+		}
+		region,key = s["sso_region"],session
+	}
+	if region == "" || key == "" || profile["sso_account_id"] == "" || profile["sso_role_name"] == "" {
+		return nil,errors.New("conf_iam.SSOCredentials: incomplete sso profile")
+	}
+	token,token_err := ssoToken(key)
+	if token_err != nil {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: %s",token_err.Error())
+		return nil,errors.New(e)
+	}
+	params := url.Values{}
+	params.Set("account_id",profile["sso_account_id"])
+	params.Set("role_name",profile["sso_role_name"])
+	u := "https://portal.sso." + region + ".amazonaws.com/federation/credentials?" + params.Encode()
+	request,req_err := http.NewRequest("GET",u,nil)
+	if req_err != nil {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: %s",req_err.Error())
+		return nil,errors.New(e)
+	}
+	request.Header.Set(SSO_TOKEN_HDR,token)
+	response,rsp_err := ssoClient.Do(request)
+	if rsp_err != nil {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: %s",rsp_err.Error())
+		return nil,errors.New(e)
+	}
+	defer response.Body.Close()
+	body,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: err reading resp body: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	if response.StatusCode != http.StatusOK {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: code %d: %s",response.StatusCode,string(body))
+		return nil,errors.New(e)
+	}
+	var resp struct {
+		RoleCredentials struct {
+			AccessKeyId string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken string `json:"sessionToken"`
+			// milliseconds since the epoch
+			Expiration int64 `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if um_err := json.Unmarshal(body,&resp); um_err != nil {
+		e := fmt.Sprintf("conf_iam.SSOCredentials: cannot unmarshal response: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	rc := resp.RoleCredentials
+	return &Credentials{AccessKeyId:rc.AccessKeyId,SecretAccessKey:rc.SecretAccessKey,
+		SessionToken:rc.SessionToken,
+		Expiration:time.Unix(0,rc.Expiration * int64(time.Millisecond))},nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"time"
+	"context"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"crypto/tls"
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"net/http/httptest"
+)
+
+// cacheSSOToken writes the token `aws sso login` would cache for key under HOME.
+func cacheSSOToken(t *testing.T,key,token string,expires time.Time) {
+	h := sha1.Sum([]byte(key))
+	dir := filepath.Join(os.Getenv("HOME"),".aws","sso","cache")
+	os.MkdirAll(dir,0700)
+	b := fmt.Sprintf(`{"accessToken":"%s","expiresAt":"%s"}`,token,expires.UTC().Format(time.RFC3339))
+	ioutil.WriteFile(filepath.Join(dir,hex.EncodeToString(h[:]) + ".json"),[]byte(b),0600)
+}
+
+func TestSSOCredentials(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		if r.Host != "portal.sso.eu-west-1.amazonaws.com" || r.URL.Path != "/federation/credentials" {
+			t.Errorf("request for %s%s\n",r.Host,r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("account_id") != "123456789012" || q.Get("role_name") != "Reader" {
+			t.Errorf("query %v\n",q)
+		}
+		if r.Header.Get(SSO_TOKEN_HDR) != "sso-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"roleCredentials":{"accessKeyId":"ASIASSO","secretAccessKey":"s",` +
+			`"sessionToken":"t","expiration":1925002800000}}`))
+	}))
+	defer srv.Close()
+	old := ssoClient
+	ssoClient = &http.Client{Transport:&http.Transport{
+		DialContext:func(ctx context.Context,network,addr string) (net.Conn,error) {
+			return (&net.Dialer{}).DialContext(ctx,network,srv.Listener.Addr().String())
+		},
+		TLSClientConfig:&tls.Config{InsecureSkipVerify:true}}}
+	defer func() { ssoClient = old }()
+	t.Setenv("HOME",t.TempDir())
+	useProfiles(t,`[sso-session corp]
+sso_region = eu-west-1
+sso_start_url = https://corp.awsapps.com/start
+
+[profile session]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = Reader
+
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = eu-west-1
+sso_account_id = 123456789012
+sso_role_name = Reader
+
+[profile missing]
+sso_session = none
+sso_account_id = 123456789012
+sso_role_name = Reader
+`,"")
+	// the token is cached under the session name, or the start url of a legacy profile
+	cacheSSOToken(t,"corp","sso-token",time.Now().Add(time.Hour))
+	cacheSSOToken(t,"https://legacy.awsapps.com/start","sso-token",time.Now().Add(time.Hour))
+	for _,name := range []string{"session","legacy"} {
+		c,err := ProfileCredentials(name)
+		if err != nil {
+			t.Errorf("%s: %s\n",name,err.Error())
+			continue
+		}
+		if c.AccessKeyId != "ASIASSO" || c.SessionToken != "t" ||
+			!c.Expiration.Equal(time.Unix(1925002800,0)) {
+			t.Errorf("%s: credentials %+v\n",name,*c)
+		}
+	}
+	if _,err := ProfileCredentials("missing"); err == nil {
+		t.Errorf("credentials for a missing sso-session\n")
+	}
+	cacheSSOToken(t,"corp","sso-token",time.Now().Add(-time.Minute))
+	if _,err := ProfileCredentials("session"); err == nil {
+		t.Errorf("credentials for an expired sso token\n")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"sort"
+	"strings"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/auth_v4/tasks"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	STS_SERVICE = "sts"
+	STS_VERSION = "2011-06-15"
+	STS_CTYPE   = "application/x-www-form-urlencoded; charset=utf-8"
+)
+
+var stsClient = &http.Client{Timeout:30 * time.Second}
+
+// stsHost returns the regional STS endpoint for the DynamoDB zone, in the partition of the
+// zone and the FIPS and dual-stack variants DynamoDB uses.
+func stsHost() (string,string) {
+	conf.Vals.ConfLock.RLock()
+	zone := conf.Vals.Network.DynamoDB.Zone
+	fips := conf.Vals.Network.DynamoDB.UseFIPS
+	dualstack := conf.Vals.Network.DynamoDB.UseDualStack
+	conf.Vals.ConfLock.RUnlock()
+	host,host_err := conf.ServiceHost(STS_SERVICE,zone,fips,dualstack)
+	if host_err != nil {
+		host = "sts." + zone + "." + conf.PartitionOf(zone).DNSSuffix
+	}
+	return host,zone
+}
+
+// stsCall makes a v4 signed STS request for action with params, signed with the
+// accessKey/secret pair (and token, for temporary keys). An empty accessKey sends
+// the request unsigned, as AssumeRoleWithWebIdentity allows. The response body is
+// unmarshaled into resp.
+func stsCall(action string,params url.Values,accessKey,secret,token string,resp interface{}) error {
+	params.Set("Action",action)
+	params.Set("Version",STS_VERSION)
+	payload := params.Encode()
+	host,zone := stsHost()
+	request,req_err := http.NewRequest(aws_const.METHOD,"https://" + host + "/",strings.NewReader(payload))
+	if req_err != nil {
+		e := fmt.Sprintf("conf_iam.%s: %s",action,req_err.Error())
+		return errors.New(e)
+	}
+	request.Header.Set(aws_const.CONTENT_TYPE_HDR,STS_CTYPE)
+	if accessKey != "" {
+		now := auth_v4.SigningTime()
+		amz_date := now.UTC().Format(aws_const.ISO8601FMT_CONDENSED)
+		request.Header.Set(aws_const.X_AMZ_DATE_HDR,amz_date)
+		h := sha256.Sum256([]byte(payload))
+		signed_headers := "content-type;host;x-amz-date"
+		canonical_hdrs := "content-type:" + STS_CTYPE + "\n" +
+			"host:" + host + "\n" +
+			"x-amz-date:" + amz_date + "\n"
+		if token != "" {
+			request.Header.Set(aws_const.X_AMZ_SECURITY_TOKEN_HDR,token)
+			signed_headers += ";x-amz-security-token"
+			canonical_hdrs += "x-amz-security-token:" + token + "\n"
+		}
+		canonical_request := aws_const.METHOD + "\n/\n\n" + canonical_hdrs + "\n" +
+			signed_headers + "\n" + hex.EncodeToString(h[:])
+		str2sign := tasks.String2Sign(now,canonical_request,zone,STS_SERVICE)
+		signature := tasks.MakeSignatureAt(now,str2sign,zone,STS_SERVICE,secret)
+		request.Header.Set("Authorization","AWS4-HMAC-SHA256 Credential=" + accessKey +
+			"/" + now.UTC().Format(aws_const.ISODATEFMT) + "/" + zone + "/" +
+			STS_SERVICE + "/aws4_request," +
+			"SignedHeaders=" + signed_headers + "," +
+			"Signature=" + signature)
+	}
+	response,rsp_err := stsClient.Do(request)
+	if rsp_err != nil {
+		e := fmt.Sprintf("conf_iam.%s: %s",action,rsp_err.Error())
+		return errors.New(e)
+	}
+	defer response.Body.Close()
+	body,read_err := ioutil.ReadAll(response.Body)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.%s: err reading resp body: %s",action,read_err.Error())
+		return errors.New(e)
+	}
+	if response.StatusCode != http.StatusOK {
+		var er struct {
+			Error struct {
+				Code string
+				Message string
+			}
+		}
+		_ = xml.Unmarshal(body,&er)
+		e := fmt.Sprintf("conf_iam.%s: code %d %s: %s",action,
+			response.StatusCode,er.Error.Code,er.Error.Message)
+		return errors.New(e)
+	}
+	if um_err := xml.Unmarshal(body,resp); um_err != nil {
+		e := fmt.Sprintf("conf_iam.%s: cannot unmarshal response: %s",action,um_err.Error())
+		return errors.New(e)
+	}
+	return nil
+}
+
+// AssumeRole calls sts:AssumeRole for role_arn, signed with the conf.Vals.Auth pair.
+// A zero duration takes the STS default.
+func AssumeRole(role_arn,session_name string,duration time.Duration) (*Credentials,error) {
+	return AssumeRoleWithMFA(role_arn,session_name,duration,"")
+}
+
+// AssumeRoleWithMFA is AssumeRole with the MFA device mfa_serial, whose token code is
+// obtained from the callback set with SetMFATokenProvider. An empty mfa_serial assumes
+// the role without MFA.
+func AssumeRoleWithMFA(role_arn,session_name string,duration time.Duration,mfa_serial string) (*Credentials,error) {
+	return AssumeRoleWith(role_arn,session_name,duration,RoleOptions{MFASerial:mfa_serial})
+}
+
+// RoleOptions are the optional parameters of sts:AssumeRole.
+type RoleOptions struct {
+	// required by the trust policies of many third-party and cross-account roles
+	ExternalId string
+	// an inline session policy (JSON) and managed session policy ARNs, which further
+	// restrict the permissions of the role for this session
+	Policy string
+	PolicyArns []string
+	// session tags, and the keys of those that sessions of roles assumed with the
+	// credentials inherit (transitive tags)
+	Tags map[string] string
+	TransitiveTagKeys []string
+	// see AssumeRoleWithMFA
+	MFASerial string
+}
+
+// AssumeRoleWith is AssumeRole with the options o.
+func AssumeRoleWith(role_arn,session_name string,duration time.Duration,o RoleOptions) (*Credentials,error) {
+	conf.Vals.ConfLock.RLock()
+	base := Credentials{AccessKeyId:conf.Vals.Auth.AccessKey,SecretAccessKey:conf.Vals.Auth.Secret,
+		SessionToken:conf.Vals.Auth.Token}
+	conf.Vals.ConfLock.RUnlock()
+	return assumeRoleWith(role_arn,session_name,duration,o,&base)
+}
+
+// assumeRoleWith is AssumeRoleWith signed with the base credentials. An empty
+// session_name, which STS rejects, is defaulted.
+func assumeRoleWith(role_arn,session_name string,duration time.Duration,o RoleOptions,base *Credentials) (*Credentials,error) {
+	if role_arn == "" {
+		return nil,errors.New("conf_iam.AssumeRole: no role arn")
+	}
+	if session_name == "" {
+		session_name = fmt.Sprintf("godynamo-%d",time.Now().Unix())
+	}
+	params := url.Values{}
+	params.Set("RoleArn",role_arn)
+	params.Set("RoleSessionName",session_name)
+	if duration != 0 {
+		params.Set("DurationSeconds",fmt.Sprintf("%d",int64(duration / time.Second)))
+	}
+	if o.ExternalId != "" {
+		params.Set("ExternalId",o.ExternalId)
+	}
+	if o.Policy != "" {
+		params.Set("Policy",o.Policy)
+	}
+	for i,arn := range o.PolicyArns {
+		params.Set(fmt.Sprintf("PolicyArns.member.%d.arn",i+1),arn)
+	}
+	keys := make([]string,0,len(o.Tags))
+	for k := range o.Tags {
+		keys = append(keys,k)
+	}
+	sort.Strings(keys)
+	for i,k := range keys {
+		params.Set(fmt.Sprintf("Tags.member.%d.Key",i+1),k)
+		params.Set(fmt.Sprintf("Tags.member.%d.Value",i+1),o.Tags[k])
+	}
+	for i,k := range o.TransitiveTagKeys {
+		params.Set(fmt.Sprintf("TransitiveTagKeys.member.%d",i+1),k)
+	}
+	if mfa_err := mfaParams(params,o.MFASerial); mfa_err != nil {
+		return nil,mfa_err
+	}
+	return assumeRole(params,base)
+}
+
+// assumeRole calls sts:AssumeRole with params, signed with the base credentials. The
+// credentials carry the ARN of the session, for audit logs.
+func assumeRole(params url.Values,base *Credentials) (*Credentials,error) {
+	var resp struct {
+		Credentials Credentials `xml:"AssumeRoleResult>Credentials"`
+		SessionArn string `xml:"AssumeRoleResult>AssumedRoleUser>Arn"`
+	}
+	err := stsCall("AssumeRole",params,base.AccessKeyId,base.SecretAccessKey,base.SessionToken,&resp)
+	if err != nil {
+		return nil,err
+	}
+	resp.Credentials.SessionArn = resp.SessionArn
+	auth_v4.RegisterSecrets(resp.Credentials.SecretAccessKey,resp.Credentials.SessionToken)
+	return &resp.Credentials,nil
+}
+
+// AssumeRoleProvider assumes the role configured in conf.Vals.IAM.AssumeRole, followed by
+// the roles of its Chain, if any (see RoleChain).
+var AssumeRoleProvider = ProviderFunc(func() (*Credentials,error) {
+	conf.Vals.ConfLock.RLock()
+	role_arn := conf.Vals.IAM.AssumeRole.RoleArn
+	session_name := conf.Vals.IAM.AssumeRole.SessionName
+	duration := conf.Vals.IAM.AssumeRole.Duration
+	o := RoleOptions{ExternalId:conf.Vals.IAM.AssumeRole.ExternalId,
+		Policy:conf.Vals.IAM.AssumeRole.Policy,
+		PolicyArns:conf.Vals.IAM.AssumeRole.PolicyArns,
+		Tags:conf.Vals.IAM.AssumeRole.Tags,
+		TransitiveTagKeys:conf.Vals.IAM.AssumeRole.TransitiveTagKeys,
+		MFASerial:conf.Vals.IAM.AssumeRole.MFASerial}
+	chain := conf.Vals.IAM.AssumeRole.Chain
+	conf.Vals.ConfLock.RUnlock()
+	if len(chain) != 0 {
+		arns := append([]string{role_arn},chain...)
+		return configuredRoleChain(arns,session_name,duration,o).Retrieve()
+	}
+	return AssumeRoleWith(role_arn,session_name,duration,o)
+})
+
+// GoAssumeRole is GoProvider for AssumeRoleProvider.
+func GoAssumeRole(ready_chan chan bool) {
+	GoProvider(AssumeRoleProvider,ready_chan)
+}
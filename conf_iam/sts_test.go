@@ -0,0 +1,209 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"context"
+	"strings"
+	"testing"
+	"net/url"
+	"net/http"
+	"crypto/tls"
+	"net/http/httptest"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/auth_v4"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const stsResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/%s/session</Arn>
+      <AssumedRoleId>AROAEXAMPLE:session</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <AccessKeyId>ASIA%s</AccessKeyId>
+      <SecretAccessKey>secret-of-%s</SecretAccessKey>
+      <SessionToken>token-of-%s</SessionToken>
+      <Expiration>2031-01-02T03:04:05Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+// stsServer starts a fake STS endpoint serving handler, which stsCall is directed to
+// until the returned func is called. Requests are signed with the AKIDEXAMPLE pair.
+func stsServer(handler http.HandlerFunc) func() {
+	srv := httptest.NewTLSServer(handler)
+	old := stsClient
+	stsClient = &http.Client{Transport:&http.Transport{
+		DialContext:func(ctx context.Context,network,addr string) (net.Conn,error) {
+			return (&net.Dialer{}).DialContext(ctx,network,srv.Listener.Addr().String())
+		},
+		TLSClientConfig:&tls.Config{InsecureSkipVerify:true}}}
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.Auth.Token = ""
+	conf.Vals.ConfLock.Unlock()
+	return func() {
+		stsClient = old
+		srv.Close()
+	}
+}
+
+// assumeRoleHandler answers AssumeRole for any role, with credentials named after it.
+func assumeRoleHandler(t *testing.T,check func(r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter,r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRole" || r.Form.Get("Version") != STS_VERSION {
+			t.Errorf("unexpected request %v\n",r.Form)
+		}
+		if check != nil {
+			check(r)
+		}
+		role := r.Form.Get("RoleArn")
+		role = role[strings.LastIndex(role,"/")+1:]
+		fmt.Fprintf(w,stsResponse,role,strings.ToUpper(role),role,role)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	want := map[string] string{
+		"RoleArn":"arn:aws:iam::123456789012:role/reader",
+		"RoleSessionName":"s",
+		"DurationSeconds":"900",
+		"ExternalId":"ext",
+		"Policy":`{"Version":"2012-10-17"}`,
+		"PolicyArns.member.1.arn":"arn:aws:iam::aws:policy/ReadOnlyAccess",
+		// session tags are sent in key order
+		"Tags.member.1.Key":"project",
+		"Tags.member.1.Value":"p",
+		"Tags.member.2.Key":"team",
+		"Tags.member.2.Value":"t",
+		"TransitiveTagKeys.member.1":"team",
+	}
+	defer stsServer(assumeRoleHandler(t,func(r *http.Request) {
+		for k,v := range want {
+			if got := r.Form.Get(k); got != v {
+				t.Errorf("%s = %q, want %q\n",k,got,v)
+			}
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth,"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(auth,"/us-east-1/sts/aws4_request,") {
+			t.Errorf("Authorization %q\n",auth)
+		}
+	}))()
+	o := RoleOptions{ExternalId:"ext",Policy:`{"Version":"2012-10-17"}`,
+		PolicyArns:[]string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		Tags:map[string] string{"team":"t","project":"p"},
+		TransitiveTagKeys:[]string{"team"}}
+	c,err := AssumeRoleWith(want["RoleArn"],"s",15 * time.Minute,o)
+	if err != nil {
+		t.Fatalf("AssumeRoleWith: %s\n",err.Error())
+	}
+	if c.AccessKeyId != "ASIAREADER" || c.SecretAccessKey != "secret-of-reader" ||
+		c.SessionToken != "token-of-reader" {
+		t.Errorf("credentials %+v\n",*c)
+	}
+	if !c.Expiration.Equal(time.Date(2031,1,2,3,4,5,0,time.UTC)) {
+		t.Errorf("expiration %v\n",c.Expiration)
+	}
+	if c.SessionArn != "arn:aws:sts::123456789012:assumed-role/reader/session" {
+		t.Errorf("session arn %q\n",c.SessionArn)
+	}
+	if strings.Contains(auth_v4.Redact("token-of-reader"),"token-of-reader") {
+		t.Errorf("assumed role token not redacted\n")
+	}
+}
+
+func TestAssumeRoleError(t *testing.T) {
+	defer stsServer(func(w http.ResponseWriter,r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>not authorized to perform sts:AssumeRole</Message>
+  </Error>
+  <RequestId>r</RequestId>
+</ErrorResponse>`))
+	})()
+	_,err := AssumeRole("arn:aws:iam::123456789012:role/reader","s",0)
+	if err == nil {
+		t.Fatalf("AssumeRole succeeded\n")
+	}
+	for _,s := range []string{"403","AccessDenied","not authorized to perform sts:AssumeRole"} {
+		if !strings.Contains(err.Error(),s) {
+			t.Errorf("error %q does not mention %q\n",err.Error(),s)
+		}
+	}
+	if _,err := AssumeRole("","s",0); err == nil {
+		t.Errorf("AssumeRole without a role arn succeeded\n")
+	}
+}
+
+// skewBy has DynamoDB report a clock skew error with a server time offset from local
+// time, as auth_v4 would see from a server with that offset.
+func skewBy(t *testing.T,offset time.Duration) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		w.Header().Set(aws_const.DATE_HDR,time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazon.coral.service#InvalidSignatureException",` +
+			`"message":"Signature expired: RequestTimeTooSkewed"}`))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.ConfLock.Unlock()
+	auth_v4.RawReqContext(context.Background(),[]byte(`{}`),"DynamoDB_20120810.ListTables")
+	if d := auth_v4.ClockSkew() - offset; d < -5 * time.Second || d > 5 * time.Second {
+		t.Fatalf("clock skew %v, want %v\n",auth_v4.ClockSkew(),offset)
+	}
+}
+
+// STS requests are signed at the time corrected for the skew measured against DynamoDB.
+func TestSTSSkew(t *testing.T) {
+	var signed time.Time
+	defer stsServer(assumeRoleHandler(t,func(r *http.Request) {
+		signed,_ = time.Parse(aws_const.ISO8601FMT_CONDENSED,r.Header.Get(aws_const.X_AMZ_DATE_HDR))
+	}))()
+	skewBy(t,time.Hour)
+	defer skewBy(t,0)
+	if _,err := AssumeRole("arn:aws:iam::123456789012:role/reader","s",0); err != nil {
+		t.Fatalf("AssumeRole: %s\n",err.Error())
+	}
+	if d := signed.Sub(time.Now().Add(time.Hour)); d < -time.Minute || d > time.Minute {
+		t.Errorf("signed at %v, an hour ahead of %v\n",signed,time.Now())
+	}
+}
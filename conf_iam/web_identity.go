@@ -0,0 +1,101 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"net/url"
+	"io/ioutil"
+	"github.com/smugmug/godynamo/auth_v4"
+	conf "github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// environment variables set for pods using IAM roles for service accounts
+	WEB_IDENTITY_TOKEN_FILE_ENV = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	ROLE_ARN_ENV                = "AWS_ROLE_ARN"
+	ROLE_SESSION_NAME_ENV       = "AWS_ROLE_SESSION_NAME"
+)
+
+// AssumeRoleWithWebIdentity calls sts:AssumeRoleWithWebIdentity for role_arn with the
+// token read from token_file. The file is read on every call, as it is rotated.
+// The request is not signed. A zero duration takes the STS default.
+func AssumeRoleWithWebIdentity(role_arn,session_name,token_file string,duration time.Duration) (*Credentials,error) {
+	if role_arn == "" || token_file == "" {
+		return nil,errors.New("conf_iam.AssumeRoleWithWebIdentity: no role arn or token file")
+	}
+	token,read_err := ioutil.ReadFile(token_file)
+	if read_err != nil {
+		e := fmt.Sprintf("conf_iam.AssumeRoleWithWebIdentity: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	if session_name == "" {
+		session_name = fmt.Sprintf("godynamo-%d",time.Now().Unix())
+	}
+	params := url.Values{}
+	params.Set("RoleArn",role_arn)
+	params.Set("RoleSessionName",session_name)
+	params.Set("WebIdentityToken",strings.TrimSpace(string(token)))
+	if duration != 0 {
+		params.Set("DurationSeconds",fmt.Sprintf("%d",int64(duration / time.Second)))
+	}
+	var resp struct {
+		Credentials Credentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		SessionArn string `xml:"AssumeRoleWithWebIdentityResult>AssumedRoleUser>Arn"`
+	}
+	if err := stsCall("AssumeRoleWithWebIdentity",params,"","","",&resp); err != nil {
+		return nil,err
+	}
+	resp.Credentials.SessionArn = resp.SessionArn
+	auth_v4.RegisterSecrets(resp.Credentials.SecretAccessKey,resp.Credentials.SessionToken)
+	return &resp.Credentials,nil
+}
+
+// WebIdentityEnv reports whether the web identity environment variables are set.
+func WebIdentityEnv() bool {
+	return os.Getenv(WEB_IDENTITY_TOKEN_FILE_ENV) != "" && os.Getenv(ROLE_ARN_ENV) != ""
+}
+
+// WebIdentityProvider assumes the role named in the environment with its token file.
+// conf.Vals.IAM.AssumeRole.Duration sets the lifetime of the credentials.
+var WebIdentityProvider = ProviderFunc(func() (*Credentials,error) {
+	if !WebIdentityEnv() {
+		return nil,errors.New("conf_iam.WebIdentityProvider: no web identity environment")
+	}
+	conf.Vals.ConfLock.RLock()
+	duration := conf.Vals.IAM.AssumeRole.Duration
+	conf.Vals.ConfLock.RUnlock()
+	return AssumeRoleWithWebIdentity(os.Getenv(ROLE_ARN_ENV),os.Getenv(ROLE_SESSION_NAME_ENV),
+		os.Getenv(WEB_IDENTITY_TOKEN_FILE_ENV),duration)
+})
+
+// GoWebIdentity is GoProvider for WebIdentityProvider.
+func GoWebIdentity(ready_chan chan bool) {
+	GoProvider(WebIdentityProvider,ready_chan)
+}
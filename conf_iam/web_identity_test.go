@@ -0,0 +1,75 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package conf_iam
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestWebIdentityProvider(t *testing.T) {
+	token_file := filepath.Join(t.TempDir(),"token")
+	defer stsServer(func(w http.ResponseWriter,r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" {
+			t.Errorf("action %q\n",r.Form.Get("Action"))
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("web identity request signed: %q\n",auth)
+		}
+		if r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/pod" ||
+			r.Form.Get("RoleSessionName") != "pod-session" {
+			t.Errorf("unexpected request %v\n",r.Form)
+		}
+		// the token is read afresh for every request
+		token := r.Form.Get("WebIdentityToken")
+		fmt.Fprintf(w,strings.Replace(stsResponse,"AssumeRole","AssumeRoleWithWebIdentity",-1),
+			token,strings.ToUpper(token),token,token)
+	})()
+	t.Setenv(ROLE_ARN_ENV,"")
+	if _,err := WebIdentityProvider.Retrieve(); err == nil {
+		t.Errorf("retrieved credentials without the web identity environment\n")
+	}
+	t.Setenv(ROLE_ARN_ENV,"arn:aws:iam::123456789012:role/pod")
+	t.Setenv(ROLE_SESSION_NAME_ENV,"pod-session")
+	t.Setenv(WEB_IDENTITY_TOKEN_FILE_ENV,token_file)
+	for _,token := range []string{"first","rotated"} {
+		ioutil.WriteFile(token_file,[]byte(token + "\n"),0600)
+		c,err := WebIdentityProvider.Retrieve()
+		if err != nil {
+			t.Fatalf("Retrieve: %s\n",err.Error())
+		}
+		if c.AccessKeyId != "ASIA" + strings.ToUpper(token) || c.SessionToken != "token-of-" + token {
+			t.Errorf("credentials %+v\n",*c)
+		}
+		if c.SessionArn != "arn:aws:sts::123456789012:assumed-role/" + token + "/session" {
+			t.Errorf("session arn %q\n",c.SessionArn)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package endpoint
+
+import (
+	"sort"
+	"math/big"
+)
+
+const (
+	DIFF_ADDED   = "ADDED"
+	DIFF_REMOVED = "REMOVED"
+	DIFF_CHANGED = "CHANGED"
+)
+
+// Change is one difference between two items. Path is the attribute name; for sets,
+// a change of members lists them in Added and Removed rather than replacing the set.
+type Change struct {
+	Path string
+	Kind string
+	Old *AttributeValue
+	New *AttributeValue
+	Added []string
+	Removed []string
+}
+
+// valueType returns the type of a, if it is not set in a.Type.
+func valueType(a AttributeValue) string {
+	switch {
+	case a.N != "":
+		return N
+	case a.S != "":
+		return S
+	case a.B != "":
+		return B
+	case len(a.SS) != 0:
+		return SS
+	case len(a.NS) != 0:
+		return NS
+	case len(a.BS) != 0:
+		return BS
+	}
+	return a.Type
+}
+
+// numKey returns a canonical form of a number, so "1" and "1.0" compare equal.
+func numKey(n string) string {
+	f,_,err := big.ParseFloat(n,10,256,big.ToNearestEven)
+	if err != nil {
+		return n
+	}
+	return f.Text('g',-1)
+}
+
+// members returns the members of a set, canonicalized for NS, and the original members
+// by canonical member.
+func members(a AttributeValue) map[string] string {
+	m := make(map[string] string)
+	switch valueType(a) {
+	case SS:
+		for _,s := range a.SS {
+			m[s] = s
+		}
+	case NS:
+		for _,n := range a.NS {
+			m[numKey(n)] = n
+		}
+	case BS:
+		for _,b := range a.BS {
+			m[b] = b
+		}
+	}
+	return m
+}
+
+// Equal reports whether a and b are the same value. Numbers are compared by value and
+// sets without regard to order.
+func (a AttributeValue) Equal(b AttributeValue) bool {
+	t := valueType(a)
+	if t != valueType(b) {
+		return false
+	}
+	switch t {
+	case N:
+		return numKey(a.N) == numKey(b.N)
+	case S:
+		return a.S == b.S
+	case B:
+		return a.B == b.B
+	}
+	ma,mb := members(a),members(b)
+	if len(ma) != len(mb) {
+		return false
+	}
+	for k,_ := range ma {
+		if _,ok := mb[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff lists the attributes added, removed or changed from old to new, by path.
+func Diff(old,new Item) []Change {
+	changes := make([]Change,0)
+	for k,ov := range old {
+		ov := ov
+		nv,ok := new[k]
+		if !ok {
+			changes = append(changes,Change{Path:k,Kind:DIFF_REMOVED,Old:&ov})
+			continue
+		}
+		if ov.Equal(nv) {
+			continue
+		}
+		nv_copy := nv
+		c := Change{Path:k,Kind:DIFF_CHANGED,Old:&ov,New:&nv_copy}
+		t := valueType(ov)
+		if t == valueType(nv) && (t == SS || t == NS || t == BS) {
+			om,nm := members(ov),members(nv)
+			for m,orig := range nm {
+				if _,ok := om[m]; !ok {
+					c.Added = append(c.Added,orig)
+				}
+			}
+			for m,orig := range om {
+				if _,ok := nm[m]; !ok {
+					c.Removed = append(c.Removed,orig)
+				}
+			}
+			sort.Strings(c.Added)
+			sort.Strings(c.Removed)
+		}
+		changes = append(changes,c)
+	}
+	for k,nv := range new {
+		if _,ok := old[k]; !ok {
+			nv := nv
+			changes = append(changes,Change{Path:k,Kind:DIFF_ADDED,New:&nv})
+		}
+	}
+	sort.Slice(changes,func(i,j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -31,7 +31,9 @@ import (
 	"encoding/json"
 	"encoding/base64"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -128,6 +130,47 @@ func AWSParseFloat(s string) (string,error) {
 	return strconv.FormatFloat(f,'f',-1,64),nil
 }
 
+// ValidNumber reports whether s is a number DynamoDB accepts as it is written: decimal,
+// with an optional sign, fraction and exponent, at most 38 significant digits, and a
+// magnitude between 1E-130 and 9.9999999999999999999999999999999999999E+125. Unlike
+// AWSParseFloat it does not rewrite s, so large numbers keep their precision and
+// leading zeros do not make them octal.
+func ValidNumber(s string) error {
+	m := number_re.FindStringSubmatch(s)
+	if m == nil || m[1] == "" && len(m[2]) < 2 {
+		e := fmt.Sprintf("endpoint.ValidNumber: not a decimal number: %q",s)
+		return errors.New(e)
+	}
+	int_part,frac_part := m[1],strings.TrimPrefix(m[2],".")
+	exp := 0
+	if m[3] != "" {
+		var exp_err error
+		if exp,exp_err = strconv.Atoi(m[3][1:]); exp_err != nil {
+			e := fmt.Sprintf("endpoint.ValidNumber: exponent out of range: %q",s)
+			return errors.New(e)
+		}
+	}
+	digits := strings.TrimLeft(int_part + frac_part,"0")
+	if digits == "" {
+		return nil // zero
+	}
+	// the power of ten of the first significant digit
+	magnitude := len(digits) - len(frac_part) - 1 + exp
+	digits = strings.TrimRight(digits,"0")
+	if len(digits) > 38 {
+		e := fmt.Sprintf("endpoint.ValidNumber: more than 38 significant digits: %q",s)
+		return errors.New(e)
+	}
+	if magnitude < -130 || magnitude > 125 {
+		e := fmt.Sprintf("endpoint.ValidNumber: magnitude out of range: %q",s)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// number_re matches decimal numbers, capturing their integer part, fraction and exponent.
+var number_re = regexp.MustCompile(`^[-+]?([0-9]*)(\.[0-9]*)?([eE][-+]?[0-9]+)?$`)
+
 // AWSParseBinary can test if a string has already been encoded.
 func AWSParseBinary(s string) (error) {
 	_,err := base64.StdEncoding.DecodeString(s)
@@ -534,3 +577,56 @@ func (l LocalSecondaryIndex) MarshalJSON() ([]byte, error) {
 	li.Projection.ProjectionType = l.Projection.ProjectionType
 	return json.Marshal(li)
 }
+
+type GlobalSecondaryIndex struct {
+	IndexName string
+	KeySchema KeySchema
+	Projection struct {
+		NonKeyAttributes []string
+		ProjectionType string
+	}
+	ProvisionedThroughput ProvisionedThroughput
+}
+
+func NewGlobalSecondaryIndex() (*GlobalSecondaryIndex) {
+	g := new(GlobalSecondaryIndex)
+	g.KeySchema = make(KeySchema,0)
+	g.Projection.NonKeyAttributes = make([]string,0)
+	return g
+}
+
+type globalSecondaryIndex GlobalSecondaryIndex
+
+func (g GlobalSecondaryIndex) MarshalJSON() ([]byte, error) {
+	if !(g.Projection.ProjectionType == ALL ||
+		g.Projection.ProjectionType == KEYS_ONLY ||
+		g.Projection.ProjectionType == INCLUDE) {
+		e := fmt.Sprintf("endpoint.GlobalSecondaryIndex.MarshalJSON: " +
+			"ProjectionType %s is not valid",g.Projection.ProjectionType)
+		return nil, errors.New(e)
+	}
+	if len(g.Projection.NonKeyAttributes) > 20 {
+		e := fmt.Sprintf("endpoint.GlobalSecondaryIndex.MarshalJSON: " +
+			"NonKeyAttributes > 20")
+		return nil, errors.New(e)
+	}
+	gi := globalSecondaryIndex(g)
+	// if present, must have length between 1 and 20
+	if len(g.Projection.NonKeyAttributes) == 0 {
+		gi.Projection.NonKeyAttributes = nil
+	}
+	return json.Marshal(gi)
+}
+
+type GlobalSecondaryIndexes []GlobalSecondaryIndex
+
+type globalSecondaryIndexes GlobalSecondaryIndexes
+
+// GlobalSecondaryIndexes are optional, and marshaled as null when empty.
+func (g GlobalSecondaryIndexes) MarshalJSON() ([]byte, error) {
+	if len(g) == 0 {
+		var i interface{} = nil
+		return json.Marshal(i)
+	}
+	return json.Marshal(globalSecondaryIndexes(g))
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package endpoint
+
+// Pipeline is a sequence of Go-side stages applied to each item read by a Scan or Query,
+// for predicates and projections that the server side conditions cannot express. Each
+// stage returns the (possibly transformed) item and whether to keep it; a dropped item
+// is not passed to later stages. Adding a stage returns a new Pipeline and leaves the
+// one it was added to as it was, so several can be built from a common base.
+type Pipeline []func(Item) (Item,bool)
+
+// Filter returns the Pipeline with a stage that keeps only the items for which f is true.
+func (p Pipeline) Filter(f func(Item) bool) Pipeline {
+	return p.stage(func(i Item) (Item,bool) { return i,f(i) })
+}
+
+// Map returns the Pipeline with a stage that replaces each item with f(item).
+func (p Pipeline) Map(f func(Item) Item) Pipeline {
+	return p.stage(func(i Item) (Item,bool) { return f(i),true })
+}
+
+// stage returns the Pipeline with s added, in a new array so that Pipelines sharing p
+// do not overwrite each other's stages.
+func (p Pipeline) stage(s func(Item) (Item,bool)) Pipeline {
+	return append(p[:len(p):len(p)],s)
+}
+
+// Project returns the Pipeline with a stage that keeps only the named attributes.
+func (p Pipeline) Project(names ...string) Pipeline {
+	return p.Map(func(i Item) Item {
+		pi := make(Item)
+		for _,k := range names {
+			if v,ok := i[k]; ok {
+				pi[k] = v
+			}
+		}
+		return pi
+	})
+}
+
+// Run passes item through each stage, returning the result and whether it was kept.
+func (p Pipeline) Run(item Item) (Item,bool) {
+	for _,stage := range p {
+		var keep bool
+		if item,keep = stage(item); !keep {
+			return nil,false
+		}
+	}
+	return item,true
+}
+
+// Apply runs each of items through the Pipeline, returning those that are kept.
+func (p Pipeline) Apply(items []Item) []Item {
+	kept := make([]Item,0,len(items))
+	for _,item := range items {
+		if pi,keep := p.Run(item); keep {
+			kept = append(kept,pi)
+		}
+	}
+	return kept
+}
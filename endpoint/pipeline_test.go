@@ -0,0 +1,66 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package endpoint
+
+import (
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	items := []Item{
+		Item{"id":AttributeValue{S:"a"},"n":AttributeValue{N:"1"},"x":AttributeValue{S:"x"}},
+		Item{"id":AttributeValue{S:"b"},"n":AttributeValue{N:"2"}},
+		Item{"id":AttributeValue{S:"c"},"n":AttributeValue{N:"3"}},
+	}
+	p := Pipeline{}.Filter(func(i Item) bool { return i["id"].S != "b" }).Project("id","n")
+	kept := p.Apply(items)
+	if len(kept) != 2 || kept[0]["id"].S != "a" || kept[1]["id"].S != "c" {
+		t.Errorf("unexpected items %v\n",kept)
+	}
+	if _,ok := kept[0]["x"]; ok {
+		t.Errorf("attribute x was not projected out\n")
+	}
+	if _,keep := (Pipeline{}).Run(items[1]); !keep {
+		t.Errorf("an empty Pipeline dropped an item\n")
+	}
+}
+
+func TestPipelineSharedBase(t *testing.T) {
+	// a base with spare capacity, so that an append in place would be shared
+	base := make(Pipeline,1,4)
+	base[0] = func(i Item) (Item,bool) { return i,true }
+	a := base.Filter(func(i Item) bool { return i["id"].S == "a" })
+	b := base.Filter(func(i Item) bool { return i["id"].S == "b" })
+	item := Item{"id":AttributeValue{S:"a"}}
+	if _,keep := a.Run(item); !keep {
+		t.Errorf("a ran the filter of b\n")
+	}
+	if _,keep := b.Run(item); keep {
+		t.Errorf("b kept an item it filters out\n")
+	}
+	if len(base) != 1 || len(a) != 2 || len(b) != 2 {
+		t.Errorf("unexpected lengths %d %d %d\n",len(base),len(a),len(b))
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package endpoint
+
+import (
+	"time"
+	"strconv"
+)
+
+const (
+	// Timestamps formats
+	TIMESTAMP_EPOCH   = "EPOCH"   // N, epoch seconds
+	TIMESTAMP_RFC3339 = "RFC3339" // S, RFC 3339 in UTC
+)
+
+// Timestamps names the audit attributes maintained on writes. Share one Timestamps value
+// among all writers of a table so the attributes are consistent. There is no struct
+// marshaler in this package to drive this from struct tags; call Stamp on the request
+// (see put_item and update_item) before sending it.
+type Timestamps struct {
+	Created string
+	Updated string
+	// TIMESTAMP_EPOCH (the default) or TIMESTAMP_RFC3339
+	Format string
+}
+
+// Value renders t as a timestamp attribute.
+func (ts Timestamps) Value(t time.Time) AttributeValue {
+	if ts.Format == TIMESTAMP_RFC3339 {
+		return AttributeValue{S:t.UTC().Format(time.RFC3339)}
+	}
+	return AttributeValue{N:strconv.FormatInt(t.Unix(),10)}
+}
+
+// StampItem sets the Updated attribute of item to now, and the Created attribute to now
+// if item has none. A Put replaces the whole item, so writers that put items read earlier
+// keep the original Created value.
+func (ts Timestamps) StampItem(item Item,now time.Time) {
+	v := ts.Value(now)
+	if ts.Updated != "" {
+		item[ts.Updated] = v
+	}
+	if ts.Created != "" {
+		if _,ok := item[ts.Created]; !ok {
+			item[ts.Created] = v
+		}
+	}
+}
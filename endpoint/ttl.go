@@ -0,0 +1,67 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package endpoint
+
+import (
+	"time"
+	"strconv"
+)
+
+// DynamoDB TTL deletes items some time (up to days) after the epoch seconds in their TTL
+// attribute have passed. Until then, expired items are still returned by reads; the
+// helpers below let readers treat them as absent.
+//
+// There is no struct marshaler in this package, so a `dynamo:",ttl"` tag is not
+// supported; build the attribute with TTLValue or TTLAfter instead.
+
+// TTLValue is the TTL attribute value for an item expiring at t.
+func TTLValue(t time.Time) AttributeValue {
+	return AttributeValue{N:strconv.FormatInt(t.Unix(),10)}
+}
+
+// TTLAfter is the TTL attribute value for an item expiring d from now.
+func TTLAfter(d time.Duration) AttributeValue {
+	return TTLValue(time.Now().Add(d))
+}
+
+// Expired determines if the TTL attribute `ttl_attr` of item has passed at time now.
+// Items without a numeric TTL attribute never expire.
+func (i Item) Expired(ttl_attr string,now time.Time) bool {
+	a,ok := i[ttl_attr]
+	if !ok || a.N == "" {
+		return false
+	}
+	f,f_err := strconv.ParseFloat(a.N,64)
+	if f_err != nil {
+		return false
+	}
+	return int64(f) <= now.Unix()
+}
+
+// Unexpired returns the Pipeline with a stage that drops items whose TTL attribute
+// `ttl_attr` has passed.
+func (p Pipeline) Unexpired(ttl_attr string) Pipeline {
+	return p.Filter(func(i Item) bool { return !i.Expired(ttl_attr,time.Now()) })
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package batch_get_item
+
+import (
+	"fmt"
+	"sync"
+	"errors"
+	"net/http"
+	"encoding/json"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+)
+
+const (
+	// consistent tables with at most this many keys are read with individual GetItem calls
+	CONSISTENT_GET_LIM = 10
+	// individual GetItem calls in flight at once for DoBatchGetConsistent
+	CONSISTENT_GET_CONCURRENCY = 10
+)
+
+// DoBatchGetConsistent is like DoBatchGet, but the keys of each table with ConsistentRead
+// set are read with parallel consistent GetItem calls when there are few enough of them
+// (CONSISTENT_GET_LIM), where a BatchGetItem round trip (and its UnprocessedKeys retries)
+// costs more latency than it saves. Tables with more keys, and tables without ConsistentRead,
+// are read with DoBatchGet. The response has the form of a BatchGetItem response.
+func (b BatchGetItem) DoBatchGetConsistent() (string,int,error) {
+	batch := NewBatchGetItem()
+	batch.ReturnConsumedCapacity = b.ReturnConsumedCapacity
+	gets := make(map[string] *RequestInstance)
+	for tn,ri := range b.RequestItems {
+		if ri.ConsistentRead && len(ri.Keys) <= CONSISTENT_GET_LIM {
+			gets[tn] = ri
+		} else {
+			batch.RequestItems[tn] = ri
+		}
+	}
+	combined_resp := NewResponse()
+	if len(batch.RequestItems) != 0 {
+		body,code,err := batch.DoBatchGet()
+		if err != nil || code != http.StatusOK {
+			return body,code,err
+		}
+		if um_err := json.Unmarshal([]byte(body),combined_resp); um_err != nil {
+			e := fmt.Sprintf("batch_get_item.DoBatchGetConsistent: %s",um_err.Error())
+			return "",0,errors.New(e)
+		}
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{},CONSISTENT_GET_CONCURRENCY)
+	code := http.StatusOK
+	var err error
+	for tn,ri := range gets {
+		for _,key := range ri.Keys {
+			g := get.NewGet()
+			g.TableName = tn
+			g.Key = key
+			g.AttributesToGet = ri.AttributesToGet
+			g.ConsistentRead = true
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(tn string,g *get.Get) {
+				defer wg.Done()
+				defer func() { <- sem }()
+				g_body,g_code,g_err := g.EndpointReq()
+				lock.Lock()
+				defer lock.Unlock()
+				if g_err != nil {
+					err = g_err
+					return
+				} else if g_code != http.StatusOK {
+					code = g_code
+					return
+				}
+				r := get.NewResponse()
+				if um_err := json.Unmarshal([]byte(g_body),r); um_err != nil {
+					e := fmt.Sprintf("batch_get_item.DoBatchGetConsistent: %s",um_err.Error())
+					err = errors.New(e)
+					return
+				}
+				if len(r.Item) != 0 {
+					combined_resp.Responses[tn] = append(combined_resp.Responses[tn],r.Item)
+				}
+			}(tn,g)
+		}
+	}
+	wg.Wait()
+	body := ""
+	body_bytes,marshal_err := json.Marshal(*combined_resp)
+	if marshal_err != nil {
+		err = marshal_err
+	} else {
+		body = string(body_bytes)
+	}
+	return body,code,err
+}
@@ -27,6 +27,8 @@ package batch_write_item
 import (
 	"testing"
 	"fmt"
+	"time"
+	"strconv"
 	"encoding/json"
 	ep "github.com/smugmug/godynamo/endpoint"
 )
@@ -285,6 +287,22 @@ func TestSplit1(t *testing.T) {
 	}
 }
 
+func TestOversizeFail(t *testing.T) {
+	b := NewBatchWriteItem()
+	for i := 0; i < 30; i++ {
+		var p PutRequest
+		p.Item = ep.Item{"Key":ep.AttributeValue{S:fmt.Sprintf("TheKey%d",i)}}
+		b.RequestItems["foo"] = append(b.RequestItems["foo"],RequestInstance{PutRequest:&p})
+	}
+	if b.Len() != 30 {
+		t.Errorf("Len should be 30, it is %d\n",b.Len())
+	}
+	_,_,err := b.DoBatchWriteWith(OVERSIZE_FAIL)
+	if tl,ok := err.(*TooLargeError); !ok || tl.Requests != 30 || tl.Limit != QUERY_LIM {
+		t.Errorf("expected a TooLargeError, got %v\n",err)
+	}
+}
+
 func TestSplit2(t *testing.T) {
 	b := NewBatchWriteItem()
 	b.RequestItems["foo"] = make([]RequestInstance,0)
@@ -311,3 +329,22 @@ func TestSplit2(t *testing.T) {
 		i++
 	}
 }
+
+func TestWriteBufferAccumulates(t *testing.T) {
+	w := NewWriteBuffer(time.Hour,nil)
+	for i := 0; i < QUERY_LIM - 1; i++ {
+		if err := w.Put("Thread",ep.Item{"id":ep.AttributeValue{N:strconv.Itoa(i)}}); err != nil {
+			t.Errorf("cannot buffer: %s\n",err.Error())
+		}
+	}
+	if w.count != QUERY_LIM - 1 || len(w.pending.RequestItems["Thread"]) != QUERY_LIM - 1 {
+		t.Errorf("buffered %d\n",w.count)
+	}
+	w.lock.Lock()
+	w.take()
+	w.closed = true
+	w.lock.Unlock()
+	if err := w.Delete("Thread",ep.Item{"id":ep.AttributeValue{N:"1"}}); err != ErrBufferClosed {
+		t.Errorf("write to a closed buffer should fail\n")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package batch_write_item
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+const (
+	// a buffer is flushed at this many bytes of marshaled items, well under QUERY_LIM_BYTES
+	WRITE_BUFFER_BYTES = QUERY_LIM_BYTES / 2
+	// and at least this often if it holds anything
+	WRITE_BUFFER_INTERVAL = time.Second
+)
+
+// ErrBufferClosed is returned for writes to a closed WriteBuffer.
+var ErrBufferClosed = errors.New("batch_write_item: WriteBuffer is closed")
+
+// WriteBuffer accumulates puts and deletes and writes them as a BatchWriteItem when it
+// holds QUERY_LIM requests, WRITE_BUFFER_BYTES of items, or when Interval has passed
+// since the first buffered request. Flushes caused by a Put or Delete are made in the
+// caller's goroutine and their error returned; flushes on the interval report errors
+// to OnError, wait while the client is paused (see authreq.Pause) and are not made after
+// authreq.Close, so Close the buffer first. A batch may not contain two requests for the
+// same key, so callers should not buffer more than one write per key between flushes.
+type WriteBuffer struct {
+	Interval time.Duration
+	// called with each batch whose write failed in the background, if not nil
+	OnError func(b BatchWriteItem,err error)
+	lock sync.Mutex
+	pending *BatchWriteItem
+	count int
+	bytes int
+	// closed to cancel the interval flush of the requests buffered
+	cancel chan bool
+	closed bool
+}
+
+// NewWriteBuffer returns a pointer to a WriteBuffer flushed at least every interval
+// (WRITE_BUFFER_INTERVAL if interval is 0).
+func NewWriteBuffer(interval time.Duration,onError func(BatchWriteItem,error)) (*WriteBuffer) {
+	if interval <= 0 {
+		interval = WRITE_BUFFER_INTERVAL
+	}
+	return &WriteBuffer{Interval:interval,OnError:onError,pending:NewBatchWriteItem()}
+}
+
+// Put buffers a put of item to tablename.
+func (w *WriteBuffer) Put(tablename string,item ep.Item) error {
+	return w.add(tablename,RequestInstance{PutRequest:&PutRequest{Item:item}})
+}
+
+// Delete buffers a delete of key from tablename.
+func (w *WriteBuffer) Delete(tablename string,key ep.Item) error {
+	return w.add(tablename,RequestInstance{DeleteRequest:&DeleteRequest{Key:key}})
+}
+
+func (w *WriteBuffer) add(tablename string,r RequestInstance) error {
+	b,json_err := json.Marshal(r)
+	if json_err != nil {
+		e := fmt.Sprintf("batch_write_item.WriteBuffer: %s",json_err.Error())
+		return errors.New(e)
+	}
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return ErrBufferClosed
+	}
+	w.pending.RequestItems[tablename] = append(w.pending.RequestItems[tablename],r)
+	w.count++
+	w.bytes += len(b)
+	if w.count == 1 {
+		cancel := make(chan bool)
+		if authreq.GoBackground(func(stop <-chan bool) { w.flushOnTimer(cancel,stop) }) {
+			w.cancel = cancel
+		}
+	}
+	var full *BatchWriteItem
+	if w.count >= QUERY_LIM || w.bytes >= WRITE_BUFFER_BYTES {
+		full = w.take()
+	}
+	w.lock.Unlock()
+	if full != nil {
+		return write(*full)
+	}
+	return nil
+}
+
+// take removes the buffered requests. w.lock must be held.
+func (w *WriteBuffer) take() *BatchWriteItem {
+	if w.count == 0 {
+		return nil
+	}
+	if w.cancel != nil {
+		close(w.cancel)
+		w.cancel = nil
+	}
+	b := w.pending
+	w.pending = NewBatchWriteItem()
+	w.count = 0
+	w.bytes = 0
+	return b
+}
+
+// flushOnTimer writes the buffered requests after w.Interval, once the client is not
+// paused (see authreq.Pause), unless cancel or stop is closed first.
+func (w *WriteBuffer) flushOnTimer(cancel,stop <-chan bool) {
+	t := time.NewTimer(w.Interval)
+	defer t.Stop()
+	select {
+	case <- t.C:
+	case <- cancel:
+		return
+	case <- stop:
+		return
+	}
+	select {
+	case <- authreq.Resumed():
+	case <- cancel:
+		return
+	case <- stop:
+		return
+	}
+	w.lock.Lock()
+	b := w.take()
+	w.lock.Unlock()
+	if b == nil {
+		return
+	}
+	if err := write(*b); err != nil && w.OnError != nil {
+		w.OnError(*b,err)
+	}
+}
+
+func write(b BatchWriteItem) error {
+	body,code,err := b.RetryBatchWrite(0)
+	if err != nil {
+		e := fmt.Sprintf("batch_write_item.WriteBuffer: %s",err.Error())
+		return errors.New(e)
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("batch_write_item.WriteBuffer: code %d: %s",code,body)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// Flush writes any buffered requests now.
+func (w *WriteBuffer) Flush() error {
+	w.lock.Lock()
+	b := w.take()
+	w.lock.Unlock()
+	if b == nil {
+		return nil
+	}
+	return write(*b)
+}
+
+// Close flushes any buffered requests; later writes return ErrBufferClosed.
+func (w *WriteBuffer) Close() error {
+	w.lock.Lock()
+	w.closed = true
+	w.lock.Unlock()
+	return w.Flush()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package batch_write_item
+
+import (
+	"fmt"
+	"github.com/smugmug/godynamo/auth_v4"
+)
+
+// Oversize selects what DoBatchWriteWith does with a BatchWriteItem of more than QUERY_LIM
+// requests.
+type Oversize int
+
+const (
+	// split the request into conforming chunks, as DoBatchWrite does
+	OVERSIZE_SPLIT Oversize = iota
+	// return a *TooLargeError without sending anything
+	OVERSIZE_FAIL
+)
+
+// TooLargeError is returned by DoBatchWriteWith under OVERSIZE_FAIL.
+type TooLargeError struct {
+	Requests int
+	Limit int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("batch_write_item: %d requests exceed the limit of %d per BatchWriteItem",
+		e.Requests,e.Limit)
+}
+
+// Len is the number of put and delete requests in b, over all tables.
+func (b BatchWriteItem) Len() int {
+	n := 0
+	for _,ris := range b.RequestItems {
+		n += len(ris)
+	}
+	return n
+}
+
+// DoBatchWriteWith sends b, treating a b over the AWS limit as oversize directs. Under
+// OVERSIZE_SPLIT the chunks are sent independently, so some may be written while others
+// fail; a warning is logged to that effect, since callers that need all-or-nothing
+// semantics should use OVERSIZE_FAIL and handle the error instead.
+func (b BatchWriteItem) DoBatchWriteWith(oversize Oversize) (string,int,error) {
+	n := b.Len()
+	if n <= QUERY_LIM {
+		return b.DoBatchWrite()
+	}
+	if oversize == OVERSIZE_FAIL {
+		return "",0,&TooLargeError{Requests:n,Limit:QUERY_LIM}
+	}
+	auth_v4.Logf("batch_write_item.DoBatchWriteWith: WARNING splitting %d requests into "+
+		"chunks of %d; each chunk succeeds or fails on its own\n",n,QUERY_LIM)
+	return b.DoBatchWrite()
+}
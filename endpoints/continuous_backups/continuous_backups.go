@@ -0,0 +1,89 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Support for the DynamoDB DescribeContinuousBackups endpoint, which reports whether
+// point-in-time recovery is enabled on a table.
+package continuous_backups
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+const (
+	DESCRIBE_ENDPOINT_NAME = "DescribeContinuousBackups"
+	DESCRIBE_ENDPOINT      = aws_const.ENDPOINT_PREFIX + DESCRIBE_ENDPOINT_NAME
+	ENABLED                = "ENABLED"
+	DISABLED               = "DISABLED"
+)
+
+type Describe struct {
+	TableName string
+}
+
+type PointInTimeRecoveryDescription struct {
+	PointInTimeRecoveryStatus string
+	EarliestRestorableDateTime float64
+	LatestRestorableDateTime float64
+}
+
+type DescribeResponse struct {
+	ContinuousBackupsDescription struct {
+		ContinuousBackupsStatus string
+		PointInTimeRecoveryDescription PointInTimeRecoveryDescription
+	}
+}
+
+// EndpointReq implements the Endpoint interface.
+func (d Describe) EndpointReq() (string,int,error) {
+	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
+		e := fmt.Sprintf("continuous_backups(Describe).EndpointReq " +
+			"auth must be v4")
+		return "",0,errors.New(e)
+	}
+	return authreq.RetryReq_V4(&d,DESCRIBE_ENDPOINT)
+}
+
+// PointInTimeRecovery returns the point-in-time recovery status of tablename, ENABLED
+// or DISABLED.
+func PointInTimeRecovery(tablename string) (string,error) {
+	body,code,err := Describe{TableName:tablename}.EndpointReq()
+	if err != nil {
+		e := fmt.Sprintf("continuous_backups.PointInTimeRecovery: %s",err.Error())
+		return "",errors.New(e)
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("continuous_backups.PointInTimeRecovery: code %d: %s",code,body)
+		return "",errors.New(e)
+	}
+	var r DescribeResponse
+	if um_err := json.Unmarshal([]byte(body),&r); um_err != nil {
+		e := fmt.Sprintf("continuous_backups.PointInTimeRecovery: cannot unmarshal: %s",um_err.Error())
+		return "",errors.New(e)
+	}
+	return r.ContinuousBackupsDescription.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus,nil
+}
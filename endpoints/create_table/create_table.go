@@ -49,6 +49,7 @@ type Create struct {
 	AttributeDefinitions ep.AttributeDefinitions
 	KeySchema ep.KeySchema
 	LocalSecondaryIndexes ep.LocalSecondaryIndexes
+	GlobalSecondaryIndexes ep.GlobalSecondaryIndexes
 	ProvisionedThroughput ep.ProvisionedThroughput
 }
 
@@ -101,6 +102,7 @@ func (c Create) MarshalJSON() ([]byte, error) {
 	ci.KeySchema = c.KeySchema
 	ci.AttributeDefinitions  = c.AttributeDefinitions
 	ci.LocalSecondaryIndexes = c.LocalSecondaryIndexes
+	ci.GlobalSecondaryIndexes = c.GlobalSecondaryIndexes
 	ci.ProvisionedThroughput = c.ProvisionedThroughput
 	return json.Marshal(ci)
 }
@@ -27,6 +27,8 @@ package create_table
 import (
 	"testing"
 	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 )
 
 func TestRequestMarshal(t *testing.T) {
@@ -169,3 +171,25 @@ func TestResponseMarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestSchemaDiff(t *testing.T) {
+	c := NewCreate()
+	c.TableName = "Thread"
+	c.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"ForumName",KeyType:ep.HASH}}
+	c.AttributeDefinitions = ep.AttributeDefinitions{ep.AttributeDefinition{AttributeName:"ForumName",AttributeType:ep.S}}
+	g := ep.NewGlobalSecondaryIndex()
+	g.IndexName = "SubjectIndex"
+	g.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"Subject",KeyType:ep.HASH}}
+	g.Projection.ProjectionType = ep.ALL
+	c.GlobalSecondaryIndexes = ep.GlobalSecondaryIndexes{*g}
+	var live desc.TableDescription
+	live.KeySchema = c.KeySchema
+	live.AttributeDefinitions = ep.AttributeDefinitions{ep.AttributeDefinition{AttributeName:"ForumName",AttributeType:ep.N}}
+	diffs := SchemaDiff(*c,live)
+	if len(diffs) != 2 {
+		t.Errorf("expected an attribute type and a missing index diff, got %v\n",diffs)
+	}
+	if _,jerr := json.Marshal(c); jerr != nil {
+		t.Errorf("cannot marshal: %s\n",jerr.Error())
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package create_table
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	ttl "github.com/smugmug/godynamo/endpoints/time_to_live"
+)
+
+const (
+	// tries made for a newly created table to become ACTIVE
+	ENSURE_ACTIVE_TRIES = 60
+)
+
+// SchemaError lists each way a live table differs from its declared schema.
+type SchemaError struct {
+	TableName string
+	Diffs []string
+}
+
+func (s *SchemaError) Error() string {
+	return fmt.Sprintf("create_table.EnsureSchema: table %s does not match its schema:\n\t%s",
+		s.TableName,strings.Join(s.Diffs,"\n\t"))
+}
+
+// EnsureSchema verifies at startup that the table declared by c exists with the declared
+// key schema, key attribute types, and secondary indexes, and, if ttl_attribute is not
+// "", that TTL is enabled on that attribute. Differences are returned as a *SchemaError
+// listing each of them. Provisioned throughput is not compared. If the table does not
+// exist and create is true, it is created (and its TTL enabled) and EnsureSchema waits
+// for it to become ACTIVE.
+func EnsureSchema(c Create,ttl_attribute string,create bool) error {
+	exists,exists_err := desc.Describe{TableName:c.TableName}.TableExists()
+	if exists_err != nil {
+		e := fmt.Sprintf("create_table.EnsureSchema: %s",exists_err.Error())
+		return errors.New(e)
+	}
+	if !exists {
+		if !create {
+			return &SchemaError{TableName:c.TableName,Diffs:[]string{"table does not exist"}}
+		}
+		return createFor(c,ttl_attribute)
+	}
+	t,t_err := desc.DescribeTable(c.TableName)
+	if t_err != nil {
+		e := fmt.Sprintf("create_table.EnsureSchema: %s",t_err.Error())
+		return errors.New(e)
+	}
+	diffs := SchemaDiff(c,*t)
+	if ttl_attribute != "" {
+		live_ttl,ttl_err := ttl.Attribute(c.TableName)
+		if ttl_err != nil {
+			e := fmt.Sprintf("create_table.EnsureSchema: %s",ttl_err.Error())
+			return errors.New(e)
+		}
+		if live_ttl != ttl_attribute {
+			diffs = append(diffs,fmt.Sprintf("TTL attribute: declared %q, live %q",
+				ttl_attribute,live_ttl))
+		}
+	}
+	if len(diffs) != 0 {
+		return &SchemaError{TableName:c.TableName,Diffs:diffs}
+	}
+	return nil
+}
+
+func createFor(c Create,ttl_attribute string) error {
+	body,code,err := c.EndpointReq()
+	if err != nil || ep.HttpErr(code) {
+		e := fmt.Sprintf("create_table.EnsureSchema: create: code %d: %s %v",code,body,err)
+		return errors.New(e)
+	}
+	active,poll_err := desc.PollTableStatus(c.TableName,desc.ACTIVE,ENSURE_ACTIVE_TRIES)
+	if poll_err != nil {
+		e := fmt.Sprintf("create_table.EnsureSchema: %s",poll_err.Error())
+		return errors.New(e)
+	}
+	if !active {
+		e := fmt.Sprintf("create_table.EnsureSchema: table %s did not become ACTIVE",c.TableName)
+		return errors.New(e)
+	}
+	if ttl_attribute != "" {
+		u := ttl.Update{TableName:c.TableName,
+			TimeToLiveSpecification:ttl.TimeToLiveSpecification{AttributeName:ttl_attribute,Enabled:true}}
+		body,code,err := u.EndpointReq()
+		if err != nil || ep.HttpErr(code) {
+			e := fmt.Sprintf("create_table.EnsureSchema: enable TTL: code %d: %s %v",code,body,err)
+			return errors.New(e)
+		}
+	}
+	return nil
+}
+
+func keySchemaString(k ep.KeySchema) string {
+	parts := make([]string,0,len(k))
+	for _,d := range k {
+		parts = append(parts,d.AttributeName + ":" + d.KeyType)
+	}
+	return strings.Join(parts,",")
+}
+
+// SchemaDiff describes each way the live table t differs from the declared table c.
+func SchemaDiff(c Create,t desc.TableDescription) []string {
+	diffs := make([]string,0)
+	if want,have := keySchemaString(c.KeySchema),keySchemaString(t.KeySchema); want != have {
+		diffs = append(diffs,fmt.Sprintf("key schema: declared %s, live %s",want,have))
+	}
+	for _,a := range c.AttributeDefinitions {
+		if have := t.AttributeType(a.AttributeName); have != a.AttributeType {
+			diffs = append(diffs,fmt.Sprintf("attribute %s: declared type %s, live %q",
+				a.AttributeName,a.AttributeType,have))
+		}
+	}
+	for _,l := range c.LocalSecondaryIndexes {
+		if !t.HasLSI(l.IndexName) {
+			diffs = append(diffs,fmt.Sprintf("local secondary index %s: missing",l.IndexName))
+		}
+	}
+	for _,g := range c.GlobalSecondaryIndexes {
+		live := t.GSI(g.IndexName)
+		if live == nil {
+			diffs = append(diffs,fmt.Sprintf("global secondary index %s: missing",g.IndexName))
+			continue
+		}
+		if want,have := keySchemaString(g.KeySchema),keySchemaString(live.KeySchema); want != have {
+			diffs = append(diffs,fmt.Sprintf("global secondary index %s: declared keys %s, live %s",
+				g.IndexName,want,have))
+		}
+		if g.Projection.ProjectionType != live.Projection.ProjectionType {
+			diffs = append(diffs,fmt.Sprintf("global secondary index %s: declared projection %s, live %s",
+				g.IndexName,g.Projection.ProjectionType,live.Projection.ProjectionType))
+		}
+	}
+	return diffs
+}
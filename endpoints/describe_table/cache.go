@@ -0,0 +1,130 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package describe_table
+
+import (
+	"sync"
+	"time"
+	"context"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// how long Cached reuses a TableDescription, unless the conf file sets another
+	CACHE_TTL = 5 * time.Minute
+	// how long Cached returns the error of a DescribeTable that failed before trying again,
+	// so that callers not allowed to describe a table do not try on every request
+	CACHE_ERROR_TTL = 30 * time.Second
+)
+
+// a TableDescription, or the error describing the table failed with
+type cached struct {
+	t *TableDescription
+	err error
+	at time.Time
+}
+
+// descriptions are cached per configuration (see conf.WithName), as a table name can be
+// that of different tables in different accounts and regions
+type cacheKey struct {
+	name string
+	table string
+}
+
+var cache struct {
+	lock sync.Mutex
+	m map[cacheKey] cached
+}
+
+// Cached returns the TableDescription of tablename, calling DescribeTable at most once per
+// CACHE_TTL (or the cache.table_description_ttl of the conf file). A failure is returned
+// again for CACHE_ERROR_TTL. It is for checks against a table's schema, which changes
+// rarely; use DescribeTable for status and counts.
+func Cached(tablename string) (*TableDescription,error) {
+	return CachedContext(context.Background(),tablename)
+}
+
+// CachedContext is Cached for the configuration requests made with ctx use.
+func CachedContext(ctx context.Context,tablename string) (*TableDescription,error) {
+	k := cacheKey{name:conf.NameFrom(ctx),table:tablename}
+	cache.lock.Lock()
+	c,ok := cache.m[k]
+	cache.lock.Unlock()
+	if ok && c.err != nil && time.Since(c.at) < CACHE_ERROR_TTL {
+		return nil,c.err
+	}
+	if ok && c.err == nil && time.Since(c.at) < cacheTTL(ctx) {
+		return c.t,nil
+	}
+	t,err := DescribeTableContext(ctx,tablename)
+	if err != nil && ctx.Err() != nil {
+		// the caller gave up; that says nothing of the table
+		return nil,err
+	}
+	cache.lock.Lock()
+	if cache.m == nil {
+		cache.m = make(map[cacheKey] cached)
+	}
+	cache.m[k] = cached{t:t,err:err,at:time.Now()}
+	cache.lock.Unlock()
+	return t,err
+}
+
+// cacheTTL returns how long CachedContext reuses a TableDescription.
+func cacheTTL(ctx context.Context) time.Duration {
+	c := conf.FromContext(ctx)
+	if c == nil {
+		return CACHE_TTL
+	}
+	c.ConfLock.RLock()
+	ttl := c.Cache.TableDescriptionTTL
+	c.ConfLock.RUnlock()
+	if ttl <= 0 {
+		return CACHE_TTL
+	}
+	return ttl
+}
+
+// Invalidate removes tablename from the cache used by Cached, for every configuration.
+func Invalidate(tablename string) {
+	cache.lock.Lock()
+	for k,_ := range cache.m {
+		if k.table == tablename {
+			delete(cache.m,k)
+		}
+	}
+	cache.lock.Unlock()
+}
+
+// Prime adds t to the cache used by Cached, as if it had just been described, such as with
+// the description returned by CreateTable.
+func Prime(t TableDescription) {
+	cache.lock.Lock()
+	if cache.m == nil {
+		cache.m = make(map[cacheKey] cached)
+	}
+	cache.m[cacheKey{table:t.TableName}] = cached{t:&t,at:time.Now()}
+	cache.lock.Unlock()
+}
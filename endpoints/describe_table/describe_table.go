@@ -33,6 +33,7 @@ package describe_table
 import (
 	"fmt"
 	"time"
+	"context"
 	"net/http"
 	"encoding/json"
 	"errors"
@@ -53,18 +54,53 @@ type Describe struct {
 
 type Request Describe
 
-type Response struct {
-	Table struct {
-		AttributeDefinitions ep.AttributeDefinitions
-		CreationDateTime float64
-		ItemCount uint64
-		KeySchema ep.KeySchema
-		LocalSecondaryIndexes []ep.LocalSecondaryIndex
-		ProvisionedThroughput ep.ProvisionedThroughputDesc
-		TableName string
-		TableSizeBytes uint64
-		TableStatus string
+// GlobalSecondaryIndexDescription describes a global secondary index of a table.
+type GlobalSecondaryIndexDescription struct {
+	IndexName string
+	IndexSizeBytes uint64
+	IndexStatus string
+	ItemCount uint64
+	KeySchema ep.KeySchema
+	Projection struct {
+		NonKeyAttributes []string
+		ProjectionType string
 	}
+	ProvisionedThroughput ep.ProvisionedThroughputDesc
+}
+
+// StreamSpecification describes the stream settings of a table.
+type StreamSpecification struct {
+	StreamEnabled bool
+	StreamViewType string
+}
+
+// BillingModeSummary describes the capacity mode of a table. Tables created before
+// on-demand capacity existed may have no summary; they are PROVISIONED.
+type BillingModeSummary struct {
+	BillingMode string
+	LastUpdateToPayPerRequestDateTime float64
+}
+
+// TableDescription is the Table of a DescribeTable response.
+type TableDescription struct {
+	AttributeDefinitions ep.AttributeDefinitions
+	BillingModeSummary BillingModeSummary
+	CreationDateTime float64
+	GlobalSecondaryIndexes []GlobalSecondaryIndexDescription
+	ItemCount uint64
+	KeySchema ep.KeySchema
+	LatestStreamArn string
+	LocalSecondaryIndexes []ep.LocalSecondaryIndex
+	ProvisionedThroughput ep.ProvisionedThroughputDesc
+	StreamSpecification StreamSpecification
+	TableArn string
+	TableName string
+	TableSizeBytes uint64
+	TableStatus string
+}
+
+type Response struct {
+	Table TableDescription
 }
 
 // NewResponse returns a pointer to an instantiation of the local Response struct.
@@ -160,3 +196,90 @@ func (desc Describe) EndpointReq() (string,int,error) {
 func (req Request) EndpointReq() (string,int,error) {
 	return (Describe(req)).EndpointReq()
 }
+
+// KeyAttributeNames returns the names of the hash key and range key of the table.
+// The range key name is "" if the table has only a hash key.
+func (t TableDescription) KeyAttributeNames() (string,string) {
+	var hash,rangekey string
+	for _,k := range t.KeySchema {
+		switch k.KeyType {
+		case ep.HASH:
+			hash = k.AttributeName
+		case ep.RANGE:
+			rangekey = k.AttributeName
+		}
+	}
+	return hash,rangekey
+}
+
+// AttributeType returns the declared type of a key attribute, or "" if it is not declared.
+func (t TableDescription) AttributeType(name string) string {
+	for _,a := range t.AttributeDefinitions {
+		if a.AttributeName == name {
+			return a.AttributeType
+		}
+	}
+	return ""
+}
+
+// HasGSI determines if the table has a global secondary index with this name.
+func (t TableDescription) HasGSI(name string) bool {
+	return t.GSI(name) != nil
+}
+
+// GSI returns the global secondary index with this name, or nil.
+func (t TableDescription) GSI(name string) *GlobalSecondaryIndexDescription {
+	for i,_ := range t.GlobalSecondaryIndexes {
+		if t.GlobalSecondaryIndexes[i].IndexName == name {
+			return &t.GlobalSecondaryIndexes[i]
+		}
+	}
+	return nil
+}
+
+// HasLSI determines if the table has a local secondary index with this name.
+func (t TableDescription) HasLSI(name string) bool {
+	for _,l := range t.LocalSecondaryIndexes {
+		if l.IndexName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamArn returns the ARN of the latest stream of the table, or "" if streams are not enabled.
+func (t TableDescription) StreamArn() string {
+	if !t.StreamSpecification.StreamEnabled {
+		return ""
+	}
+	return t.LatestStreamArn
+}
+
+// IsActive determines if the table status is ACTIVE.
+func (t TableDescription) IsActive() bool {
+	return t.TableStatus == ACTIVE
+}
+
+// DescribeTable returns the TableDescription of tablename.
+func DescribeTable(tablename string) (*TableDescription,error) {
+	return DescribeTableContext(context.Background(),tablename)
+}
+
+// DescribeTableContext is DescribeTable for the configuration requests made with ctx use.
+func DescribeTableContext(ctx context.Context,tablename string) (*TableDescription,error) {
+	body,code,err := authreq.RetryReqContext_V4(ctx,&Describe{TableName:tablename},DESCTABLE_ENDPOINT)
+	if err != nil {
+		e := fmt.Sprintf("describe_table.DescribeTable: %s",err.Error())
+		return nil,errors.New(e)
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("describe_table.DescribeTable: code %d: %s",code,body)
+		return nil,errors.New(e)
+	}
+	r := NewResponse()
+	if um_err := json.Unmarshal([]byte(body),r); um_err != nil {
+		e := fmt.Sprintf("describe_table.DescribeTable: cannot unmarshal: %s",um_err.Error())
+		return nil,errors.New(e)
+	}
+	return &r.Table,nil
+}
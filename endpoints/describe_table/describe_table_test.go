@@ -25,8 +25,14 @@
 package describe_table
 
 import (
+	"context"
 	"testing"
+	"net/url"
+	"net/http"
+	"sync/atomic"
 	"encoding/json"
+	"net/http/httptest"
+	"github.com/smugmug/godynamo/conf"
 )
 
 func TestRequestMarshal(t *testing.T) {
@@ -123,3 +129,141 @@ func TestResponseMarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestTableDescriptionAccessors(t *testing.T) {
+	s := `{
+    "Table": {
+        "KeySchema": [
+            {"AttributeName": "ForumName","KeyType": "HASH"},
+            {"AttributeName": "Subject","KeyType": "RANGE"}
+        ],
+        "GlobalSecondaryIndexes": [
+            {
+                "IndexName": "SubjectIndex",
+                "IndexStatus": "ACTIVE",
+                "KeySchema": [{"AttributeName": "Subject","KeyType": "HASH"}],
+                "Projection": {"ProjectionType": "ALL"}
+            }
+        ],
+        "StreamSpecification": {"StreamEnabled": true,"StreamViewType": "NEW_IMAGE"},
+        "LatestStreamArn": "arn:aws:dynamodb:us-east-1:123456789012:table/Thread/stream/2015-05-11T21:21:33.291",
+        "TableName": "Thread",
+        "TableStatus": "ACTIVE"
+    }
+}`
+	r := NewResponse()
+	if um_err := json.Unmarshal([]byte(s),r); um_err != nil {
+		t.Fatalf("cannot unmarshal\n")
+	}
+	if hash,rangekey := r.Table.KeyAttributeNames(); hash != "ForumName" || rangekey != "Subject" {
+		t.Errorf("got keys %s %s\n",hash,rangekey)
+	}
+	if !r.Table.HasGSI("SubjectIndex") || r.Table.HasGSI("Other") {
+		t.Errorf("HasGSI is wrong\n")
+	}
+	if r.Table.StreamArn() == "" || !r.Table.IsActive() {
+		t.Errorf("stream or status is wrong\n")
+	}
+}
+
+func TestSchemaAccessors(t *testing.T) {
+	s := `{
+    "Table": {
+        "AttributeDefinitions": [
+            {"AttributeName": "ForumName","AttributeType": "S"},
+            {"AttributeName": "Views","AttributeType": "N"}
+        ],
+        "KeySchema": [{"AttributeName": "ForumName","KeyType": "HASH"}],
+        "GlobalSecondaryIndexes": [
+            {
+                "IndexName": "ViewsIndex",
+                "KeySchema": [{"AttributeName": "Views","KeyType": "HASH"}]
+            }
+        ],
+        "TableName": "Forum"
+    }
+}`
+	r := NewResponse()
+	if um_err := json.Unmarshal([]byte(s),r); um_err != nil {
+		t.Fatalf("cannot unmarshal\n")
+	}
+	if index,ok := r.Table.IndexFor("Views",""); !ok || index != "ViewsIndex" {
+		t.Errorf("got index %s %v\n",index,ok)
+	}
+	if index,ok := r.Table.IndexFor("ForumName",""); !ok || index != "" {
+		t.Errorf("got index %s %v for the table key\n",index,ok)
+	}
+	if _,ok := r.Table.IndexFor("Other",""); ok {
+		t.Errorf("found an index for an unknown key\n")
+	}
+	for _,n := range []string{"12.50","12345678901234567890","010"} {
+		if av,err := r.Table.KeyValue("Views",n); err != nil || av.N != n {
+			t.Errorf("got %v %v for %s\n",av,err,n)
+		}
+	}
+	for _,n := range []string{"many","0x10","1_000"} {
+		if _,err := r.Table.KeyValue("Views",n); err == nil {
+			t.Errorf("encoded %q as N\n",n)
+		}
+	}
+	if av,err := r.Table.KeyValue("ForumName","12"); err != nil || av.S != "12" {
+		t.Errorf("got %v %v\n",av,err)
+	}
+}
+
+// A table that cannot be described is not described again for CACHE_ERROR_TTL.
+func TestCachedError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		atomic.AddInt32(&calls,1)
+		w.Header().Set("X-Amzn-RequestId","test")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazon.coral.service#AccessDeniedException"}`))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	defer Invalidate("Denied")
+	for i := 0; i < 3; i++ {
+		if _,err := Cached("Denied"); err == nil {
+			t.Fatalf("described a table that cannot be\n")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d requests, want 1\n",n)
+	}
+	// once the failure has aged out, the table is described again
+	cache.lock.Lock()
+	k := cacheKey{table:"Denied"}
+	c := cache.m[k]
+	c.at = c.at.Add(-CACHE_ERROR_TTL)
+	cache.m[k] = c
+	cache.lock.Unlock()
+	Cached("Denied")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("%d requests after the failure aged out, want 2\n",n)
+	}
+	Invalidate("Denied")
+	Cached("Denied")
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("%d requests after Invalidate, want 3\n",n)
+	}
+	// a request abandoned by its caller is not a failure to remember
+	Invalidate("Denied")
+	ctx,cancel := context.WithCancel(context.Background())
+	cancel()
+	CachedContext(ctx,"Denied")
+	cache.lock.Lock()
+	_,ok := cache.m[k]
+	cache.lock.Unlock()
+	if ok {
+		t.Errorf("cancelled describe cached\n")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package describe_table
+
+import (
+	"fmt"
+	"sync"
+	"errors"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// Higher-level helpers (get_item.RegisterPattern and GetByPatternStrings, query.ForEachPage,
+// update_table.UpdateThroughput) fill in what a request omits, such as key names and types,
+// index names and billing mode, from the Cached description of its table.
+// SetSchemaDefaults(false) turns this off, so that requests are sent as given.
+
+var schemaDefaults struct {
+	lock sync.RWMutex
+	off bool
+}
+
+// SetSchemaDefaults turns the filling in of omitted request fields from table schemas on
+// or off. It is on unless turned off.
+func SetSchemaDefaults(on bool) {
+	schemaDefaults.lock.Lock()
+	schemaDefaults.off = !on
+	schemaDefaults.lock.Unlock()
+}
+
+// SchemaDefaults reports if helpers fill in omitted request fields from table schemas.
+func SchemaDefaults() bool {
+	schemaDefaults.lock.RLock()
+	defer schemaDefaults.lock.RUnlock()
+	return !schemaDefaults.off
+}
+
+// IndexKeyAttributeNames returns the names of the hash key and range key of the named
+// index, or of the table if index is "". It returns false if there is no such index.
+func (t TableDescription) IndexKeyAttributeNames(index string) (string,string,bool) {
+	var ks ep.KeySchema
+	if index == "" {
+		ks = t.KeySchema
+	} else if g := t.GSI(index); g != nil {
+		ks = g.KeySchema
+	} else {
+		for _,l := range t.LocalSecondaryIndexes {
+			if l.IndexName == index {
+				ks = l.KeySchema
+			}
+		}
+		if ks == nil {
+			return "","",false
+		}
+	}
+	var hash,rangekey string
+	for _,k := range ks {
+		switch k.KeyType {
+		case ep.HASH:
+			hash = k.AttributeName
+		case ep.RANGE:
+			rangekey = k.AttributeName
+		}
+	}
+	return hash,rangekey,true
+}
+
+// IndexFor returns the name of the index keyed on hash, and on rangekey unless it is "",
+// or "" if the table itself is. The table is preferred, then its local secondary indexes,
+// then its global ones. It returns false if none are keyed that way.
+func (t TableDescription) IndexFor(hash,rangekey string) (string,bool) {
+	names := []string{""}
+	for _,l := range t.LocalSecondaryIndexes {
+		names = append(names,l.IndexName)
+	}
+	for _,g := range t.GlobalSecondaryIndexes {
+		names = append(names,g.IndexName)
+	}
+	for _,name := range names {
+		h,r,_ := t.IndexKeyAttributeNames(name)
+		if h == hash && (rangekey == "" || r == rangekey) {
+			return name,true
+		}
+	}
+	return "",false
+}
+
+// KeyValue encodes value, the string form of a value of the key attribute name, as the
+// declared type of the attribute: a number for N, base64 data for B, and a string for S.
+func (t TableDescription) KeyValue(name,value string) (ep.AttributeValue,error) {
+	return EncodeKeyValue(t.AttributeType(name),name,value)
+}
+
+// EncodeKeyValue encodes value, the string form of a value of the key attribute name, as
+// attribute type typ (ep.S, ep.N or ep.B).
+func EncodeKeyValue(typ,name,value string) (ep.AttributeValue,error) {
+	switch typ {
+	case ep.S:
+		return ep.AttributeValue{S:value},nil
+	case ep.N:
+		// sent as written, so that the item named is the one read or written
+		if n_err := ep.ValidNumber(value); n_err != nil {
+			e := fmt.Sprintf("describe_table.EncodeKeyValue: %s is a number, not %q",name,value)
+			return ep.AttributeValue{},errors.New(e)
+		}
+		return ep.AttributeValue{N:value},nil
+	case ep.B:
+		if b_err := ep.AWSParseBinary(value); b_err != nil {
+			e := fmt.Sprintf("describe_table.EncodeKeyValue: %s is binary, %q is not base64",name,value)
+			return ep.AttributeValue{},errors.New(e)
+		}
+		return ep.AttributeValue{B:value},nil
+	}
+	e := fmt.Sprintf("describe_table.EncodeKeyValue: %s has no key type",name)
+	return ep.AttributeValue{},errors.New(e)
+}
@@ -32,6 +32,7 @@ package get_item
 
 import (
 	"fmt"
+	"time"
 	"errors"
 	"encoding/json"
 	"github.com/smugmug/godynamo/authreq"
@@ -96,3 +97,12 @@ func (get Get) EndpointReq() (string,int,error) {
 func (req Request) EndpointReq() (string,int,error) {
 	return (Get(req)).EndpointReq()
 }
+
+// Live returns the Item of the response, or nil if it is absent or its TTL attribute
+// `ttl_attr` has passed but DynamoDB has not yet deleted it.
+func (r *Response) Live(ttl_attr string) ep.Item {
+	if len(r.Item) == 0 || r.Item.Expired(ttl_attr,time.Now()) {
+		return nil
+	}
+	return r.Item
+}
@@ -25,8 +25,11 @@
 package get_item
 
 import (
+	"time"
 	"testing"
 	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 )
 
 func TestRequestMarshal(t *testing.T) {
@@ -91,3 +94,64 @@ func TestResponseMarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestPatternMarshal(t *testing.T) {
+	p := Pattern{TableName:"Thread",KeyNames:[]string{"ForumName","Subject"},
+		AttributesToGet:[]string{"Message"},ConsistentRead:true}
+	if err := RegisterPattern("thread",p); err != nil {
+		t.Fatalf("cannot register: %s\n",err.Error())
+	}
+	desc.SetSchemaDefaults(false)
+	if err := RegisterPattern("bad",Pattern{TableName:"Thread"}); err == nil {
+		t.Errorf("pattern without keys should not register\n")
+	}
+	desc.SetSchemaDefaults(true)
+	pg := patternGet{t:patterns.m["thread"],
+		key:[]ep.AttributeValue{ep.AttributeValue{S:"DynamoDB"},ep.AttributeValue{S:"Help"}}}
+	b,jerr := json.Marshal(pg)
+	if jerr != nil {
+		t.Fatalf("cannot marshal: %s\n",jerr.Error())
+	}
+	var g Get
+	if um_err := json.Unmarshal(b,&g); um_err != nil {
+		t.Fatalf("cannot unmarshal template output:\n" + string(b) + "\n")
+	}
+	if g.TableName != "Thread" || !g.ConsistentRead || len(g.AttributesToGet) != 1 ||
+		g.Key["ForumName"].S != "DynamoDB" || g.Key["Subject"].S != "Help" {
+		t.Errorf("unexpected request %s\n",string(b))
+	}
+}
+
+func TestPatternSchemaDefaults(t *testing.T) {
+	var td desc.TableDescription
+	td.TableName = "Reply"
+	td.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"Id",KeyType:ep.HASH},
+		ep.KeyDefinition{AttributeName:"ReplyDateTime",KeyType:ep.RANGE}}
+	td.AttributeDefinitions = ep.AttributeDefinitions{
+		ep.AttributeDefinition{AttributeName:"Id",AttributeType:ep.S},
+		ep.AttributeDefinition{AttributeName:"ReplyDateTime",AttributeType:ep.N}}
+	desc.Prime(td)
+	if err := RegisterPattern("reply",Pattern{TableName:"Reply"}); err != nil {
+		t.Fatalf("cannot register: %s\n",err.Error())
+	}
+	tp := patterns.m["reply"]
+	if len(tp.key_names) != 2 || tp.key_names[0] != "Id" || tp.key_names[1] != "ReplyDateTime" {
+		t.Errorf("key names not taken from the schema: %v\n",tp.key_names)
+	}
+	if _,_,err := GetByPatternStrings("reply","a","not a number"); err == nil {
+		t.Errorf("a non-numeric value for an N key should not encode\n")
+	}
+}
+
+func TestResponseLive(t *testing.T) {
+	r := NewResponse()
+	r.Item["id"] = ep.AttributeValue{S:"a"}
+	r.Item["expires"] = ep.TTLAfter(-time.Minute)
+	if r.Live("expires") != nil {
+		t.Errorf("expired item should not be live\n")
+	}
+	r.Item["expires"] = ep.TTLAfter(time.Hour)
+	if r.Live("expires") == nil || r.Live("other") == nil {
+		t.Errorf("unexpired item should be live\n")
+	}
+}
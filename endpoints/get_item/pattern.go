@@ -0,0 +1,246 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package get_item
+
+import (
+	"fmt"
+	"sync"
+	"bytes"
+	"errors"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+)
+
+// An access pattern names a point read that an application makes repeatedly: a table, the
+// names of its key attributes, and a projection. Registering the pattern validates it once
+// and renders everything but the key values into a request template, so each GetByPattern
+// call only marshals the key.
+
+// Pattern describes a registered access pattern.
+type Pattern struct {
+	TableName string
+	// the hash key name, followed by the range key name for tables that have one;
+	// taken from the table's schema if omitted (see describe_table.SchemaDefaults)
+	KeyNames []string
+	// the types (ep.S, ep.N or ep.B) of KeyNames, for GetByPatternStrings; taken
+	// from the table's schema if omitted
+	KeyTypes []string
+	AttributesToGet ep.AttributesToGet
+	ConsistentRead bool
+	ReturnConsumedCapacity ep.ReturnConsumedCapacity
+}
+
+type template struct {
+	table string
+	key_names []string
+	key_types []string
+	// the request body before and after the key object
+	prefix []byte
+	suffix []byte
+}
+
+var patterns struct {
+	lock sync.RWMutex
+	m map[string] *template
+}
+
+// RegisterPattern validates p and stores it under name, replacing any pattern already
+// registered with that name.
+func RegisterPattern(name string,p Pattern) error {
+	if p.TableName == "" {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: no TableName",name)
+		return errors.New(e)
+	}
+	if len(p.KeyNames) == 0 && desc.SchemaDefaults() {
+		t,t_err := desc.Cached(p.TableName)
+		if t_err != nil {
+			e := fmt.Sprintf("get_item.RegisterPattern: %s: %s",name,t_err.Error())
+			return errors.New(e)
+		}
+		hash,rangekey := t.KeyAttributeNames()
+		p.KeyNames = []string{hash}
+		if rangekey != "" {
+			p.KeyNames = append(p.KeyNames,rangekey)
+		}
+	}
+	if len(p.KeyTypes) != 0 && len(p.KeyTypes) != len(p.KeyNames) {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: %d KeyTypes for %d KeyNames",
+			name,len(p.KeyTypes),len(p.KeyNames))
+		return errors.New(e)
+	}
+	if len(p.KeyNames) != 1 && len(p.KeyNames) != 2 {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: need one or two KeyNames, have %d",
+			name,len(p.KeyNames))
+		return errors.New(e)
+	}
+	if len(p.KeyNames) == 2 && p.KeyNames[0] == p.KeyNames[1] {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: duplicate key name %s",name,p.KeyNames[0])
+		return errors.New(e)
+	}
+	for _,k := range p.KeyNames {
+		if k == "" {
+			e := fmt.Sprintf("get_item.RegisterPattern: %s: empty key name",name)
+			return errors.New(e)
+		}
+	}
+	// render the request with a placeholder key, then split the body around it
+	g := NewGet()
+	g.TableName = p.TableName
+	g.AttributesToGet = p.AttributesToGet
+	g.ConsistentRead = p.ConsistentRead
+	g.ReturnConsumedCapacity = p.ReturnConsumedCapacity
+	g.Key = nil
+	body,json_err := json.Marshal(Request(*g))
+	if json_err != nil {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: %s",name,json_err.Error())
+		return errors.New(e)
+	}
+	marker := []byte(`"Key":null`)
+	i := bytes.Index(body,marker)
+	if i < 0 {
+		e := fmt.Sprintf("get_item.RegisterPattern: %s: cannot build template",name)
+		return errors.New(e)
+	}
+	t := &template{table:p.TableName,key_names:append([]string{},p.KeyNames...),
+		key_types:append([]string{},p.KeyTypes...)}
+	t.prefix = append([]byte{},body[:i + len(`"Key":`)]...)
+	t.suffix = append([]byte{},body[i + len(marker):]...)
+	patterns.lock.Lock()
+	if patterns.m == nil {
+		patterns.m = make(map[string] *template)
+	}
+	patterns.m[name] = t
+	patterns.lock.Unlock()
+	return nil
+}
+
+// patternGet is the Endpoint for a request built from a template.
+type patternGet struct {
+	t *template
+	key []ep.AttributeValue
+}
+
+func (pg patternGet) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(pg.t.prefix)
+	b.WriteByte('{')
+	for i,k := range pg.t.key_names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		kb,_ := json.Marshal(k)
+		b.Write(kb)
+		b.WriteByte(':')
+		vb,v_err := json.Marshal(pg.key[i])
+		if v_err != nil {
+			return nil,v_err
+		}
+		b.Write(vb)
+	}
+	b.WriteByte('}')
+	b.Write(pg.t.suffix)
+	return b.Bytes(),nil
+}
+
+// EndpointReq implements the Endpoint interface.
+func (pg patternGet) EndpointReq() (string,int,error) {
+	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
+		e := fmt.Sprintf("get_item(patternGet).EndpointReq " +
+			"auth must be v4")
+		return "",0,errors.New(e)
+	}
+	return authreq.RetryReq_V4(&pg,GETITEM_ENDPOINT)
+}
+
+// GetByPattern makes the GetItem request of the named pattern for the given key values,
+// which are in the order of the pattern's KeyNames. It returns resp_body,code,err like
+// EndpointReq.
+func GetByPattern(name string,key ...ep.AttributeValue) (string,int,error) {
+	patterns.lock.RLock()
+	t,ok := patterns.m[name]
+	patterns.lock.RUnlock()
+	if !ok {
+		e := fmt.Sprintf("get_item.GetByPattern: no pattern %s",name)
+		return "",0,errors.New(e)
+	}
+	if len(key) != len(t.key_names) {
+		e := fmt.Sprintf("get_item.GetByPattern: %s: need %d key values, have %d",
+			name,len(t.key_names),len(key))
+		return "",0,errors.New(e)
+	}
+	for i,k := range key {
+		if k.Empty() {
+			e := fmt.Sprintf("get_item.GetByPattern: %s: empty value for key %s",
+				name,t.key_names[i])
+			return "",0,errors.New(e)
+		}
+	}
+	return patternGet{t:t,key:key}.EndpointReq()
+}
+
+// GetByPatternStrings is GetByPattern for key values in string form, which are encoded as
+// the KeyTypes of the pattern, or the types of the table's schema if it has none.
+func GetByPatternStrings(name string,values ...string) (string,int,error) {
+	patterns.lock.RLock()
+	t,ok := patterns.m[name]
+	patterns.lock.RUnlock()
+	if !ok {
+		e := fmt.Sprintf("get_item.GetByPatternStrings: no pattern %s",name)
+		return "",0,errors.New(e)
+	}
+	if len(values) != len(t.key_names) {
+		e := fmt.Sprintf("get_item.GetByPatternStrings: %s: need %d key values, have %d",
+			name,len(t.key_names),len(values))
+		return "",0,errors.New(e)
+	}
+	types := t.key_types
+	if len(types) == 0 {
+		if !desc.SchemaDefaults() {
+			e := fmt.Sprintf("get_item.GetByPatternStrings: %s: no KeyTypes",name)
+			return "",0,errors.New(e)
+		}
+		td,td_err := desc.Cached(t.table)
+		if td_err != nil {
+			e := fmt.Sprintf("get_item.GetByPatternStrings: %s: %s",name,td_err.Error())
+			return "",0,errors.New(e)
+		}
+		types = make([]string,len(t.key_names))
+		for i,k := range t.key_names {
+			types[i] = td.AttributeType(k)
+		}
+	}
+	key := make([]ep.AttributeValue,len(values))
+	for i,v := range values {
+		av,av_err := desc.EncodeKeyValue(types[i],t.key_names[i],v)
+		if av_err != nil {
+			e := fmt.Sprintf("get_item.GetByPatternStrings: %s: %s",name,av_err.Error())
+			return "",0,errors.New(e)
+		}
+		key[i] = av
+	}
+	return GetByPattern(name,key...)
+}
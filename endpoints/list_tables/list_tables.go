@@ -32,6 +32,9 @@ package list_tables
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"net/http"
 	"encoding/json"
 	"errors"
 	"github.com/smugmug/godynamo/authreq"
@@ -105,3 +108,43 @@ func (list List) EndpointReq() (string,int,error) {
 func (req Request) EndpointReq() (string,int,error) {
 	return (List(req)).EndpointReq()
 }
+
+// ListAll pages through ListTables (following LastEvaluatedTableName) and returns the
+// names of all tables that begin with prefix and, if re is not nil, match re.
+// An empty prefix and nil re return every table.
+func ListAll(prefix string,re *regexp.Regexp) ([]string,error) {
+	names := make([]string,0)
+	var l List
+	for {
+		body,code,err := l.EndpointReq()
+		if err != nil {
+			e := fmt.Sprintf("list_tables.ListAll: %s",err.Error())
+			return nil,errors.New(e)
+		}
+		if code != http.StatusOK {
+			e := fmt.Sprintf("list_tables.ListAll: code %d: %s",code,body)
+			return nil,errors.New(e)
+		}
+		r := NewResponse()
+		if um_err := json.Unmarshal([]byte(body),r); um_err != nil {
+			e := fmt.Sprintf("list_tables.ListAll: cannot unmarshal: %s",um_err.Error())
+			return nil,errors.New(e)
+		}
+		names = append(names,Filter(r.TableNames,prefix,re)...)
+		if r.LastEvaluatedTableName == "" {
+			return names,nil
+		}
+		l.ExclusiveStartTableName = ep.NullableString(r.LastEvaluatedTableName)
+	}
+}
+
+// Filter returns the names that begin with prefix and, if re is not nil, match re.
+func Filter(names []string,prefix string,re *regexp.Regexp) []string {
+	matched := make([]string,0,len(names))
+	for _,name := range names {
+		if strings.HasPrefix(name,prefix) && (re == nil || re.MatchString(name)) {
+			matched = append(matched,name)
+		}
+	}
+	return matched
+}
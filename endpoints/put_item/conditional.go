@@ -0,0 +1,87 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package put_item
+
+import (
+	"fmt"
+	"errors"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// keyExpected builds the Expected conditions on the key attributes of item. With exists
+// false the put only succeeds if there is no item with that key; with exists true it only
+// succeeds if there is one.
+func keyExpected(item ep.Item,exists bool,keynames []string) (ep.Expected,error) {
+	if len(keynames) == 0 {
+		return nil,errors.New("no key names")
+	}
+	expected := make(ep.Expected)
+	for _,k := range keynames {
+		v,ok := item[k]
+		if !ok || v.Empty() {
+			e := fmt.Sprintf("item has no value for key %s",k)
+			return nil,errors.New(e)
+		}
+		if exists {
+			expected[k] = ep.Constraints{Value:v,Exists:true}
+		} else {
+			expected[k] = ep.Constraints{Exists:false}
+		}
+	}
+	return expected,nil
+}
+
+// PutIfNotExists puts item only if no item with the same key exists. `keynames` are the
+// names of the hash key and (if any) range key of the table. A put that fails because the
+// item exists returns code 400 with a body for which ConditionalFailed is true.
+func PutIfNotExists(tablename string,item ep.Item,keynames ...string) (string,int,error) {
+	expected,k_err := keyExpected(item,false,keynames)
+	if k_err != nil {
+		e := fmt.Sprintf("put_item.PutIfNotExists: %s",k_err.Error())
+		return "",0,errors.New(e)
+	}
+	p := NewPut()
+	p.TableName = tablename
+	p.Item = item
+	p.Expected = expected
+	return p.EndpointReq()
+}
+
+// ReplaceIfExists puts item only if an item with the same key already exists. `keynames`
+// are the names of the hash key and (if any) range key of the table. A put that fails
+// because the item does not exist returns code 400 with a body for which ConditionalFailed
+// is true.
+func ReplaceIfExists(tablename string,item ep.Item,keynames ...string) (string,int,error) {
+	expected,k_err := keyExpected(item,true,keynames)
+	if k_err != nil {
+		e := fmt.Sprintf("put_item.ReplaceIfExists: %s",k_err.Error())
+		return "",0,errors.New(e)
+	}
+	p := NewPut()
+	p.TableName = tablename
+	p.Item = item
+	p.Expected = expected
+	return p.EndpointReq()
+}
@@ -32,6 +32,7 @@ package put_item
 
 import (
 	"fmt"
+	"time"
 	"errors"
 	"encoding/json"
 	"github.com/smugmug/godynamo/authreq"
@@ -114,3 +115,11 @@ func (req Request) EndpointReq() (string,int,error) {
 func ValidItem(i string) bool {
 	return !(len([]byte(i)) > 65536)
 }
+
+// Stamp maintains the timestamp attributes of ts on the Item to put.
+func (p *Put) Stamp(ts ep.Timestamps) {
+	if p.Item == nil {
+		p.Item = make(ep.Item)
+	}
+	ts.StampItem(p.Item,time.Now())
+}
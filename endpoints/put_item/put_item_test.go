@@ -28,6 +28,7 @@ package put_item
 import (
 	"testing"
 	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
 )
 
 
@@ -119,3 +120,40 @@ func TestResponseMarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestExpectedFrom(t *testing.T) {
+	key := ep.Item{"id":ep.AttributeValue{S:"a"}}
+	absent := expectedFrom(key,ep.Item{})
+	if b,_ := json.Marshal(absent); string(b) != `{"id":{"Exists":false}}` {
+		t.Errorf("unexpected absent condition %s\n",string(b))
+	}
+	orig := ep.Item{"id":ep.AttributeValue{S:"a"},"n":ep.AttributeValue{N:"1"}}
+	b,jerr := json.Marshal(expectedFrom(key,orig))
+	if jerr != nil {
+		t.Errorf("cannot marshal\n")
+	}
+	if string(b) != `{"id":{"Value":{"S":"a"},"Exists":true},"n":{"Value":{"N":"1"},"Exists":true}}` {
+		t.Errorf("unexpected condition %s\n",string(b))
+	}
+}
+
+func TestKeyExpected(t *testing.T) {
+	item := ep.Item{"id":ep.AttributeValue{S:"a"},"n":ep.AttributeValue{N:"1"}}
+	if _,err := keyExpected(item,false,[]string{"id","missing"}); err == nil {
+		t.Errorf("missing key should be an error\n")
+	}
+	present,_ := keyExpected(item,true,[]string{"id"})
+	if b,_ := json.Marshal(present); string(b) != `{"id":{"Value":{"S":"a"},"Exists":true}}` {
+		t.Errorf("unexpected condition %s\n",string(b))
+	}
+}
+
+func TestStamp(t *testing.T) {
+	ts := ep.Timestamps{Created:"created",Updated:"updated"}
+	p := NewPut()
+	p.Item["created"] = ep.AttributeValue{N:"1"}
+	p.Stamp(ts)
+	if p.Item["created"].N != "1" || p.Item["updated"].N == "" {
+		t.Errorf("unexpected stamps %v\n",p.Item)
+	}
+}
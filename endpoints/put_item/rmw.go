@@ -0,0 +1,118 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package put_item
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/smugmug/godynamo/aws_errors"
+	ep "github.com/smugmug/godynamo/endpoint"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+)
+
+const (
+	// read-modify-write cycles attempted by UpdateWithRetry unless otherwise specified
+	UPDATE_RETRIES = 5
+)
+
+// ConditionalFailed determines if a response body is a failed Expected condition.
+func ConditionalFailed(code int,body string) bool {
+	return code == http.StatusBadRequest && aws_errors.IsConditional(body)
+}
+
+// expectedFrom builds the Expected conditions that hold only if the item is unchanged
+// from orig. If orig is empty, the condition is that the item does not exist.
+func expectedFrom(key,orig ep.Item) ep.Expected {
+	expected := make(ep.Expected)
+	if len(orig) == 0 {
+		for k,_ := range key {
+			expected[k] = ep.Constraints{Exists:false}
+		}
+		return expected
+	}
+	for k,v := range orig {
+		expected[k] = ep.Constraints{Value:v,Exists:true}
+	}
+	return expected
+}
+
+// UpdateWithRetry reads the item with `key` (consistently), passes it to fn, and puts
+// the item fn returns on the condition that the stored item still has its original
+// values. If the item does not exist, fn is passed an empty Item and the put requires
+// that the item still does not exist. If the condition fails because another writer
+// got there first, the whole cycle is repeated, up to `retries` times (UPDATE_RETRIES
+// if retries is 0). An error from fn stops the cycle and is returned.
+// It returns resp_body,code,err of the final PutItem.
+func UpdateWithRetry(tablename string,key ep.Item,fn func(ep.Item) (ep.Item,error),retries int) (string,int,error) {
+	if retries <= 0 {
+		retries = UPDATE_RETRIES
+	}
+	for i := 0; i < retries; i++ {
+		g := get.NewGet()
+		g.TableName = tablename
+		g.Key = key
+		g.ConsistentRead = true
+		body,code,err := g.EndpointReq()
+		if err != nil || code != http.StatusOK {
+			e := fmt.Sprintf("put_item.UpdateWithRetry: read: code %d: %s %v",code,body,err)
+			return body,code,errors.New(e)
+		}
+		r := get.NewResponse()
+		if um_err := json.Unmarshal([]byte(body),r); um_err != nil {
+			e := fmt.Sprintf("put_item.UpdateWithRetry: cannot unmarshal: %s",um_err.Error())
+			return body,code,errors.New(e)
+		}
+		if r.Item == nil {
+			r.Item = make(ep.Item)
+		}
+		// hand fn a copy so the conditions reflect the values read
+		cp := make(ep.Item)
+		for k,v := range r.Item {
+			cp[k] = v
+		}
+		next,fn_err := fn(cp)
+		if fn_err != nil {
+			return "",0,fn_err
+		}
+		for k,v := range key {
+			if next[k].Empty() {
+				next[k] = v
+			}
+		}
+		p := NewPut()
+		p.TableName = tablename
+		p.Item = next
+		p.Expected = expectedFrom(key,r.Item)
+		body,code,err = p.EndpointReq()
+		if err == nil && ConditionalFailed(code,body) {
+			continue
+		}
+		return body,code,err
+	}
+	e := fmt.Sprintf("put_item.UpdateWithRetry: item changed during each of %d attempts",retries)
+	return "",http.StatusBadRequest,errors.New(e)
+}
@@ -33,10 +33,14 @@ package query
 import (
 	"fmt"
 	"errors"
+	"context"
+	"net/http"
 	"encoding/json"
+	"github.com/smugmug/godynamo/auth_v4"
 	"github.com/smugmug/godynamo/authreq"
 	"github.com/smugmug/godynamo/aws_const"
  	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 )
 
 const (
@@ -105,6 +109,9 @@ type Request Query
 
 type query Query
 
+// Response of a Query. Items read from a global secondary index are always eventually
+// consistent; items read from the table or a local secondary index are strongly consistent
+// only if the Query set ConsistentRead.
 type Response struct {
 	Count uint64
 	Items []ep.Item
@@ -146,18 +153,165 @@ func ValidOp(op string) bool {
 		op == OP_BETWEEN)
 }
 
+// CheckConsistency returns an error if the Query asks for a consistent read of a global
+// secondary index, which DynamoDB does not support. The index type is found with
+// describe_table.Cached, so only consistent index queries describe the table. If the
+// table cannot be described (the caller may not be allowed dynamodb:DescribeTable), the
+// Query is let through for DynamoDB to decide.
+func (q Query) CheckConsistency() error {
+	return q.CheckConsistencyContext(context.Background())
+}
+
+// CheckConsistencyContext is CheckConsistency for the configuration requests made with ctx
+// use (see conf.WithName).
+func (q Query) CheckConsistencyContext(ctx context.Context) error {
+	if !q.ConsistentRead || q.IndexName == "" {
+		return nil
+	}
+	t,t_err := desc.CachedContext(ctx,q.TableName)
+	if t_err != nil {
+		return nil
+	}
+	if t.HasGSI(string(q.IndexName)) {
+		e := fmt.Sprintf("query.CheckConsistency: %s is a global secondary index of %s; " +
+			"global secondary indexes do not support ConsistentRead",q.IndexName,q.TableName)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// DefaultIndex returns the index to query when IndexName is omitted: the index of the
+// table keyed on the attributes of KeyConditions, found with describe_table.Cached. It is
+// "" when the table's own key matches, or when IndexName is set, SchemaDefaults is off,
+// no index matches or the table cannot be described (the caller may not be allowed
+// dynamodb:DescribeTable), in which case the Query is sent as it is.
+func (q Query) DefaultIndex() (string,error) {
+	if q.IndexName != "" || len(q.KeyConditions) == 0 || !desc.SchemaDefaults() {
+		return "",nil
+	}
+	t,t_err := desc.Cached(q.TableName)
+	if t_err != nil {
+		auth_v4.Logf("query.DefaultIndex: sending the query without an index: %s\n",t_err.Error())
+		return "",nil
+	}
+	if len(q.KeyConditions) > 2 {
+		return "",nil
+	}
+	// the hash key is compared with EQ, the range key (if any) by any operator; try the
+	// hash key of the table first, so its own key is preferred
+	table_hash,_ := t.KeyAttributeNames()
+	hashes := make([]string,0,len(q.KeyConditions))
+	if _,ok := q.KeyConditions[table_hash]; ok {
+		hashes = append(hashes,table_hash)
+	}
+	for k,_ := range q.KeyConditions {
+		if k != table_hash {
+			hashes = append(hashes,k)
+		}
+	}
+	for _,hash := range hashes {
+		if q.KeyConditions[hash].ComparisonOperator != OP_EQ {
+			continue
+		}
+		rangekey := ""
+		for k,_ := range q.KeyConditions {
+			if k != hash {
+				rangekey = k
+			}
+		}
+		if index,ok := t.IndexFor(hash,rangekey); ok {
+			return index,nil
+		}
+	}
+	return "",nil
+}
+
 // EndpointReq implements the Endpoint interface.
 func (q Query) EndpointReq() (string,int,error) {
+	return q.EndpointReqContext(context.Background())
+}
+
+// EndpointReqContext is EndpointReq governed by ctx, which also selects the configuration
+// the request and its CheckConsistency use (see conf.WithName).
+func (q Query) EndpointReqContext(ctx context.Context) (string,int,error) {
 	// returns resp_body,code,err
 	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
 		e := fmt.Sprintf("query(Query).EndpointReq " +
 			"auth must be v4")
 		return "",0,errors.New(e)
 	}
-	return authreq.RetryReq_V4(&q,QUERY_ENDPOINT)
+	if c_err := q.CheckConsistencyContext(ctx); c_err != nil {
+		return "",0,c_err
+	}
+	return authreq.RetryReqContext_V4(ctx,&q,QUERY_ENDPOINT)
 }
 
 // EndpointReq implements the Endpoint interface on the local Request type.
 func (req Request) EndpointReq() (string,int,error) {
 	return (Query(req)).EndpointReq()
 }
+
+// EndpointReqContext is EndpointReq governed by ctx.
+func (req Request) EndpointReqContext(ctx context.Context) (string,int,error) {
+	return (Query(req)).EndpointReqContext(ctx)
+}
+
+// ForEachPage runs the Query to completion, following LastEvaluatedKey from page to page,
+// calling f with each page of results in turn. Only one page is held in memory at a time.
+// If f returns an error, querying stops and that error is returned. An omitted IndexName
+// is filled in with DefaultIndex.
+func (q Query) ForEachPage(f func(*Response) error) error {
+	index,index_err := q.DefaultIndex()
+	if index_err != nil {
+		e := fmt.Sprintf("query.ForEachPage: %s",index_err.Error())
+		return errors.New(e)
+	}
+	if index != "" {
+		q.IndexName = ep.NullableString(index)
+	}
+	// copy the start key so the caller's Query is not modified
+	start_key := make(ep.Item)
+	for k,v := range q.ExclusiveStartKey {
+		start_key[k] = v
+	}
+	q.ExclusiveStartKey = start_key
+	for {
+		body,code,err := q.EndpointReq()
+		if err != nil {
+			e := fmt.Sprintf("query.ForEachPage: %s",err.Error())
+			return errors.New(e)
+		}
+		if code != http.StatusOK {
+			e := fmt.Sprintf("query.ForEachPage: code %d: %s",code,body)
+			return errors.New(e)
+		}
+		r := NewResponse()
+		um_err := json.Unmarshal([]byte(body),r)
+		if um_err != nil {
+			e := fmt.Sprintf("query.ForEachPage: cannot unmarshal: %s",um_err.Error())
+			return errors.New(e)
+		}
+		if f_err := f(r); f_err != nil {
+			return f_err
+		}
+		if len(r.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		q.ExclusiveStartKey = r.LastEvaluatedKey
+	}
+}
+
+// ForEachItem runs the Query to completion like ForEachPage, passing each item through
+// the Pipeline p and calling f with each item that is kept.
+func (q Query) ForEachItem(p ep.Pipeline,f func(ep.Item) error) error {
+	return q.ForEachPage(func(r *Response) error {
+		for _,item := range r.Items {
+			if pi,keep := p.Run(item); keep {
+				if f_err := f(pi); f_err != nil {
+					return f_err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -28,6 +28,15 @@ import (
 	"testing"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"context"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"io/ioutil"
+	"github.com/smugmug/godynamo/conf"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 )
 
 func TestRequestUnmarshal(t *testing.T) {
@@ -160,3 +169,194 @@ func TestResponseUnmarshal(t *testing.T) {
 
 	}
 }
+
+func TestDefaultIndex(t *testing.T) {
+	s := `{
+    "Table": {
+        "TableName": "Thread",
+        "KeySchema": [
+            {"AttributeName": "ForumName","KeyType": "HASH"},
+            {"AttributeName": "Subject","KeyType": "RANGE"}
+        ],
+        "LocalSecondaryIndexes": [
+            {
+                "IndexName": "LastPostIndex",
+                "KeySchema": [
+                    {"AttributeName": "ForumName","KeyType": "HASH"},
+                    {"AttributeName": "LastPostDateTime","KeyType": "RANGE"}
+                ]
+            }
+        ],
+        "GlobalSecondaryIndexes": [
+            {
+                "IndexName": "SubjectIndex",
+                "KeySchema": [{"AttributeName": "Subject","KeyType": "HASH"}]
+            }
+        ]
+    }
+}`
+	r := desc.NewResponse()
+	if um_err := json.Unmarshal([]byte(s),r); um_err != nil {
+		t.Fatalf("cannot unmarshal\n")
+	}
+	desc.Prime(r.Table)
+	cases := []struct {
+		conds KeyConditions
+		index string
+	}{
+		{KeyConditions{"ForumName":KeyCondition{ComparisonOperator:OP_EQ}},""},
+		{KeyConditions{"ForumName":KeyCondition{ComparisonOperator:OP_EQ},
+			"LastPostDateTime":KeyCondition{ComparisonOperator:OP_GT}},"LastPostIndex"},
+		{KeyConditions{"Subject":KeyCondition{ComparisonOperator:OP_EQ}},"SubjectIndex"},
+	}
+	for _,c := range cases {
+		q := Query{TableName:"Thread",KeyConditions:c.conds}
+		index,err := q.DefaultIndex()
+		if err != nil || index != c.index {
+			t.Errorf("%v: got index %q %v, want %q\n",c.conds,index,err,c.index)
+		}
+	}
+	desc.SetSchemaDefaults(false)
+	q := Query{TableName:"Thread",KeyConditions:cases[2].conds}
+	if index,_ := q.DefaultIndex(); index != "" {
+		t.Errorf("index %s chosen with schema defaults off\n",index)
+	}
+	desc.SetSchemaDefaults(true)
+}
+
+// pagedServer answers with two pages of items, the second for requests that set an
+// ExclusiveStartKey, and points conf.Vals at itself.
+// pagedServer answers with two pages of items, the second for requests that set an
+// ExclusiveStartKey, and points conf.Vals at itself.
+func pagedServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		body,_ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Amzn-RequestId","test")
+		if strings.Contains(string(body),`"ExclusiveStartKey":{"id"`) {
+			w.Write([]byte(`{"Items":[{"id":{"S":"c"}},{"id":{"S":"d"}}],"Count":2}`))
+			return
+		}
+		w.Write([]byte(`{"Items":[{"id":{"S":"a"}},{"id":{"S":"b"}}],"Count":2,` +
+			`"LastEvaluatedKey":{"id":{"S":"b"}}}`))
+	}))
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	return srv
+}
+
+func TestForEachItem(t *testing.T) {
+	srv := pagedServer(t)
+	defer srv.Close()
+	q := NewQuery()
+	q.TableName = "T"
+	p := ep.Pipeline{}.Map(func(i ep.Item) ep.Item {
+		return ep.Item{"id":ep.AttributeValue{S:strings.ToUpper(i["id"].S)}}
+	}).Filter(func(i ep.Item) bool { return i["id"].S != "C" })
+	ids := ""
+	err := q.ForEachItem(p,func(i ep.Item) error {
+		ids += i["id"].S
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachItem: %s\n",err.Error())
+	}
+	if ids != "ABD" {
+		t.Errorf("got items %s, expected ABD\n",ids)
+	}
+	if len(q.ExclusiveStartKey) != 0 {
+		t.Errorf("the caller's Query was modified\n")
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	s := `{"Table":{"TableName":"Forum",
+        "LocalSecondaryIndexes":[{"IndexName":"LastPostIndex"}],
+        "GlobalSecondaryIndexes":[{"IndexName":"SubjectIndex"}]}}`
+	r := desc.NewResponse()
+	if um_err := json.Unmarshal([]byte(s),r); um_err != nil {
+		t.Fatalf("cannot unmarshal\n")
+	}
+	desc.Prime(r.Table)
+	q := Query{TableName:"Forum",IndexName:"SubjectIndex",ConsistentRead:true}
+	if q.CheckConsistency() == nil {
+		t.Errorf("consistent query of a global secondary index allowed\n")
+	}
+	q.IndexName = "LastPostIndex"
+	if err := q.CheckConsistency(); err != nil {
+		t.Errorf("consistent query of a local secondary index: %s\n",err.Error())
+	}
+	// the cached description is that of the default configuration only
+	q.IndexName = "SubjectIndex"
+	if err := q.CheckConsistencyContext(conf.WithName(context.Background(),"other")); err != nil {
+		t.Errorf("description of the default configuration used for another: %s\n",err.Error())
+	}
+
+	// a table that cannot be described is left for DynamoDB to check
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazon.coral.service#AccessDeniedException"}`))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	q = Query{TableName:"Undescribed",IndexName:"AnyIndex",ConsistentRead:true}
+	if err := q.CheckConsistency(); err != nil {
+		t.Errorf("query of a table that cannot be described: %s\n",err.Error())
+	}
+	q = Query{TableName:"Undescribed",KeyConditions:KeyConditions{"a":KeyCondition{ComparisonOperator:OP_EQ}}}
+	if index,err := q.DefaultIndex(); index != "" || err != nil {
+		t.Errorf("default index of a table that cannot be described: %q %v\n",index,err)
+	}
+}
+
+// EndpointReqContext checks consistency against the table of the configuration of ctx.
+func TestEndpointReqContext(t *testing.T) {
+	r := desc.NewResponse()
+	json.Unmarshal([]byte(`{"Table":{"TableName":"Posts",
+        "GlobalSecondaryIndexes":[{"IndexName":"ByAuthor"}]}}`),r)
+	desc.Prime(r.Table)
+	defer desc.Invalidate("Posts")
+	q := Query{TableName:"Posts",IndexName:"ByAuthor",ConsistentRead:true}
+	if _,_,err := q.EndpointReq(); err == nil {
+		t.Errorf("consistent query of a global secondary index sent\n")
+	}
+	// in the other account, ByAuthor is a local secondary index
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		if strings.HasSuffix(r.Header.Get("X-Amz-Target"),".DescribeTable") {
+			w.Write([]byte(`{"Table":{"TableName":"Posts",
+                "LocalSecondaryIndexes":[{"IndexName":"ByAuthor"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"Items":[],"Count":0}`))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	other := &conf.AWS_Conf{}
+	other.Network.DynamoDB.Host = u.Hostname()
+	other.Network.DynamoDB.Port = u.Port()
+	other.Network.DynamoDB.URL = srv.URL
+	other.Network.DynamoDB.Zone = "us-east-1"
+	other.Auth.AccessKey = "AKIDEXAMPLE"
+	other.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Register("query-other",other)
+	body,code,err := q.EndpointReqContext(conf.WithName(context.Background(),"query-other"))
+	if err != nil || code != http.StatusOK {
+		t.Errorf("query in the other configuration: %d %s %v\n",code,body,err)
+	}
+}
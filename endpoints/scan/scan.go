@@ -33,6 +33,7 @@ package scan
 import (
 	"fmt"
 	"errors"
+	"net/http"
 	"github.com/smugmug/godynamo/authreq"
 	"github.com/smugmug/godynamo/aws_const"
 	"encoding/json"
@@ -135,6 +136,21 @@ func (r Request) MarshalJSON() ([]byte, error) {
 	return json.Marshal(Scan(r))
 }
 
+type scan Scan
+
+// segmented overrides Segment so that segment 0 of a parallel scan is not marshaled as null.
+type segmented struct {
+	scan
+	Segment uint64
+}
+
+func (s Scan) MarshalJSON() ([]byte, error) {
+	if s.TotalSegments == 0 {
+		return json.Marshal(scan(s))
+	}
+	return json.Marshal(segmented{scan:scan(s),Segment:uint64(s.Segment)})
+}
+
 // ValidOp determines if an operation is in the approved list.
 func ValidOp(op string) bool {
 	return (op == OP_EQ           ||
@@ -163,7 +179,97 @@ func (s Scan) EndpointReq() (string,int,error) {
 	return authreq.RetryReq_V4(&s,SCAN_ENDPOINT)
 }
 
+// ForEachPage runs the Scan to completion, following LastEvaluatedKey from page to page,
+// calling f with each page of results in turn. Only one page is held in memory at a time.
+// If f returns an error, scanning stops and that error is returned.
+func (s Scan) ForEachPage(f func(*Response) error) error {
+	// copy the start key so the caller's Scan is not modified
+	start_key := make(ep.Item)
+	for k,v := range s.ExclusiveStartKey {
+		start_key[k] = v
+	}
+	s.ExclusiveStartKey = start_key
+	for {
+		body,code,err := s.EndpointReq()
+		if err != nil {
+			e := fmt.Sprintf("scan.ForEachPage: %s",err.Error())
+			return errors.New(e)
+		}
+		if code != http.StatusOK {
+			e := fmt.Sprintf("scan.ForEachPage: code %d: %s",code,body)
+			return errors.New(e)
+		}
+		r := NewResponse()
+		um_err := json.Unmarshal([]byte(body),r)
+		if um_err != nil {
+			e := fmt.Sprintf("scan.ForEachPage: cannot unmarshal: %s",um_err.Error())
+			return errors.New(e)
+		}
+		if f_err := f(r); f_err != nil {
+			return f_err
+		}
+		if len(r.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		s.ExclusiveStartKey = r.LastEvaluatedKey
+	}
+}
+
 // EndpointReq implements the Endpoint interface on the local Request type.
 func (req Request) EndpointReq() (string,int,error) {
 	return (Scan(req)).EndpointReq()
 }
+
+// ForEachItem runs the Scan to completion like ForEachPage, passing each item through
+// the Pipeline p and calling f with each item that is kept.
+func (s Scan) ForEachItem(p ep.Pipeline,f func(ep.Item) error) error {
+	return s.ForEachPage(func(r *Response) error {
+		for _,item := range r.Items {
+			if pi,keep := p.Run(item); keep {
+				if f_err := f(pi); f_err != nil {
+					return f_err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ErrStreamCanceled is sent by Stream when cancel is closed before the Scan completes.
+var ErrStreamCanceled = errors.New("scan.Stream: canceled")
+
+// Stream runs the Scan like ForEachItem, sending each kept item on the returned item
+// channel, which holds up to buffer items. When the consumer falls behind and the channel
+// fills, Stream blocks rather than fetching further pages, and resumes fetching once the
+// consumer has drained the current page, so at most buffer items and one page are held
+// in memory. When the Scan completes, fails or cancel is closed, the outcome (nil on
+// completion) is sent on the error channel and both channels are closed.
+func (s Scan) Stream(p ep.Pipeline,buffer int,cancel <-chan struct{}) (<-chan ep.Item,<-chan error) {
+	items := make(chan ep.Item,buffer)
+	errs := make(chan error,1)
+	go func() {
+		defer close(errs)
+		defer close(items)
+		errs <- s.ForEachPage(func(r *Response) error {
+			for _,item := range r.Items {
+				pi,keep := p.Run(item)
+				if !keep {
+					continue
+				}
+				select {
+				case items <- pi:
+				case <-cancel:
+					return ErrStreamCanceled
+				}
+			}
+			// check between pages too, in case no items were kept
+			select {
+			case <-cancel:
+				return ErrStreamCanceled
+			default:
+				return nil
+			}
+		})
+	}()
+	return items,errs
+}
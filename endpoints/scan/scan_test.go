@@ -28,6 +28,12 @@ import (
 	"testing"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"io/ioutil"
+	"github.com/smugmug/godynamo/conf"
  	ep "github.com/smugmug/godynamo/endpoint"
 )
 
@@ -221,3 +227,71 @@ func TestResponseUnmarshal(t *testing.T) {
 
 	}
 }
+
+func TestSegmentZeroMarshal(t *testing.T) {
+	s := NewScan()
+	s.TableName = "Thread"
+	s.TotalSegments = 4
+	b,jerr := json.Marshal(s)
+	if jerr != nil {
+		t.Fatalf("cannot marshal: %s\n",jerr.Error())
+	}
+	var m map[string] interface{}
+	_ = json.Unmarshal(b,&m)
+	if seg,ok := m["Segment"].(float64); !ok || seg != 0 {
+		t.Errorf("segment 0 not marshaled: %s\n",string(b))
+	}
+}
+
+// pagedServer answers with two pages of items, the second for requests that set an
+// ExclusiveStartKey, and points conf.Vals at itself.
+func pagedServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		body,_ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Amzn-RequestId","test")
+		if strings.Contains(string(body),`"ExclusiveStartKey":{"id"`) {
+			w.Write([]byte(`{"Items":[{"id":{"S":"c"}},{"id":{"S":"d"}}],"Count":2,"ScannedCount":2}`))
+			return
+		}
+		w.Write([]byte(`{"Items":[{"id":{"S":"a"}},{"id":{"S":"b"}}],"Count":2,"ScannedCount":2,` +
+			`"LastEvaluatedKey":{"id":{"S":"b"}}}`))
+	}))
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	return srv
+}
+
+func TestForEachItem(t *testing.T) {
+	srv := pagedServer(t)
+	defer srv.Close()
+	s := NewScan()
+	s.TableName = "T"
+	p := ep.Pipeline{}.Filter(func(i ep.Item) bool { return i["id"].S != "b" })
+	ids := ""
+	err := s.ForEachItem(p,func(i ep.Item) error {
+		ids += i["id"].S
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachItem: %s\n",err.Error())
+	}
+	if ids != "acd" {
+		t.Errorf("got items %s, expected acd\n",ids)
+	}
+	stop := fmt.Errorf("stop")
+	n := 0
+	err = s.ForEachItem(ep.Pipeline{},func(i ep.Item) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("got %v after %d items, expected the error of f after 1\n",err,n)
+	}
+}
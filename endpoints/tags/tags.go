@@ -0,0 +1,94 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Support for the DynamoDB ListTagsOfResource endpoint.
+package tags
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+const (
+	LIST_ENDPOINT_NAME = "ListTagsOfResource"
+	LIST_ENDPOINT      = aws_const.ENDPOINT_PREFIX + LIST_ENDPOINT_NAME
+)
+
+type Tag struct {
+	Key string
+	Value string
+}
+
+type List struct {
+	ResourceArn string
+	NextToken string `json:",omitempty"`
+}
+
+type ListResponse struct {
+	Tags []Tag
+	NextToken string
+}
+
+// EndpointReq implements the Endpoint interface.
+func (l List) EndpointReq() (string,int,error) {
+	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
+		e := fmt.Sprintf("tags(List).EndpointReq " +
+			"auth must be v4")
+		return "",0,errors.New(e)
+	}
+	return authreq.RetryReq_V4(&l,LIST_ENDPOINT)
+}
+
+// ListAll pages through ListTagsOfResource (following NextToken) and returns the tags
+// of the resource (e.g. a table ARN) as a map of keys to values.
+func ListAll(arn string) (map[string]string,error) {
+	m := make(map[string]string)
+	l := List{ResourceArn:arn}
+	for {
+		body,code,err := l.EndpointReq()
+		if err != nil {
+			e := fmt.Sprintf("tags.ListAll: %s",err.Error())
+			return nil,errors.New(e)
+		}
+		if code != http.StatusOK {
+			e := fmt.Sprintf("tags.ListAll: code %d: %s",code,body)
+			return nil,errors.New(e)
+		}
+		var r ListResponse
+		if um_err := json.Unmarshal([]byte(body),&r); um_err != nil {
+			e := fmt.Sprintf("tags.ListAll: cannot unmarshal: %s",um_err.Error())
+			return nil,errors.New(e)
+		}
+		for _,t := range r.Tags {
+			m[t.Key] = t.Value
+		}
+		if r.NextToken == "" {
+			return m,nil
+		}
+		l.NextToken = r.NextToken
+	}
+}
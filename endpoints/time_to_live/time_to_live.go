@@ -0,0 +1,115 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Support for the DynamoDB DescribeTimeToLive and UpdateTimeToLive endpoints.
+package time_to_live
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	"github.com/smugmug/godynamo/aws_const"
+)
+
+const (
+	DESCRIBE_ENDPOINT_NAME = "DescribeTimeToLive"
+	DESCRIBE_ENDPOINT      = aws_const.ENDPOINT_PREFIX + DESCRIBE_ENDPOINT_NAME
+	UPDATE_ENDPOINT_NAME   = "UpdateTimeToLive"
+	UPDATE_ENDPOINT        = aws_const.ENDPOINT_PREFIX + UPDATE_ENDPOINT_NAME
+	ENABLED                = "ENABLED"
+	ENABLING               = "ENABLING"
+	DISABLED               = "DISABLED"
+	DISABLING              = "DISABLING"
+)
+
+type Describe struct {
+	TableName string
+}
+
+type DescribeResponse struct {
+	TimeToLiveDescription struct {
+		AttributeName string
+		TimeToLiveStatus string
+	}
+}
+
+// EndpointReq implements the Endpoint interface.
+func (d Describe) EndpointReq() (string,int,error) {
+	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
+		e := fmt.Sprintf("time_to_live(Describe).EndpointReq " +
+			"auth must be v4")
+		return "",0,errors.New(e)
+	}
+	return authreq.RetryReq_V4(&d,DESCRIBE_ENDPOINT)
+}
+
+type TimeToLiveSpecification struct {
+	AttributeName string
+	Enabled bool
+}
+
+type Update struct {
+	TableName string
+	TimeToLiveSpecification TimeToLiveSpecification
+}
+
+type UpdateResponse struct {
+	TimeToLiveSpecification TimeToLiveSpecification
+}
+
+// EndpointReq implements the Endpoint interface.
+func (u Update) EndpointReq() (string,int,error) {
+	if authreq.AUTH_VERSION != authreq.AUTH_V4 {
+		e := fmt.Sprintf("time_to_live(Update).EndpointReq " +
+			"auth must be v4")
+		return "",0,errors.New(e)
+	}
+	return authreq.RetryReq_V4(&u,UPDATE_ENDPOINT)
+}
+
+// Attribute returns the TTL attribute name of tablename, or "" if TTL is not
+// enabled (or being enabled).
+func Attribute(tablename string) (string,error) {
+	body,code,err := Describe{TableName:tablename}.EndpointReq()
+	if err != nil {
+		e := fmt.Sprintf("time_to_live.Attribute: %s",err.Error())
+		return "",errors.New(e)
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("time_to_live.Attribute: code %d: %s",code,body)
+		return "",errors.New(e)
+	}
+	var r DescribeResponse
+	if um_err := json.Unmarshal([]byte(body),&r); um_err != nil {
+		e := fmt.Sprintf("time_to_live.Attribute: cannot unmarshal: %s",um_err.Error())
+		return "",errors.New(e)
+	}
+	status := r.TimeToLiveDescription.TimeToLiveStatus
+	if status != ENABLED && status != ENABLING {
+		return "",nil
+	}
+	return r.TimeToLiveDescription.AttributeName,nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Tests JSON formats as described on the AWS docs site. For live tests, see ../../tests
+package time_to_live
+
+import (
+	"testing"
+	"encoding/json"
+)
+
+func TestRequestMarshal(t *testing.T) {
+	s := []string{
+		`{
+    "TableName": "Thread",
+    "TimeToLiveSpecification": {
+        "AttributeName": "ExpirationTime",
+        "Enabled": true
+    }
+}`,
+	}
+	for _,v := range s {
+		var u Update
+		um_err := json.Unmarshal([]byte(v),&u)
+		if um_err != nil {
+			t.Errorf("cannot unmarshal to create:\n" + v + "\n")
+		}
+		_,jerr := json.Marshal(u)
+		if jerr != nil {
+			t.Errorf("cannot marshal\n")
+		}
+	}
+}
+
+func TestResponseMarshal(t *testing.T) {
+	s := []string{
+		`{
+    "TimeToLiveDescription": {
+        "AttributeName": "ExpirationTime",
+        "TimeToLiveStatus": "ENABLED"
+    }
+}`,
+	}
+	for _,v := range s {
+		var d DescribeResponse
+		um_err := json.Unmarshal([]byte(v),&d)
+		if um_err != nil {
+			t.Errorf("cannot unmarshal to create:\n" + v + "\n")
+		}
+		if d.TimeToLiveDescription.TimeToLiveStatus != ENABLED {
+			t.Errorf("status not unmarshaled\n")
+		}
+		_,jerr := json.Marshal(d)
+		if jerr != nil {
+			t.Errorf("cannot marshal\n")
+		}
+	}
+}
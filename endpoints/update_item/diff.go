@@ -0,0 +1,61 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package update_item
+
+import (
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// UpdatesFromDiff returns the AttributeUpdates that apply the changes of ep.Diff to the
+// old item. Set members only added or only removed are sent with ADD and DELETE, so
+// concurrent changes to other members are kept; other changes PUT the new value.
+// Leave key attributes out of the diff, as they cannot be updated.
+func UpdatesFromDiff(changes []ep.Change) AttributeUpdates {
+	updates := make(AttributeUpdates)
+	for _,c := range changes {
+		switch {
+		case c.Kind == ep.DIFF_REMOVED:
+			updates[c.Path] = AttributeAction{Action:ACTION_DEL}
+		case c.Kind == ep.DIFF_CHANGED && len(c.Added) != 0 && len(c.Removed) == 0:
+			updates[c.Path] = AttributeAction{Action:ACTION_ADD,Value:members(*c.New,c.Added)}
+		case c.Kind == ep.DIFF_CHANGED && len(c.Removed) != 0 && len(c.Added) == 0:
+			updates[c.Path] = AttributeAction{Action:ACTION_DEL,Value:members(*c.Old,c.Removed)}
+		default:
+			updates[c.Path] = AttributeAction{Action:ACTION_PUT,Value:*c.New}
+		}
+	}
+	return updates
+}
+
+// members returns the set of ms, of the set type of a.
+func members(a ep.AttributeValue,ms []string) ep.AttributeValue {
+	switch {
+	case len(a.NS) != 0:
+		return ep.AttributeValue{NS:ms,Type:ep.NS}
+	case len(a.BS) != 0:
+		return ep.AttributeValue{BS:ms,Type:ep.BS}
+	}
+	return ep.AttributeValue{SS:ms,Type:ep.SS}
+}
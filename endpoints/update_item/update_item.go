@@ -32,6 +32,7 @@ package update_item
 
 import (
 	"fmt"
+	"time"
 	"encoding/json"
 	"errors"
 	"github.com/smugmug/godynamo/authreq"
@@ -163,3 +164,16 @@ func (u Update) EndpointReq() (string,int,error) {
 func (req Request) EndpointReq() (string,int,error) {
 	return (Update(req)).EndpointReq()
 }
+
+// Stamp adds an update setting the Updated attribute of ts to now. The Created attribute
+// is not set: AttributeUpdates cannot set an attribute only if it is absent, so items
+// should be created with put_item (see put_item.Put.Stamp).
+func (u *Update) Stamp(ts ep.Timestamps) {
+	if ts.Updated == "" {
+		return
+	}
+	if u.AttributeUpdates == nil {
+		u.AttributeUpdates = make(AttributeUpdates)
+	}
+	u.AttributeUpdates[ts.Updated] = AttributeAction{Value:ts.Value(time.Now()),Action:ACTION_PUT}
+}
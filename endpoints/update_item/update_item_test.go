@@ -27,6 +27,7 @@ package update_item
 import (
 	"testing"
 	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
 )
 
 func TestRequestUnmarshal(t *testing.T) {
@@ -114,3 +115,36 @@ func TestResponseUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdatesFromDiff(t *testing.T) {
+	old := ep.Item{
+		"count":ep.AttributeValue{N:"1.0"},
+		"name":ep.AttributeValue{S:"a"},
+		"tags":ep.AttributeValue{SS:[]string{"x","y"}},
+		"gone":ep.AttributeValue{S:"z"}}
+	new := ep.Item{
+		"count":ep.AttributeValue{N:"1"},
+		"name":ep.AttributeValue{S:"b"},
+		"tags":ep.AttributeValue{SS:[]string{"y","x","w"}},
+		"added":ep.AttributeValue{N:"2"}}
+	changes := ep.Diff(old,new)
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %v\n",changes)
+	}
+	u := UpdatesFromDiff(changes)
+	if u["gone"].Action != ACTION_DEL || !u["gone"].Value.Empty() {
+		t.Errorf("gone: %v\n",u["gone"])
+	}
+	if u["tags"].Action != ACTION_ADD || len(u["tags"].Value.SS) != 1 || u["tags"].Value.SS[0] != "w" {
+		t.Errorf("tags: %v\n",u["tags"])
+	}
+	if u["name"].Action != ACTION_PUT || u["name"].Value.S != "b" {
+		t.Errorf("name: %v\n",u["name"])
+	}
+	if u["added"].Action != ACTION_PUT || u["added"].Value.N != "2" {
+		t.Errorf("added: %v\n",u["added"])
+	}
+	if _,ok := u["count"]; ok {
+		t.Errorf("expected 1.0 and 1 to be equal\n")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package update_table
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"errors"
+	"github.com/smugmug/godynamo/capacity"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+)
+
+// The advisor is for accounts not using Application Auto Scaling. It sizes provisioned
+// capacity so that the ADVISOR_PERCENTILE of per-second consumption, as recorded by the
+// capacity package, is ADVISOR_UTILIZATION of the provisioned units. The capacity package
+// only sees the requests of this process that set ReturnConsumedCapacity, so decreases are
+// only recommended once its observations cover the whole window, and never below the peak.
+
+const (
+	ADVISOR_PERCENTILE  = 0.95
+	ADVISOR_UTILIZATION = 0.7
+	// changes smaller than this fraction of the current units are not recommended
+	ADVISOR_HYSTERESIS  = 0.2
+	ADVISOR_WINDOW      = capacity.WINDOW * time.Second
+)
+
+// Advice is the capacity recommended for a table.
+type Advice struct {
+	TableName string
+	Current ep.ProvisionedThroughput
+	Recommended ep.ProvisionedThroughput
+	// the observed ADVISOR_PERCENTILE per-second consumption
+	ReadPercentile float64
+	WritePercentile float64
+	// whether consumption was recorded over the whole window; if not, only increases
+	// are recommended
+	Covered bool
+}
+
+// Changed determines if the Advice recommends a change.
+func (a Advice) Changed() bool {
+	return a.Current != a.Recommended
+}
+
+// recommend returns the units to provision for the percentile consumption used, given
+// the units have and the peak consumption. A decrease is recommended only if covered,
+// and not below the peak.
+func recommend(have uint64,used,peak float64,covered bool) uint64 {
+	want := uint64(math.Ceil(used / ADVISOR_UTILIZATION))
+	if want < have {
+		if !covered {
+			return have
+		}
+		if floor := uint64(math.Ceil(peak)); want < floor {
+			want = floor
+		}
+	}
+	if want < 1 {
+		want = 1
+	}
+	if math.Abs(float64(want) - float64(have)) < ADVISOR_HYSTERESIS * float64(have) {
+		return have
+	}
+	return want
+}
+
+// Advise recommends the capacity of table t from its consumption over window.
+func Advise(t desc.TableDescription,window time.Duration) Advice {
+	a := Advice{TableName:t.TableName}
+	a.Current.ReadCapacityUnits = t.ProvisionedThroughput.ReadCapacityUnits
+	a.Current.WriteCapacityUnits = t.ProvisionedThroughput.WriteCapacityUnits
+	a.ReadPercentile,a.WritePercentile = capacity.Percentile(t.TableName,window,ADVISOR_PERCENTILE)
+	read,write := capacity.Usage(t.TableName,window)
+	since,observed := capacity.Since(t.TableName)
+	a.Covered = observed && !since.After(time.Now().Add(-window))
+	a.Recommended.ReadCapacityUnits = recommend(a.Current.ReadCapacityUnits,a.ReadPercentile,
+		read.Peak,a.Covered)
+	a.Recommended.WriteCapacityUnits = recommend(a.Current.WriteCapacityUnits,a.WritePercentile,
+		write.Peak,a.Covered)
+	return a
+}
+
+// AdviseTable returns the Advice for tablename over ADVISOR_WINDOW and, if apply is set
+// and the Advice recommends a change, applies it with UpdateThroughput, whose peak usage
+// and daily decrease checks apply.
+func AdviseTable(tablename string,apply bool) (*Advice,error) {
+	t,t_err := desc.DescribeTable(tablename)
+	if t_err != nil {
+		e := fmt.Sprintf("update_table.AdviseTable: %s",t_err.Error())
+		return nil,errors.New(e)
+	}
+	if t.BillingModeSummary.BillingMode == BILLING_PAY_PER_REQUEST {
+		e := fmt.Sprintf("update_table.AdviseTable: table %s is %s",tablename,BILLING_PAY_PER_REQUEST)
+		return nil,errors.New(e)
+	}
+	a := Advise(*t,ADVISOR_WINDOW)
+	if apply && a.Changed() {
+		if u_err := UpdateThroughput(tablename,a.Recommended,false,false); u_err != nil {
+			return &a,u_err
+		}
+	}
+	return &a,nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package update_table
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"net/http"
+	"github.com/smugmug/godynamo/authreq"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+)
+
+const (
+	BILLING_PROVISIONED     = "PROVISIONED"
+	BILLING_PAY_PER_REQUEST = "PAY_PER_REQUEST"
+	// AWS permits one capacity mode switch per table in this interval
+	BILLING_SWITCH_INTERVAL = 24 * time.Hour
+)
+
+// SwitchTooSoonError is returned when a capacity mode switch is not yet permitted.
+type SwitchTooSoonError struct {
+	TableName string
+	BillingMode string
+	Allowed time.Time
+}
+
+func (s *SwitchTooSoonError) Error() string {
+	return fmt.Sprintf("update_table: table %s cannot switch to %s until %s",
+		s.TableName,s.BillingMode,s.Allowed.Format(time.RFC3339))
+}
+
+// billingMode returns the current capacity mode of t and the earliest time it may switch.
+func billingMode(t desc.TableDescription) (string,time.Time) {
+	mode := t.BillingModeSummary.BillingMode
+	if mode == "" {
+		mode = BILLING_PROVISIONED
+	}
+	var allowed time.Time
+	if last := t.BillingModeSummary.LastUpdateToPayPerRequestDateTime; last != 0 {
+		sec := int64(last)
+		allowed = time.Unix(sec,int64((last - float64(sec)) * 1e9)).Add(BILLING_SWITCH_INTERVAL)
+	}
+	return mode,allowed
+}
+
+func switchUpdate(tablename,mode string,pt ep.ProvisionedThroughput) (*Update,error) {
+	u := &Update{TableName:tablename,BillingMode:ep.NullableString(mode)}
+	switch mode {
+	case BILLING_PAY_PER_REQUEST:
+	case BILLING_PROVISIONED:
+		if pt.ReadCapacityUnits == 0 || pt.WriteCapacityUnits == 0 {
+			return nil,errors.New("PROVISIONED mode needs read and write capacity units")
+		}
+		u.ProvisionedThroughput = pt
+	default:
+		e := fmt.Sprintf("unknown billing mode %s",mode)
+		return nil,errors.New(e)
+	}
+	return u,nil
+}
+
+// SwitchBillingMode switches tablename to capacity mode `mode` (BILLING_PROVISIONED, which
+// requires the units in pt, or BILLING_PAY_PER_REQUEST). If the table is already in that
+// mode, nothing is done and code 200 is returned with an empty body. If the last switch was
+// within BILLING_SWITCH_INTERVAL, a *SwitchTooSoonError is returned without calling
+// UpdateTable.
+func SwitchBillingMode(tablename,mode string,pt ep.ProvisionedThroughput) (string,int,error) {
+	u,u_err := switchUpdate(tablename,mode,pt)
+	if u_err != nil {
+		e := fmt.Sprintf("update_table.SwitchBillingMode: %s",u_err.Error())
+		return "",0,errors.New(e)
+	}
+	t,t_err := desc.DescribeTable(tablename)
+	if t_err != nil {
+		e := fmt.Sprintf("update_table.SwitchBillingMode: %s",t_err.Error())
+		return "",0,errors.New(e)
+	}
+	current,allowed := billingMode(*t)
+	if current == mode {
+		return "",http.StatusOK,nil
+	}
+	if time.Now().Before(allowed) {
+		return "",0,&SwitchTooSoonError{TableName:tablename,BillingMode:mode,Allowed:allowed}
+	}
+	return u.EndpointReq()
+}
+
+// ScheduleBillingMode is like SwitchBillingMode, but if the switch is not yet permitted it
+// waits until it is and then makes it. The result is sent on the returned channel. Closing
+// cancel before the switch is made abandons it. While authreq.Pause is in effect the switch
+// is held until authreq.Resume; authreq.Close abandons it with authreq.ErrClosed.
+func ScheduleBillingMode(tablename,mode string,pt ep.ProvisionedThroughput,cancel <-chan struct{}) (<-chan ep.Endpoint_Response) {
+	c := make(chan ep.Endpoint_Response,1)
+	started := authreq.GoBackground(func(stop <-chan bool) {
+		for {
+			select {
+			case <-authreq.Resumed():
+			case <-cancel:
+				c <- ep.Endpoint_Response{Err:errors.New("update_table.ScheduleBillingMode: canceled while paused")}
+				return
+			case <-stop:
+				c <- ep.Endpoint_Response{Err:authreq.ErrClosed}
+				return
+			}
+			body,code,err := SwitchBillingMode(tablename,mode,pt)
+			too_soon,is_too_soon := err.(*SwitchTooSoonError)
+			if !is_too_soon {
+				c <- ep.Endpoint_Response{Body:body,Code:code,Err:err}
+				return
+			}
+			select {
+			case <-time.After(too_soon.Allowed.Sub(time.Now())):
+			case <-cancel:
+				c <- ep.Endpoint_Response{Err:err}
+				return
+			case <-stop:
+				c <- ep.Endpoint_Response{Err:authreq.ErrClosed}
+				return
+			}
+		}
+	})
+	if !started {
+		c <- ep.Endpoint_Response{Err:authreq.ErrClosed}
+	}
+	return c
+}
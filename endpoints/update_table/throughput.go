@@ -0,0 +1,149 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package update_table
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"github.com/smugmug/godynamo/capacity"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+)
+
+const (
+	// the AWS limit on throughput decreases per table per UTC day
+	MAX_DECREASES_PER_DAY = 27
+	// each step of an increase is at most this multiple of the current units
+	MAX_INCREASE_FACTOR = 2
+	// the consumption window compared against a decrease
+	USAGE_WINDOW = 5 * time.Minute
+	// tries made for the table to become ACTIVE between steps
+	STEP_ACTIVE_TRIES = 150
+)
+
+// stepUnits returns the units of the next step from have toward want. Increases are
+// limited to MAX_INCREASE_FACTOR times have; decreases are made in one step, since
+// they are limited in number.
+func stepUnits(have,want uint64) uint64 {
+	if want > have && have != 0 && want > have * MAX_INCREASE_FACTOR {
+		return have * MAX_INCREASE_FACTOR
+	}
+	return want
+}
+
+// CheckThroughput determines if table t may be changed to the units in pt. It is an error
+// to decrease either units when the table has used its decreases for the day, or (unless
+// force is set) to decrease them below the peak usage recorded by the capacity package
+// over USAGE_WINDOW.
+func CheckThroughput(t desc.TableDescription,pt ep.ProvisionedThroughput,force bool) error {
+	if pt.ReadCapacityUnits == 0 || pt.WriteCapacityUnits == 0 {
+		return errors.New("read and write capacity units must be at least 1")
+	}
+	have := t.ProvisionedThroughput
+	decrease := pt.ReadCapacityUnits < have.ReadCapacityUnits ||
+		pt.WriteCapacityUnits < have.WriteCapacityUnits
+	if !decrease {
+		return nil
+	}
+	if have.NumberOfDecreasesToday >= MAX_DECREASES_PER_DAY {
+		e := fmt.Sprintf("table %s has used its %d throughput decreases today",
+			t.TableName,MAX_DECREASES_PER_DAY)
+		return errors.New(e)
+	}
+	if force {
+		return nil
+	}
+	read,write := capacity.Usage(t.TableName,USAGE_WINDOW)
+	if float64(pt.ReadCapacityUnits) < read.Peak {
+		e := fmt.Sprintf("table %s peak read usage %.1f exceeds requested %d units",
+			t.TableName,read.Peak,pt.ReadCapacityUnits)
+		return errors.New(e)
+	}
+	if float64(pt.WriteCapacityUnits) < write.Peak {
+		e := fmt.Sprintf("table %s peak write usage %.1f exceeds requested %d units",
+			t.TableName,write.Peak,pt.WriteCapacityUnits)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// UpdateThroughput changes the provisioned throughput of tablename to pt after checking
+// the change with CheckThroughput. Large increases are applied in steps of at most
+// MAX_INCREASE_FACTOR, waiting for the table to become ACTIVE after each one. A
+// PAY_PER_REQUEST table is an error unless switch_mode is set, in which case it is
+// switched to PROVISIONED with the first step, or a *SwitchTooSoonError returned if its
+// last switch was within BILLING_SWITCH_INTERVAL (see SwitchBillingMode).
+func UpdateThroughput(tablename string,pt ep.ProvisionedThroughput,force,switch_mode bool) error {
+	t,t_err := desc.DescribeTable(tablename)
+	if t_err != nil {
+		e := fmt.Sprintf("update_table.UpdateThroughput: %s",t_err.Error())
+		return errors.New(e)
+	}
+	if check_err := CheckThroughput(*t,pt,force); check_err != nil {
+		e := fmt.Sprintf("update_table.UpdateThroughput: %s",check_err.Error())
+		return errors.New(e)
+	}
+	have := ep.ProvisionedThroughput{
+		ReadCapacityUnits:t.ProvisionedThroughput.ReadCapacityUnits,
+		WriteCapacityUnits:t.ProvisionedThroughput.WriteCapacityUnits}
+	mode,allowed := billingMode(*t)
+	if mode != BILLING_PAY_PER_REQUEST {
+		switch_mode = false
+	} else if !switch_mode {
+		e := fmt.Sprintf("update_table.UpdateThroughput: table %s is %s; set switch_mode to switch it to %s",
+			tablename,BILLING_PAY_PER_REQUEST,BILLING_PROVISIONED)
+		return errors.New(e)
+	} else if time.Now().Before(allowed) {
+		return &SwitchTooSoonError{TableName:tablename,BillingMode:BILLING_PROVISIONED,Allowed:allowed}
+	}
+	for have != pt {
+		next := ep.ProvisionedThroughput{
+			ReadCapacityUnits:stepUnits(have.ReadCapacityUnits,pt.ReadCapacityUnits),
+			WriteCapacityUnits:stepUnits(have.WriteCapacityUnits,pt.WriteCapacityUnits)}
+		u := Update{TableName:tablename,ProvisionedThroughput:next}
+		if switch_mode {
+			u.BillingMode = ep.NullableString(BILLING_PROVISIONED)
+			switch_mode = false
+		}
+		body,code,err := u.EndpointReq()
+		if err != nil || ep.HttpErr(code) {
+			e := fmt.Sprintf("update_table.UpdateThroughput: %d/%d: code %d: %s %v",
+				next.ReadCapacityUnits,next.WriteCapacityUnits,code,body,err)
+			return errors.New(e)
+		}
+		active,poll_err := desc.PollTableStatus(tablename,desc.ACTIVE,STEP_ACTIVE_TRIES)
+		if poll_err != nil {
+			e := fmt.Sprintf("update_table.UpdateThroughput: %s",poll_err.Error())
+			return errors.New(e)
+		}
+		if !active {
+			e := fmt.Sprintf("update_table.UpdateThroughput: table %s did not become ACTIVE",tablename)
+			return errors.New(e)
+		}
+		have = next
+	}
+	return nil
+}
@@ -33,6 +33,7 @@ package update_table
 import (
 	"fmt"
 	"errors"
+	"encoding/json"
 	"github.com/smugmug/godynamo/authreq"
 	"github.com/smugmug/godynamo/aws_const"
 	ep "github.com/smugmug/godynamo/endpoint"
@@ -46,11 +47,33 @@ const (
 
 type Update struct {
 	TableName string
+	// BillingMode is optional, see SwitchBillingMode
+	BillingMode ep.NullableString
 	ProvisionedThroughput ep.ProvisionedThroughput
 }
 
+type update struct {
+	TableName string
+	BillingMode ep.NullableString `json:",omitempty"`
+	ProvisionedThroughput *ep.ProvisionedThroughput `json:",omitempty"`
+}
+
 type Request Update
 
+// ProvisionedThroughput is omitted when zero, as it must be for PAY_PER_REQUEST tables.
+func (u Update) MarshalJSON() ([]byte, error) {
+	ui := update{TableName:u.TableName,BillingMode:u.BillingMode}
+	if u.ProvisionedThroughput.ReadCapacityUnits != 0 ||
+		u.ProvisionedThroughput.WriteCapacityUnits != 0 {
+		ui.ProvisionedThroughput = &u.ProvisionedThroughput
+	}
+	return json.Marshal(ui)
+}
+
+func (r Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Update(r))
+}
+
 type Response create.Response
 
 // NewResponse will return a pointer to an initialized Response struct.
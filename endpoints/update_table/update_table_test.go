@@ -26,7 +26,11 @@ package update_table
 
 import (
 	"testing"
+	"time"
 	"encoding/json"
+	"github.com/smugmug/godynamo/capacity"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 )
 
 func TestRequestUnmarshal(t *testing.T) {
@@ -126,3 +130,66 @@ func TestResponseUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestBillingModeMarshal(t *testing.T) {
+	u,u_err := switchUpdate("Thread",BILLING_PAY_PER_REQUEST,ep.ProvisionedThroughput{})
+	if u_err != nil {
+		t.Fatalf("cannot make update: %s\n",u_err.Error())
+	}
+	b,_ := json.Marshal(u)
+	if string(b) != `{"TableName":"Thread","BillingMode":"PAY_PER_REQUEST"}` {
+		t.Errorf("unexpected request %s\n",string(b))
+	}
+	if _,err := switchUpdate("Thread",BILLING_PROVISIONED,ep.ProvisionedThroughput{}); err == nil {
+		t.Errorf("PROVISIONED without units should be an error\n")
+	}
+	var td desc.TableDescription
+	td.BillingModeSummary.BillingMode = BILLING_PAY_PER_REQUEST
+	td.BillingModeSummary.LastUpdateToPayPerRequestDateTime = 1.5e9
+	mode,allowed := billingMode(td)
+	if mode != BILLING_PAY_PER_REQUEST || !allowed.Equal(time.Unix(1.5e9,0).Add(BILLING_SWITCH_INTERVAL)) {
+		t.Errorf("got %s %v\n",mode,allowed)
+	}
+}
+
+func TestThroughputSteps(t *testing.T) {
+	if stepUnits(10,100) != 20 || stepUnits(40,60) != 60 || stepUnits(100,10) != 10 {
+		t.Errorf("unexpected steps\n")
+	}
+	var td desc.TableDescription
+	td.TableName = "Thread"
+	td.ProvisionedThroughput.ReadCapacityUnits = 10
+	td.ProvisionedThroughput.WriteCapacityUnits = 10
+	td.ProvisionedThroughput.NumberOfDecreasesToday = MAX_DECREASES_PER_DAY
+	if err := CheckThroughput(td,ep.ProvisionedThroughput{ReadCapacityUnits:5,WriteCapacityUnits:10},true); err == nil {
+		t.Errorf("decrease past the daily limit should be an error\n")
+	}
+	if err := CheckThroughput(td,ep.ProvisionedThroughput{ReadCapacityUnits:20,WriteCapacityUnits:10},false); err != nil {
+		t.Errorf("increase should be allowed: %s\n",err.Error())
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	if recommend(100,70,70,true) != 100 || recommend(100,7,7,true) != 10 || recommend(10,0,0,true) != 1 {
+		t.Errorf("unexpected recommendation\n")
+	}
+	if recommend(100,7,7,false) != 100 || recommend(100,7,40,true) != 40 || recommend(10,70,70,false) != 100 {
+		t.Errorf("unexpected recommendation without coverage or above the peak\n")
+	}
+	now := time.Now()
+	var td desc.TableDescription
+	td.TableName = "AdviseTest"
+	td.ProvisionedThroughput.ReadCapacityUnits = 10
+	td.ProvisionedThroughput.WriteCapacityUnits = 10
+	if a := Advise(td,10 * time.Second); a.Covered || a.Changed() {
+		t.Errorf("advised without observations: %v\n",a)
+	}
+	capacity.Add("AdviseTest",0,true,now.Add(-10 * time.Second))
+	for i := 0; i < 10; i++ {
+		capacity.Add("AdviseTest",35,true,now.Add(-time.Duration(i) * time.Second))
+	}
+	a := Advise(td,10 * time.Second)
+	if !a.Covered || a.Recommended.WriteCapacityUnits != 50 || a.Recommended.ReadCapacityUnits != 1 || !a.Changed() {
+		t.Errorf("unexpected advice %v\n",a)
+	}
+}
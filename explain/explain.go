@@ -0,0 +1,252 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Reports how DynamoDB will execute a Query or Scan, to catch accidental full scans
+// in review and tests.
+//
+// example use:
+//
+//   p,err := explain.Explain(q)
+//   if err == nil && p.IsScan() {
+//	t.Errorf("%s\n",p.String())
+//   }
+package explain
+
+import (
+	"fmt"
+	"math"
+	"errors"
+	"strings"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	query "github.com/smugmug/godynamo/endpoints/query"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+const (
+	// a single item by its full key
+	KEY_LOOKUP = "KEY_LOOKUP"
+	// the items of one partition within a range key condition
+	KEY_RANGE = "KEY_RANGE"
+	// all the items of one partition
+	PARTITION = "PARTITION"
+	// every item of the table or index
+	FULL_SCAN = "FULL_SCAN"
+	// every item of the table or index, returning those the filter matches
+	FILTERED_SCAN = "FILTERED_SCAN"
+)
+
+// SCAN_FILTER_SELECTIVITY is the fraction of items each scan filter condition is
+// assumed to match, for the read amplification of filtered scans.
+var SCAN_FILTER_SELECTIVITY = 0.1
+
+// Plan describes how a request reads a table.
+type Plan struct {
+	Kind string
+	TableName string
+	// "" for the table itself
+	IndexName string
+	HashKey string
+	RangeKey string
+	// estimated items read, from the table's ItemCount
+	ItemsRead uint64
+	// estimated items read per item returned
+	ReadAmplification float64
+	Warnings []string
+}
+
+// IsScan reports whether the plan reads the whole table or index.
+func (p *Plan) IsScan() bool {
+	return p.Kind == FULL_SCAN || p.Kind == FILTERED_SCAN
+}
+
+func (p *Plan) String() string {
+	on := p.TableName
+	if p.IndexName != "" {
+		on += "." + p.IndexName
+	}
+	s := fmt.Sprintf("%s on %s: ~%d items read, read amplification ~%.1f",
+		p.Kind,on,p.ItemsRead,p.ReadAmplification)
+	if len(p.Warnings) != 0 {
+		s += "; " + strings.Join(p.Warnings,"; ")
+	}
+	return s
+}
+
+// Explain returns the Plan of req, a get_item.Get, query.Query or scan.Scan (or a
+// pointer to one), using the schema cached by describe_table.Cached.
+func Explain(req interface{}) (*Plan,error) {
+	var tablename string
+	switch r := req.(type) {
+	case get.Get:
+		tablename = r.TableName
+	case *get.Get:
+		tablename = r.TableName
+	case query.Query:
+		tablename = r.TableName
+	case *query.Query:
+		tablename = r.TableName
+	case scan.Scan:
+		tablename = r.TableName
+	case *scan.Scan:
+		tablename = r.TableName
+	default:
+		return nil,errors.New("explain.Explain: not a Get, Query or Scan")
+	}
+	t,t_err := desc.Cached(tablename)
+	if t_err != nil {
+		e := fmt.Sprintf("explain.Explain: %s",t_err.Error())
+		return nil,errors.New(e)
+	}
+	return ExplainWith(req,t)
+}
+
+// keySchema returns the key schema and item count of the table or the named index.
+func keySchema(t *desc.TableDescription,index string) (ep.KeySchema,uint64,bool) {
+	if index == "" {
+		return t.KeySchema,t.ItemCount,true
+	}
+	if g := t.GSI(index); g != nil {
+		return g.KeySchema,g.ItemCount,true
+	}
+	for _,l := range t.LocalSecondaryIndexes {
+		if l.IndexName == index {
+			// LSI descriptions carry no counts here; the table's bounds them
+			return l.KeySchema,t.ItemCount,true
+		}
+	}
+	return nil,0,false
+}
+
+func keyNames(ks ep.KeySchema) (string,string) {
+	var hash,rangekey string
+	for _,k := range ks {
+		switch k.KeyType {
+		case ep.HASH:
+			hash = k.AttributeName
+		case ep.RANGE:
+			rangekey = k.AttributeName
+		}
+	}
+	return hash,rangekey
+}
+
+// ExplainWith is Explain against the given table description.
+func ExplainWith(req interface{},t *desc.TableDescription) (*Plan,error) {
+	switch r := req.(type) {
+	case get.Get,*get.Get:
+		return explainGet(t),nil
+	case query.Query:
+		return explainQuery(r,t)
+	case *query.Query:
+		return explainQuery(*r,t)
+	case scan.Scan:
+		return explainScan(r,t),nil
+	case *scan.Scan:
+		return explainScan(*r,t),nil
+	}
+	return nil,errors.New("explain.ExplainWith: not a Get, Query or Scan")
+}
+
+func explainGet(t *desc.TableDescription) *Plan {
+	p := &Plan{Kind:KEY_LOOKUP,TableName:t.TableName,ItemsRead:1,ReadAmplification:1}
+	p.HashKey,p.RangeKey = t.KeyAttributeNames()
+	return p
+}
+
+func explainQuery(q query.Query,t *desc.TableDescription) (*Plan,error) {
+	ks,count,ok := keySchema(t,string(q.IndexName))
+	if !ok {
+		e := fmt.Sprintf("explain.Explain: %s has no index %s",t.TableName,string(q.IndexName))
+		return nil,errors.New(e)
+	}
+	p := &Plan{TableName:t.TableName,IndexName:string(q.IndexName),ReadAmplification:1}
+	p.HashKey,p.RangeKey = keyNames(ks)
+	hc,ok := q.KeyConditions[p.HashKey]
+	if !ok || hc.ComparisonOperator != query.OP_EQ {
+		e := fmt.Sprintf("explain.Explain: query needs an EQ condition on %s",p.HashKey)
+		return nil,errors.New(e)
+	}
+	for k,_ := range q.KeyConditions {
+		if k != p.HashKey && k != p.RangeKey {
+			e := fmt.Sprintf("explain.Explain: %s is not a key of the query's table or index",k)
+			return nil,errors.New(e)
+		}
+	}
+	rc,has_range := q.KeyConditions[p.RangeKey]
+	switch {
+	case has_range && rc.ComparisonOperator == query.OP_EQ:
+		p.Kind = KEY_LOOKUP
+		p.ItemsRead = 1
+	case has_range:
+		p.Kind = KEY_RANGE
+	default:
+		p.Kind = PARTITION
+		if p.RangeKey == "" {
+			p.Kind = KEY_LOOKUP
+			p.ItemsRead = 1
+		}
+	}
+	if p.ItemsRead == 0 {
+		// the partition size is unknown; one partition of a table of count items is
+		// at most all of them
+		p.ItemsRead = count
+		if q.Limit != 0 && uint64(q.Limit) < count {
+			p.ItemsRead = uint64(q.Limit)
+		}
+	}
+	return p,nil
+}
+
+func explainScan(s scan.Scan,t *desc.TableDescription) *Plan {
+	p := &Plan{Kind:FULL_SCAN,TableName:t.TableName,ItemsRead:t.ItemCount,ReadAmplification:1}
+	p.HashKey,p.RangeKey = t.KeyAttributeNames()
+	if s.TotalSegments != 0 {
+		p.ItemsRead = t.ItemCount / uint64(s.TotalSegments)
+	}
+	if len(s.ScanFilter) == 0 {
+		return p
+	}
+	p.Kind = FILTERED_SCAN
+	p.ReadAmplification = math.Min(math.Pow(1 / SCAN_FILTER_SELECTIVITY,float64(len(s.ScanFilter))),
+		math.Max(1,float64(p.ItemsRead)))
+	// an EQ filter on a partition key could be a Query instead
+	for k,f := range s.ScanFilter {
+		if f.ComparisonOperator != scan.OP_EQ {
+			continue
+		}
+		if k == p.HashKey {
+			p.Warnings = append(p.Warnings,
+				fmt.Sprintf("filter on %s could be a Query of the table",k))
+		}
+		for _,g := range t.GlobalSecondaryIndexes {
+			if hash,_ := keyNames(g.KeySchema); hash == k {
+				p.Warnings = append(p.Warnings,
+					fmt.Sprintf("filter on %s could be a Query of index %s",k,g.IndexName))
+			}
+		}
+	}
+	return p
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package explain
+
+import (
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	query "github.com/smugmug/godynamo/endpoints/query"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+func table() *desc.TableDescription {
+	t := &desc.TableDescription{TableName:"orders",ItemCount:1000}
+	t.KeySchema = ep.KeySchema{
+		ep.KeyDefinition{AttributeName:"customer",KeyType:ep.HASH},
+		ep.KeyDefinition{AttributeName:"date",KeyType:ep.RANGE}}
+	g := desc.GlobalSecondaryIndexDescription{IndexName:"by_status",ItemCount:1000}
+	g.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"status",KeyType:ep.HASH}}
+	t.GlobalSecondaryIndexes = append(t.GlobalSecondaryIndexes,g)
+	return t
+}
+
+func TestExplainQuery(t *testing.T) {
+	q := query.NewQuery()
+	q.TableName = "orders"
+	eq := query.KeyCondition{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:"c1"}},
+		ComparisonOperator:query.OP_EQ}
+	q.KeyConditions["customer"] = eq
+	p,err := ExplainWith(q,table())
+	if err != nil || p.Kind != PARTITION {
+		t.Errorf("expected a PARTITION plan, got %v %v\n",p,err)
+	}
+	q.KeyConditions["date"] = query.KeyCondition{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:"2013"}},
+		ComparisonOperator:query.OP_BEGINS_WITH}
+	if p,err = ExplainWith(q,table()); err != nil || p.Kind != KEY_RANGE {
+		t.Errorf("expected a KEY_RANGE plan, got %v %v\n",p,err)
+	}
+	delete(q.KeyConditions,"customer")
+	if _,err = ExplainWith(q,table()); err == nil {
+		t.Errorf("expected a query without a hash key condition to fail\n")
+	}
+}
+
+func TestExplainScan(t *testing.T) {
+	s := scan.NewScan()
+	s.TableName = "orders"
+	p,_ := ExplainWith(s,table())
+	if !p.IsScan() || p.ItemsRead != 1000 || p.ReadAmplification != 1 {
+		t.Errorf("unexpected plan %s\n",p.String())
+	}
+	s.ScanFilter["status"] = scan.ScanFilter{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:"open"}},
+		ComparisonOperator:scan.OP_EQ}
+	p,_ = ExplainWith(s,table())
+	if p.Kind != FILTERED_SCAN || len(p.Warnings) != 1 || p.ReadAmplification <= 1 {
+		t.Errorf("unexpected plan %s\n",p.String())
+	}
+}
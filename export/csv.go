@@ -0,0 +1,338 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"errors"
+	"strings"
+	"net/http"
+	"encoding/csv"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+	batch_write_item "github.com/smugmug/godynamo/endpoints/batch_write_item"
+)
+
+const (
+	// separator used to flatten set types into one CSV cell unless otherwise specified
+	SET_SEPARATOR = "|"
+)
+
+// CSVMapping describes how items map to CSV rows. Each column is named for the attribute it
+// holds and has a DynamoDB attribute type (ep.S, ep.N, ep.B, ep.SS, ep.NS or ep.BS); columns
+// without an entry in Types are strings. An empty cell means the attribute is absent.
+// Set types are flattened into one cell, with elements separated by SetSeparator.
+type CSVMapping struct {
+	Columns []string
+	Types map[string] string
+	SetSeparator string
+}
+
+// NewCSVMapping returns a pointer to a CSVMapping for the named columns, all of type S.
+func NewCSVMapping(columns ...string) (*CSVMapping) {
+	m := new(CSVMapping)
+	m.Columns = columns
+	m.Types = make(map[string] string)
+	m.SetSeparator = SET_SEPARATOR
+	return m
+}
+
+func (m *CSVMapping) colType(col string) string {
+	if t,ok := m.Types[col]; ok {
+		return t
+	}
+	return ep.S
+}
+
+func (m *CSVMapping) separator() string {
+	if m.SetSeparator == "" {
+		return SET_SEPARATOR
+	}
+	return m.SetSeparator
+}
+
+// cell renders attribute value a of column col.
+func (m *CSVMapping) cell(col string,a ep.AttributeValue) (string,error) {
+	t := m.colType(col)
+	if a.Type != "" && a.Type != t {
+		e := fmt.Sprintf("export.CSVMapping: column %s is type %s, attribute is %s",col,t,a.Type)
+		return "",errors.New(e)
+	}
+	sep := m.separator()
+	join := func(l []string) (string,error) {
+		for _,v := range l {
+			if strings.Contains(v,sep) {
+				e := fmt.Sprintf("export.CSVMapping: column %s set element %q contains separator %q",
+					col,v,sep)
+				return "",errors.New(e)
+			}
+		}
+		return strings.Join(l,sep),nil
+	}
+	switch t {
+	case ep.S:
+		return a.S,nil
+	case ep.N:
+		return a.N,nil
+	case ep.B:
+		return a.B,nil
+	case ep.SS:
+		return join(a.SS)
+	case ep.NS:
+		return join(a.NS)
+	case ep.BS:
+		return join(a.BS)
+	}
+	e := fmt.Sprintf("export.CSVMapping: column %s has unknown type %s",col,t)
+	return "",errors.New(e)
+}
+
+// attributeValue parses the cell of column col.
+func (m *CSVMapping) attributeValue(col,cell string) (ep.AttributeValue,error) {
+	var a ep.AttributeValue
+	t := m.colType(col)
+	a.Type = t
+	var parse_err error
+	switch t {
+	case ep.S:
+		a.S = cell
+	case ep.N:
+		a.N = cell
+		parse_err = ep.ValidNumber(cell)
+	case ep.B:
+		a.B = cell
+		parse_err = ep.AWSParseBinary(cell)
+	case ep.SS:
+		a.SS = strings.Split(cell,m.separator())
+	case ep.NS:
+		a.NS = strings.Split(cell,m.separator())
+		for _,v := range a.NS {
+			if parse_err = ep.ValidNumber(v); parse_err != nil {
+				break
+			}
+		}
+	case ep.BS:
+		a.BS = strings.Split(cell,m.separator())
+		for _,v := range a.BS {
+			if parse_err = ep.AWSParseBinary(v); parse_err != nil {
+				break
+			}
+		}
+	default:
+		e := fmt.Sprintf("export.CSVMapping: column %s has unknown type %s",col,t)
+		return a,errors.New(e)
+	}
+	if parse_err != nil {
+		e := fmt.Sprintf("export.CSVMapping: column %s value %q is not a valid %s: %s",
+			col,cell,t,parse_err.Error())
+		return a,errors.New(e)
+	}
+	return a,nil
+}
+
+// CSVWriter writes items as CSV rows, preceded by a header row of column names.
+type CSVWriter struct {
+	w *csv.Writer
+	m *CSVMapping
+	wrote_header bool
+}
+
+// NewCSVWriter returns a pointer to a CSVWriter writing to w with mapping m.
+func NewCSVWriter(w io.Writer,m *CSVMapping) (*CSVWriter) {
+	return &CSVWriter{w:csv.NewWriter(w),m:m}
+}
+
+// Write writes item as a row. Attributes of item that have no column are an error rather than
+// being silently dropped.
+func (c *CSVWriter) Write(item ep.Item) error {
+	if len(c.m.Columns) == 0 {
+		return errors.New("export.CSVWriter.Write: mapping has no columns")
+	}
+	if !c.wrote_header {
+		if err := c.w.Write(c.m.Columns); err != nil {
+			return err
+		}
+		c.wrote_header = true
+	}
+	mapped := make(map[string] bool)
+	row := make([]string,len(c.m.Columns))
+	for i,col := range c.m.Columns {
+		mapped[col] = true
+		if a,ok := item[col]; ok {
+			cell,cell_err := c.m.cell(col,a)
+			if cell_err != nil {
+				return cell_err
+			}
+			row[i] = cell
+		}
+	}
+	unmapped := make([]string,0)
+	for k,_ := range item {
+		if !mapped[k] {
+			unmapped = append(unmapped,k)
+		}
+	}
+	if len(unmapped) != 0 {
+		sort.Strings(unmapped)
+		e := fmt.Sprintf("export.CSVWriter.Write: no column for attributes %v",unmapped)
+		return errors.New(e)
+	}
+	return c.w.Write(row)
+}
+
+// Flush writes any buffered rows to the underlying io.Writer.
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// CSVReader reads items from CSV rows. The first row is a header naming the columns; if the
+// mapping has no Columns, they are taken from the header. Otherwise the header must match.
+type CSVReader struct {
+	r *csv.Reader
+	m *CSVMapping
+	read_header bool
+}
+
+// NewCSVReader returns a pointer to a CSVReader reading from r with mapping m.
+func NewCSVReader(r io.Reader,m *CSVMapping) (*CSVReader) {
+	return &CSVReader{r:csv.NewReader(r),m:m}
+}
+
+func sameColumns(a,b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i,_ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Read returns the item in the next row, or io.EOF when there are no more rows.
+func (c *CSVReader) Read() (ep.Item,error) {
+	if !c.read_header {
+		header,h_err := c.r.Read()
+		if h_err != nil {
+			return nil,h_err
+		}
+		if len(c.m.Columns) == 0 {
+			c.m.Columns = header
+		} else if !sameColumns(header,c.m.Columns) {
+			e := fmt.Sprintf("export.CSVReader.Read: header %v does not match columns %v",
+				header,c.m.Columns)
+			return nil,errors.New(e)
+		}
+		c.read_header = true
+	}
+	row,r_err := c.r.Read()
+	if r_err != nil {
+		return nil,r_err
+	}
+	item := make(ep.Item)
+	for i,col := range c.m.Columns {
+		if row[i] == "" {
+			continue
+		}
+		a,a_err := c.m.attributeValue(col,row[i])
+		if a_err != nil {
+			return nil,a_err
+		}
+		item[col] = a
+	}
+	return item,nil
+}
+
+// ScanCSV scans a table to completion, writing each item to w as a CSV row per mapping m,
+// and returns the count of items written.
+func ScanCSV(s scan.Scan,w io.Writer,m *CSVMapping) (uint64,error) {
+	cw := NewCSVWriter(w,m)
+	var n uint64
+	scan_err := s.ForEachPage(func(r *scan.Response) error {
+		for _,item := range r.Items {
+			if write_err := cw.Write(item); write_err != nil {
+				return write_err
+			}
+			n++
+		}
+		return nil
+	})
+	if flush_err := cw.Flush(); flush_err != nil && scan_err == nil {
+		scan_err = flush_err
+	}
+	if scan_err != nil {
+		e := fmt.Sprintf("export.ScanCSV: after %d items: %s",n,scan_err.Error())
+		return n,errors.New(e)
+	}
+	return n,nil
+}
+
+// ImportCSV reads items from CSV rows per mapping m and writes them to table `tablename`,
+// batch_write_item.QUERY_LIM items at a time, returning the count of items written.
+func ImportCSV(r io.Reader,m *CSVMapping,tablename string) (uint64,error) {
+	cr := NewCSVReader(r,m)
+	var n uint64
+	b := batch_write_item.NewBatchWriteItem()
+	flush := func() error {
+		if len(b.RequestItems[tablename]) == 0 {
+			return nil
+		}
+		_,code,err := b.RetryBatchWrite(0)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			e := fmt.Sprintf("batch write returned code %d",code)
+			return errors.New(e)
+		}
+		n += uint64(len(b.RequestItems[tablename]))
+		b = batch_write_item.NewBatchWriteItem()
+		return nil
+	}
+	for {
+		item,read_err := cr.Read()
+		if read_err == io.EOF {
+			break
+		}
+		if read_err == nil && len(b.RequestItems[tablename]) == batch_write_item.QUERY_LIM {
+			read_err = flush()
+		}
+		if read_err != nil {
+			e := fmt.Sprintf("export.ImportCSV: after %d items: %s",n,read_err.Error())
+			return n,errors.New(e)
+		}
+		b.RequestItems[tablename] = append(b.RequestItems[tablename],
+			batch_write_item.RequestInstance{PutRequest:&batch_write_item.PutRequest{Item:item}})
+	}
+	if flush_err := flush(); flush_err != nil {
+		e := fmt.Sprintf("export.ImportCSV: after %d items: %s",n,flush_err.Error())
+		return n,errors.New(e)
+	}
+	return n,nil
+}
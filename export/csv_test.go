@@ -0,0 +1,121 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"bytes"
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	m := NewCSVMapping("id","count","tags","data")
+	m.Types["count"] = ep.N
+	m.Types["tags"] = ep.SS
+	m.Types["data"] = ep.B
+	items := []ep.Item{
+		ep.Item{"id":ep.AttributeValue{S:"a,1"},"count":ep.AttributeValue{N:"12"},
+			"tags":ep.AttributeValue{SS:[]string{"x","y"}},"data":ep.AttributeValue{B:"aGVsbG8="}},
+		ep.Item{"id":ep.AttributeValue{S:"b"}},
+	}
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf,m)
+	for _,item := range items {
+		if err := w.Write(item); err != nil {
+			t.Errorf("cannot write: %s\n",err.Error())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Errorf("cannot flush: %s\n",err.Error())
+	}
+	expected := "id,count,tags,data\n\"a,1\",12,x|y,aGVsbG8=\nb,,,\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n",buf.String(),expected)
+	}
+	r := NewCSVReader(&buf,NewCSVMapping())
+	r.m.Types = m.Types
+	for i,_ := range items {
+		item,err := r.Read()
+		if err != nil {
+			t.Errorf("cannot read: %s\n",err.Error())
+			continue
+		}
+		if len(item) != len(items[i]) {
+			t.Errorf("row %d has %d attributes, expected %d\n",i,len(item),len(items[i]))
+		}
+		for k,v := range items[i] {
+			if item[k].S != v.S || item[k].N != v.N || item[k].B != v.B || len(item[k].SS) != len(v.SS) {
+				t.Errorf("row %d attribute %s: %v != %v\n",i,k,item[k],v)
+			}
+		}
+	}
+	if _,err := r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n",err)
+	}
+}
+
+func TestCSVWriteErrors(t *testing.T) {
+	m := NewCSVMapping("id","count")
+	m.Types["count"] = ep.N
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf,m)
+	if err := w.Write(ep.Item{"id":ep.AttributeValue{S:"a"},"other":ep.AttributeValue{S:"b"}}); err == nil {
+		t.Errorf("unmapped attribute should be an error\n")
+	}
+	if err := w.Write(ep.Item{"count":ep.AttributeValue{S:"a",Type:ep.S}}); err == nil {
+		t.Errorf("mismatched type should be an error\n")
+	}
+	r := NewCSVReader(bytes.NewBufferString("id,count\na,abc\n"),m)
+	if _,err := r.Read(); err == nil {
+		t.Errorf("non-numeric N should be an error\n")
+	}
+}
+
+func TestCSVNumbersKeepText(t *testing.T) {
+	m := NewCSVMapping("n","ns")
+	m.Types["n"] = ep.N
+	m.Types["ns"] = ep.NS
+	for _,n := range []string{"12345678901234567890123","010","1.10","-0.000","1E+125"} {
+		r := NewCSVReader(bytes.NewBufferString("n,ns\n" + n + "," + n + "|7\n"),m)
+		item,err := r.Read()
+		if err != nil {
+			t.Errorf("cannot read %s: %s\n",n,err.Error())
+			continue
+		}
+		if item["n"].N != n {
+			t.Errorf("N %s read as %s\n",n,item["n"].N)
+		}
+		if len(item["ns"].NS) != 2 || item["ns"].NS[0] != n {
+			t.Errorf("NS %s read as %v\n",n,item["ns"].NS)
+		}
+	}
+	for _,n := range []string{"0x10","1_000","-",".","1e","NaN","Inf","1E+126","123456789012345678901234567890123456789"} {
+		r := NewCSVReader(bytes.NewBufferString("n,ns\n\"" + n + "\",1\n"),m)
+		if _,err := r.Read(); err == nil {
+			t.Errorf("%q should not be a valid N\n",n)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Support for exporting table contents.
+//
+// example use:
+//
+//   s := scan.NewScan()
+//   s.TableName = "my-table"
+//   n,err := export.ScanNDJSON(*s,os.Stdout)
+//
+package export
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"errors"
+	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+// ScanNDJSON scans a table to completion and writes each item to w as one line of JSON
+// (newline-delimited JSON), returning the count of items written. Memory use is bounded by
+// the size of one page of Scan results, so this is suitable for piping entire tables into
+// files, uploads or other processes.
+func ScanNDJSON(s scan.Scan,w io.Writer) (uint64,error) {
+	bw := bufio.NewWriter(w)
+	var n uint64
+	scan_err := s.ForEachPage(func(r *scan.Response) error {
+		c,write_err := writeNDJSON(bw,r.Items)
+		n += c
+		return write_err
+	})
+	if flush_err := bw.Flush(); flush_err != nil && scan_err == nil {
+		scan_err = flush_err
+	}
+	if scan_err != nil {
+		e := fmt.Sprintf("export.ScanNDJSON: after %d items: %s",n,scan_err.Error())
+		return n,errors.New(e)
+	}
+	return n,nil
+}
+
+// writeNDJSON writes each item as a line of JSON.
+func writeNDJSON(w io.Writer,items []ep.Item) (uint64,error) {
+	var n uint64
+	enc := json.NewEncoder(w)
+	for _,item := range items {
+		// Encode terminates each value with a newline
+		if enc_err := enc.Encode(item); enc_err != nil {
+			return n,enc_err
+		}
+		n++
+	}
+	return n,nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"bytes"
+	"testing"
+	"strings"
+	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestWriteNDJSON(t *testing.T) {
+	items := []ep.Item{
+		ep.Item{"id":ep.AttributeValue{S:"a"},"n":ep.AttributeValue{N:"1"}},
+		ep.Item{"id":ep.AttributeValue{S:"b"},"ss":ep.AttributeValue{SS:[]string{"x","y"}}},
+	}
+	var buf bytes.Buffer
+	n,err := writeNDJSON(&buf,items)
+	if err != nil {
+		t.Errorf("cannot write: %s\n",err.Error())
+	}
+	if n != 2 {
+		t.Errorf("wrote %d items, expected 2\n",n)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(),"\n"),"\n")
+	if len(lines) != 2 {
+		t.Errorf("expected 2 lines:\n%s\n",buf.String())
+	}
+	for i,l := range lines {
+		var item ep.Item
+		um_err := json.Unmarshal([]byte(l),&item)
+		if um_err != nil {
+			t.Errorf("cannot unmarshal line:\n" + l + "\n")
+		}
+		if item["id"].S != items[i]["id"].S {
+			t.Errorf("line %d does not match item\n",i)
+		}
+	}
+}
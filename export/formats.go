@@ -0,0 +1,264 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+// Formats emitted by ScanFormat. FORMAT_DYNAMODB_JSON and FORMAT_ION match the formats of the
+// AWS export-to-S3 feature, so dumps made here can be used in the same downstream pipelines.
+const (
+	// one item per line, as ep.Item JSON
+	FORMAT_NDJSON = "NDJSON"
+	// one {"Item":{...}} object per line
+	FORMAT_DYNAMODB_JSON = "DYNAMODB_JSON"
+	// Amazon Ion text, one {Item:{...}} struct per line
+	FORMAT_ION = "ION"
+	ION_VERSION_MARKER = "$ion_1_0"
+)
+
+// exportRecord is the DynamoDB JSON export envelope.
+type exportRecord struct {
+	Item ep.Item
+}
+
+// ItemWriter writes items in one of the export formats.
+type ItemWriter struct {
+	w *bufio.Writer
+	format string
+	started bool
+}
+
+// NewItemWriter returns a pointer to an ItemWriter writing the named format to w.
+func NewItemWriter(w io.Writer,format string) (*ItemWriter,error) {
+	switch format {
+	case FORMAT_NDJSON,FORMAT_DYNAMODB_JSON,FORMAT_ION:
+		return &ItemWriter{w:bufio.NewWriter(w),format:format},nil
+	}
+	e := fmt.Sprintf("export.NewItemWriter: unknown format %s",format)
+	return nil,errors.New(e)
+}
+
+// Write writes one item.
+func (iw *ItemWriter) Write(item ep.Item) error {
+	var line []byte
+	var err error
+	switch iw.format {
+	case FORMAT_NDJSON:
+		line,err = json.Marshal(item)
+	case FORMAT_DYNAMODB_JSON:
+		line,err = json.Marshal(exportRecord{Item:item})
+	case FORMAT_ION:
+		if !iw.started {
+			if _,err = iw.w.WriteString(ION_VERSION_MARKER + "\n"); err != nil {
+				return err
+			}
+		}
+		line,err = IonItem(item)
+		if err == nil {
+			line = []byte("{Item:" + string(line) + "}")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	iw.started = true
+	if _,err = iw.w.Write(line); err != nil {
+		return err
+	}
+	return iw.w.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (iw *ItemWriter) Flush() error {
+	return iw.w.Flush()
+}
+
+// ScanFormat scans a table to completion, writing each item to w in the named format,
+// and returns the count of items written.
+func ScanFormat(s scan.Scan,w io.Writer,format string) (uint64,error) {
+	iw,iw_err := NewItemWriter(w,format)
+	if iw_err != nil {
+		return 0,iw_err
+	}
+	var n uint64
+	scan_err := s.ForEachPage(func(r *scan.Response) error {
+		for _,item := range r.Items {
+			if write_err := iw.Write(item); write_err != nil {
+				return write_err
+			}
+			n++
+		}
+		return nil
+	})
+	if flush_err := iw.Flush(); flush_err != nil && scan_err == nil {
+		scan_err = flush_err
+	}
+	if scan_err != nil {
+		e := fmt.Sprintf("export.ScanFormat: after %d items: %s",n,scan_err.Error())
+		return n,errors.New(e)
+	}
+	return n,nil
+}
+
+// DynamoDBJSONReader reads items from DynamoDB JSON export data, such as the files
+// written by the AWS export-to-S3 feature.
+type DynamoDBJSONReader struct {
+	d *json.Decoder
+}
+
+// NewDynamoDBJSONReader returns a pointer to a DynamoDBJSONReader reading from r.
+func NewDynamoDBJSONReader(r io.Reader) (*DynamoDBJSONReader) {
+	return &DynamoDBJSONReader{d:json.NewDecoder(r)}
+}
+
+// Read returns the next item, or io.EOF when there are no more.
+func (dr *DynamoDBJSONReader) Read() (ep.Item,error) {
+	var rec exportRecord
+	if err := dr.d.Decode(&rec); err != nil {
+		return nil,err
+	}
+	return rec.Item,nil
+}
+
+// ionString renders s as an Ion string. JSON string escapes are a subset of Ion's.
+func ionString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimRight(buf.String(),"\n")
+}
+
+// ionSymbol renders s as an Ion symbol, quoting it unless it is a plain identifier.
+func ionSymbol(s string) string {
+	plain := s != ""
+	for i,c := range s {
+		if !(c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return s
+	}
+	return "'" + strings.Replace(strings.Replace(s,"\\","\\\\",-1),"'","\\'",-1) + "'"
+}
+
+// ionDecimal renders a DynamoDB number as an Ion decimal, digit for digit: the integer
+// part without leading zeros or a plus sign, always a decimal point (an Ion number
+// without one would be an int), and the exponent marked with d (with e it would be a
+// float).
+func ionDecimal(n string) (string,error) {
+	if n_err := ep.ValidNumber(n); n_err != nil {
+		return "",n_err
+	}
+	sign := ""
+	if n[0] == '-' || n[0] == '+' {
+		if n[0] == '-' {
+			sign = "-"
+		}
+		n = n[1:]
+	}
+	mantissa,exp := n,""
+	if i := strings.IndexAny(n,"eE"); i >= 0 {
+		mantissa,exp = n[:i],"d" + n[i + 1:]
+	}
+	int_part,frac := mantissa,""
+	if i := strings.Index(mantissa,"."); i >= 0 {
+		int_part,frac = mantissa[:i],mantissa[i + 1:]
+	}
+	int_part = strings.TrimLeft(int_part,"0")
+	if int_part == "" {
+		int_part = "0"
+	}
+	return sign + int_part + "." + frac + exp,nil
+}
+
+// IonItem renders an item as an Ion struct, using the type mapping of the AWS export
+// to S3 feature: S as string, N as decimal, B as blob, and sets as lists annotated with
+// $dynamodb_SS, $dynamodb_NS or $dynamodb_BS. Fields are written in sorted order.
+func IonItem(item ep.Item) ([]byte,error) {
+	names := make([]string,0,len(item))
+	for k,_ := range item {
+		names = append(names,k)
+	}
+	sort.Strings(names)
+	fields := make([]string,0,len(names))
+	for _,k := range names {
+		a := item[k]
+		var v string
+		switch {
+		case a.S != "":
+			v = ionString(a.S)
+		case a.N != "":
+			d,d_err := ionDecimal(a.N)
+			if d_err != nil {
+				return nil,d_err
+			}
+			v = d
+		case a.B != "":
+			v = "{{" + a.B + "}}"
+		case len(a.SS) != 0:
+			l := make([]string,len(a.SS))
+			for i,e := range a.SS {
+				l[i] = ionString(e)
+			}
+			v = "$dynamodb_SS::[" + strings.Join(l,",") + "]"
+		case len(a.NS) != 0:
+			l := make([]string,len(a.NS))
+			for i,e := range a.NS {
+				d,d_err := ionDecimal(e)
+				if d_err != nil {
+					return nil,d_err
+				}
+				l[i] = d
+			}
+			v = "$dynamodb_NS::[" + strings.Join(l,",") + "]"
+		case len(a.BS) != 0:
+			l := make([]string,len(a.BS))
+			for i,e := range a.BS {
+				l[i] = "{{" + e + "}}"
+			}
+			v = "$dynamodb_BS::[" + strings.Join(l,",") + "]"
+		default:
+			e := fmt.Sprintf("export.IonItem: attribute %s has no value",k)
+			return nil,errors.New(e)
+		}
+		fields = append(fields,ionSymbol(k) + ":" + v)
+	}
+	return []byte("{" + strings.Join(fields,",") + "}"),nil
+}
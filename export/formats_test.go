@@ -0,0 +1,109 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"bytes"
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestIonItem(t *testing.T) {
+	item := ep.Item{
+		"id":ep.AttributeValue{S:"a \"quoted\" string"},
+		"count":ep.AttributeValue{N:"12"},
+		"price":ep.AttributeValue{N:"1.50"},
+		"data":ep.AttributeValue{B:"aGVsbG8="},
+		"tags":ep.AttributeValue{SS:[]string{"x","y"}},
+		"scores":ep.AttributeValue{NS:[]string{"1","2.5"}},
+		"odd-name":ep.AttributeValue{S:"v"},
+	}
+	ion,err := IonItem(item)
+	if err != nil {
+		t.Errorf("cannot render ion: %s\n",err.Error())
+	}
+	expected := `{count:12.,data:{{aGVsbG8=}},id:"a \"quoted\" string",` +
+		`'odd-name':"v",price:1.50,scores:$dynamodb_NS::[1.,2.5],tags:$dynamodb_SS::["x","y"]}`
+	if string(ion) != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n",string(ion),expected)
+	}
+}
+
+func TestIonDecimal(t *testing.T) {
+	cases := map[string] string{
+		"12345678901234567890123":"12345678901234567890123.",
+		"010":"10.",
+		"-0.50":"-0.50",
+		".5":"0.5",
+		"+7":"7.",
+		"1E+125":"1.d+125",
+		"-2.5e-3":"-2.5d-3",
+	}
+	for n,expected := range cases {
+		if d,err := ionDecimal(n); err != nil || d != expected {
+			t.Errorf("%s rendered as %s %v, expected %s\n",n,d,err,expected)
+		}
+	}
+	if _,err := ionDecimal("0x10"); err == nil {
+		t.Errorf("0x10 rendered as a decimal\n")
+	}
+}
+
+func TestDynamoDBJSONRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w,w_err := NewItemWriter(&buf,FORMAT_DYNAMODB_JSON)
+	if w_err != nil {
+		t.Errorf("cannot make writer: %s\n",w_err.Error())
+	}
+	w.Write(ep.Item{"id":ep.AttributeValue{S:"a"}})
+	w.Write(ep.Item{"id":ep.AttributeValue{S:"b"}})
+	w.Flush()
+	expected := `{"Item":{"id":{"S":"a"}}}` + "\n" + `{"Item":{"id":{"S":"b"}}}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n",buf.String(),expected)
+	}
+	r := NewDynamoDBJSONReader(&buf)
+	for _,id := range []string{"a","b"} {
+		item,err := r.Read()
+		if err != nil || item["id"].S != id {
+			t.Errorf("expected item %s, got %v %v\n",id,item,err)
+		}
+	}
+	if _,err := r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n",err)
+	}
+}
+
+func TestIonWriterMarker(t *testing.T) {
+	var buf bytes.Buffer
+	w,_ := NewItemWriter(&buf,FORMAT_ION)
+	w.Write(ep.Item{"id":ep.AttributeValue{S:"a"}})
+	w.Flush()
+	expected := ION_VERSION_MARKER + "\n" + `{Item:{id:"a"}}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s\n",buf.String(),expected)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"errors"
+	"math/big"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+// Incremental exports are for tables without streams enabled that record an updated-at
+// attribute on every write. Each run exports only the items whose attribute is at or after
+// the high-water mark of the previous run; the comparison is GE rather than GT so items
+// written at the same instant as the mark are not lost, at the cost of re-exporting the
+// items at the mark itself. The attribute must be an N (such as epoch seconds) or an S
+// that sorts lexically (such as RFC 3339 timestamps in UTC).
+
+// Watermark is the highest value of Attribute seen by an incremental export.
+type Watermark struct {
+	Attribute string
+	Value ep.AttributeValue
+}
+
+// ReadWatermark reads a Watermark saved by Save. If the file does not exist, a nil
+// Watermark and nil error are returned, and the next incremental export is a full export.
+func ReadWatermark(path string) (*Watermark,error) {
+	b,read_err := ioutil.ReadFile(path)
+	if os.IsNotExist(read_err) {
+		return nil,nil
+	} else if read_err != nil {
+		e := fmt.Sprintf("export.ReadWatermark: %s",read_err.Error())
+		return nil,errors.New(e)
+	}
+	m := new(Watermark)
+	if um_err := json.Unmarshal(b,m); um_err != nil {
+		e := fmt.Sprintf("export.ReadWatermark: cannot unmarshal %s: %s",path,um_err.Error())
+		return nil,errors.New(e)
+	}
+	return m,nil
+}
+
+// Save writes the Watermark to path. The file is replaced atomically so an interrupted
+// run leaves the previous mark in place.
+func (m *Watermark) Save(path string) error {
+	b,json_err := json.Marshal(m)
+	if json_err != nil {
+		e := fmt.Sprintf("export.Watermark.Save: %s",json_err.Error())
+		return errors.New(e)
+	}
+	tmp,tmp_err := ioutil.TempFile(filepath.Dir(path),filepath.Base(path) + ".")
+	if tmp_err != nil {
+		e := fmt.Sprintf("export.Watermark.Save: %s",tmp_err.Error())
+		return errors.New(e)
+	}
+	_,write_err := tmp.Write(b)
+	close_err := tmp.Close()
+	if write_err == nil {
+		write_err = close_err
+	}
+	if write_err == nil {
+		write_err = os.Rename(tmp.Name(),path)
+	}
+	if write_err != nil {
+		os.Remove(tmp.Name())
+		e := fmt.Sprintf("export.Watermark.Save: %s",write_err.Error())
+		return errors.New(e)
+	}
+	return nil
+}
+
+// after reports whether a is greater than the mark value b. Both must be of the same type.
+func after(a,b ep.AttributeValue) (bool,error) {
+	switch {
+	case a.N != "" && b.N != "":
+		af,_,a_err := big.ParseFloat(a.N,10,256,big.ToNearestEven)
+		bf,_,b_err := big.ParseFloat(b.N,10,256,big.ToNearestEven)
+		if a_err != nil || b_err != nil {
+			e := fmt.Sprintf("cannot compare numbers %s and %s",a.N,b.N)
+			return false,errors.New(e)
+		}
+		return af.Cmp(bf) > 0,nil
+	case a.S != "" && b.S != "":
+		return a.S > b.S,nil
+	}
+	return false,errors.New("watermark values must both be N or both be S")
+}
+
+// ScanIncremental scans for the items whose mark.Attribute is at or after mark.Value,
+// writing them to w in the named format (see ItemWriter). Any ScanFilter already set
+// on s is kept. It returns the count of items written and the new Watermark, which
+// should be saved for the next run only after w has been safely stored. If mark.Value
+// is empty, all items with the attribute are exported.
+func ScanIncremental(s scan.Scan,w io.Writer,format string,mark Watermark) (uint64,*Watermark,error) {
+	if mark.Attribute == "" {
+		return 0,nil,errors.New("export.ScanIncremental: no watermark attribute")
+	}
+	filters := make(scan.ScanFilters)
+	for k,v := range s.ScanFilter {
+		filters[k] = v
+	}
+	if mark.Value.N == "" && mark.Value.S == "" {
+		filters[mark.Attribute] = scan.ScanFilter{ComparisonOperator:scan.OP_NOT_NULL}
+	} else {
+		filters[mark.Attribute] = scan.ScanFilter{
+			AttributeValueList:[]ep.AttributeValue{mark.Value},
+			ComparisonOperator:scan.OP_GE}
+	}
+	s.ScanFilter = filters
+	iw,iw_err := NewItemWriter(w,format)
+	if iw_err != nil {
+		return 0,nil,iw_err
+	}
+	next := &Watermark{Attribute:mark.Attribute,Value:mark.Value}
+	var n uint64
+	scan_err := s.ForEachPage(func(r *scan.Response) error {
+		for _,item := range r.Items {
+			if write_err := iw.Write(item); write_err != nil {
+				return write_err
+			}
+			n++
+			v := item[mark.Attribute]
+			if next.Value.N == "" && next.Value.S == "" {
+				next.Value = ep.AttributeValue{N:v.N,S:v.S}
+				continue
+			}
+			later,cmp_err := after(v,next.Value)
+			if cmp_err != nil {
+				return cmp_err
+			}
+			if later {
+				next.Value = ep.AttributeValue{N:v.N,S:v.S}
+			}
+		}
+		return nil
+	})
+	if flush_err := iw.Flush(); flush_err != nil && scan_err == nil {
+		scan_err = flush_err
+	}
+	if scan_err != nil {
+		e := fmt.Sprintf("export.ScanIncremental: after %d items: %s",n,scan_err.Error())
+		return n,nil,errors.New(e)
+	}
+	return n,next,nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestWatermarkSaveRead(t *testing.T) {
+	dir,dir_err := ioutil.TempDir("","watermark")
+	if dir_err != nil {
+		t.Fatalf("cannot make dir: %s\n",dir_err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir,"mark.json")
+	if m,err := ReadWatermark(path); m != nil || err != nil {
+		t.Errorf("missing file should be a nil mark\n")
+	}
+	m := &Watermark{Attribute:"updated",Value:ep.AttributeValue{N:"1400000000"}}
+	if err := m.Save(path); err != nil {
+		t.Fatalf("cannot save: %s\n",err.Error())
+	}
+	r,r_err := ReadWatermark(path)
+	if r_err != nil {
+		t.Fatalf("cannot read: %s\n",r_err.Error())
+	}
+	if r.Attribute != m.Attribute || r.Value.N != m.Value.N {
+		t.Errorf("got %v expected %v\n",r,m)
+	}
+}
+
+func TestWatermarkAfter(t *testing.T) {
+	tests := []struct {
+		a,b ep.AttributeValue
+		later bool
+	}{
+		{ep.AttributeValue{N:"10"},ep.AttributeValue{N:"9"},true},
+		{ep.AttributeValue{N:"9.5"},ep.AttributeValue{N:"10"},false},
+		{ep.AttributeValue{S:"2014-05-02T00:00:00Z"},ep.AttributeValue{S:"2014-05-01T12:00:00Z"},true},
+	}
+	for _,test := range tests {
+		later,err := after(test.a,test.b)
+		if err != nil || later != test.later {
+			t.Errorf("after(%v,%v) got %v %v\n",test.a,test.b,later,err)
+		}
+	}
+	if _,err := after(ep.AttributeValue{N:"1"},ep.AttributeValue{S:"1"}); err == nil {
+		t.Errorf("mixed types should be an error\n")
+	}
+}
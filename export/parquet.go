@@ -0,0 +1,547 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"encoding/json"
+	"encoding/base64"
+	"encoding/binary"
+	ep "github.com/smugmug/godynamo/endpoint"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+// This is a deliberately small Parquet writer: a flat schema of OPTIONAL columns, PLAIN
+// encoding, no compression, and one data page per column chunk. That is enough for
+// analytics engines to query table exports directly. S columns are UTF8 strings, N columns
+// are UTF8 strings of the number as DynamoDB returns it (its 38 digits of precision do not
+// fit a double), B columns are (decoded) binary, and set types are UTF8 JSON arrays.
+// See https://github.com/apache/parquet-format for the format.
+
+const (
+	PARQUET_MAGIC = "PAR1"
+	// rows buffered into each row group unless otherwise specified
+	PARQUET_ROW_GROUP_SIZE = 10000
+
+	// parquet physical type
+	parquet_byte_array = 6
+	// parquet converted type
+	parquet_utf8 = 0
+	// parquet repetition type
+	parquet_optional = 1
+	// parquet encodings
+	parquet_plain = 0
+	parquet_rle = 3
+	// parquet page type
+	parquet_data_page = 0
+	// parquet compression codec
+	parquet_uncompressed = 0
+
+	// thrift compact protocol types
+	thrift_i32 = 5
+	thrift_i64 = 6
+	thrift_binary = 8
+	thrift_list = 9
+	thrift_struct = 12
+)
+
+// ParquetColumn names an attribute and its DynamoDB type (ep.S, ep.N, ep.B, ep.SS, ep.NS, ep.BS).
+type ParquetColumn struct {
+	Name string
+	Type string
+}
+
+// ParquetSchema is the list of columns written to a Parquet file.
+type ParquetSchema []ParquetColumn
+
+// ParquetSchemaFromItems derives a schema from a sample of items. Columns are sorted by
+// name. An attribute seen with more than one type is an error.
+func ParquetSchemaFromItems(items []ep.Item) (ParquetSchema,error) {
+	types := make(map[string] string)
+	for _,item := range items {
+		for k,a := range item {
+			t := attributeType(a)
+			if prev,ok := types[k]; ok && prev != t {
+				e := fmt.Sprintf("export.ParquetSchemaFromItems: attribute %s is both %s and %s",
+					k,prev,t)
+				return nil,errors.New(e)
+			}
+			types[k] = t
+		}
+	}
+	schema := make(ParquetSchema,0,len(types))
+	for k,t := range types {
+		schema = append(schema,ParquetColumn{Name:k,Type:t})
+	}
+	sort.Sort(schema)
+	return schema,nil
+}
+
+func (p ParquetSchema) Len() int { return len(p) }
+func (p ParquetSchema) Less(i,j int) bool { return p[i].Name < p[j].Name }
+func (p ParquetSchema) Swap(i,j int) { p[i],p[j] = p[j],p[i] }
+
+// ParquetSchemaFromStruct derives a schema from the exported fields of struct `v`. A field's
+// column is named by its `dynamo` struct tag, or by the field name if there is no tag; a tag
+// of "-" skips the field. Strings map to S, numbers to N, []byte to B, and slices of
+// strings or numbers to SS or NS.
+func ParquetSchemaFromStruct(v interface{}) (ParquetSchema,error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil,errors.New("export.ParquetSchemaFromStruct: v is not a struct")
+	}
+	schema := make(ParquetSchema,0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("dynamo"),",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		col_type := kindType(f.Type)
+		if col_type == "" {
+			e := fmt.Sprintf("export.ParquetSchemaFromStruct: field %s has unsupported type %v",
+				f.Name,f.Type)
+			return nil,errors.New(e)
+		}
+		schema = append(schema,ParquetColumn{Name:name,Type:col_type})
+	}
+	return schema,nil
+}
+
+func kindType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return ep.S
+	case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,
+		reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64,
+		reflect.Float32,reflect.Float64:
+		return ep.N
+	case reflect.Slice:
+		switch kindType(t.Elem()) {
+		case ep.S:
+			return ep.SS
+		case ep.N:
+			if t.Elem().Kind() == reflect.Uint8 {
+				return ep.B
+			}
+			return ep.NS
+		}
+	}
+	return ""
+}
+
+func attributeType(a ep.AttributeValue) string {
+	if a.Type != "" {
+		return a.Type
+	}
+	switch {
+	case a.N != "":
+		return ep.N
+	case a.B != "":
+		return ep.B
+	case len(a.SS) != 0:
+		return ep.SS
+	case len(a.NS) != 0:
+		return ep.NS
+	case len(a.BS) != 0:
+		return ep.BS
+	}
+	return ep.S
+}
+
+// column accumulates the values of one column for the current row group.
+type column struct {
+	def_levels []byte
+	values bytes.Buffer
+}
+
+type columnChunkMeta struct {
+	offset int64
+	size int64
+	num_values int64
+}
+
+// ParquetWriter writes items as rows of a Parquet file. Rows are buffered in memory in
+// row groups of RowGroupSize rows. Close must be called to write the file footer.
+type ParquetWriter struct {
+	w io.Writer
+	schema ParquetSchema
+	RowGroupSize int
+	offset int64
+	columns []column
+	rows int
+	total_rows int64
+	row_groups [][]columnChunkMeta
+	group_rows []int64
+}
+
+// NewParquetWriter returns a pointer to a ParquetWriter writing to w with the given schema.
+func NewParquetWriter(w io.Writer,schema ParquetSchema) (*ParquetWriter,error) {
+	if len(schema) == 0 {
+		return nil,errors.New("export.NewParquetWriter: schema has no columns")
+	}
+	return newParquetWriter(w,schema)
+}
+
+// newParquetWriter is NewParquetWriter allowing a schema of no columns, for the file of
+// a scan that found no items to derive a schema from.
+func newParquetWriter(w io.Writer,schema ParquetSchema) (*ParquetWriter,error) {
+	for _,c := range schema {
+		switch c.Type {
+		case ep.S,ep.N,ep.B,ep.SS,ep.NS,ep.BS:
+		default:
+			e := fmt.Sprintf("export.NewParquetWriter: column %s has unknown type %s",c.Name,c.Type)
+			return nil,errors.New(e)
+		}
+	}
+	p := &ParquetWriter{w:w,schema:schema,RowGroupSize:PARQUET_ROW_GROUP_SIZE}
+	p.columns = make([]column,len(schema))
+	if _,err := io.WriteString(w,PARQUET_MAGIC); err != nil {
+		return nil,err
+	}
+	p.offset = int64(len(PARQUET_MAGIC))
+	return p,nil
+}
+
+// Write adds item as a row. Attributes not in the schema are ignored; attributes missing
+// from item are null.
+func (p *ParquetWriter) Write(item ep.Item) error {
+	for i,c := range p.schema {
+		a,ok := item[c.Name]
+		if !ok {
+			p.columns[i].def_levels = append(p.columns[i].def_levels,0)
+			continue
+		}
+		if t := attributeType(a); t != c.Type {
+			e := fmt.Sprintf("export.ParquetWriter.Write: column %s is type %s, attribute is %s",
+				c.Name,c.Type,t)
+			return errors.New(e)
+		}
+		if err := p.columns[i].appendValue(c.Type,a); err != nil {
+			e := fmt.Sprintf("export.ParquetWriter.Write: column %s: %s",c.Name,err.Error())
+			return errors.New(e)
+		}
+		p.columns[i].def_levels = append(p.columns[i].def_levels,1)
+	}
+	p.rows++
+	if p.rows >= p.RowGroupSize {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (c *column) appendValue(t string,a ep.AttributeValue) error {
+	var l [4]byte
+	byte_array := func(b []byte) {
+		binary.LittleEndian.PutUint32(l[:],uint32(len(b)))
+		c.values.Write(l[:])
+		c.values.Write(b)
+	}
+	switch t {
+	case ep.S:
+		byte_array([]byte(a.S))
+	case ep.N:
+		byte_array([]byte(a.N))
+	case ep.B:
+		b,b_err := base64.StdEncoding.DecodeString(a.B)
+		if b_err != nil {
+			return b_err
+		}
+		byte_array(b)
+	default:
+		var set []string
+		switch t {
+		case ep.SS:
+			set = a.SS
+		case ep.NS:
+			set = a.NS
+		case ep.BS:
+			set = a.BS
+		}
+		b,j_err := json.Marshal(set)
+		if j_err != nil {
+			return j_err
+		}
+		byte_array(b)
+	}
+	return nil
+}
+
+// rleDefinitionLevels encodes definition levels (bit width 1) with the RLE/bit-packing
+// hybrid encoding as RLE runs, prefixed with the 4 byte length data page v1 requires.
+func rleDefinitionLevels(levels []byte) []byte {
+	var runs bytes.Buffer
+	var vb [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		n := binary.PutUvarint(vb[:],uint64(j-i) << 1)
+		runs.Write(vb[:n])
+		runs.WriteByte(levels[i])
+		i = j
+	}
+	out := make([]byte,4,4+runs.Len())
+	binary.LittleEndian.PutUint32(out,uint32(runs.Len()))
+	return append(out,runs.Bytes()...)
+}
+
+// flushRowGroup writes the buffered rows as a row group.
+func (p *ParquetWriter) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	metas := make([]columnChunkMeta,len(p.schema))
+	for i,_ := range p.schema {
+		c := &p.columns[i]
+		page := append(rleDefinitionLevels(c.def_levels),c.values.Bytes()...)
+		var hdr thriftWriter
+		hdr.i32Field(1,parquet_data_page)
+		hdr.i32Field(2,int32(len(page)))
+		hdr.i32Field(3,int32(len(page)))
+		hdr.structBegin(5)
+		hdr.i32Field(1,int32(len(c.def_levels)))
+		hdr.i32Field(2,parquet_plain)
+		hdr.i32Field(3,parquet_rle)
+		hdr.i32Field(4,parquet_rle)
+		hdr.structEnd()
+		hdr.stop()
+		metas[i] = columnChunkMeta{offset:p.offset,
+			size:int64(hdr.buf.Len() + len(page)),num_values:int64(len(c.def_levels))}
+		if _,err := p.w.Write(hdr.buf.Bytes()); err != nil {
+			return err
+		}
+		if _,err := p.w.Write(page); err != nil {
+			return err
+		}
+		p.offset += metas[i].size
+		p.columns[i] = column{}
+	}
+	p.row_groups = append(p.row_groups,metas)
+	p.group_rows = append(p.group_rows,int64(p.rows))
+	p.total_rows += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// Close writes any buffered rows and the file footer. It does not close the underlying io.Writer.
+func (p *ParquetWriter) Close() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+	var t thriftWriter
+	t.i32Field(1,1)
+	t.listBegin(2,thrift_struct,len(p.schema) + 1)
+	t.elemBegin()
+	t.binaryField(4,[]byte("schema"))
+	t.i32Field(5,int32(len(p.schema)))
+	t.structEnd()
+	for _,c := range p.schema {
+		t.elemBegin()
+		t.i32Field(1,parquet_byte_array)
+		t.i32Field(3,parquet_optional)
+		t.binaryField(4,[]byte(c.Name))
+		if c.Type != ep.B {
+			t.i32Field(6,parquet_utf8)
+		}
+		t.structEnd()
+	}
+	t.i64Field(3,p.total_rows)
+	t.listBegin(4,thrift_struct,len(p.row_groups))
+	for g,metas := range p.row_groups {
+		t.elemBegin()
+		t.listBegin(1,thrift_struct,len(metas))
+		var group_size int64
+		for i,m := range metas {
+			group_size += m.size
+			t.elemBegin()
+			t.i64Field(2,m.offset)
+			t.structBegin(3)
+			t.i32Field(1,parquet_byte_array)
+			t.listBegin(2,thrift_i32,2)
+			t.varint(zigzag(parquet_plain))
+			t.varint(zigzag(parquet_rle))
+			t.listBegin(3,thrift_binary,1)
+			t.binary([]byte(p.schema[i].Name))
+			t.i32Field(4,parquet_uncompressed)
+			t.i64Field(5,m.num_values)
+			t.i64Field(6,m.size)
+			t.i64Field(7,m.size)
+			t.i64Field(9,m.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64Field(2,group_size)
+		t.i64Field(3,p.group_rows[g])
+		t.structEnd()
+	}
+	t.binaryField(6,[]byte("godynamo"))
+	t.stop()
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:],uint32(t.buf.Len()))
+	footer := append(t.buf.Bytes(),l[:]...)
+	footer = append(footer,[]byte(PARQUET_MAGIC)...)
+	_,err := p.w.Write(footer)
+	return err
+}
+
+// ScanParquet scans a table to completion and writes the items to w as a Parquet file,
+// returning the count of items written. If schema is nil, it is derived from the items of
+// the first page of results that has any, and attributes first seen later are not
+// exported. A scan that finds no items writes a file of no rows, with no columns if
+// schema is nil.
+func ScanParquet(s scan.Scan,w io.Writer,schema ParquetSchema) (uint64,error) {
+	var p *ParquetWriter
+	var n uint64
+	scan_err := s.ForEachPage(func(r *scan.Response) error {
+		if p == nil {
+			if schema == nil {
+				if len(r.Items) == 0 {
+					return nil
+				}
+				var schema_err error
+				if schema,schema_err = ParquetSchemaFromItems(r.Items); schema_err != nil {
+					return schema_err
+				}
+			}
+			var p_err error
+			if p,p_err = NewParquetWriter(w,schema); p_err != nil {
+				return p_err
+			}
+		}
+		for _,item := range r.Items {
+			if write_err := p.Write(item); write_err != nil {
+				return write_err
+			}
+			n++
+		}
+		return nil
+	})
+	if scan_err == nil && p == nil {
+		p,scan_err = newParquetWriter(w,schema)
+	}
+	if scan_err == nil {
+		scan_err = p.Close()
+	}
+	if scan_err != nil {
+		e := fmt.Sprintf("export.ScanParquet: after %d items: %s",n,scan_err.Error())
+		return n,errors.New(e)
+	}
+	return n,nil
+}
+
+// thriftWriter writes the subset of the thrift compact protocol the Parquet footer needs.
+type thriftWriter struct {
+	buf bytes.Buffer
+	last int16
+	stack []int16
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var vb [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(vb[:],v)
+	t.buf.Write(vb[:n])
+}
+
+func (t *thriftWriter) fieldHeader(id int16,typ byte) {
+	delta := id - t.last
+	if delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta) << 4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32Field(id int16,v int32) {
+	t.fieldHeader(id,thrift_i32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int16,v int64) {
+	t.fieldHeader(id,thrift_i64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(b []byte) {
+	t.varint(uint64(len(b)))
+	t.buf.Write(b)
+}
+
+func (t *thriftWriter) binaryField(id int16,b []byte) {
+	t.fieldHeader(id,thrift_binary)
+	t.binary(b)
+}
+
+func (t *thriftWriter) listBegin(id int16,elem_type byte,size int) {
+	t.fieldHeader(id,thrift_list)
+	if size < 15 {
+		t.buf.WriteByte(byte(size) << 4 | elem_type)
+	} else {
+		t.buf.WriteByte(0xf0 | elem_type)
+		t.varint(uint64(size))
+	}
+}
+
+// elemBegin starts a struct that is an element of a list.
+func (t *thriftWriter) elemBegin() {
+	t.stack = append(t.stack,t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldHeader(id,thrift_struct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package export
+
+import (
+	"bytes"
+	"testing"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"encoding/binary"
+	"github.com/smugmug/godynamo/conf"
+	ep "github.com/smugmug/godynamo/endpoint"
+	"github.com/smugmug/godynamo/endpoints/scan"
+)
+
+func TestParquetSchemaFromStruct(t *testing.T) {
+	type row struct {
+		Id string `dynamo:"id"`
+		Count int
+		Data []byte `dynamo:"data"`
+		Tags []string `dynamo:"tags"`
+		Skip string `dynamo:"-"`
+		hidden string
+	}
+	schema,err := ParquetSchemaFromStruct(row{})
+	if err != nil {
+		t.Errorf("cannot derive schema: %s\n",err.Error())
+	}
+	expected := ParquetSchema{{"id",ep.S},{"Count",ep.N},{"data",ep.B},{"tags",ep.SS}}
+	if len(schema) != len(expected) {
+		t.Fatalf("got %v expected %v\n",schema,expected)
+	}
+	for i,_ := range expected {
+		if schema[i] != expected[i] {
+			t.Errorf("got %v expected %v\n",schema[i],expected[i])
+		}
+	}
+}
+
+func TestParquetSchemaFromItemsConflict(t *testing.T) {
+	items := []ep.Item{
+		ep.Item{"a":ep.AttributeValue{S:"x"}},
+		ep.Item{"a":ep.AttributeValue{N:"1"}},
+	}
+	if _,err := ParquetSchemaFromItems(items); err == nil {
+		t.Errorf("conflicting types should be an error\n")
+	}
+}
+
+func TestRLEDefinitionLevels(t *testing.T) {
+	got := rleDefinitionLevels([]byte{1,1,1,0,1})
+	expected := []byte{6,0,0,0, 6,1, 2,0, 2,1}
+	if !bytes.Equal(got,expected) {
+		t.Errorf("got %v expected %v\n",got,expected)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	items := []ep.Item{
+		ep.Item{"id":ep.AttributeValue{S:"a"},"n":ep.AttributeValue{N:"1.5"}},
+		ep.Item{"id":ep.AttributeValue{S:"b"}},
+		ep.Item{"id":ep.AttributeValue{S:"c"},"n":ep.AttributeValue{N:"3"}},
+	}
+	schema,_ := ParquetSchemaFromItems(items)
+	p,p_err := NewParquetWriter(&buf,schema)
+	if p_err != nil {
+		t.Fatalf("cannot make writer: %s\n",p_err.Error())
+	}
+	p.RowGroupSize = 2
+	for _,item := range items {
+		if err := p.Write(item); err != nil {
+			t.Errorf("cannot write: %s\n",err.Error())
+		}
+	}
+	if err := p.Write(ep.Item{"n":ep.AttributeValue{S:"x"}}); err == nil {
+		t.Errorf("type mismatch should be an error\n")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("cannot close: %s\n",err.Error())
+	}
+	b := buf.Bytes()
+	if string(b[:4]) != PARQUET_MAGIC || string(b[len(b)-4:]) != PARQUET_MAGIC {
+		t.Fatalf("missing magic\n")
+	}
+	footer_len := int(binary.LittleEndian.Uint32(b[len(b)-8:len(b)-4]))
+	footer := b[len(b)-8-footer_len:len(b)-8]
+	// version field, then the schema list of three structs
+	if !bytes.Equal(footer[:4],[]byte{0x15,0x02,0x19,0x3c}) {
+		t.Errorf("unexpected footer start %v\n",footer[:4])
+	}
+	if len(p.row_groups) != 2 || p.total_rows != 3 {
+		t.Errorf("got %d row groups %d rows\n",len(p.row_groups),p.total_rows)
+	}
+	// the first column chunk starts after the magic
+	if p.row_groups[0][0].offset != 4 {
+		t.Errorf("first chunk at %d\n",p.row_groups[0][0].offset)
+	}
+}
+
+// N values are written as the decimal strings DynamoDB returns, without loss of precision.
+func TestParquetNumbers(t *testing.T) {
+	var buf bytes.Buffer
+	const big = "12345678901234567890"
+	schema := ParquetSchema{{"n",ep.N}}
+	p,p_err := NewParquetWriter(&buf,schema)
+	if p_err != nil {
+		t.Fatalf("cannot make writer: %s\n",p_err.Error())
+	}
+	for _,n := range []string{big,"-0.000000000000000000000000000000000001"} {
+		if err := p.Write(ep.Item{"n":ep.AttributeValue{N:n}}); err != nil {
+			t.Fatalf("cannot write %s: %s\n",n,err.Error())
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("cannot close: %s\n",err.Error())
+	}
+	b := buf.Bytes()
+	// a PLAIN BYTE_ARRAY value is its length and then its bytes
+	value := append([]byte{byte(len(big)),0,0,0},big...)
+	if !bytes.Contains(b,value) {
+		t.Errorf("%s not written as a string\n",big)
+	}
+	// the schema element of n: BYTE_ARRAY, OPTIONAL, the name, and converted type UTF8
+	element := []byte{0x15,0x0c,0x25,0x02,0x18,0x01,'n',0x25,0x00,0x00}
+	if !bytes.Contains(b,element) {
+		t.Errorf("no UTF8 BYTE_ARRAY schema element for n\n")
+	}
+}
+
+func TestScanParquetEmpty(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId","test")
+		w.Write([]byte(`{"Items":[],"Count":0}`))
+	}))
+	defer srv.Close()
+	u,_ := url.Parse(srv.URL)
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = u.Hostname()
+	conf.Vals.Network.DynamoDB.Port = u.Port()
+	conf.Vals.Network.DynamoDB.URL = srv.URL
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+	s := scan.NewScan()
+	s.TableName = "T"
+	var buf bytes.Buffer
+	n,err := ScanParquet(*s,&buf,nil)
+	if err != nil || n != 0 {
+		t.Fatalf("got %d items %v\n",n,err)
+	}
+	b := buf.Bytes()
+	if len(b) < 12 || string(b[:4]) != PARQUET_MAGIC || string(b[len(b)-4:]) != PARQUET_MAGIC {
+		t.Fatalf("not a parquet file: %v\n",b)
+	}
+	footer_len := int(binary.LittleEndian.Uint32(b[len(b)-8:len(b)-4]))
+	if footer_len != len(b) - 12 {
+		t.Fatalf("footer of %d bytes in a file of %d\n",footer_len,len(b))
+	}
+	// version field, then the schema list of just the root
+	if !bytes.Equal(b[4:8],[]byte{0x15,0x02,0x19,0x1c}) {
+		t.Errorf("unexpected footer start %v\n",b[4:8])
+	}
+}
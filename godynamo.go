@@ -40,9 +40,20 @@ import (
 	create "github.com/smugmug/godynamo/endpoints/create_table"
 	query "github.com/smugmug/godynamo/endpoints/query"
 	scan "github.com/smugmug/godynamo/endpoints/scan"
+	time_to_live "github.com/smugmug/godynamo/endpoints/time_to_live"
 	conf_iam "github.com/smugmug/godynamo/conf_iam"
 	"github.com/smugmug/godynamo/conf"
 	"github.com/smugmug/godynamo/conf_file"
+	"github.com/smugmug/godynamo/bulk"
+	"github.com/smugmug/godynamo/explain"
+	"github.com/smugmug/godynamo/export"
+	"github.com/smugmug/godynamo/inventory"
+	"github.com/smugmug/godynamo/journal"
+	"github.com/smugmug/godynamo/keygen"
+	"github.com/smugmug/godynamo/overload"
+	"github.com/smugmug/godynamo/saga"
+	"github.com/smugmug/godynamo/sigtrace"
+	"github.com/smugmug/godynamo/tenant"
 )
 
 // This program serves only to include all of the libraries in GoDynamo so that you can
@@ -73,7 +84,20 @@ func installAll() {
 	var scan1 scan.Request
 	var desc1 describe_table.Request
 	var list1 list_tables.Request
-	fmt.Printf("%v%v%v%v%v%v%v%v%v%v%v%v%v",get1,put1,up1,upt1,del1,batchw1,batchg1,create1,delt1,query1,scan1,desc1,list1)
+	var ttl1 time_to_live.Describe
+	fmt.Printf("%v%v%v%v%v%v%v%v%v%v%v%v%v%v",get1,put1,up1,upt1,del1,batchw1,batchg1,create1,delt1,query1,scan1,desc1,list1,ttl1)
+
+	// tools built on the endpoints
+	_ = bulk.TruncateTable
+	_ = explain.Explain
+	_ = export.ScanNDJSON
+	_ = inventory.Take
+	_ = journal.Open
+	_ = keygen.UUID
+	_ = overload.NewDesign
+	_ = saga.PutStep
+	_ = sigtrace.Compare
+	_ = tenant.Scope
 
 
 }
@@ -0,0 +1,213 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Inventories the tables of the account and region, and reports how they have drifted
+// from their declared schemas.
+//
+// example use:
+//
+//   inv,err := inventory.Take("",nil)
+//   if err == nil {
+//	r := inventory.Compare(inv,declared)
+//	b,_ := json.MarshalIndent(r,"","  ")
+//	fmt.Printf("%s\n",string(b))
+//   }
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"errors"
+	"regexp"
+	"github.com/smugmug/godynamo/conf"
+	create "github.com/smugmug/godynamo/endpoints/create_table"
+	pitr "github.com/smugmug/godynamo/endpoints/continuous_backups"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	list "github.com/smugmug/godynamo/endpoints/list_tables"
+	"github.com/smugmug/godynamo/endpoints/tags"
+	ttl "github.com/smugmug/godynamo/endpoints/time_to_live"
+)
+
+// Table is the live configuration of one table.
+type Table struct {
+	Description desc.TableDescription
+	// "" if TTL is not enabled
+	TTLAttribute string
+	// continuous_backups.ENABLED or DISABLED
+	PointInTimeRecovery string
+	Tags map[string]string
+}
+
+// Inventory is the configuration of the tables of a region at a point in time.
+type Inventory struct {
+	Region string
+	Time time.Time
+	Tables []Table
+}
+
+// Take lists the tables that begin with prefix and, if re is not nil, match re (see
+// list_tables.ListAll), and fetches the description, TTL, point-in-time recovery and
+// tags of each.
+func Take(prefix string,re *regexp.Regexp) (*Inventory,error) {
+	names,list_err := list.ListAll(prefix,re)
+	if list_err != nil {
+		e := fmt.Sprintf("inventory.Take: %s",list_err.Error())
+		return nil,errors.New(e)
+	}
+	sort.Strings(names)
+	conf.Vals.ConfLock.RLock()
+	inv := &Inventory{Region:conf.Vals.Network.DynamoDB.Zone,Time:time.Now(),
+		Tables:make([]Table,0,len(names))}
+	conf.Vals.ConfLock.RUnlock()
+	for _,name := range names {
+		t,t_err := TakeTable(name)
+		if t_err != nil {
+			e := fmt.Sprintf("inventory.Take: %s",t_err.Error())
+			return nil,errors.New(e)
+		}
+		inv.Tables = append(inv.Tables,*t)
+	}
+	return inv,nil
+}
+
+// TakeTable fetches the configuration of one table.
+func TakeTable(tablename string) (*Table,error) {
+	d,d_err := desc.DescribeTable(tablename)
+	if d_err != nil {
+		return nil,d_err
+	}
+	t := &Table{Description:*d}
+	var err error
+	if t.TTLAttribute,err = ttl.Attribute(tablename); err != nil {
+		return nil,err
+	}
+	if t.PointInTimeRecovery,err = pitr.PointInTimeRecovery(tablename); err != nil {
+		return nil,err
+	}
+	if t.Tags,err = tags.ListAll(d.TableArn); err != nil {
+		return nil,err
+	}
+	return t,nil
+}
+
+// Table returns the table of inv named tablename, or nil.
+func (inv *Inventory) Table(tablename string) *Table {
+	for i := range inv.Tables {
+		if inv.Tables[i].Description.TableName == tablename {
+			return &inv.Tables[i]
+		}
+	}
+	return nil
+}
+
+// Declared is the intended configuration of a table, as written in Go. Zero values of the
+// optional fields are not compared.
+type Declared struct {
+	Create create.Create
+	// "" to not compare TTL
+	TTLAttribute string
+	PointInTimeRecovery bool
+	// nil to not compare the stream settings
+	Stream *desc.StreamSpecification
+	// tags that must be present with these values; others are ignored
+	Tags map[string]string
+}
+
+// Drift lists each way a live table differs from its declaration.
+type Drift struct {
+	TableName string
+	Diffs []string
+}
+
+// Report is the machine-readable result of Compare.
+type Report struct {
+	Inventory *Inventory
+	// the declared tables that differ from their declarations
+	Drift []Drift
+	// declared tables that are not in the inventory
+	Missing []string
+	// tables in the inventory that are not declared
+	Undeclared []string
+}
+
+// Compare reports how the tables of inv have drifted from declared. The comparison of
+// keys, attributes and indexes is that of create_table.SchemaDiff.
+func Compare(inv *Inventory,declared []Declared) *Report {
+	r := &Report{Inventory:inv,Drift:make([]Drift,0),
+		Missing:make([]string,0),Undeclared:make([]string,0)}
+	is_declared := make(map[string]bool)
+	for _,d := range declared {
+		name := d.Create.TableName
+		is_declared[name] = true
+		t := inv.Table(name)
+		if t == nil {
+			r.Missing = append(r.Missing,name)
+			continue
+		}
+		if diffs := TableDiff(d,*t); len(diffs) != 0 {
+			r.Drift = append(r.Drift,Drift{TableName:name,Diffs:diffs})
+		}
+	}
+	for _,t := range inv.Tables {
+		if !is_declared[t.Description.TableName] {
+			r.Undeclared = append(r.Undeclared,t.Description.TableName)
+		}
+	}
+	return r
+}
+
+// TableDiff describes each way the live table t differs from its declaration d.
+func TableDiff(d Declared,t Table) []string {
+	diffs := create.SchemaDiff(d.Create,t.Description)
+	if d.TTLAttribute != "" && d.TTLAttribute != t.TTLAttribute {
+		diffs = append(diffs,fmt.Sprintf("TTL attribute: declared %q, live %q",
+			d.TTLAttribute,t.TTLAttribute))
+	}
+	if d.PointInTimeRecovery && t.PointInTimeRecovery != pitr.ENABLED {
+		diffs = append(diffs,fmt.Sprintf("point-in-time recovery: declared %s, live %s",
+			pitr.ENABLED,t.PointInTimeRecovery))
+	}
+	if d.Stream != nil {
+		live := t.Description.StreamSpecification
+		if d.Stream.StreamEnabled != live.StreamEnabled ||
+			(d.Stream.StreamEnabled && d.Stream.StreamViewType != live.StreamViewType) {
+			diffs = append(diffs,fmt.Sprintf("stream: declared %v %s, live %v %s",
+				d.Stream.StreamEnabled,d.Stream.StreamViewType,
+				live.StreamEnabled,live.StreamViewType))
+		}
+	}
+	keys := make([]string,0,len(d.Tags))
+	for k := range d.Tags {
+		keys = append(keys,k)
+	}
+	sort.Strings(keys)
+	for _,k := range keys {
+		if have,ok := t.Tags[k]; !ok {
+			diffs = append(diffs,fmt.Sprintf("tag %s: missing",k))
+		} else if have != d.Tags[k] {
+			diffs = append(diffs,fmt.Sprintf("tag %s: declared %q, live %q",k,d.Tags[k],have))
+		}
+	}
+	return diffs
+}
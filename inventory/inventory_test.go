@@ -0,0 +1,79 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package inventory
+
+import (
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+	create "github.com/smugmug/godynamo/endpoints/create_table"
+	pitr "github.com/smugmug/godynamo/endpoints/continuous_backups"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+)
+
+func inv() *Inventory {
+	t := Table{TTLAttribute:"expires",PointInTimeRecovery:pitr.DISABLED,
+		Tags:map[string]string{"owner":"blue"}}
+	t.Description.TableName = "orders"
+	t.Description.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"id",KeyType:ep.HASH}}
+	t.Description.AttributeDefinitions = ep.AttributeDefinitions{
+		ep.AttributeDefinition{AttributeName:"id",AttributeType:ep.S}}
+	other := Table{}
+	other.Description.TableName = "scratch"
+	return &Inventory{Tables:[]Table{t,other}}
+}
+
+func declared() Declared {
+	var c create.Create
+	c.TableName = "orders"
+	c.KeySchema = ep.KeySchema{ep.KeyDefinition{AttributeName:"id",KeyType:ep.HASH}}
+	c.AttributeDefinitions = ep.AttributeDefinitions{
+		ep.AttributeDefinition{AttributeName:"id",AttributeType:ep.S}}
+	return Declared{Create:c,TTLAttribute:"expires",Tags:map[string]string{"owner":"blue"}}
+}
+
+func TestCompareNoDrift(t *testing.T) {
+	r := Compare(inv(),[]Declared{declared()})
+	if len(r.Drift) != 0 || len(r.Missing) != 0 {
+		t.Errorf("expected no drift, got %v %v\n",r.Drift,r.Missing)
+	}
+	if len(r.Undeclared) != 1 || r.Undeclared[0] != "scratch" {
+		t.Errorf("expected scratch undeclared, got %v\n",r.Undeclared)
+	}
+}
+
+func TestCompareDrift(t *testing.T) {
+	d := declared()
+	d.PointInTimeRecovery = true
+	d.Stream = &desc.StreamSpecification{StreamEnabled:true,StreamViewType:"NEW_IMAGE"}
+	d.Tags["env"] = "prod"
+	missing := declared()
+	missing.Create.TableName = "users"
+	r := Compare(inv(),[]Declared{d,missing})
+	if len(r.Drift) != 1 || len(r.Drift[0].Diffs) != 3 {
+		t.Errorf("expected pitr, stream and tag drift, got %v\n",r.Drift)
+	}
+	if len(r.Missing) != 1 || r.Missing[0] != "users" {
+		t.Errorf("expected users missing, got %v\n",r.Missing)
+	}
+}
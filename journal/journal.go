@@ -0,0 +1,374 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// A write-ahead local journal for deployments that lose connectivity, such as edge and
+// IoT devices. Writes are appended to a local file before they are sent; while DynamoDB
+// cannot be reached they stay in the journal, and are replayed in order once it can.
+// Each write carries an idempotency key, and a write whose key is already in the journal
+// is not recorded or sent again.
+//
+// example use:
+//
+//   j,err := journal.Open("/var/lib/app/dynamo.journal")
+//   ...
+//   go j.GoReplay(journal.REPLAY_INTERVAL)
+//   body,code,err := j.Write(ctx,reading_id,&put,put_item.PUTITEM_ENDPOINT)
+//   if err == journal.ErrQueued {
+//	// kept to be replayed
+//   }
+package journal
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"bufio"
+	"errors"
+	"context"
+	"encoding/json"
+	"github.com/smugmug/godynamo/authreq"
+	"github.com/smugmug/godynamo/conf"
+)
+
+const (
+	// how often GoReplay retries the journal while writes are queued
+	REPLAY_INTERVAL = 10 * time.Second
+)
+
+// ErrQueued is returned by Write for a write that is journaled but could not be sent,
+// or was not sent because earlier writes are still queued. It will be sent by Replay.
+var ErrQueued = errors.New("journal: write queued for replay")
+
+// ErrDuplicate is returned by Write for a write whose idempotency key was journaled
+// already.
+var ErrDuplicate = errors.New("journal: duplicate idempotency key")
+
+// ErrClosed is returned for writes to a closed Journal.
+var ErrClosed = errors.New("journal: closed")
+
+// Entry is a write recorded in the journal. A completion record names the Seq of the
+// write that was sent, with Done set.
+type Entry struct {
+	Seq uint64
+	Key string `json:",omitempty"`
+	AmzTarget string `json:",omitempty"`
+	// the configuration the write was made with, see conf.WithName
+	Conf string `json:",omitempty"`
+	Body json.RawMessage `json:",omitempty"`
+	Done bool `json:",omitempty"`
+}
+
+// Journal is an append-only file of writes and their completions.
+type Journal struct {
+	// Send sends a journaled write, authreq.RetryReqJSONContext_V4 unless replaced.
+	// A write whose error comes without a response code (the network or DynamoDB
+	// could not be reached) stays queued.
+	Send func(ctx context.Context,reqJSON []byte,amzTarget string) (string,int,error)
+	lock sync.Mutex
+	path string
+	f *os.File
+	seq uint64
+	// the idempotency keys journaled, and the writes not yet sent, in order
+	keys map[string] bool
+	pending []Entry
+	// serializes sending, so writes go out in journal order
+	send sync.Mutex
+	stop chan struct{}
+	closed bool
+}
+
+// Open opens the journal at path, creating it if need be. Writes it records as not sent
+// are queued for Replay.
+func Open(path string) (*Journal,error) {
+	j := &Journal{Send:authreq.RetryReqJSONContext_V4,path:path,
+		keys:make(map[string] bool),stop:make(chan struct{})}
+	if read_err := j.read(); read_err != nil {
+		return nil,read_err
+	}
+	f,f_err := os.OpenFile(path,os.O_CREATE|os.O_WRONLY|os.O_APPEND,0600)
+	if f_err != nil {
+		e := fmt.Sprintf("journal.Open: %s",f_err.Error())
+		return nil,errors.New(e)
+	}
+	j.f = f
+	return j,nil
+}
+
+// read rebuilds the state of j from its file.
+func (j *Journal) read() error {
+	f,f_err := os.Open(j.path)
+	if os.IsNotExist(f_err) {
+		return nil
+	} else if f_err != nil {
+		e := fmt.Sprintf("journal.Open: %s",f_err.Error())
+		return errors.New(e)
+	}
+	defer f.Close()
+	done := make(map[uint64] bool)
+	entries := make([]Entry,0)
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte,64 * 1024),16 * 1024 * 1024)
+	for s.Scan() {
+		var e Entry
+		if um_err := json.Unmarshal(s.Bytes(),&e); um_err != nil {
+			// a record torn by a crash mid-append can only be the last
+			break
+		}
+		if e.Seq > j.seq {
+			j.seq = e.Seq
+		}
+		if e.Done {
+			done[e.Seq] = true
+			continue
+		}
+		if e.Key != "" {
+			j.keys[e.Key] = true
+		}
+		if len(e.Body) != 0 {
+			entries = append(entries,e)
+		}
+	}
+	if scan_err := s.Err(); scan_err != nil {
+		e := fmt.Sprintf("journal.Open: %s",scan_err.Error())
+		return errors.New(e)
+	}
+	for _,e := range entries {
+		if !done[e.Seq] {
+			j.pending = append(j.pending,e)
+		}
+	}
+	return nil
+}
+
+// appendEntry writes e to the journal and syncs it to disk. j must be locked.
+func (j *Journal) appendEntry(e Entry) error {
+	if j.closed {
+		return ErrClosed
+	}
+	b,m_err := json.Marshal(e)
+	if m_err != nil {
+		return m_err
+	}
+	if _,w_err := j.f.Write(append(b,'\n')); w_err != nil {
+		return w_err
+	}
+	return j.f.Sync()
+}
+
+// Write journals the write v (an ep.Endpoint, or its serialized []byte) for amzTarget
+// under the idempotency key, then sends it unless earlier writes are queued. It returns
+// ErrDuplicate if key is journaled already, ErrQueued if the write is kept for Replay, or
+// else the response to it. The write is durable once Write returns anything other than
+// ErrDuplicate or an error journaling it.
+func (j *Journal) Write(ctx context.Context,key string,v interface{},amzTarget string) (string,int,error) {
+	if key == "" {
+		return "",0,errors.New("journal.Write: no idempotency key")
+	}
+	body,ok := v.([]byte)
+	if !ok {
+		var m_err error
+		if body,m_err = json.Marshal(v); m_err != nil {
+			e := fmt.Sprintf("journal.Write: %s",m_err.Error())
+			return "",0,errors.New(e)
+		}
+	}
+	j.lock.Lock()
+	if j.keys[key] {
+		j.lock.Unlock()
+		return "",0,ErrDuplicate
+	}
+	entry := Entry{Seq:j.seq + 1,Key:key,AmzTarget:amzTarget,Conf:conf.NameFrom(ctx),Body:body}
+	if a_err := j.appendEntry(entry); a_err != nil {
+		j.lock.Unlock()
+		if a_err == ErrClosed {
+			return "",0,ErrClosed
+		}
+		e := fmt.Sprintf("journal.Write: %s",a_err.Error())
+		return "",0,errors.New(e)
+	}
+	j.seq = entry.Seq
+	j.keys[key] = true
+	j.pending = append(j.pending,entry)
+	queued := len(j.pending) > 1
+	j.lock.Unlock()
+	if queued {
+		return "",0,ErrQueued
+	}
+	resp_body,code,sent,err := j.sendNext(ctx,entry.Seq)
+	if !sent {
+		return "",0,ErrQueued
+	}
+	return resp_body,code,err
+}
+
+// sendNext sends the first pending write, if it is seq (or any, if seq is 0), and records
+// its completion. It reports whether it was sent; a write DynamoDB did not answer stays
+// first in line.
+func (j *Journal) sendNext(ctx context.Context,seq uint64) (string,int,bool,error) {
+	j.send.Lock()
+	defer j.send.Unlock()
+	j.lock.Lock()
+	if len(j.pending) == 0 || (seq != 0 && j.pending[0].Seq != seq) {
+		j.lock.Unlock()
+		return "",0,false,nil
+	}
+	next := j.pending[0]
+	j.lock.Unlock()
+	if next.Conf != "" {
+		ctx = conf.WithName(ctx,next.Conf)
+	}
+	resp_body,code,err := j.Send(ctx,next.Body,next.AmzTarget)
+	if err != nil && code == 0 {
+		return "",0,false,err
+	}
+	// answered, even if with an error: it is done with, and must not be replayed
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if a_err := j.appendEntry(Entry{Seq:next.Seq,Done:true}); a_err != nil && a_err != ErrClosed {
+		e := fmt.Sprintf("journal: cannot record completion of %d: %s",next.Seq,a_err.Error())
+		return resp_body,code,true,errors.New(e)
+	}
+	j.pending = j.pending[1:]
+	return resp_body,code,true,err
+}
+
+// Replay sends the queued writes in order, up to the first that cannot be sent. It
+// returns the error of that write, or nil once the queue is empty. Responses to the
+// writes are discarded, see Pending to inspect them beforehand.
+func (j *Journal) Replay(ctx context.Context) error {
+	for {
+		_,_,sent,err := j.sendNext(ctx,0)
+		if !sent {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// GoReplay calls Replay every interval while writes are queued, until Close. It waits
+// while the client is paused (see authreq.Pause).
+func (j *Journal) GoReplay(interval time.Duration) {
+	for {
+		select {
+		case <- j.stop:
+			return
+		case <- time.After(interval):
+		}
+		select {
+		case <- j.stop:
+			return
+		case <- authreq.Resumed():
+		}
+		if j.Len() != 0 {
+			_ = j.Replay(context.Background())
+		}
+	}
+}
+
+// Len returns the number of writes queued.
+func (j *Journal) Len() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return len(j.pending)
+}
+
+// Pending returns a copy of the writes queued, in order.
+func (j *Journal) Pending() []Entry {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]Entry(nil),j.pending...)
+}
+
+// Compact rewrites the journal with just the idempotency keys of the writes sent and the
+// writes queued, so that it does not grow without bound.
+func (j *Journal) Compact() error {
+	j.send.Lock()
+	defer j.send.Unlock()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.closed {
+		return ErrClosed
+	}
+	queued := make(map[string] bool,len(j.pending))
+	for _,e := range j.pending {
+		queued[e.Key] = true
+	}
+	tmp := j.path + ".compact"
+	f,f_err := os.OpenFile(tmp,os.O_CREATE|os.O_WRONLY|os.O_TRUNC,0600)
+	if f_err != nil {
+		e := fmt.Sprintf("journal.Compact: %s",f_err.Error())
+		return errors.New(e)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var err error
+	for key,_ := range j.keys {
+		if !queued[key] && err == nil {
+			err = enc.Encode(Entry{Seq:0,Key:key})
+		}
+	}
+	for _,e := range j.pending {
+		if err == nil {
+			err = enc.Encode(e)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp,j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		e := fmt.Sprintf("journal.Compact: %s",err.Error())
+		return errors.New(e)
+	}
+	j.f.Close()
+	j.f,err = os.OpenFile(j.path,os.O_WRONLY|os.O_APPEND,0600)
+	if err != nil {
+		j.closed = true
+		e := fmt.Sprintf("journal.Compact: %s",err.Error())
+		return errors.New(e)
+	}
+	return nil
+}
+
+// Close stops GoReplay and closes the journal file. Queued writes stay in it.
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	close(j.stop)
+	return j.f.Close()
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package journal
+
+import (
+	"errors"
+	"context"
+	"testing"
+	"path/filepath"
+)
+
+type sender struct {
+	online bool
+	sent []string
+}
+
+func (s *sender) send(ctx context.Context,reqJSON []byte,amzTarget string) (string,int,error) {
+	if !s.online {
+		return "",0,errors.New("no route to host")
+	}
+	s.sent = append(s.sent,string(reqJSON))
+	return "{}",200,nil
+}
+
+func TestQueueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(),"journal")
+	j,err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s\n",err.Error())
+	}
+	s := &sender{}
+	j.Send = s.send
+	ctx := context.Background()
+	if _,_,err := j.Write(ctx,"a",[]byte(`{"n":1}`),"PutItem"); err != ErrQueued {
+		t.Errorf("expected a write while offline to be queued, got %v\n",err)
+	}
+	s.online = true
+	// queued behind the first, so as not to overtake it
+	if _,_,err := j.Write(ctx,"b",[]byte(`{"n":2}`),"PutItem"); err != ErrQueued {
+		t.Errorf("expected a write behind a queued one to be queued, got %v\n",err)
+	}
+	if _,_,err := j.Write(ctx,"a",[]byte(`{"n":1}`),"PutItem"); err != ErrDuplicate {
+		t.Errorf("expected a duplicate key to be refused, got %v\n",err)
+	}
+	j.Close()
+
+	// the queue survives a restart
+	j,err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s\n",err.Error())
+	}
+	j.Send = s.send
+	if j.Len() != 2 {
+		t.Fatalf("expected 2 queued writes, got %d\n",j.Len())
+	}
+	if err := j.Replay(ctx); err != nil || j.Len() != 0 {
+		t.Fatalf("Replay: %v, %d left\n",err,j.Len())
+	}
+	if len(s.sent) != 2 || s.sent[0] != `{"n":1}` || s.sent[1] != `{"n":2}` {
+		t.Errorf("replayed %v\n",s.sent)
+	}
+	if _,code,err := j.Write(ctx,"c",[]byte(`{"n":3}`),"PutItem"); err != nil || code != 200 {
+		t.Errorf("expected an online write to be sent, got %d %v\n",code,err)
+	}
+	if err := j.Compact(); err != nil {
+		t.Fatalf("Compact: %s\n",err.Error())
+	}
+	j.Close()
+	j,err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s\n",err.Error())
+	}
+	defer j.Close()
+	j.Send = s.send
+	if j.Len() != 0 {
+		t.Errorf("expected nothing queued after compaction, got %d\n",j.Len())
+	}
+	if _,_,err := j.Write(ctx,"b",[]byte(`{"n":2}`),"PutItem"); err != ErrDuplicate {
+		t.Errorf("expected keys to survive compaction, got %v\n",err)
+	}
+}
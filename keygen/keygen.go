@@ -0,0 +1,108 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Key generation for new items: random UUIDs for hash keys that spread writes across
+// partitions, and time-sortable ULIDs for range keys that order items by creation time.
+package keygen
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"crypto/rand"
+	"encoding/binary"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+const (
+	// Crockford's base32, as used by ULIDs
+	ULID_ALPHABET = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// UUID returns a random (version 4) UUID in its canonical string form.
+func UUID() string {
+	var b [16]byte
+	if _,err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("keygen.UUID: %s",err.Error()))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x",b[0:4],b[4:6],b[6:8],b[8:10],b[10:16])
+}
+
+var ulid_state struct {
+	lock sync.Mutex
+	ms uint64
+	entropy [10]byte
+}
+
+// ULID returns a ULID for time t. ULIDs made in the same millisecond by this process
+// increase monotonically, so they sort in the order they were made.
+func ULID(t time.Time) string {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	ulid_state.lock.Lock()
+	if ms <= ulid_state.ms {
+		// same millisecond (or a clock step back): increment the entropy
+		ms = ulid_state.ms
+		for i := len(ulid_state.entropy) - 1; i >= 0; i-- {
+			ulid_state.entropy[i]++
+			if ulid_state.entropy[i] != 0 {
+				break
+			}
+		}
+	} else if _,err := rand.Read(ulid_state.entropy[:]); err != nil {
+		ulid_state.lock.Unlock()
+		panic(fmt.Sprintf("keygen.ULID: %s",err.Error()))
+	}
+	ulid_state.ms = ms
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8],ms << 16)
+	copy(b[6:],ulid_state.entropy[:])
+	ulid_state.lock.Unlock()
+	return encodeULID(b)
+}
+
+// encodeULID renders the 128 bits of b as 26 base32 characters.
+func encodeULID(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte,26)
+	for i := 25; i >= 0; i-- {
+		out[i] = ULID_ALPHABET[lo & 0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// NewULID returns a ULID for the current time.
+func NewULID() string {
+	return ULID(time.Now())
+}
+
+// Key returns an Item with the attribute `name` set to gen(), such as UUID or NewULID.
+// Add further attributes to it for a new item, or other key attributes for a range key.
+func Key(name string,gen func() string) ep.Item {
+	return ep.Item{name:ep.AttributeValue{S:gen()}}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package keygen
+
+import (
+	"time"
+	"regexp"
+	"testing"
+)
+
+func TestUUID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if u := UUID(); !re.MatchString(u) {
+		t.Errorf("bad uuid %s\n",u)
+	}
+}
+
+func TestULID(t *testing.T) {
+	var b [16]byte
+	b[15] = 1
+	if s := encodeULID(b); s != "00000000000000000000000001" {
+		t.Errorf("bad encoding %s\n",s)
+	}
+	now := time.Now()
+	prev := ULID(now)
+	for i := 0; i < 100; i++ {
+		u := ULID(now)
+		if len(u) != 26 || u <= prev {
+			t.Errorf("ulid %s does not sort after %s\n",u,prev)
+		}
+		prev = u
+	}
+	if later := ULID(now.Add(time.Second)); later <= prev {
+		t.Errorf("ulid %s does not sort after %s\n",later,prev)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+// Declarations of how the entity types of a single-table design are projected into
+// overloaded index slots (GSI1PK/GSI1SK and the like), and queries built from them.
+// An entity can only be queried on slots it was declared to project into, and a query
+// on a slot is restricted to the entity's items by its range key prefix, so the wrong
+// index, key attribute or prefix is caught when the query is built rather than returning
+// another entity's items.
+//
+// example use:
+//
+//   d := overload.NewDesign("app")
+//   d.Slot("GSI1","GSI1","GSI1PK","GSI1SK")
+//   order := d.Entity("Order")
+//   order.ProjectInto("GSI1","CUSTOMER#","customer","ORDER#","date")
+//   item,err := order.Index(item)   // sets GSI1PK and GSI1SK before a put
+//   q,err := order.Query("GSI1","c1") // the orders of customer c1
+package overload
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"errors"
+	ep "github.com/smugmug/godynamo/endpoint"
+	query "github.com/smugmug/godynamo/endpoints/query"
+)
+
+// Slot is an overloaded index and its key attributes.
+type Slot struct {
+	Name string
+	IndexName string
+	HashKey string
+	RangeKey string
+}
+
+// Projection is how an entity's items fill the keys of a Slot: each key is its prefix
+// followed by the value of the item's attribute.
+type Projection struct {
+	Slot string
+	HashPrefix string
+	HashAttribute string
+	RangePrefix string
+	RangeAttribute string
+}
+
+// Design is the single-table design of one table.
+type Design struct {
+	TableName string
+	lock sync.RWMutex
+	slots map[string]Slot
+	entities map[string]*Entity
+}
+
+// Entity is one entity type of a Design.
+type Entity struct {
+	Name string
+	design *Design
+	projections map[string]Projection
+}
+
+// NewDesign returns an empty Design for tablename.
+func NewDesign(tablename string) *Design {
+	return &Design{TableName:tablename,slots:make(map[string]Slot),entities:make(map[string]*Entity)}
+}
+
+// Slot declares the slot name, the index indexname with keys hash_key and range_key.
+func (d *Design) Slot(name,indexname,hash_key,range_key string) {
+	d.lock.Lock()
+	d.slots[name] = Slot{Name:name,IndexName:indexname,HashKey:hash_key,RangeKey:range_key}
+	d.lock.Unlock()
+}
+
+// Entity returns the entity type name, declaring it if need be.
+func (d *Design) Entity(name string) *Entity {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	e,ok := d.entities[name]
+	if !ok {
+		e = &Entity{Name:name,design:d,projections:make(map[string]Projection)}
+		d.entities[name] = e
+	}
+	return e
+}
+
+// ProjectInto declares that items of e fill slot with hash_prefix + the value of
+// hash_attr and range_prefix + the value of range_attr. The range_prefix is what tells
+// the entity's items apart from those of other entities in the slot, so it should not be
+// empty, nor a prefix of another entity's range prefix for the same hash prefix.
+func (en *Entity) ProjectInto(slot,hash_prefix,hash_attr,range_prefix,range_attr string) error {
+	en.design.lock.Lock()
+	defer en.design.lock.Unlock()
+	if _,ok := en.design.slots[slot]; !ok {
+		e := fmt.Sprintf("overload.ProjectInto: %s: no slot %s",en.Name,slot)
+		return errors.New(e)
+	}
+	p := Projection{Slot:slot,HashPrefix:hash_prefix,HashAttribute:hash_attr,
+		RangePrefix:range_prefix,RangeAttribute:range_attr}
+	en.projections[slot] = p
+	return nil
+}
+
+// Slots lists the slots e projects into, in order.
+func (en *Entity) Slots() []string {
+	en.design.lock.RLock()
+	defer en.design.lock.RUnlock()
+	names := make([]string,0,len(en.projections))
+	for name := range en.projections {
+		names = append(names,name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// projection returns the Slot and Projection of e for slot.
+func (en *Entity) projection(slot string) (Slot,Projection,error) {
+	en.design.lock.RLock()
+	defer en.design.lock.RUnlock()
+	p,ok := en.projections[slot]
+	if !ok {
+		e := fmt.Sprintf("overload: entity %s is not projected into slot %s",en.Name,slot)
+		return Slot{},Projection{},errors.New(e)
+	}
+	return en.design.slots[slot],p,nil
+}
+
+// Index returns item with the keys of each slot e projects into set from its attributes.
+// A slot is left unset if the item lacks one of its attributes, which keeps the item
+// out of that (sparse) index.
+func (en *Entity) Index(item ep.Item) (ep.Item,error) {
+	indexed := make(ep.Item)
+	for k,v := range item {
+		indexed[k] = v
+	}
+	for _,name := range en.Slots() {
+		s,p,err := en.projection(name)
+		if err != nil {
+			return nil,err
+		}
+		hash,has_hash := item[p.HashAttribute]
+		rng,has_range := item[p.RangeAttribute]
+		if !has_hash || !has_range {
+			continue
+		}
+		if hash.S == "" && hash.N == "" || rng.S == "" && rng.N == "" {
+			e := fmt.Sprintf("overload.Index: %s: %s and %s must be strings or numbers",
+				en.Name,p.HashAttribute,p.RangeAttribute)
+			return nil,errors.New(e)
+		}
+		indexed[s.HashKey] = ep.AttributeValue{S:p.HashPrefix + hash.S + hash.N}
+		indexed[s.RangeKey] = ep.AttributeValue{S:p.RangePrefix + rng.S + rng.N}
+	}
+	return indexed,nil
+}
+
+// Query returns a Query for the items of e in slot whose hash attribute is hash_value.
+func (en *Entity) Query(slot,hash_value string) (*query.Query,error) {
+	return en.QueryRange(slot,hash_value,query.OP_BEGINS_WITH)
+}
+
+// QueryRange is Query with a condition on the range attribute: op and its values, which
+// are prefixed with the entity's range prefix. OP_BEGINS_WITH with no values selects all
+// of the entity's items.
+func (en *Entity) QueryRange(slot,hash_value,op string,range_values ...string) (*query.Query,error) {
+	s,p,err := en.projection(slot)
+	if err != nil {
+		return nil,err
+	}
+	if p.RangePrefix == "" && len(range_values) == 0 {
+		e := fmt.Sprintf("overload.Query: %s has no range prefix in %s to restrict the query to",en.Name,slot)
+		return nil,errors.New(e)
+	}
+	if op == query.OP_BEGINS_WITH && len(range_values) == 0 {
+		range_values = []string{""}
+	}
+	q := query.NewQuery()
+	q.TableName = en.design.TableName
+	q.IndexName = ep.NullableString(s.IndexName)
+	q.KeyConditions[s.HashKey] = query.KeyCondition{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:p.HashPrefix + hash_value}},
+		ComparisonOperator:query.OP_EQ}
+	values := make([]ep.AttributeValue,0,len(range_values))
+	for _,v := range range_values {
+		values = append(values,ep.AttributeValue{S:p.RangePrefix + v})
+	}
+	q.KeyConditions[s.RangeKey] = query.KeyCondition{AttributeValueList:values,
+		ComparisonOperator:query.ComparisonOperator(op)}
+	return q,nil
+}
+
+// Validate checks that no two entities project into a slot with the same hash prefix and
+// overlapping range prefixes, in which case their queries would return each other's items.
+func (d *Design) Validate() error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	names := make([]string,0,len(d.entities))
+	for name := range d.entities {
+		names = append(names,name)
+	}
+	sort.Strings(names)
+	for i,a := range names {
+		for _,b := range names[i+1:] {
+			for slot,pa := range d.entities[a].projections {
+				pb,ok := d.entities[b].projections[slot]
+				if !ok || pa.HashPrefix != pb.HashPrefix {
+					continue
+				}
+				if hasPrefix(pa.RangePrefix,pb.RangePrefix) || hasPrefix(pb.RangePrefix,pa.RangePrefix) {
+					e := fmt.Sprintf("overload.Validate: %s and %s overlap in slot %s (range prefixes %q and %q)",
+						a,b,slot,pa.RangePrefix,pb.RangePrefix)
+					return errors.New(e)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasPrefix(s,prefix string) bool {
+	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
+}
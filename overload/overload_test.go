@@ -0,0 +1,95 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package overload
+
+import (
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+	query "github.com/smugmug/godynamo/endpoints/query"
+)
+
+func design() (*Design,*Entity,*Entity) {
+	d := NewDesign("app")
+	d.Slot("GSI1","GSI1","GSI1PK","GSI1SK")
+	order := d.Entity("Order")
+	_ = order.ProjectInto("GSI1","CUSTOMER#","customer","ORDER#","date")
+	invoice := d.Entity("Invoice")
+	_ = invoice.ProjectInto("GSI1","CUSTOMER#","customer","INVOICE#","issued")
+	return d,order,invoice
+}
+
+func TestIndex(t *testing.T) {
+	_,order,_ := design()
+	item := ep.Item{"customer":ep.AttributeValue{S:"c1"},"date":ep.AttributeValue{S:"2013-06-01"}}
+	indexed,err := order.Index(item)
+	if err != nil {
+		t.Errorf("%s\n",err.Error())
+	}
+	if indexed["GSI1PK"].S != "CUSTOMER#c1" || indexed["GSI1SK"].S != "ORDER#2013-06-01" {
+		t.Errorf("unexpected index keys %v\n",indexed)
+	}
+	if _,ok := item["GSI1PK"]; ok {
+		t.Errorf("item should not be modified\n")
+	}
+	sparse,_ := order.Index(ep.Item{"customer":ep.AttributeValue{S:"c1"}})
+	if _,ok := sparse["GSI1PK"]; ok {
+		t.Errorf("item without a date should not be indexed\n")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	d,order,_ := design()
+	if err := d.Validate(); err != nil {
+		t.Errorf("%s\n",err.Error())
+	}
+	q,err := order.Query("GSI1","c1")
+	if err != nil {
+		t.Errorf("%s\n",err.Error())
+	}
+	if string(q.IndexName) != "GSI1" || q.KeyConditions["GSI1PK"].AttributeValueList[0].S != "CUSTOMER#c1" {
+		t.Errorf("unexpected hash condition %v\n",q)
+	}
+	sk := q.KeyConditions["GSI1SK"]
+	if sk.ComparisonOperator != query.OP_BEGINS_WITH || sk.AttributeValueList[0].S != "ORDER#" {
+		t.Errorf("query should be restricted to orders: %v\n",sk)
+	}
+	q,_ = order.QueryRange("GSI1","c1",query.OP_BETWEEN,"2013-01","2013-12")
+	if q.KeyConditions["GSI1SK"].AttributeValueList[1].S != "ORDER#2013-12" {
+		t.Errorf("range values should be prefixed: %v\n",q.KeyConditions["GSI1SK"])
+	}
+	if _,err := order.Query("GSI2","c1"); err == nil {
+		t.Errorf("expected an error for a slot the entity is not projected into\n")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d,_,_ := design()
+	_ = d.Entity("OrderLine").ProjectInto("GSI1","CUSTOMER#","customer","ORDER#LINE#","line")
+	if err := d.Validate(); err == nil {
+		t.Errorf("expected overlapping range prefixes to be refused\n")
+	}
+	if err := d.Entity("X").ProjectInto("GSI9","","a","","b"); err == nil {
+		t.Errorf("expected an undeclared slot to be refused\n")
+	}
+}
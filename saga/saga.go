@@ -0,0 +1,133 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Orchestration of multi-step, cross-table workflows too large for one atomic write.
+// Each step registers a forward action and a compensation that undoes it; if a step
+// fails, the steps already completed are compensated in reverse order. Compensations
+// should be conditional writes, so they do not undo changes others have made since;
+// PutStep provides one.
+package saga
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"net/http"
+	ep "github.com/smugmug/godynamo/endpoint"
+	del "github.com/smugmug/godynamo/endpoints/delete_item"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+)
+
+// Step is one action of a Saga. Compensate may be nil for steps with nothing to undo.
+type Step struct {
+	Name string
+	Forward func() error
+	Compensate func() error
+}
+
+// Saga is an ordered list of Steps.
+type Saga struct {
+	Steps []Step
+}
+
+// Error reports the step that failed and any compensations that also failed, which
+// leave the workflow partially applied.
+type Error struct {
+	Step string
+	Err error
+	// compensation errors, by step name
+	Compensations map[string] error
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("saga: step %s failed: %s",e.Step,e.Err.Error())
+	if len(e.Compensations) != 0 {
+		failed := make([]string,0,len(e.Compensations))
+		for name,err := range e.Compensations {
+			failed = append(failed,name + ": " + err.Error())
+		}
+		s += "; compensations failed: " + strings.Join(failed,"; ")
+	}
+	return s
+}
+
+// Add appends a step to the Saga.
+func (s *Saga) Add(name string,forward,compensate func() error) *Saga {
+	s.Steps = append(s.Steps,Step{Name:name,Forward:forward,Compensate:compensate})
+	return s
+}
+
+// Run runs each step in order. If one fails, the completed steps are compensated in
+// reverse order and an *Error is returned.
+func (s *Saga) Run() error {
+	for i,step := range s.Steps {
+		err := step.Forward()
+		if err == nil {
+			continue
+		}
+		se := &Error{Step:step.Name,Err:err,Compensations:make(map[string] error)}
+		for j := i - 1; j >= 0; j-- {
+			if s.Steps[j].Compensate == nil {
+				continue
+			}
+			if c_err := s.Steps[j].Compensate(); c_err != nil {
+				se.Compensations[s.Steps[j].Name] = c_err
+			}
+		}
+		return se
+	}
+	return nil
+}
+
+func result(body string,code int,err error) error {
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		e := fmt.Sprintf("code %d: %s",code,body)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// PutStep returns a Step that creates item in tablename, failing if an item with its key
+// (named by keynames) exists. Its compensation deletes the item only if it still has
+// the values that were put.
+func PutStep(name,tablename string,item ep.Item,keynames ...string) Step {
+	forward := func() error {
+		return result(put.PutIfNotExists(tablename,item,keynames...))
+	}
+	compensate := func() error {
+		d := del.NewDelete()
+		d.TableName = tablename
+		for _,k := range keynames {
+			d.Key[k] = item[k]
+		}
+		for k,v := range item {
+			d.Expected[k] = ep.Constraints{Value:v,Exists:true}
+		}
+		return result(d.EndpointReq())
+	}
+	return Step{Name:name,Forward:forward,Compensate:compensate}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package saga
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunCompensates(t *testing.T) {
+	var undone []string
+	step := func(name string,fail bool) (func() error,func() error) {
+		forward := func() error {
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		}
+		return forward,func() error { undone = append(undone,name); return nil }
+	}
+	var s Saga
+	f1,c1 := step("one",false)
+	f2,c2 := step("two",false)
+	f3,c3 := step("three",true)
+	s.Add("one",f1,c1).Add("two",f2,c2).Add("three",f3,c3)
+	err := s.Run()
+	se,ok := err.(*Error)
+	if !ok || se.Step != "three" {
+		t.Fatalf("expected step three to fail, got %v\n",err)
+	}
+	if len(undone) != 2 || undone[0] != "two" || undone[1] != "one" {
+		t.Errorf("compensations ran as %v\n",undone)
+	}
+}
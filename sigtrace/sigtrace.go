@@ -0,0 +1,321 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Compares the requests godynamo signs with those of a reference signer and serializer,
+// to root-cause signature or serialization discrepancies. The built-in Reference signs
+// the request as it would go over the wire, following the AWS Signature Version 4
+// specification independently of auth_v4; to compare against an AWS SDK, implement
+// Signer with the SDK's signer and serializer.
+//
+// example use:
+//
+//   r,err := sigtrace.Compare(ctx,&get,get_item.GETITEM_ENDPOINT,sigtrace.Reference)
+//   if err == nil && !r.Match() {
+//	log.Printf("%s\n",r.String())
+//   }
+package sigtrace
+
+import (
+	"fmt"
+	"time"
+	"sort"
+	"bytes"
+	"errors"
+	"context"
+	"strings"
+	"net/http"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/smugmug/godynamo/auth_v4"
+	"github.com/smugmug/godynamo/aws_const"
+	"github.com/smugmug/godynamo/conf"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// Credentials are the keys a request is signed with.
+type Credentials struct {
+	AccessKey string
+	Secret string
+	Token string
+}
+
+// Signer is a reference implementation to compare godynamo against.
+type Signer interface {
+	// Serialize returns the request body for v, an ep.Endpoint, as the reference
+	// would send it.
+	Serialize(v interface{},amzTarget string) ([]byte,error)
+	// Sign returns the canonical request and Authorization header for r with the
+	// given body, signed at t for zone.
+	Sign(r *http.Request,body []byte,t time.Time,c Credentials,zone string) (string,string,error)
+}
+
+// Reference is the built-in Signer. It serializes with encoding/json and signs the
+// host, content-type and x-amz-* headers of the request.
+var Reference Signer = reference{}
+
+// Report is the outcome of a Compare.
+type Report struct {
+	AmzTarget string
+	// the request bodies of godynamo and of the reference
+	Body []byte
+	ReferenceBody []byte
+	// the JSON paths at which the bodies differ
+	BodyDiff []string
+	// the canonical requests, and the lines at which they differ
+	Canonical string
+	ReferenceCanonical string
+	CanonicalDiff []string
+	Authorization string
+	ReferenceAuthorization string
+}
+
+// Match reports if godynamo and the reference produced the same request.
+func (r *Report) Match() bool {
+	return len(r.BodyDiff) == 0 && len(r.CanonicalDiff) == 0 &&
+		r.Authorization == r.ReferenceAuthorization
+}
+
+// String describes the differences of r.
+func (r *Report) String() string {
+	if r.Match() {
+		return fmt.Sprintf("%s: godynamo and the reference agree",r.AmzTarget)
+	}
+	s := fmt.Sprintf("%s: godynamo and the reference differ",r.AmzTarget)
+	for _,d := range r.BodyDiff {
+		s += "\n  body " + d
+	}
+	for _,d := range r.CanonicalDiff {
+		s += "\n  canonical request " + d
+	}
+	if r.Authorization != r.ReferenceAuthorization {
+		s += "\n  authorization:\n    godynamo:  " + r.Authorization +
+			"\n    reference: " + r.ReferenceAuthorization
+	}
+	return s
+}
+
+// Compare signs the request v (an ep.Endpoint, or its serialized []byte) for amzTarget
+// as godynamo would send it with ctx, without sending it, and compares its body, canonical
+// request and signature with those of ref, signing with the same credentials and time.
+func Compare(ctx context.Context,v interface{},amzTarget string,ref Signer) (*Report,error) {
+	r := &Report{AmzTarget:amzTarget}
+	if b,ok := v.([]byte); ok {
+		r.Body = b
+		r.ReferenceBody = b
+	} else if _,ep_ok := v.(ep.Endpoint); ep_ok {
+		b,json_err := json.Marshal(v)
+		if json_err != nil {
+			e := fmt.Sprintf("sigtrace.Compare: %s",json_err.Error())
+			return nil,errors.New(e)
+		}
+		r.Body = b
+		ref_body,ref_err := ref.Serialize(v,amzTarget)
+		if ref_err != nil {
+			e := fmt.Sprintf("sigtrace.Compare: reference: %s",ref_err.Error())
+			return nil,errors.New(e)
+		}
+		r.ReferenceBody = ref_body
+	} else {
+		return nil,errors.New("sigtrace.Compare: v unknown type")
+	}
+	r.BodyDiff = jsonDiff(r.Body,r.ReferenceBody)
+
+	c := conf.FromContext(ctx)
+	if c == nil {
+		e := fmt.Sprintf("sigtrace.Compare: no configuration named %s",conf.NameFrom(ctx))
+		return nil,errors.New(e)
+	}
+	request,canonical,sign_err := auth_v4.SignedRequest(ctx,r.Body,amzTarget)
+	if sign_err != nil {
+		e := fmt.Sprintf("sigtrace.Compare: %s",sign_err.Error())
+		return nil,errors.New(e)
+	}
+	r.Canonical = canonical
+	r.Authorization = request.Header.Get("Authorization")
+	t,t_err := time.Parse(aws_const.ISO8601FMT_CONDENSED,request.Header.Get(aws_const.X_AMZ_DATE_HDR))
+	if t_err != nil {
+		e := fmt.Sprintf("sigtrace.Compare: %s",t_err.Error())
+		return nil,errors.New(e)
+	}
+	var creds Credentials
+	c.ConfLock.RLock()
+	if c.UseIAM {
+		creds = Credentials{c.IAM.Credentials.AccessKey,c.IAM.Credentials.Secret,c.IAM.Credentials.Token}
+	} else {
+		creds = Credentials{c.Auth.AccessKey,c.Auth.Secret,c.Auth.Token}
+	}
+	zone := c.Network.DynamoDB.Zone
+	c.ConfLock.RUnlock()
+	// the reference signs the request as it would be sent, less godynamo's signature
+	unsigned := request.Clone(ctx)
+	unsigned.Header.Del("Authorization")
+	ref_canonical,ref_auth,ref_err := ref.Sign(unsigned,r.Body,t,creds,zone)
+	if ref_err != nil {
+		e := fmt.Sprintf("sigtrace.Compare: reference: %s",ref_err.Error())
+		return nil,errors.New(e)
+	}
+	r.ReferenceCanonical = ref_canonical
+	r.ReferenceAuthorization = ref_auth
+	r.CanonicalDiff = lineDiff(r.Canonical,r.ReferenceCanonical)
+	return r,nil
+}
+
+// lineDiff describes the lines at which a and b differ.
+func lineDiff(a,b string) []string {
+	al,bl := strings.Split(a,"\n"),strings.Split(b,"\n")
+	diffs := make([]string,0)
+	for i := 0; i < len(al) || i < len(bl); i++ {
+		var x,y string
+		if i < len(al) {
+			x = al[i]
+		}
+		if i < len(bl) {
+			y = bl[i]
+		}
+		if x != y {
+			diffs = append(diffs,fmt.Sprintf("line %d: godynamo %q, reference %q",i + 1,x,y))
+		}
+	}
+	return diffs
+}
+
+// jsonDiff describes the paths at which the JSON documents a and b differ.
+func jsonDiff(a,b []byte) []string {
+	var av,bv interface{}
+	a_err := json.Unmarshal(a,&av)
+	b_err := json.Unmarshal(b,&bv)
+	if a_err != nil || b_err != nil {
+		if bytes.Equal(a,b) {
+			return []string{}
+		}
+		return []string{fmt.Sprintf("$: godynamo %s, reference %s",string(a),string(b))}
+	}
+	diffs := make([]string,0)
+	valueDiff("$",av,bv,&diffs)
+	return diffs
+}
+
+// valueDiff appends to diffs the paths below path at which a and b differ.
+func valueDiff(path string,a,b interface{},diffs *[]string) {
+	am,a_obj := a.(map[string]interface{})
+	bm,b_obj := b.(map[string]interface{})
+	if a_obj && b_obj {
+		keys := make(map[string] bool)
+		for k,_ := range am {
+			keys[k] = true
+		}
+		for k,_ := range bm {
+			keys[k] = true
+		}
+		names := make([]string,0,len(keys))
+		for k,_ := range keys {
+			names = append(names,k)
+		}
+		sort.Strings(names)
+		for _,k := range names {
+			valueDiff(path + "." + k,am[k],bm[k],diffs)
+		}
+		return
+	}
+	al,a_list := a.([]interface{})
+	bl,b_list := b.([]interface{})
+	if a_list && b_list && len(al) == len(bl) {
+		for i,_ := range al {
+			valueDiff(fmt.Sprintf("%s[%d]",path,i),al[i],bl[i],diffs)
+		}
+		return
+	}
+	aj,_ := json.Marshal(a)
+	bj,_ := json.Marshal(b)
+	if !bytes.Equal(aj,bj) {
+		*diffs = append(*diffs,fmt.Sprintf("%s: godynamo %s, reference %s",path,aj,bj))
+	}
+}
+
+type reference struct{}
+
+func (reference) Serialize(v interface{},amzTarget string) ([]byte,error) {
+	return json.Marshal(v)
+}
+
+// Sign follows the Signature Version 4 steps of the AWS General Reference.
+func (reference) Sign(r *http.Request,body []byte,t time.Time,c Credentials,zone string) (string,string,error) {
+	service := strings.ToLower(aws_const.DYNAMODB)
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	// the headers to sign, with their values trimmed and inner spaces collapsed
+	hdrs := map[string]string{"host":host}
+	for k,vs := range r.Header {
+		lk := strings.ToLower(k)
+		if lk != "content-type" && !strings.HasPrefix(lk,"x-amz-") {
+			continue
+		}
+		vals := make([]string,len(vs))
+		for i,v := range vs {
+			vals[i] = strings.Join(strings.Fields(v)," ")
+		}
+		hdrs[lk] = strings.Join(vals,",")
+	}
+	names := make([]string,0,len(hdrs))
+	for k,_ := range hdrs {
+		names = append(names,k)
+	}
+	sort.Strings(names)
+	var canonical_hdrs bytes.Buffer
+	for _,k := range names {
+		canonical_hdrs.WriteString(k + ":" + hdrs[k] + "\n")
+	}
+	signed := strings.Join(names,";")
+	uri := r.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	payload := sha256.Sum256(body)
+	canonical := r.Method + "\n" + uri + "\n" + r.URL.RawQuery + "\n" +
+		canonical_hdrs.String() + "\n" + signed + "\n" + hex.EncodeToString(payload[:])
+
+	date := t.UTC().Format(aws_const.ISODATEFMT)
+	scope := date + "/" + zone + "/" + service + "/aws4_request"
+	canonical_hash := sha256.Sum256([]byte(canonical))
+	str2sign := "AWS4-HMAC-SHA256\n" + t.UTC().Format(aws_const.ISO8601FMT_CONDENSED) + "\n" +
+		scope + "\n" + hex.EncodeToString(canonical_hash[:])
+	key := hmacSHA256([]byte("AWS4" + c.Secret),date)
+	key = hmacSHA256(key,zone)
+	key = hmacSHA256(key,service)
+	key = hmacSHA256(key,"aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key,str2sign))
+	auth := "AWS4-HMAC-SHA256 Credential=" + c.AccessKey + "/" + scope + "," +
+		"SignedHeaders=" + signed + "," + "Signature=" + signature
+	return canonical,auth,nil
+}
+
+func hmacSHA256(key []byte,data string) []byte {
+	h := hmac.New(sha256.New,key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
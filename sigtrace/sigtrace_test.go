@@ -0,0 +1,91 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sigtrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"github.com/smugmug/godynamo/conf"
+	ep "github.com/smugmug/godynamo/endpoint"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+)
+
+func setConf() {
+	conf.Vals.ConfLock.Lock()
+	conf.Vals.Network.DynamoDB.Host = "dynamodb.us-east-1.amazonaws.com"
+	conf.Vals.Network.DynamoDB.Port = "443"
+	conf.Vals.Network.DynamoDB.URL = "https://dynamodb.us-east-1.amazonaws.com:443"
+	conf.Vals.Network.DynamoDB.Zone = "us-east-1"
+	conf.Vals.Auth.AccessKey = "AKIDEXAMPLE"
+	conf.Vals.Auth.Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	conf.Vals.ConfLock.Unlock()
+}
+
+func TestCompareMatch(t *testing.T) {
+	setConf()
+	g := get.NewGet()
+	g.TableName = "sigtrace_test"
+	g.Key["id"] = ep.AttributeValue{S:"a b"}
+	r,err := Compare(context.Background(),g,get.GETITEM_ENDPOINT,Reference)
+	if err != nil {
+		t.Fatalf("Compare: %s\n",err.Error())
+	}
+	if !r.Match() {
+		t.Errorf("%s\n",r.String())
+	}
+	r,err = Compare(context.Background(),[]byte(`{"TableName":"sigtrace_test"}`),get.GETITEM_ENDPOINT,Reference)
+	if err != nil || !r.Match() {
+		t.Errorf("Compare of a body: %v %v\n",r,err)
+	}
+}
+
+// renamed serializes a different TableName
+type renamed struct {
+	reference
+}
+
+func (renamed) Serialize(v interface{},amzTarget string) ([]byte,error) {
+	b,err := json.Marshal(v)
+	return bytes.Replace(b,[]byte("sigtrace_test"),[]byte("other"),1),err
+}
+
+func TestCompareDiff(t *testing.T) {
+	setConf()
+	g := get.NewGet()
+	g.TableName = "sigtrace_test"
+	g.Key["id"] = ep.AttributeValue{S:"a b"}
+	r,err := Compare(context.Background(),g,get.GETITEM_ENDPOINT,renamed{})
+	if err != nil {
+		t.Fatalf("Compare: %s\n",err.Error())
+	}
+	if r.Match() || len(r.BodyDiff) != 1 || r.BodyDiff[0][:12] != "$.TableName:" {
+		t.Errorf("body diff %v\n",r.BodyDiff)
+	}
+	if d := lineDiff("a\nb","a\nc\nd"); len(d) != 2 {
+		t.Errorf("lineDiff %v\n",d)
+	}
+}
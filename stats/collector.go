@@ -0,0 +1,195 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"strings"
+	"math/bits"
+	"math/rand"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+// RESERVOIR_SIZE is how many value sizes of each attribute a Collector keeps, chosen
+// uniformly at random, to take size percentiles from.
+const RESERVOIR_SIZE = 10000
+
+// attribute accumulates the statistics of one attribute.
+type attribute struct {
+	present uint64
+	types map[string] uint64
+	min,max,total uint64
+	histogram []uint64
+	reservoir []uint64
+}
+
+// Collector accumulates per-attribute statistics of the items passed to Add, such as
+// those of a scan or export, for a Report. It is safe for concurrent use.
+type Collector struct {
+	lock sync.Mutex
+	items uint64
+	attributes map[string] *attribute
+	g *rand.Rand
+}
+
+// NewCollector returns an empty Collector.
+func NewCollector() *Collector {
+	return &Collector{attributes:make(map[string] *attribute),g:rand.New(rand.NewSource(1))}
+}
+
+// typeOf is the type of a, from its Type if it was unmarshaled or else from its fields.
+func typeOf(a ep.AttributeValue) string {
+	switch {
+	case a.Type != "":
+		return a.Type
+	case a.S != "":
+		return ep.S
+	case a.N != "":
+		return ep.N
+	case a.B != "":
+		return ep.B
+	case len(a.SS) != 0:
+		return ep.SS
+	case len(a.NS) != 0:
+		return ep.NS
+	case len(a.BS) != 0:
+		return ep.BS
+	}
+	return ""
+}
+
+// Add accumulates the attributes of item.
+func (c *Collector) Add(item ep.Item) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items++
+	for name,a := range item {
+		at,ok := c.attributes[name]
+		if !ok {
+			at = &attribute{types:make(map[string] uint64)}
+			c.attributes[name] = at
+		}
+		size := AttributeSize(a)
+		at.present++
+		at.types[typeOf(a)]++
+		if at.present == 1 || size < at.min {
+			at.min = size
+		}
+		if size > at.max {
+			at.max = size
+		}
+		at.total += size
+		b := bits.Len64(size)
+		for len(at.histogram) <= b {
+			at.histogram = append(at.histogram,0)
+		}
+		at.histogram[b]++
+		// reservoir sampling, so percentiles stay representative of any number of items
+		if len(at.reservoir) < RESERVOIR_SIZE {
+			at.reservoir = append(at.reservoir,size)
+		} else if i := c.g.Int63n(int64(at.present)); i < RESERVOIR_SIZE {
+			at.reservoir[i] = size
+		}
+	}
+}
+
+// Stage returns a Pipeline stage that adds each item to c and keeps it, to collect
+// statistics during a Scan or Query (see scan.ForEachItem) or an export.
+func (c *Collector) Stage() func(ep.Item) (ep.Item,bool) {
+	return func(item ep.Item) (ep.Item,bool) {
+		c.Add(item)
+		return item,true
+	}
+}
+
+// AttributeReport is the statistics of one attribute.
+type AttributeReport struct {
+	Attribute string
+	// the items with the attribute, and their percentage of all items
+	Present uint64
+	Presence float64
+	// the items with the attribute by its type; more than one type is inconsistent
+	Types map[string] uint64
+	// sizes of the values in bytes, as DynamoDB counts them, with the percentiles taken
+	// from a sample of up to RESERVOIR_SIZE of them
+	Sizes SizeDistribution
+	// Histogram[i] counts the values of size in [2**(i-1),2**i), Histogram[0] those of 0
+	Histogram []uint64
+}
+
+// Report is the statistics a Collector has accumulated.
+type Report struct {
+	Items uint64
+	// largest total size first, so bloated attributes lead
+	Attributes []AttributeReport
+}
+
+// Report returns the statistics accumulated so far.
+func (c *Collector) Report() *Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r := &Report{Items:c.items,Attributes:make([]AttributeReport,0,len(c.attributes))}
+	totals := make(map[string] uint64)
+	for name,at := range c.attributes {
+		ar := AttributeReport{Attribute:name,Present:at.present,
+			Presence:100 * float64(at.present) / float64(c.items),
+			Types:make(map[string] uint64),Histogram:append([]uint64(nil),at.histogram...)}
+		for t,n := range at.types {
+			ar.Types[t] = n
+		}
+		ar.Sizes = distribution(append([]uint64(nil),at.reservoir...))
+		ar.Sizes.Min,ar.Sizes.Max = at.min,at.max
+		ar.Sizes.Mean = float64(at.total) / float64(at.present)
+		totals[name] = at.total
+		r.Attributes = append(r.Attributes,ar)
+	}
+	sort.Slice(r.Attributes,func(i,j int) bool {
+		a,b := r.Attributes[i],r.Attributes[j]
+		if totals[a.Attribute] != totals[b.Attribute] {
+			return totals[a.Attribute] > totals[b.Attribute]
+		}
+		return a.Attribute < b.Attribute
+	})
+	return r
+}
+
+// String renders r as a table, one attribute per line.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b,"%d items\n",r.Items)
+	fmt.Fprintf(&b,"%-24s %8s %8s %8s %8s %8s  %s\n","attribute","present","mean","p50","p99","max","types")
+	for _,a := range r.Attributes {
+		types := make([]string,0,len(a.Types))
+		for t,n := range a.Types {
+			types = append(types,fmt.Sprintf("%s:%d",t,n))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(&b,"%-24s %7.1f%% %8.0f %8d %8d %8d  %s\n",a.Attribute,a.Presence,
+			a.Sizes.Mean,a.Sizes.P50,a.Sizes.P99,a.Sizes.Max,strings.Join(types,","))
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package stats
+
+import (
+	"strings"
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	p := ep.Pipeline{c.Stage()}
+	items := []ep.Item{
+		ep.Item{"id":ep.AttributeValue{S:"a"},"note":ep.AttributeValue{S:strings.Repeat("x",100)}},
+		ep.Item{"id":ep.AttributeValue{S:"b"},"note":ep.AttributeValue{N:"7"}},
+		ep.Item{"id":ep.AttributeValue{S:"c"}},
+		ep.Item{"id":ep.AttributeValue{S:"d"}},
+	}
+	if kept := p.Apply(items); len(kept) != 4 {
+		t.Errorf("expected the stage to keep 4 items, got %d\n",len(kept))
+	}
+	r := c.Report()
+	if r.Items != 4 || len(r.Attributes) != 2 {
+		t.Fatalf("unexpected report %+v\n",r)
+	}
+	note := r.Attributes[0]
+	if note.Attribute != "note" || note.Present != 2 || note.Presence != 50 {
+		t.Errorf("expected note first and in half the items, got %+v\n",note)
+	}
+	if note.Types[ep.S] != 1 || note.Types[ep.N] != 1 {
+		t.Errorf("expected one S and one N note, got %v\n",note.Types)
+	}
+	if note.Sizes.Min != 2 || note.Sizes.Max != 100 || note.Sizes.Mean != 51 {
+		t.Errorf("unexpected note sizes %+v\n",note.Sizes)
+	}
+	// 2 bytes in [2,4), 100 in [64,128)
+	if len(note.Histogram) != 8 || note.Histogram[2] != 1 || note.Histogram[7] != 1 {
+		t.Errorf("unexpected note histogram %v\n",note.Histogram)
+	}
+	if !strings.Contains(r.String(),"note") {
+		t.Errorf("expected note in the rendered report:\n%s",r.String())
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Statistics of the items of a table, taken from a random sample of it rather than a
+// full scan: SampleScan reads the first pages of a few randomly chosen segments of a
+// parallel scan, and reports the cardinality of each attribute, the distribution of
+// item sizes and the entropy of the partition keys, for schema audits. A Collector
+// gathers per-attribute statistics from the items of any scan or export.
+//
+// example use:
+//
+//   s,err := stats.SampleScan("orders",stats.SampleOptions{})
+//   if err == nil {
+//	b,_ := json.MarshalIndent(s,"","  ")
+//	fmt.Printf("%s\n",string(b))
+//   }
+//
+//   c := stats.NewCollector()
+//   err = sc.ForEachItem(ep.Pipeline{c.Stage()},iw.Write)
+//   fmt.Printf("%s",c.Report().String())
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"errors"
+	"strings"
+	"math/rand"
+	"encoding/json"
+	"encoding/base64"
+	ep "github.com/smugmug/godynamo/endpoint"
+	desc "github.com/smugmug/godynamo/endpoints/describe_table"
+	"github.com/smugmug/godynamo/endpoints/scan"
+)
+
+const (
+	// the segments a sampled table is divided into, of which SAMPLE_SEGMENTS are read
+	SAMPLE_TOTAL_SEGMENTS = 1000
+	SAMPLE_SEGMENTS = 10
+	// items per page, and pages read per segment
+	SAMPLE_PAGE_LIMIT = 100
+	SAMPLE_PAGES = 1
+	// distinct values counted per attribute; past this the count is a lower bound
+	CARDINALITY_CAP = 10000
+)
+
+// SampleOptions select the sample. Zero values take the SAMPLE_* defaults.
+type SampleOptions struct {
+	TotalSegments uint64
+	Segments uint64
+	PageLimit uint64
+	Pages uint64
+	// seeds the choice of segments, 0 for a random seed
+	Seed int64
+}
+
+// SizeDistribution summarizes item sizes in bytes, as DynamoDB counts them (see ItemSize).
+type SizeDistribution struct {
+	Min uint64
+	Max uint64
+	Mean float64
+	P50 uint64
+	P90 uint64
+	P99 uint64
+}
+
+// Cardinality is the number of items of a sample with an attribute, and the number of
+// distinct values it has among them.
+type Cardinality struct {
+	Attribute string
+	Present uint64
+	Distinct uint64
+	// set if Distinct reached CARDINALITY_CAP and stopped counting
+	Capped bool
+}
+
+// Sample is the outcome of a SampleScan.
+type Sample struct {
+	TableName string
+	TotalSegments uint64
+	// the segments read, and whether each was read to its end
+	Segments []uint64
+	Complete bool
+	Items uint64
+	// the items of the table, extrapolated from the segments read if they were complete
+	EstimatedItems uint64
+	Sizes SizeDistribution
+	// by attribute name
+	Attributes []Cardinality
+	// the Shannon entropy in bits of the partition key values of the sample, and the
+	// most it could be (every item with its own key); a ratio well below 1 means a few
+	// partition keys hold many of the items
+	HashKey string
+	KeyEntropy float64
+	MaxKeyEntropy float64
+}
+
+// numberSize is the size DynamoDB counts for the number n: a byte per two significant
+// digits, plus one.
+func numberSize(n string) uint64 {
+	n = strings.TrimLeft(n,"+-")
+	if i := strings.IndexAny(n,"eE"); i >= 0 {
+		n = n[:i]
+	}
+	digits := strings.Trim(strings.Replace(n,".","",1),"0")
+	return uint64((len(digits) + 1) / 2 + 1)
+}
+
+// binarySize is the decoded length of the base64 b.
+func binarySize(b string) uint64 {
+	if d,err := base64.StdEncoding.DecodeString(b); err == nil {
+		return uint64(len(d))
+	}
+	return uint64(len(b))
+}
+
+// AttributeSize is the size DynamoDB counts for the value a.
+func AttributeSize(a ep.AttributeValue) uint64 {
+	var n uint64
+	switch {
+	case a.S != "":
+		n = uint64(len(a.S))
+	case a.N != "":
+		n = numberSize(a.N)
+	case a.B != "":
+		n = binarySize(a.B)
+	case len(a.SS) != 0:
+		for _,s := range a.SS {
+			n += uint64(len(s))
+		}
+	case len(a.NS) != 0:
+		for _,s := range a.NS {
+			n += numberSize(s)
+		}
+	case len(a.BS) != 0:
+		for _,b := range a.BS {
+			n += binarySize(b)
+		}
+	}
+	return n
+}
+
+// ItemSize is the size DynamoDB counts for item: the lengths of its attribute names and
+// the sizes of their values.
+func ItemSize(item ep.Item) uint64 {
+	var n uint64
+	for name,a := range item {
+		n += uint64(len(name)) + AttributeSize(a)
+	}
+	return n
+}
+
+// percentile returns the q-quantile of sorted, by the nearest rank.
+func percentile(sorted []uint64,q float64) uint64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q * float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// distribution summarizes sizes, which it sorts.
+func distribution(sizes []uint64) SizeDistribution {
+	var d SizeDistribution
+	if len(sizes) == 0 {
+		return d
+	}
+	sort.Slice(sizes,func(i,j int) bool { return sizes[i] < sizes[j] })
+	var total uint64
+	for _,s := range sizes {
+		total += s
+	}
+	d.Min,d.Max = sizes[0],sizes[len(sizes) - 1]
+	d.Mean = float64(total) / float64(len(sizes))
+	d.P50,d.P90,d.P99 = percentile(sizes,0.5),percentile(sizes,0.9),percentile(sizes,0.99)
+	return d
+}
+
+// valueKey identifies the value a among the values of an attribute.
+func valueKey(a ep.AttributeValue) string {
+	b,err := json.Marshal(a)
+	if err != nil {
+		return fmt.Sprintf("%v",a)
+	}
+	return string(b)
+}
+
+// sampler accumulates the statistics of the items of a sample.
+type sampler struct {
+	hashKey string
+	sizes []uint64
+	present map[string] uint64
+	distinct map[string] map[string] bool
+	keys map[string] uint64
+}
+
+func newSampler(hashKey string) *sampler {
+	return &sampler{hashKey:hashKey,present:make(map[string] uint64),
+		distinct:make(map[string] map[string] bool),keys:make(map[string] uint64)}
+}
+
+func (s *sampler) add(item ep.Item) {
+	s.sizes = append(s.sizes,ItemSize(item))
+	for name,a := range item {
+		s.present[name]++
+		d,ok := s.distinct[name]
+		if !ok {
+			d = make(map[string] bool)
+			s.distinct[name] = d
+		}
+		if len(d) < CARDINALITY_CAP {
+			d[valueKey(a)] = true
+		}
+	}
+	if k,ok := item[s.hashKey]; ok {
+		s.keys[valueKey(k)]++
+	}
+}
+
+// fill sets the item statistics of r.
+func (s *sampler) fill(r *Sample) {
+	r.Items = uint64(len(s.sizes))
+	r.Sizes = distribution(s.sizes)
+	r.Attributes = make([]Cardinality,0,len(s.present))
+	for name,n := range s.present {
+		d := uint64(len(s.distinct[name]))
+		r.Attributes = append(r.Attributes,
+			Cardinality{Attribute:name,Present:n,Distinct:d,Capped:d >= CARDINALITY_CAP})
+	}
+	sort.Slice(r.Attributes,func(i,j int) bool {
+		return r.Attributes[i].Attribute < r.Attributes[j].Attribute
+	})
+	r.HashKey = s.hashKey
+	var keyed uint64
+	for _,n := range s.keys {
+		keyed += n
+	}
+	for _,n := range s.keys {
+		p := float64(n) / float64(keyed)
+		r.KeyEntropy -= p * math.Log2(p)
+	}
+	if keyed > 0 {
+		r.MaxKeyEntropy = math.Log2(float64(keyed))
+	}
+}
+
+// errEnough stops a segment once its pages have been read.
+var errEnough = errors.New("stats: enough pages")
+
+// SampleScan reads a random sample of tablename: the first o.Pages pages of o.PageLimit
+// items of o.Segments segments chosen at random from o.TotalSegments, and reports their
+// statistics. Only those pages are read (and paid for). Segments of a parallel scan
+// partition the table by hash key, so the sample is spread across the table's key space.
+func SampleScan(tablename string,o SampleOptions) (*Sample,error) {
+	if o.TotalSegments == 0 {
+		o.TotalSegments = SAMPLE_TOTAL_SEGMENTS
+	}
+	if o.Segments == 0 {
+		o.Segments = SAMPLE_SEGMENTS
+	}
+	if o.Segments > o.TotalSegments {
+		o.Segments = o.TotalSegments
+	}
+	if o.PageLimit == 0 {
+		o.PageLimit = SAMPLE_PAGE_LIMIT
+	}
+	if o.Pages == 0 {
+		o.Pages = SAMPLE_PAGES
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	t,desc_err := desc.Cached(tablename)
+	if desc_err != nil {
+		e := fmt.Sprintf("stats.SampleScan: %s",desc_err.Error())
+		return nil,errors.New(e)
+	}
+	hashKey := ""
+	for _,k := range t.KeySchema {
+		if k.KeyType == ep.HASH {
+			hashKey = k.AttributeName
+		}
+	}
+	r := &Sample{TableName:tablename,TotalSegments:o.TotalSegments,Complete:true}
+	g := rand.New(rand.NewSource(o.Seed))
+	chosen := make(map[uint64] bool)
+	for uint64(len(chosen)) < o.Segments {
+		chosen[uint64(g.Int63n(int64(o.TotalSegments)))] = true
+	}
+	for seg,_ := range chosen {
+		r.Segments = append(r.Segments,seg)
+	}
+	sort.Slice(r.Segments,func(i,j int) bool { return r.Segments[i] < r.Segments[j] })
+	s := newSampler(hashKey)
+	for _,seg := range r.Segments {
+		sc := scan.NewScan()
+		sc.TableName = tablename
+		sc.Limit = ep.NullableUInt64(o.PageLimit)
+		sc.Segment = ep.NullableUInt64(seg)
+		sc.TotalSegments = ep.NullableUInt64(o.TotalSegments)
+		var pages uint64
+		scan_err := sc.ForEachPage(func(page *scan.Response) error {
+			for _,item := range page.Items {
+				s.add(item)
+			}
+			pages++
+			if pages == o.Pages && len(page.LastEvaluatedKey) != 0 {
+				return errEnough
+			}
+			return nil
+		})
+		if scan_err == errEnough {
+			r.Complete = false
+		} else if scan_err != nil {
+			e := fmt.Sprintf("stats.SampleScan: segment %d: %s",seg,scan_err.Error())
+			return nil,errors.New(e)
+		}
+	}
+	s.fill(r)
+	if r.Complete {
+		r.EstimatedItems = r.Items * r.TotalSegments / uint64(len(r.Segments))
+	}
+	return r,nil
+}
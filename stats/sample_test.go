@@ -0,0 +1,85 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package stats
+
+import (
+	"math"
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+)
+
+func TestItemSize(t *testing.T) {
+	item := ep.Item{
+		"id":ep.AttributeValue{S:"abc"},
+		"n":ep.AttributeValue{N:"12345"},
+		"b":ep.AttributeValue{B:"AAEC"},
+		"ss":ep.AttributeValue{SS:[]string{"x","yz"}},
+	}
+	// 2+3, 1+(5+1)/2+1, 1+3, 2+3
+	if n := ItemSize(item); n != 5 + 5 + 4 + 5 {
+		t.Errorf("expected 19 bytes, got %d\n",n)
+	}
+	if n := numberSize("-0.00120"); n != 2 {
+		t.Errorf("expected 2 bytes for -0.00120, got %d\n",n)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	sizes := make([]uint64,0,100)
+	for i := 100; i >= 1; i-- {
+		sizes = append(sizes,uint64(i))
+	}
+	d := distribution(sizes)
+	if d.Min != 1 || d.Max != 100 || d.P50 != 50 || d.P90 != 90 || d.P99 != 99 || d.Mean != 50.5 {
+		t.Errorf("unexpected distribution %+v\n",d)
+	}
+}
+
+func TestSamplerEntropy(t *testing.T) {
+	s := newSampler("id")
+	for _,k := range []string{"a","b","c","d"} {
+		s.add(ep.Item{"id":ep.AttributeValue{S:k},"kind":ep.AttributeValue{S:"order"}})
+	}
+	var r Sample
+	s.fill(&r)
+	if r.Items != 4 || math.Abs(r.KeyEntropy - 2) > 1e-9 || r.MaxKeyEntropy != 2 {
+		t.Errorf("expected 2 bits of 2 over 4 items, got %+v\n",r)
+	}
+	if len(r.Attributes) != 2 || r.Attributes[0].Attribute != "id" ||
+		r.Attributes[0].Distinct != 4 || r.Attributes[1].Distinct != 1 ||
+		r.Attributes[1].Present != 4 {
+		t.Errorf("unexpected cardinalities %+v\n",r.Attributes)
+	}
+
+	skewed := newSampler("id")
+	for i := 0; i < 4; i++ {
+		skewed.add(ep.Item{"id":ep.AttributeValue{S:"hot"}})
+	}
+	var sr Sample
+	skewed.fill(&sr)
+	if sr.KeyEntropy != 0 {
+		t.Errorf("expected no entropy for one key, got %v\n",sr.KeyEntropy)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Partitioning of shared tables between tenants. The tenant of a request is bound to
+// its context with WithTenant; Scope then prefixes the tenant-keyed attributes of the
+// request (each table's partition key, and the partition keys of its indexes) with the
+// tenant id, and restricts scans to the tenant's items. Strip removes the prefix from
+// items read back, failing on items of any other tenant.
+//
+// example use:
+//
+//   tenant.Register("orders","customer")
+//   ctx := tenant.WithTenant(context.Background(),"acme")
+//   g := get_item.NewGet()
+//   ...
+//   if err := tenant.Scope(ctx,g); err != nil { ... }
+//   body,code,err := g.EndpointReq()
+package tenant
+
+import (
+	"fmt"
+	"sync"
+	"errors"
+	"strings"
+	"context"
+	ep "github.com/smugmug/godynamo/endpoint"
+	batch_get "github.com/smugmug/godynamo/endpoints/batch_get_item"
+	batch_write "github.com/smugmug/godynamo/endpoints/batch_write_item"
+	del "github.com/smugmug/godynamo/endpoints/delete_item"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+	query "github.com/smugmug/godynamo/endpoints/query"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+	update "github.com/smugmug/godynamo/endpoints/update_item"
+)
+
+// SEPARATOR divides the tenant id from the rest of a tenant-keyed value.
+const SEPARATOR = "#"
+
+var (
+	ErrNoTenant = errors.New("tenant: no tenant bound to context")
+	// returned for requests that cannot be restricted to one tenant
+	ErrUnscoped = errors.New("tenant: request is not scoped to a tenant")
+)
+
+type key int
+
+const tenantKey key = 0
+
+// WithTenant returns ctx bound to the tenant id, which may not contain SEPARATOR; such
+// an id, which could match another tenant's prefix, is treated as no tenant.
+func WithTenant(ctx context.Context,id string) context.Context {
+	return context.WithValue(ctx,tenantKey,id)
+}
+
+// FromContext returns the tenant bound to ctx.
+func FromContext(ctx context.Context) (string,bool) {
+	id,ok := ctx.Value(tenantKey).(string)
+	return id,ok && id != "" && !strings.Contains(id,SEPARATOR)
+}
+
+var tables struct {
+	lock sync.RWMutex
+	m map[string] []string
+}
+
+// Register marks tablename as shared, with its tenant-keyed attributes: the partition
+// key of the table first, then those of any indexes. These must be of type S.
+func Register(tablename string,keynames ...string) {
+	tables.lock.Lock()
+	defer tables.lock.Unlock()
+	if tables.m == nil {
+		tables.m = make(map[string] []string)
+	}
+	tables.m[tablename] = keynames
+}
+
+func keyNames(tablename string) []string {
+	tables.lock.RLock()
+	defer tables.lock.RUnlock()
+	return tables.m[tablename]
+}
+
+func prefix(id string) string {
+	return id + SEPARATOR
+}
+
+// scopeItem returns a copy of item with its tenant-keyed attributes prefixed.
+// Unless partial is set, item must have the table's partition key.
+func scopeItem(id,tablename string,item ep.Item,partial bool) (ep.Item,error) {
+	keys := keyNames(tablename)
+	if keys == nil {
+		return item,nil
+	}
+	scoped := make(ep.Item,len(item))
+	for k,v := range item {
+		scoped[k] = v
+	}
+	for i,k := range keys {
+		v,ok := item[k]
+		if !ok {
+			if i == 0 && !partial {
+				e := fmt.Sprintf("tenant: %s has no %s",tablename,k)
+				return nil,errors.New(e)
+			}
+			continue
+		}
+		if v.S == "" {
+			e := fmt.Sprintf("tenant: %s.%s is not a string",tablename,k)
+			return nil,errors.New(e)
+		}
+		v.S = prefix(id) + v.S
+		scoped[k] = v
+	}
+	return scoped,nil
+}
+
+func scopeExpected(id,tablename string,x ep.Expected) ep.Expected {
+	if len(x) == 0 {
+		return x
+	}
+	scoped := make(ep.Expected,len(x))
+	for k,c := range x {
+		scoped[k] = c
+	}
+	for _,k := range keyNames(tablename) {
+		if c,ok := x[k]; ok && c.Value.S != "" {
+			c.Value.S = prefix(id) + c.Value.S
+			scoped[k] = c
+		}
+	}
+	return scoped
+}
+
+// Scope restricts the request v, a pointer to one of the item endpoints (get, put,
+// update, delete, query, scan and the batch requests), to the tenant bound to ctx.
+// Requests on unregistered tables are unchanged. Scope a request only once.
+func Scope(ctx context.Context,v interface{}) error {
+	id,ok := FromContext(ctx)
+	if !ok {
+		return ErrNoTenant
+	}
+	var err error
+	switch r := v.(type) {
+	case *get.Get:
+		r.Key,err = scopeItem(id,r.TableName,r.Key,false)
+	case *put.Put:
+		r.Item,err = scopeItem(id,r.TableName,r.Item,false)
+		r.Expected = scopeExpected(id,r.TableName,r.Expected)
+	case *del.Delete:
+		r.Key,err = scopeItem(id,r.TableName,r.Key,false)
+		r.Expected = scopeExpected(id,r.TableName,r.Expected)
+	case *update.Update:
+		r.Key,err = scopeItem(id,r.TableName,r.Key,false)
+		r.Expected = scopeExpected(id,r.TableName,r.Expected)
+	case *query.Query:
+		err = scopeQuery(id,r)
+	case *scan.Scan:
+		err = scopeScan(id,r)
+	case *batch_get.BatchGetItem:
+		for tablename,ri := range r.RequestItems {
+			keys := make([]ep.Item,len(ri.Keys))
+			for i,k := range ri.Keys {
+				if keys[i],err = scopeItem(id,tablename,k,false); err != nil {
+					return err
+				}
+			}
+			scoped := *ri
+			scoped.Keys = keys
+			r.RequestItems[tablename] = &scoped
+		}
+	case *batch_write.BatchWriteItem:
+		for tablename,ris := range r.RequestItems {
+			scoped := make([]batch_write.RequestInstance,len(ris))
+			for i,ri := range ris {
+				if ri.PutRequest != nil {
+					item,s_err := scopeItem(id,tablename,ri.PutRequest.Item,false)
+					if s_err != nil {
+						return s_err
+					}
+					scoped[i].PutRequest = &batch_write.PutRequest{Item:item}
+				}
+				if ri.DeleteRequest != nil {
+					k,s_err := scopeItem(id,tablename,ri.DeleteRequest.Key,false)
+					if s_err != nil {
+						return s_err
+					}
+					scoped[i].DeleteRequest = &batch_write.DeleteRequest{Key:k}
+				}
+			}
+			r.RequestItems[tablename] = scoped
+		}
+	default:
+		return ErrUnscoped
+	}
+	return err
+}
+
+// scopeQuery prefixes the partition key condition of q, which must be an EQ on one
+// of the table's tenant-keyed attributes.
+func scopeQuery(id string,q *query.Query) error {
+	keys := keyNames(q.TableName)
+	if keys == nil {
+		return nil
+	}
+	scoped := make(query.KeyConditions,len(q.KeyConditions))
+	found := false
+	for k,c := range q.KeyConditions {
+		scoped[k] = c
+	}
+	for _,k := range keys {
+		c,ok := q.KeyConditions[k]
+		if !ok || c.ComparisonOperator != query.OP_EQ || len(c.AttributeValueList) != 1 {
+			continue
+		}
+		c.AttributeValueList = []ep.AttributeValue{c.AttributeValueList[0]}
+		c.AttributeValueList[0].S = prefix(id) + c.AttributeValueList[0].S
+		scoped[k] = c
+		found = true
+	}
+	if !found {
+		return ErrUnscoped
+	}
+	q.KeyConditions = scoped
+	var err error
+	q.ExclusiveStartKey,err = scopeStart(id,q.TableName,q.ExclusiveStartKey)
+	return err
+}
+
+// scopeScan restricts s to the items whose partition key has the tenant's prefix.
+func scopeScan(id string,s *scan.Scan) error {
+	keys := keyNames(s.TableName)
+	if keys == nil {
+		return nil
+	}
+	if _,ok := s.ScanFilter[keys[0]]; ok {
+		return ErrUnscoped
+	}
+	scoped := make(scan.ScanFilters,len(s.ScanFilter) + 1)
+	for k,f := range s.ScanFilter {
+		scoped[k] = f
+	}
+	scoped[keys[0]] = scan.ScanFilter{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:prefix(id)}},
+		ComparisonOperator:scan.OP_BEGINS_WITH}
+	s.ScanFilter = scoped
+	var err error
+	s.ExclusiveStartKey,err = scopeStart(id,s.TableName,s.ExclusiveStartKey)
+	return err
+}
+
+// scopeStart scopes a start key taken from stripped items; keys passed back from
+// LastEvaluatedKey unchanged already have the prefix.
+func scopeStart(id,tablename string,start ep.Item) (ep.Item,error) {
+	if len(start) == 0 {
+		return start,nil
+	}
+	keys := keyNames(tablename)
+	if v,ok := start[keys[0]]; ok && strings.HasPrefix(v.S,prefix(id)) {
+		return start,nil
+	}
+	return scopeItem(id,tablename,start,true)
+}
+
+// Strip returns a copy of item, read from tablename, with the tenant prefix removed
+// from its tenant-keyed attributes. It fails if item belongs to another tenant.
+func Strip(ctx context.Context,tablename string,item ep.Item) (ep.Item,error) {
+	id,ok := FromContext(ctx)
+	if !ok {
+		return nil,ErrNoTenant
+	}
+	keys := keyNames(tablename)
+	if keys == nil || len(item) == 0 {
+		return item,nil
+	}
+	stripped := make(ep.Item,len(item))
+	for k,v := range item {
+		stripped[k] = v
+	}
+	for _,k := range keys {
+		v,ok := item[k]
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(v.S,prefix(id)) {
+			e := fmt.Sprintf("tenant: %s item of another tenant",tablename)
+			return nil,errors.New(e)
+		}
+		v.S = strings.TrimPrefix(v.S,prefix(id))
+		stripped[k] = v
+	}
+	return stripped,nil
+}
+
+// StripItems is Strip for each of items.
+func StripItems(ctx context.Context,tablename string,items []ep.Item) ([]ep.Item,error) {
+	stripped := make([]ep.Item,len(items))
+	for i,item := range items {
+		var err error
+		if stripped[i],err = Strip(ctx,tablename,item); err != nil {
+			return nil,err
+		}
+	}
+	return stripped,nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2013, SmugMug, Inc. All rights reserved.
+// 
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+//       copyright notice, this list of conditions and the following
+//       disclaimer in the documentation and/or other materials provided
+//       with the distribution.
+// 
+// THIS SOFTWARE IS PROVIDED BY SMUGMUG, INC. ``AS IS'' AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL SMUGMUG, INC. BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE
+// GOODS OR SERVICES;LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER
+// IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package tenant
+
+import (
+	"context"
+	"testing"
+	ep "github.com/smugmug/godynamo/endpoint"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	query "github.com/smugmug/godynamo/endpoints/query"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+)
+
+func TestScopeGetStrip(t *testing.T) {
+	Register("tenant_test","customer")
+	ctx := WithTenant(context.Background(),"acme")
+	g := get.NewGet()
+	g.TableName = "tenant_test"
+	g.Key["customer"] = ep.AttributeValue{S:"c1"}
+	orig := g.Key
+	if err := Scope(ctx,g); err != nil {
+		t.Fatalf("Scope: %s\n",err.Error())
+	}
+	if g.Key["customer"].S != "acme#c1" || orig["customer"].S != "c1" {
+		t.Errorf("scoped key %v, original %v\n",g.Key,orig)
+	}
+	item,err := Strip(ctx,"tenant_test",g.Key)
+	if err != nil || item["customer"].S != "c1" {
+		t.Errorf("Strip: %v %v\n",item,err)
+	}
+	other := WithTenant(context.Background(),"other")
+	if _,err := Strip(other,"tenant_test",g.Key); err == nil {
+		t.Errorf("expected Strip of another tenant's item to fail\n")
+	}
+}
+
+func TestScopeQueryScan(t *testing.T) {
+	Register("tenant_test","customer")
+	ctx := WithTenant(context.Background(),"acme")
+	q := query.NewQuery()
+	q.TableName = "tenant_test"
+	if err := Scope(ctx,q); err != ErrUnscoped {
+		t.Errorf("expected query without a key condition to be unscoped, got %v\n",err)
+	}
+	q.KeyConditions["customer"] = query.KeyCondition{
+		AttributeValueList:[]ep.AttributeValue{ep.AttributeValue{S:"c1"}},
+		ComparisonOperator:query.OP_EQ}
+	if err := Scope(ctx,q); err != nil || q.KeyConditions["customer"].AttributeValueList[0].S != "acme#c1" {
+		t.Errorf("scoped query %v %v\n",q.KeyConditions,err)
+	}
+	s := scan.NewScan()
+	s.TableName = "tenant_test"
+	if err := Scope(ctx,s); err != nil {
+		t.Fatalf("Scope: %s\n",err.Error())
+	}
+	f := s.ScanFilter["customer"]
+	if f.ComparisonOperator != scan.OP_BEGINS_WITH || f.AttributeValueList[0].S != "acme#" {
+		t.Errorf("scoped scan filter %v\n",f)
+	}
+}
+
+func TestNoTenant(t *testing.T) {
+	if err := Scope(context.Background(),get.NewGet()); err != ErrNoTenant {
+		t.Errorf("expected ErrNoTenant, got %v\n",err)
+	}
+	if _,ok := FromContext(WithTenant(context.Background(),"a#b")); ok {
+		t.Errorf("expected an id containing the separator to be refused\n")
+	}
+}